@@ -0,0 +1,397 @@
+package app
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// registerAdmin starts separate listener for /admin/ handlers if AdminAddr is set. Handlers are added to public
+// websocket listener only with AdminToken, otherwise admin endpoints are disabled.
+func (a *App) registerAdmin() error {
+	mux := http.DefaultServeMux
+	if a.AdminAddr == "" && a.AdminToken == "" {
+		a.Printf("admin endpoints are disabled, set admin listener or admin token")
+		return nil
+	} else if a.AdminAddr != "" {
+		ln, err := net.Listen("tcp", a.AdminAddr)
+		if err != nil {
+			return err
+		}
+
+		mux = http.NewServeMux()
+		a.Printf("starting admin listener at http://%s\n", a.AdminAddr)
+		go func() {
+			if err := http.Serve(ln, mux); err != nil {
+				a.Errorf("admin listener err=%s", err)
+			}
+		}()
+	}
+
+	mux.Handle(a.endpoint("/admin/broadcast"), a.adminHandler(http.HandlerFunc(a.broadcast)))
+	mux.Handle(a.endpoint("/admin/maintenance"), a.adminHandler(http.HandlerFunc(a.maintenance)))
+	mux.Handle(a.endpoint("/admin/drain"), a.adminHandler(http.HandlerFunc(a.drain)))
+	mux.Handle(a.endpoint("/admin/routes"), a.adminHandler(http.HandlerFunc(a.routeList)))
+	mux.Handle(a.endpoint("/admin/backends"), a.adminHandler(http.HandlerFunc(a.backendList)))
+	mux.Handle(a.endpoint("/admin/purge"), a.adminHandler(http.HandlerFunc(a.purge)))
+	mux.Handle(a.endpoint("/admin/tags"), a.adminHandler(http.HandlerFunc(a.tagSession)))
+	mux.Handle(a.endpoint("/admin/switch"), a.adminHandler(http.HandlerFunc(a.switchRoute)))
+	mux.Handle(a.endpoint("/admin/slo"), a.adminHandler(http.HandlerFunc(a.slo)))
+	mux.Handle(a.endpoint("/admin/sessions"), a.adminHandler(http.HandlerFunc(a.lookupSession)))
+	mux.Handle(a.endpoint("/admin/disconnect"), a.adminHandler(http.HandlerFunc(a.disconnect)))
+	mux.Handle(a.endpoint("/admin/cache/invalidate"), a.adminHandler(http.HandlerFunc(a.cacheInvalidate)))
+	mux.Handle(a.endpoint("/admin/events"), a.adminHandler(a.eventsHandler()))
+	return nil
+}
+
+// adminHandler checks AdminToken bearer token and requires application/json body of mutating requests, so admin
+// actions can't be triggered by cross-site form posts.
+func (a *App) adminHandler(h http.Handler) http.Handler {
+	return a.httpHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.AdminToken != "" && subtle.ConstantTimeCompare([]byte(bearerToken(r.Header.Get("Authorization"))), []byte(a.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+				http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	}))
+}
+
+// httpHandler adds CORS and security headers to built-in http endpoint.
+func (a *App) httpHandler(h http.Handler) http.Handler {
+	return corsHandler(a.Cors, secureHeaders(h))
+}
+
+// auditRecord is an admin action saved to storage.
+type auditRecord struct {
+	Time       time.Time   `json:"time"`
+	Action     string      `json:"action"`
+	RemoteAddr string      `json:"remoteAddr"`
+	Request    interface{} `json:"request"`
+}
+
+// audit saves admin action to storage as audit/<time>-<action>.json if storage is configured.
+func (a *App) audit(r *http.Request, action string, req interface{}) {
+	if a.storage == nil {
+		return
+	}
+
+	rec := auditRecord{Time: time.Now().UTC(), Action: action, RemoteAddr: r.RemoteAddr, Request: req}
+	data, err := json.Marshal(rec)
+	if err == nil {
+		err = a.storage.Put(r.Context(), "audit/"+rec.Time.Format(storageTimeFormat)+"-"+action+".json", data)
+	}
+
+	if err != nil {
+		a.Errorf("can't save audit record action=%s err=%s", action, err)
+	}
+}
+
+// broadcastRequest is a body of /admin/broadcast request.
+type broadcastRequest struct {
+	sessionFilter
+	Method string           `json:"method"`
+	Params *json.RawMessage `json:"params,omitempty"`
+}
+
+type broadcastResponse struct {
+	Total     int `json:"total"`
+	Delivered int `json:"delivered"`
+	Failed    int `json:"failed"`
+}
+
+// broadcast sends JSON-RPC notification to all sessions matched by session id, route and tag on all cluster instances.
+// Example: curl -H 'Content-Type: application/json' -d '{"method":"maintenance","params":{"in":300},"route":"/rpc"}' http://localhost:8090/admin/broadcast
+func (a *App) broadcast(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var br broadcastRequest
+	if err := json.NewDecoder(r.Body).Decode(&br); err != nil || br.Method == "" {
+		http.Error(w, "invalid broadcast request", http.StatusBadRequest)
+		return
+	}
+
+	var params []byte
+	if br.Params != nil {
+		params = *br.Params
+	}
+
+	var resp broadcastResponse
+	for _, s := range a.sessions.find(br.sessionFilter) {
+		resp.Total++
+		if err := s.send(s.notification(br.Method, params)); err != nil {
+			a.Errorf("can't broadcast to session=%s err=%s", s.id, err)
+			resp.Failed++
+			continue
+		}
+		resp.Delivered++
+	}
+
+	// deliver to sessions on other instances
+	a.forwardPeers(r, "/admin/broadcast", br.sessionFilter, br, func(data []byte) {
+		var pr broadcastResponse
+		if json.Unmarshal(data, &pr) == nil {
+			resp.Total, resp.Delivered, resp.Failed = resp.Total+pr.Total, resp.Delivered+pr.Delivered, resp.Failed+pr.Failed
+		}
+	})
+
+	a.Printf("broadcast method=%s route=%s tag=%s total=%d delivered=%d", br.Method, br.Route, br.Tag, resp.Total, resp.Delivered)
+	a.audit(r, "broadcast", br)
+	writeJSON(w, resp)
+}
+
+// maintenanceRequest is a body of /admin/maintenance request.
+type maintenanceRequest struct {
+	Route         string `json:"route"`
+	Enabled       bool   `json:"enabled"`
+	Message       string `json:"message"`       // custom error message for clients
+	CloseSessions bool   `json:"closeSessions"` // close existing route connections
+}
+
+type maintenanceStatus struct {
+	Route     string   `json:"route"`
+	Enabled   bool     `json:"enabled"`
+	Message   string   `json:"message,omitempty"`
+	Scheduled bool     `json:"scheduled,omitempty"` // route is inside scheduled maintenance window
+	Windows   []string `json:"windows,omitempty"`
+}
+
+// maintenance enables or disables maintenance mode for route (POST) or returns maintenance status for all routes (GET).
+// Example: curl -H 'Content-Type: application/json' -d '{"route":"/rpc","enabled":true,"message":"back in 5 minutes"}' http://localhost:8090/admin/maintenance
+func (a *App) maintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		list := []maintenanceStatus{}
+		for src, rs := range a.routes {
+			rs.lock.RLock()
+			list = append(list, maintenanceStatus{
+				Route:     src,
+				Enabled:   rs.maintenance,
+				Message:   rs.maintenanceMessage,
+				Scheduled: rs.scheduledMaintenance(),
+				Windows:   rs.rule.MaintenanceWindows,
+			})
+			rs.lock.RUnlock()
+		}
+		writeJSON(w, list)
+		return
+	} else if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var mr maintenanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&mr); err != nil {
+		http.Error(w, "invalid maintenance request", http.StatusBadRequest)
+		return
+	}
+
+	rs, ok := a.routes[mr.Route]
+	if !ok {
+		http.Error(w, "route not found", http.StatusNotFound)
+		return
+	}
+
+	rs.setMaintenance(mr.Enabled, mr.Message)
+	a.Printf("maintenance route=%s enabled=%v close_sessions=%v", mr.Route, mr.Enabled, mr.CloseSessions)
+	a.audit(r, "maintenance", mr)
+	proxyEvents.publish(proxyEvent{Type: eventMaintenance, Route: mr.Route, Data: mr})
+
+	// close existing connections, clients should reconnect after maintenance
+	if mr.Enabled && mr.CloseSessions {
+		for _, s := range a.sessions.find(sessionFilter{Route: mr.Route}) {
+			s.ws.closeWith(websocket.CloseTryAgainLater, "maintenance")
+		}
+	}
+
+	writeJSON(w, maintenanceStatus{Route: mr.Route, Enabled: mr.Enabled, Message: mr.Message})
+}
+
+// tagRequest is a body of /admin/tags request.
+type tagRequest struct {
+	Session string   `json:"session"`
+	Tags    []string `json:"tags"`
+}
+
+// tagSession marks session with tags for /admin/broadcast and /debug/conns search.
+// Example: curl -H 'Content-Type: application/json' -d '{"session":"42","tags":["vip"]}' http://localhost:8090/admin/tags
+func (a *App) tagSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var tr tagRequest
+	if err := json.NewDecoder(r.Body).Decode(&tr); err != nil || len(tr.Tags) == 0 {
+		http.Error(w, "invalid tags request", http.StatusBadRequest)
+		return
+	}
+
+	s, ok := a.sessions.get(tr.Session)
+	if !ok {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+
+	for _, tag := range tr.Tags {
+		s.addAdminTag(tag)
+	}
+
+	a.Printf("tagged session=%s tags=%v", tr.Session, tr.Tags)
+	a.audit(r, "tags", tr)
+	writeJSON(w, s.tagList())
+}
+
+// switchRequest is a body of /admin/switch request.
+type switchRequest struct {
+	Route string `json:"route"`
+	To    string `json:"to"` // blue or green
+}
+
+// switchStatus is a result of blue/green switchover.
+type switchStatus struct {
+	Route    string `json:"route"`
+	Active   string `json:"active"`
+	DstUrl   string `json:"dstUrl"`
+	Previous string `json:"previous"`
+	Drained  bool   `json:"drained"`
+	InFlight int    `json:"inFlight"` // requests to previous destination at switch
+}
+
+// switchRoute switches blue/green route destination. In-flight requests to previous destination are drained
+// in background up to request timeout, so switch is accepted with 202 and drain result is logged.
+// Example: curl -H 'Content-Type: application/json' -d '{"route":"/rpc","to":"green"}' http://localhost:8090/admin/switch
+func (a *App) switchRoute(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var sr switchRequest
+	if err := json.NewDecoder(r.Body).Decode(&sr); err != nil || (sr.To != colorBlue && sr.To != colorGreen) {
+		http.Error(w, "invalid switch request", http.StatusBadRequest)
+		return
+	}
+
+	rs, ok := a.routes[sr.Route]
+	if !ok {
+		http.Error(w, "route not found", http.StatusNotFound)
+		return
+	}
+
+	prev, err := rs.switchTo(sr.To)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	a.Printf("switched route=%s from=%s to=%s dst=%s", sr.Route, prev, sr.To, rs.colorUrl(sr.To))
+	a.audit(r, "switch", sr)
+	proxyEvents.publish(proxyEvent{Type: eventSwitch, Route: sr.Route, Data: map[string]string{"active": sr.To, "prev": prev}})
+
+	st := switchStatus{Route: sr.Route, Active: sr.To, DstUrl: rs.colorUrl(sr.To), Previous: prev, Drained: true}
+	if prev == sr.To {
+		writeJSON(w, st)
+		return
+	}
+
+	st.InFlight = rs.inFlight(prev)
+	st.Drained = st.InFlight == 0
+	go func() {
+		if n := rs.drain(prev, time.Duration(a.Timeout)*time.Second); n > 0 {
+			a.Errorf("switch drain timeout route=%s color=%s in_flight=%d", sr.Route, prev, n)
+		} else {
+			a.Printf("switch drained route=%s color=%s", sr.Route, prev)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(st)
+}
+
+type routeInfo struct {
+	Src                 string   `json:"src"`
+	DstUrl              string   `json:"dstUrl"`
+	Active              string   `json:"active,omitempty"` // blue or green for blue/green routes
+	AllowedHeaders      []string `json:"allowedHeaders"`
+	Timeout             int      `json:"timeout"`
+	MaxParallelRequests int      `json:"maxParallelRequests"`
+	MaxClientRequests   int      `json:"maxClientRequests"`
+	RateLimit           float64  `json:"rateLimit,omitempty"` // route requests per second
+	Maintenance         bool     `json:"maintenance"`
+	Draining            bool     `json:"draining"`
+	Sessions            int      `json:"sessions"`
+	Health              struct {
+		LastStatus  string     `json:"lastStatus,omitempty"` // ok, timeout, dns_error, connection_refused, tls_error, error
+		LastRequest *time.Time `json:"lastRequest,omitempty"`
+	} `json:"health"`
+}
+
+// routeList returns active routing table with route settings and passive backend health.
+// Example: curl http://localhost:8090/admin/routes
+func (a *App) routeList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	list := []routeInfo{}
+	for src, rs := range a.routes {
+		ri := routeInfo{
+			Src:               src,
+			DstUrl:            rs.rule.DstUrl,
+			MaxClientRequests: a.MaxClientRequests,
+			RateLimit:         rs.rule.RateLimit,
+			Sessions:          len(a.sessions.find(sessionFilter{Route: src})),
+		}
+		ri.AllowedHeaders, ri.Timeout, ri.MaxParallelRequests = a.routeSettings(src)
+
+		if rs.rule.GreenUrl != "" {
+			ri.Active = rs.activeColor()
+			ri.DstUrl = rs.colorUrl(ri.Active)
+		}
+
+		rs.lock.RLock()
+		ri.Maintenance = rs.maintenance || rs.scheduledMaintenance()
+		ri.Draining = !rs.drainSince.IsZero()
+		ri.Health.LastStatus = rs.lastStatus
+		if !rs.lastRequest.IsZero() {
+			t := rs.lastRequest
+			ri.Health.LastRequest = &t
+		}
+		rs.lock.RUnlock()
+
+		list = append(list, ri)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Src < list[j].Src })
+	writeJSON(w, list)
+}
+
+// writeJSON marshals v as response body.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,214 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestAdminHandler(t *testing.T) {
+	a := &App{AdminToken: "secret"}
+	h := a.adminHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	do := func(method, contentType, authorization string) int {
+		r := httptest.NewRequest(method, "/admin/broadcast", strings.NewReader(`{}`))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := do(http.MethodPost, "application/json; charset=utf-8", "Bearer secret"); code != http.StatusOK {
+		t.Errorf("json: got %d", code)
+	}
+	if code := do(http.MethodGet, "", "Bearer secret"); code != http.StatusOK {
+		t.Errorf("get: got %d", code)
+	}
+	if code := do(http.MethodPost, "application/json", "Bearer other"); code != http.StatusUnauthorized {
+		t.Errorf("wrong token: got %d", code)
+	}
+	if code := do(http.MethodGet, "", ""); code != http.StatusUnauthorized {
+		t.Errorf("no token: got %d", code)
+	}
+
+	// cross-site form posts can't send json
+	for _, ct := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+		if code := do(http.MethodPost, ct, "Bearer secret"); code != http.StatusUnsupportedMediaType {
+			t.Errorf("%q: got %d", ct, code)
+		}
+	}
+
+	// separate listener without token
+	a.AdminToken = ""
+	if code := do(http.MethodPost, "application/json", ""); code != http.StatusOK {
+		t.Errorf("no admin token: got %d", code)
+	}
+}
+
+func TestAdminBroadcast(t *testing.T) {
+	a := &App{sessions: newSessionRegistry()}
+	received := make(map[string][]string)
+	for _, s := range []*session{
+		{id: "a", route: "/rpc", tags: map[string]struct{}{"vip": {}}},
+		{id: "b", route: "/v1", tags: map[string]struct{}{}},
+		{id: "c", route: "/rpc", tags: map[string]struct{}{}},
+	} {
+		s := s
+		s.send = func(msg []byte) error {
+			if s.id == "c" {
+				return errQueueClosed
+			}
+			received[s.id] = append(received[s.id], string(msg))
+			return nil
+		}
+		a.sessions.add(s)
+	}
+
+	tests := []struct {
+		name, body string
+		code       int
+		resp       broadcastResponse
+		received   []string
+	}{
+		{"all", `{"method":"maintenance","params":{"in":300}}`, http.StatusOK, broadcastResponse{Total: 3, Delivered: 2, Failed: 1}, []string{"a", "b"}},
+		{"route", `{"method":"maintenance","route":"/v1"}`, http.StatusOK, broadcastResponse{Total: 1, Delivered: 1}, []string{"b"}},
+		{"tag", `{"method":"maintenance","tag":"vip"}`, http.StatusOK, broadcastResponse{Total: 1, Delivered: 1}, []string{"a"}},
+		{"session", `{"method":"maintenance","session":"b"}`, http.StatusOK, broadcastResponse{Total: 1, Delivered: 1}, []string{"b"}},
+		{"no sessions", `{"method":"maintenance","route":"/v2"}`, http.StatusOK, broadcastResponse{}, nil},
+		{"no method", `{"route":"/rpc"}`, http.StatusBadRequest, broadcastResponse{}, nil},
+	}
+
+	for _, tt := range tests {
+		received = make(map[string][]string)
+		w := httptest.NewRecorder()
+		a.broadcast(w, httptest.NewRequest(http.MethodPost, "/admin/broadcast", strings.NewReader(tt.body)))
+
+		var resp broadcastResponse
+		if w.Code == http.StatusOK {
+			json.Unmarshal(w.Body.Bytes(), &resp)
+		}
+		if w.Code != tt.code || resp != tt.resp || len(received) != len(tt.received) {
+			t.Errorf("%s: got %d %+v, received %v", tt.name, w.Code, resp, received)
+		}
+		for _, id := range tt.received {
+			if len(received[id]) != 1 || !strings.HasPrefix(received[id][0], `{"jsonrpc":"2.0","method":"maintenance"`) {
+				t.Errorf("%s: session %s got %v", tt.name, id, received[id])
+			}
+		}
+	}
+}
+
+func TestAdminMaintenance(t *testing.T) {
+	var calls int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":true}`))
+	}))
+	defer backend.Close()
+
+	routes, err := newRouteStates([]ProxyRule{{Src: "/rpc", DstUrl: backend.URL, DisableDebug: true}}, clock.Real)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &App{routes: routes, sessions: newSessionRegistry()}
+	hf := NewHttpForwarder(backend.URL, nil, 10, 1)
+	hf.route = routes["/rpc"]
+	rf := hf.newRequestForwarder(&wsConn{})
+
+	tests := []struct {
+		name, body string
+		code       int
+		resp       string // response of request after admin request, empty for rejected admin request
+		calls      int32
+	}{
+		{"enable", `{"route":"/rpc","enabled":true,"message":"back in 5 minutes"}`, http.StatusOK, `{"jsonrpc":"2.0","id":1,"error":{"code":-32001,"message":"back in 5 minutes"}}`, 0},
+		{"unknown route", `{"route":"/v1","enabled":true}`, http.StatusNotFound, "", 0},
+		{"invalid", `{"route":`, http.StatusBadRequest, "", 0},
+		{"disable", `{"route":"/rpc","enabled":false}`, http.StatusOK, `{"jsonrpc":"2.0","id":1,"result":true}`, 1},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		a.maintenance(w, httptest.NewRequest(http.MethodPost, "/admin/maintenance", strings.NewReader(tt.body)))
+		if w.Code != tt.code {
+			t.Errorf("%s: got %d", tt.name, w.Code)
+		}
+		if tt.resp == "" {
+			continue
+		}
+
+		replies := make(chan string, 1)
+		hf.handleRequest(rf, []byte(`{"jsonrpc":"2.0","method":"get","id":1}`), func(resp []byte) error {
+			replies <- string(resp)
+			return nil
+		})
+		if resp := <-replies; resp != tt.resp || atomic.LoadInt32(&calls) != tt.calls {
+			t.Errorf("%s: got %s, %d backend calls", tt.name, resp, atomic.LoadInt32(&calls))
+		}
+	}
+
+	// status of all routes
+	w := httptest.NewRecorder()
+	a.maintenance(w, httptest.NewRequest(http.MethodGet, "/admin/maintenance", nil))
+	if body := strings.TrimSpace(w.Body.String()); body != `[{"route":"/rpc","enabled":false}]` {
+		t.Errorf("got %s", body)
+	}
+}
+
+func TestAdminRouteList(t *testing.T) {
+	c := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	routes, err := newRouteStates([]ProxyRule{
+		{Src: "/rpc", DstUrl: "http://blue/rpc", GreenUrl: "http://green/rpc", Timeout: 30, RateLimit: 100},
+		{Src: "/v1", DstUrl: "http://v1", Headers: []string{"X-Token"}, MaxParallelRequests: 2},
+	}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &App{routes: routes, sessions: newSessionRegistry(), Headers: []string{"X-User"}, Timeout: 10, MaxParallelRequests: 5, MaxClientRequests: 100}
+	a.sessions.add(&session{id: "a", route: "/rpc", tags: make(map[string]struct{})})
+	routes["/rpc"].switchTo(colorGreen)
+	routes["/v1"].setMaintenance(true, "")
+	routes["/v1"].observe("timeout")
+
+	w := httptest.NewRecorder()
+	a.routeList(w, httptest.NewRequest(http.MethodGet, "/admin/routes", nil))
+
+	var list []routeInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list) != 2 {
+		t.Fatalf("got %s %v", w.Body, err)
+	}
+
+	tests := []struct {
+		got, want string
+	}{
+		{list[0].Src + " " + list[0].DstUrl + " " + list[0].Active, "/rpc http://green/rpc green"},
+		{fmt.Sprint(list[0].AllowedHeaders, list[0].Timeout, list[0].MaxParallelRequests, list[0].MaxClientRequests, list[0].RateLimit), "[X-User] 30 5 100 100"},
+		{fmt.Sprint(list[0].Sessions, list[0].Maintenance, list[0].Health.LastRequest == nil), "1 false true"},
+		{list[1].Src + " " + list[1].DstUrl + " " + list[1].Active, "/v1 http://v1 "},
+		{fmt.Sprint(list[1].AllowedHeaders, list[1].Timeout, list[1].MaxParallelRequests), "[X-Token] 10 2"},
+		{fmt.Sprintf("%d %t %s %t", list[1].Sessions, list[1].Maintenance, list[1].Health.LastStatus, list[1].Health.LastRequest.Equal(c.Now())), "0 true timeout true"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("got %q, want %q", tt.got, tt.want)
+		}
+	}
+
+	// routes are read only
+	w = httptest.NewRecorder()
+	a.routeList(w, httptest.NewRequest(http.MethodPost, "/admin/routes", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("post: got %d", w.Code)
+	}
+}
@@ -3,70 +3,481 @@ package app
 import (
 	"errors"
 	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"golang.org/x/net/websocket"
+	"github.com/semrush/ws2http/clock"
 )
 
 type ProxyRule struct {
 	Src, DstUrl string
+
+	AuthUrl        string   // forward auth url for route, checked on connect
+	AuthPerRequest bool     // check AuthUrl for every request, always true in multi mode
+	AuthHeaders    []string // auth response headers passed to backend, like X-User
+	TagHeaders     []string // auth headers with comma-separated session tags, like X-Roles, must be in AuthHeaders
+
+	MethodCase    string            // method case normalization: lower, upper or empty
+	MethodAliases map[string]string // method aliases applied after case normalization, like getUser -> users.get
+
+	RequestTemplate string // text/template for backend request envelope, see requestTemplateData
+	Protocol        string // backend protocol: jsonrpc (default), xmlrpc or soap
+
+	SoapActions    map[string]string // method -> SOAPAction, default is method
+	SoapResultPath string            // path to result element in soap response, like Envelope/Body/*/Result
+
+	DisableDebug bool // exclude route traffic from /debug/conns tracing
+
+	MirrorUrl     string  // secondary backend for shadow traffic, responses are ignored
+	MirrorPercent float64 // percent of requests mirrored to MirrorUrl, 0-100
+
+	CanaryUrl     string  // canary backend for part of route traffic
+	CanaryPercent float64 // percent of sessions routed to CanaryUrl, 0-100
+	CanaryTag     string  // session tag routing session requests to CanaryUrl, like beta
+	CanaryHeader  string  // request header routing request to CanaryUrl if present, like X-Canary
+
+	MaxBodySize int // max forwarded request size in bytes, larger requests are rejected with -32600, 0 is unlimited
+
+	GreenUrl string // second destination for blue/green deploys, DstUrl is blue, switched by /admin/switch
+
+	SlowStart time.Duration // traffic ramp of destination after recovery from outage, requests over share are rejected with -32007
+
+	RateLimit float64 // max requests per second of route over all connections, requests over limit are rejected with -32029, 0 is unlimited
+	RateBurst int     // requests accepted at once over RateLimit, 0 is one second of RateLimit
+
+	MaintenanceWindows []string // scheduled maintenance windows, like "mon-fri 02:00-04:00 Europe/Moscow", UTC by default
+	MaintenanceMessage string   // error message for requests in maintenance windows, default is errMaintenance
+
+	CountNotifications bool // count notifications in proxy_notifications_total instead of requests metrics
+
+	// route handler overrides of App settings, "/" multiple rules handler uses App settings
+	Timeout             int      // backend request timeout in seconds, 0 is App.Timeout
+	Headers             []string // allowed session headers, nil is App.Headers
+	MaxParallelRequests int      // max parallel backend requests per connection, 0 is App.MaxParallelRequests
+	MaxResponseSize     int      // max backend response size in bytes, 0 is App.MaxResponseSize
+
+	// backend TLS client settings, backend certificates are verified with system roots by default
+	TlsCaFile     string // backend CA certificates in PEM instead of system roots
+	TlsCertFile   string // client certificate in PEM for mutual TLS
+	TlsKeyFile    string // client certificate key in PEM
+	TlsServerName string // expected backend certificate name, default is url host
+	TlsInsecure   bool   // skip backend certificate verification, explicit opt-in for self-signed backends
 }
 
 type App struct {
 	AppName                      string
 	ListenAddr                   string
+	AdminAddr                    string        // separate listener for /admin/ handlers, optional
+	AdminToken                   string        // bearer token required by /admin/ handlers, admin handlers on websocket listener need it
+	TlsCertFile, TlsKeyFile      string        // listener certificate and key in PEM, wss:// is served if set
+	TlsReload                    time.Duration // check listener certificate files for changes, 0 disables reload
+	Banner                       string        // startup banner template, like "{{.AppName}} at {{.ListenAddr}}"
 	RedirectRules                []ProxyRule
 	Headers                      []string
+	Http2WsRoutes                []Http2WsRoute // http endpoints forwarding json-rpc POST requests to websocket-only backends
+	LocaleHeaders                []string       // handshake headers forwarded with every backend request, like Accept-Language
+	ForwardedHeaders             string         // client X-Forwarded-For mode: ForwardedOverwrite, ForwardedAppend or empty
+	ExtensionMembers             []string       // allowed client json-rpc extension members, like meta, empty keeps any member
+	MaxHeaders, MaxHeadersSize   int            // session headers count and total size limits for SET, 0 is unlimited
+	TokenExpiryNotice            time.Duration  // notify clients before JWT expiry and reject requests after, 0 is disabled
+	DeadlineHeader               string         // backend header with remaining request time in ms, like X-Request-Timeout-Ms
+	QueueHeader                  string         // backend header with time in ms request waited in proxy, like X-WS2HTTP-Queue-Ms
+	RetryAfterHold               time.Duration  // max delay of method requests after backend 429/503 with Retry-After, 0 is disabled
+	ErrorBackendHost             bool           // expose backend host in JsonRpcErrData, omitted by default
+	SloObjectives                []SloObjective // method latency objectives, violations are counted in slo_violation_total
+	CacheRules                   []CacheRule    // cacheable methods with result ttl and size limit
+	CacheUrl                     string         // response cache, like memory://?entries=10000 or redis://localhost:6379/0, empty is memory
+	CacheInvalidateUrl           string         // redis channel of invalidation messages, like redis://localhost:6379/0?channel=ws2http:cache:invalidate
+	ShutdownGrace                time.Duration  // time for clients to reconnect after ws2http.shutdown notification
+	ReconnectUrl                 string         // suggested reconnect endpoint in ws2http.shutdown notification
+	DrainTimeout                 time.Duration  // wait for in-flight backend requests on shutdown after ShutdownGrace, 0 doesn't wait
+	MessageIds                   IdGenerator    // messageId of proxy notifications, like SequenceIds, nil sends no ids
+	ClusterUrl                   string         // session registry shared by instances, like redis://localhost:6379/0
+	AdvertiseUrl                 string         // instance admin url for other instances, like http://10.0.0.1:8090
+	InstanceId                   string         // instance id for metrics, logs, close frames and session ids, default is hostname
+	Zone                         string         // instance zone or datacenter for metrics, logs and close frames
 	Timeout, MaxParallelRequests int
+	MaxClientRequests            int      // max outstanding requests per connection, 0 is unlimited
+	MaxResponseSize              int      // max backend response size in bytes, larger responses are rejected with -32012, 0 is unlimited
+	RateLimit                    float64  // max requests per second per connection, requests over limit are rejected with -32029, 0 is unlimited
+	RateBurst                    int      // requests accepted at once over RateLimit, 0 is one second of RateLimit
+	MaxConnections               int      // max websocket connections of instance, further upgrades are rejected with 503, 0 is derived from open files limit
+	MaxIdleConnsPerHost          int      // idle backend connections per host, 0 is derived from open files limit up to 128
+	BackendSlots                 int      // max parallel requests per backend url for all connections, fair queuing between connections, 0 is unlimited
+	CookieJar                    bool     // store backend cookies per connection
+	BrowserMode                  bool     // enforce AllowedOrigins and csrf handshake with CsrfCookie
+	AllowedOrigins               []string // exact, wildcard like https://*.example.com or ~regex origins, checked if Origin is sent outside browser mode
+	CsrfCookie                   string
+	FeatureRules                 []FeatureRule // request features enabled by client header, token claim or session tag
+	Codec                        string        // default codec name for client frames, see RegisterCodec
+	MqttBridge                   bool          // enable MQTT-over-WebSocket sessions by mqtt subprotocol
+	Stomp                        bool          // enable STOMP sessions by v1x.stomp subprotocols
+	SlowClientGrace              time.Duration // disconnect clients with full send queue after grace period, 0 is disabled
+	LeakGrace                    time.Duration // report connection goroutines running after grace since disconnect, 0 is disabled
+	CorrelationTtl               time.Duration // track request ids per connection to report orphan backend responses, 0 is disabled
+	ControlAcks                  bool          // acknowledge control messages (SET, AUTH, TAG, CSRF)
+	WriteTimeout                 time.Duration // write deadline for every frame sent to client, 0 is disabled
+	PingInterval                 time.Duration // ping frames period, clients without pong for two periods are disconnected, 0 is disabled
+	ReadBufferSize               int           // websocket connection read buffer in bytes, 0 is default 4096
+	WriteBufferSize              int           // websocket connection write buffer in bytes, 0 is default 4096
+	TcpReadBuffer                int           // client socket receive buffer (SO_RCVBUF) in bytes, 0 is system default
+	TcpWriteBuffer               int           // client socket send buffer (SO_SNDBUF) in bytes, 0 is system default
+	TcpDelay                     bool          // disable TCP_NODELAY for client connections to batch small frames
+	Acceptors                    int           // listening sockets with SO_REUSEPORT and independent accept loops, 0 or 1 is single listener
+	KeepAliveMethod              string        // json-rpc method for backend keep-alive probes, like system.ping, empty is disabled
+	KeepAliveInterval            time.Duration // interval between keep-alive probes
+	HealthCheckInterval          time.Duration // interval between active backend health probes for /readyz and /admin/backends, 0 is disabled
+	HealthCheckPath              string        // backend path of GET health probes, like /health, empty posts HealthCheckMethod notification
+	HealthCheckMethod            string        // json-rpc method of POST health probes to DstUrl, like system.ping
+	CaptureMaxAge                time.Duration // retention of captured traffic for /debug/conns/export
+	CaptureMaxSize               int           // max captured traffic bytes, 0 disables capture
+	StorageUrl                   string        // storage for saved captures and audit logs, like /var/lib/ws2http or s3://bucket
+	MetricsSnapshot              []string      // counters saved to storage and restored at startup, like proxy_requests_total
+	MetricsSnapshotInterval      time.Duration // interval between metrics snapshots, default is 1m
+	MetricsSnapshotName          string        // stable snapshot name across restarts, required with MetricsSnapshot, like ws2http-0
+	DebugEventsBuffer            int           // debug traffic events buffer, events are dropped on overflow, default is 1000
+	DebugTraceBuffer             int           // buffer per /debug/conns tracer, default is 1000
+	DebugTemplatesDir            string        // overrides for debug UI: index.html, trace.html, stats.html and static/ assets
+	DebugBasePath                string        // reverse proxy path prefix for debug UI links, like /ws2http
+	EndpointPrefix               string        // path prefix for /metrics, /debug/ and /admin/ endpoints, like /_ws2http
+	Cors                         CorsConfig    // CORS for /metrics, /debug/ and /admin/ endpoints, disabled without origins
+	UpgradeHooks                 []UpgradeHook
+	UpgradeRejectStatus          int         // http status for rejected upgrades, default is 403
+	Clock                        clock.Clock // time source for keepalives, heartbeats, token expiry and slow-start, default is system clock
 
 	logger
 
+	sessions      *sessionRegistry
+	allowNoOrigin bool     // upgrades without Origin are allowed by origin check hook
+	instance      instance // identity of instance, set by Run
+	routes        map[string]*routeState
+	shutdownState *shutdownState
+	healthChecker *healthChecker
+	snapshots     *metricsSnapshotter
+	cache         *methodCache      // response cache of CacheRules, nil is disabled
+	slots         *destinationSlots // BackendSlots per destination
+	captures      *captureStore     // traffic captured for debug export
+	slos          *sloTracker       // method latencies for /admin/slo
+	upstreams     []*wsUpstream     // http2ws upstream connections
+	conns         int32             // open websocket connections for MaxConnections
+	listening     int32             // 1 while listener accepts connections, for /healthz and /readyz
+
 	statBackendRequests  *prometheus.CounterVec
 	statBackendDurations *prometheus.SummaryVec
 	statActiveConns      *prometheus.GaugeVec
+	statConnsMax         prometheus.Gauge
+	statConnsRejected    prometheus.Counter
+	statOriginRejected   *prometheus.CounterVec
+	statCacheRequests    *prometheus.CounterVec
+	statRateLimited      *prometheus.CounterVec
+	statBackendHealthy   *prometheus.GaugeVec
+	statHealthLatency    *prometheus.GaugeVec
+	statSlowClients      *prometheus.CounterVec
+	statGoroutineLeaks   *prometheus.CounterVec
+	statBackendPhases    *prometheus.HistogramVec
+	statDebugDropped     *prometheus.CounterVec
+	statBackendVersions  *prometheus.CounterVec
+	statBodySizes        *prometheus.HistogramVec
+	statSloViolations    *prometheus.CounterVec
+	statSlotsQueued      *prometheus.GaugeVec
+	statSlotsInUse       *prometheus.GaugeVec
+	statSlotWaits        *prometheus.HistogramVec
+	statOrphanResponses  *prometheus.CounterVec
+	statNotifications    *prometheus.CounterVec
+	pool                 *poolStats
+	storage              Storage
+	serverLock           sync.Mutex
+	server               *http.Server  // nil before serve and after Shutdown
+	stopped              chan struct{} // closed after Shutdown
 }
 
-var ErrNoEndpoints = errors.New("no endpoints were defined")
+// clock returns App.Clock or system clock if it is not set.
+func (a *App) clock() clock.Clock {
+	return clock.OrReal(a.Clock)
+}
+
+// clockSetter is implemented by plugins with own timers, like memory cache and redis registry, so they use App.Clock.
+type clockSetter interface {
+	setClock(c clock.Clock)
+}
+
+var (
+	ErrNoEndpoints    = errors.New("no endpoints were defined")
+	ErrUnknownCodec   = errors.New("unknown codec")
+	ErrUnknownStorage = errors.New("unknown storage scheme")
+	ErrUnknownCluster = errors.New("unknown cluster registry scheme")
+)
 
 // Run runs web server with specified redirect rules.
 func (a *App) Run() error {
 	if len(a.RedirectRules) == 0 {
 		return ErrNoEndpoints
+	} else if _, ok := lookupCodec(a.Codec); a.Codec != "" && !ok {
+		return ErrUnknownCodec
+	} else if err := a.Cors.Validate(); err != nil {
+		return err
 	}
 
+	a.instance = newInstance(a.InstanceId, a.Zone)
+	a.logger = a.logger.withFields("instance", a.instance.id, "zone", a.instance.zone)
+
+	if err := a.printBanner(); err != nil {
+		return err
+	}
+
+	a.checkFileLimit()
 	a.registerMetrics()
+	if a.StorageUrl != "" {
+		storage, err := OpenStorage(a.StorageUrl)
+		if err != nil {
+			return err
+		}
+		a.storage = storage
+	}
+	a.captures = newCaptureStore(a.CaptureMaxAge, a.CaptureMaxSize, a.storage, a.clock())
+
+	debug.statDropped = a.statDebugDropped
+	debug.clock = a.clock()
+	debug.captures = a.captures
+	debug.start(a.DebugEventsBuffer, a.DebugTraceBuffer)
+	debug.basePath = strings.TrimSuffix(a.DebugBasePath, "/") + a.endpoint("")
+	if a.DebugTemplatesDir != "" {
+		if err := debug.loadTemplates(a.DebugTemplatesDir); err != nil {
+			return err
+		}
+	}
+
+	if len(a.MetricsSnapshot) > 0 {
+		if err := a.startMetricsSnapshot(); err != nil {
+			return err
+		}
+	}
+	a.slos = newSloTracker(a.SloObjectives, a.statSloViolations, a.clock())
+	if len(a.CacheRules) > 0 {
+		store, err := OpenResponseCache(a.CacheUrl)
+		if err != nil {
+			return err
+		}
+		if cs, ok := store.(clockSetter); ok {
+			cs.setClock(a.clock())
+		}
+		a.cache = newMethodCache(a.CacheRules, store, a.statCacheRequests, a.logger)
+		if a.CacheInvalidateUrl != "" {
+			if err := a.subscribeCacheInvalidation(a.CacheInvalidateUrl); err != nil {
+				return err
+			}
+		}
+	}
+	a.slots = newDestinationSlots(a.BackendSlots, a.statSlotsQueued, a.statSlotsInUse, a.statSlotWaits, a.clock())
+
+	if err := a.initRoutes(); err != nil {
+		return err
+	}
+	hc, err := newHealthChecker(a.routes, a.HealthCheckPath, a.HealthCheckMethod, a.HealthCheckInterval, time.Duration(a.Timeout)*time.Second, a.clock(), a.logger)
+	if err != nil {
+		return err
+	} else if hc != nil {
+		hc.setStats(a.statBackendHealthy, a.statHealthLatency)
+		hc.start()
+		a.healthChecker = hc
+	}
 
+	if err := a.registerAdmin(); err != nil {
+		return err
+	}
+	http.Handle(a.endpoint("/debug/"), corsHandler(a.Cors, http.StripPrefix(a.endpoint(""), debug.handler())))
+
+	ch, err := a.clientHandler()
+	if err != nil {
+		return err
+	}
+	http.Handle(a.endpoint("/client.js"), ch)
+	http.Handle(a.endpoint("/client.d.ts"), ch)
+	a.handleHealth(http.DefaultServeMux)
+
+	a.handleRoutes(http.DefaultServeMux)
+	if err := a.handleHttp2Ws(http.DefaultServeMux); err != nil {
+		return err
+	}
+
+	// start server
+	scheme := "http"
+	if a.TlsCertFile != "" {
+		scheme = "https"
+	}
+	a.Printf("starting http listener at %s://%s\n", scheme, a.ListenAddr)
+	return a.serve()
+}
+
+// Handler returns websocket handler for RedirectRules without listeners, metrics, admin and debug endpoints,
+// like in-process proxy for integration tests, see ws2httptest package.
+func (a *App) Handler() (http.Handler, error) {
+	if len(a.RedirectRules) == 0 {
+		return nil, ErrNoEndpoints
+	} else if _, ok := lookupCodec(a.Codec); a.Codec != "" && !ok {
+		return nil, ErrUnknownCodec
+	}
+
+	if err := a.initRoutes(); err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	a.handleRoutes(mux)
+	return mux, nil
+}
+
+// initRoutes initializes sessions registry, route states and upgrade hooks.
+func (a *App) initRoutes() error {
+	// check origin before other hooks
+	if hook, err := a.originCheckHook(); err != nil {
+		return err
+	} else if hook != nil {
+		a.UpgradeHooks = append([]UpgradeHook{hook}, a.UpgradeHooks...)
+		a.allowNoOrigin = !a.BrowserMode
+	}
+
+	a.sessions = newSessionRegistry()
+	a.shutdownState = &shutdownState{}
+	if a.ClusterUrl != "" {
+		cluster, err := OpenClusterRegistry(a.ClusterUrl)
+		if err != nil {
+			return err
+		}
+		a.sessions.idPrefix = newSessionIdPrefix(a.instance.id)
+		a.sessions.setCluster(cluster, a.AdvertiseUrl, a.clock(), a.logger)
+	}
+
+	routes, err := newRouteStates(a.RedirectRules, a.clock())
+	if err != nil {
+		return err
+	}
+	a.routes = routes
+
+	return nil
+}
+
+// handleRoutes adds websocket handlers for RedirectRules to mux.
+func (a *App) handleRoutes(mux *http.ServeMux) {
 	// set redirect rules, handle specific endpoint
 	for _, r := range a.RedirectRules {
 		hf := a.newHttpForwarder(r.Src, r.DstUrl)
-		http.Handle(r.Src, websocket.Handler(hf.Handler))
+		hf.StartKeepAlive(a.KeepAliveMethod, a.KeepAliveInterval)
+		mux.Handle(r.Src, a.connLimitHandler(a.drainHandler(r.Src, a.upgradeHandler(a.routeAuthHandler(r, hf.authClient, a.bufferHandler(hf.WebsocketHandler()))))))
 	}
 
 	// handle all src:dstUrl endpoint in one / handler
 	ghf := a.newHttpForwarder("/", "*", a.RedirectRules...)
-	http.Handle("/", websocket.Handler(ghf.Handler))
-
-	// start server
-	a.Printf("starting http listener at http://%s\n", a.ListenAddr)
-	return http.ListenAndServe(a.ListenAddr, nil)
+	ghf.StartKeepAlive(a.KeepAliveMethod, a.KeepAliveInterval)
+	mux.Handle("/", a.connLimitHandler(a.upgradeHandler(a.bufferHandler(ghf.WebsocketHandler()))))
 }
 
 func (a *App) newHttpForwarder(src, dstUrl string, rule ...ProxyRule) *HttpForwarder {
-	a.Printf("adding rule from=ws://%s%s to=%s, allowed_headers=%s timeout=%ds parallel_requests=%d", a.ListenAddr, src, dstUrl, a.Headers, a.Timeout, a.MaxParallelRequests)
+	headers, timeout, parallel := a.routeSettings(src)
+	if len(rule) > 0 {
+		headers, timeout, parallel = a.Headers, a.Timeout, a.MaxParallelRequests
+	}
+	a.Printf("adding rule from=ws://%s%s to=%s, allowed_headers=%s timeout=%ds parallel_requests=%d", a.ListenAddr, src, dstUrl, headers, timeout, parallel)
 
-	hf := NewHttpForwarder(dstUrl, a.Headers, a.Timeout, a.MaxParallelRequests)
+	hf := NewHttpForwarder(dstUrl, headers, timeout, parallel)
 	hf.SetLoggers(a.warn, a.log, a.trace)
+	hf.SetClock(a.Clock)
 	hf.SetLogLevel(a.logLevel)
+	hf.SetMaxClientRequests(a.MaxClientRequests)
+	hf.SetMaxResponseSize(a.MaxResponseSize)
+	hf.SetRateLimit(a.RateLimit, a.RateBurst)
+	hf.SetFeatureRules(a.FeatureRules)
+	hf.SetCookieJar(a.CookieJar)
+	hf.SetIdleConnsPerHost(a.MaxIdleConnsPerHost)
+	hf.SetLocaleHeaders(a.LocaleHeaders)
+	hf.SetForwardedHeaders(a.ForwardedHeaders)
+	hf.SetExtensionMembers(a.ExtensionMembers)
+	hf.SetHeaderLimits(a.MaxHeaders, a.MaxHeadersSize)
+	hf.SetTokenExpiry(a.TokenExpiryNotice)
+	hf.SetMessageIds(a.MessageIds)
+	hf.SetDeadlineHeader(a.DeadlineHeader)
+	hf.SetQueueHeader(a.QueueHeader)
+	hf.SetRetryAfterHold(a.RetryAfterHold)
+	hf.SetErrorBackendHost(a.ErrorBackendHost)
+	hf.SetMqttBridge(a.MqttBridge)
+	hf.SetStomp(a.Stomp)
+	hf.SetSlowClientGrace(a.SlowClientGrace)
+	hf.SetLeakGrace(a.LeakGrace)
+	hf.SetCorrelationTtl(a.CorrelationTtl)
+	hf.SetControlAcks(a.ControlAcks)
+	hf.SetAllowMissingOrigin(a.allowNoOrigin)
+	hf.SetWriteTimeout(a.WriteTimeout)
+	hf.SetPingInterval(a.PingInterval)
+	if c, ok := lookupCodec(a.Codec); ok {
+		hf.SetCodec(c)
+	}
+	if a.BrowserMode {
+		hf.SetBrowserMode(a.CsrfCookie)
+	}
 	hf.SetStats(a.statBackendRequests, a.statBackendDurations, a.statActiveConns)
+	hf.statSlowClients = a.statSlowClients
+	hf.statGoroutineLeaks = a.statGoroutineLeaks
+	hf.statBackendPhases = a.statBackendPhases
+	hf.statBackendVersions = a.statBackendVersions
+	hf.statBodySizes = a.statBodySizes
+	hf.statOrphanResponses = a.statOrphanResponses
+	hf.statNotifications = a.statNotifications
+	hf.statRateLimited = a.statRateLimited
+	hf.setPoolStats(a.pool)
+	hf.sessions = a.sessions
+	hf.shutdown = a.shutdownState
+	hf.instance = a.instance
+	hf.routes = a.routes
+	hf.cache = a.cache
+	hf.slots = a.slots
+	hf.slos = a.slos
 
 	if len(rule) > 0 {
 		hf.SetMultiMode(rule)
+		for _, r := range rule {
+			if rs := a.routes[r.Src]; rs != nil && rs.tlsConfig != nil {
+				hf.setRouteTlsConfig(r.Src, rs.tlsConfig)
+			}
+		}
+	} else {
+		hf.route = a.routes[src]
+		if hf.route != nil && hf.route.tlsConfig != nil {
+			hf.SetTlsConfig(hf.route.tlsConfig)
+		}
 	}
 
 	return hf
 }
 
+// printBanner prints startup banner rendered with App fields.
+func (a *App) printBanner() error {
+	if a.Banner == "" {
+		return nil
+	}
+
+	tmpl, err := template.New("banner").Parse(a.Banner)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	if err = tmpl.Execute(&b, a); err != nil {
+		return err
+	}
+
+	a.Printf("%s", b.String())
+	return nil
+}
+
+// endpoint returns path of built-in endpoint with EndpointPrefix.
+func (a *App) endpoint(path string) string {
+	return strings.TrimSuffix(a.EndpointPrefix, "/") + path
+}
+
 // registerMetrics is a function that initializes a.stat* variables and adds /metrics endpoint to echo.
 func (a *App) registerMetrics() {
 	a.statActiveConns = prometheus.NewGaugeVec(prometheus.GaugeOpts{
@@ -76,12 +487,48 @@ func (a *App) registerMetrics() {
 		Help:      "Current active websocket connections by uri.",
 	}, []string{"uri"})
 
+	a.statConnsMax = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: a.AppName,
+		Subsystem: "ws",
+		Name:      "connections_max",
+		Help:      "Max websocket connections of instance, 0 is unlimited.",
+	})
+	a.statConnsMax.Set(float64(a.MaxConnections))
+
+	a.statConnsRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: a.AppName,
+		Subsystem: "ws",
+		Name:      "connections_rejected_total",
+		Help:      "Websocket upgrades rejected by max connections limit.",
+	})
+
+	a.statOriginRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: a.AppName,
+		Subsystem: "ws",
+		Name:      "origin_rejected_total",
+		Help:      "Websocket upgrades rejected by origin check by origin, first 100 origins are counted separately.",
+	}, []string{"origin"})
+
+	a.statCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: a.AppName,
+		Subsystem: "proxy",
+		Name:      "cache_requests_total",
+		Help:      "Response cache lookups by url/method/result: hit, miss or error.",
+	}, []string{"url", "method", "result"})
+
+	a.statRateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: a.AppName,
+		Subsystem: "proxy",
+		Name:      "rate_limited_total",
+		Help:      "Requests rejected by rate limits by route/scope: connection or route.",
+	}, []string{"url", "scope"})
+
 	a.statBackendRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: a.AppName,
 		Subsystem: "proxy",
 		Name:      "requests_total",
 		Help:      "Requests to backend by url/method/status.",
-	}, []string{"url", "method", "status"}) //status: ok, timeout, error
+	}, []string{"url", "method", "status"}) //status: ok, timeout, dns_error, connection_refused, tls_error, error, cancelled
 
 	a.statBackendDurations = prometheus.NewSummaryVec(prometheus.SummaryOpts{
 		Namespace: a.AppName,
@@ -90,7 +537,115 @@ func (a *App) registerMetrics() {
 		Help:      "Response time by rpc method/http status code.",
 	}, []string{"url", "method", "code"}) // http code
 
-	prometheus.MustRegister(a.statActiveConns, a.statBackendRequests, a.statBackendDurations)
-	a.Printf("registering /metrics url as prometheus handler")
-	http.Handle("/metrics", promhttp.Handler())
+	a.statSlowClients = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: a.AppName,
+		Subsystem: "ws",
+		Name:      "slow_clients_total",
+		Help:      "Slow clients disconnected by uri.",
+	}, []string{"uri"})
+
+	a.statGoroutineLeaks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: a.AppName,
+		Subsystem: "ws",
+		Name:      "goroutine_leaks_total",
+		Help:      "Connections with goroutines running after leak grace since disconnect by uri.",
+	}, []string{"uri"})
+
+	a.statBackendPhases = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: a.AppName,
+		Subsystem: "proxy",
+		Name:      "phase_duration_seconds",
+		Help:      "Backend request phases by url/phase: dns, connect, tls, ttfb, body_read.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"url", "phase"})
+
+	a.statDebugDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: a.AppName,
+		Subsystem: "debug",
+		Name:      "dropped_events_total",
+		Help:      "Debug traffic events dropped on full buffer: events (debug loop) or trace (/debug/conns tracer).",
+	}, []string{"buffer"})
+
+	a.statBackendVersions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: a.AppName,
+		Subsystem: "proxy",
+		Name:      "version_requests_total",
+		Help:      "Requests to backend by url/version/status for canary routes: stable or canary.",
+	}, []string{"url", "version", "status"})
+
+	a.statBodySizes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: a.AppName,
+		Subsystem: "proxy",
+		Name:      "request_body_bytes",
+		Help:      "Forwarded request body sizes by url.",
+		Buckets:   prometheus.ExponentialBuckets(64, 4, 9), // 64B - 4MB
+	}, []string{"url"})
+
+	a.statSloViolations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: a.AppName,
+		Name:      "slo_violation_total",
+		Help:      "Method latency objective violations by url/method/objective, checked every 10 seconds.",
+	}, []string{"url", "method", "objective"})
+
+	a.statSlotsQueued = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: a.AppName,
+		Subsystem: "proxy",
+		Name:      "slots_queued",
+		Help:      "Requests waiting for backend slot by url.",
+	}, []string{"url"})
+
+	a.statSlotsInUse = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: a.AppName,
+		Subsystem: "proxy",
+		Name:      "slots_in_use",
+		Help:      "Running backend requests with slot by url.",
+	}, []string{"url"})
+
+	a.statSlotWaits = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: a.AppName,
+		Subsystem: "proxy",
+		Name:      "slot_wait_seconds",
+		Help:      "Backend slot wait durations by url.",
+		Buckets:   []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10},
+	}, []string{"url"})
+
+	a.statOrphanResponses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: a.AppName,
+		Subsystem: "proxy",
+		Name:      "orphan_responses_total",
+		Help:      "Backend responses with unexpected ids by url/reason: unknown_id, duplicate, mismatched.",
+	}, []string{"url", "reason"})
+
+	a.statNotifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: a.AppName,
+		Subsystem: "proxy",
+		Name:      "notifications_total",
+		Help:      "Notifications to backend by url/method/status for routes with CountNotifications.",
+	}, []string{"url", "method", "status"})
+
+	a.statBackendHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: a.AppName,
+		Subsystem: "proxy",
+		Name:      "backend_healthy",
+		Help:      "Result of last active health probe by backend url: 1 is healthy, 0 is unhealthy.",
+	}, []string{"url"})
+
+	a.statHealthLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: a.AppName,
+		Subsystem: "proxy",
+		Name:      "backend_health_check_seconds",
+		Help:      "Duration of last active health probe by backend url.",
+	}, []string{"url"})
+
+	a.pool = newPoolStats(a.AppName)
+
+	// instance identity as constant labels of all metrics
+	reg := prometheus.WrapRegistererWith(a.instance.labels(), prometheus.DefaultRegisterer)
+	reg.MustRegister(a.statActiveConns, a.statBackendRequests, a.statBackendDurations, a.statSlowClients, a.statGoroutineLeaks, a.statBackendPhases, a.statDebugDropped)
+	reg.MustRegister(a.statBackendVersions, a.statBodySizes, a.statSloViolations, a.statSlotsQueued, a.statSlotsInUse, a.statSlotWaits, a.statOrphanResponses)
+	reg.MustRegister(a.statNotifications, a.statConnsMax, a.statConnsRejected, a.statOriginRejected, a.statCacheRequests)
+	reg.MustRegister(a.statRateLimited, a.statBackendHealthy, a.statHealthLatency)
+	reg.MustRegister(a.pool.collectors()...)
+	a.Printf("registering %s url as prometheus handler", a.endpoint("/metrics"))
+	http.Handle(a.endpoint("/metrics"), corsHandler(a.Cors, promhttp.Handler()))
 }
@@ -0,0 +1,32 @@
+package app
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestPrintBanner(t *testing.T) {
+	tests := []struct {
+		name, banner, want string
+		err                bool
+	}{
+		{"disabled", "", "", false},
+		{"template", "{{.AppName}} listening on {{.ListenAddr}}{{range .RedirectRules}} {{.Src}}->{{.DstUrl}}{{end}}", "ws2http listening on :8090 /rpc->http://localhost/rpc\n", false},
+		{"invalid template", "{{.AppName", "", true},
+		{"unknown field", "{{.Unknown}}", "", true},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		a := &App{AppName: "ws2http", ListenAddr: ":8090", Banner: tt.banner, RedirectRules: []ProxyRule{{Src: "/rpc", DstUrl: "http://localhost/rpc"}}}
+		a.logger = logger{logLevel: LogVerbose, log: log.New(&buf, "", 0)}
+
+		if err := a.printBanner(); (err != nil) != tt.err || buf.String() != tt.want {
+			t.Errorf("%s: got %q %v", tt.name, buf.String(), err)
+		} else if tt.err && !strings.Contains(err.Error(), "banner") {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+}
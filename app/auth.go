@@ -0,0 +1,113 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+type ctxKey int
+
+const authHeadersKey ctxKey = iota
+
+var errAuthFailed = errors.New("auth request failed")
+
+// upgradeHeaders are handshake headers which are not passed to auth subrequests.
+var upgradeHeaders = []string{"Connection", "Upgrade", "Sec-Websocket-Key", "Sec-Websocket-Version", "Sec-Websocket-Extensions", "Sec-Websocket-Protocol"}
+
+// forwardAuth performs GET auth subrequest with client headers and returns auth response headers.
+// 401 and 403 statuses are returned as UpgradeError, other non-2xx statuses as error.
+func forwardAuth(client *http.Client, authUrl, uri string, header http.Header) (http.Header, error) {
+	req, err := http.NewRequest(http.MethodGet, authUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, vv := range header {
+		for _, v := range vv {
+			req.Header.Add(k, v)
+		}
+	}
+	for _, h := range upgradeHeaders {
+		req.Header.Del(h)
+	}
+	req.Header.Set("X-Original-URI", uri)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return resp.Header, nil
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, &UpgradeError{Status: resp.StatusCode, Message: errAuthFailed.Error()}
+	}
+
+	return nil, fmt.Errorf("%s: status=%d", errAuthFailed, resp.StatusCode)
+}
+
+// filterHeaders returns copy of headers h with given names only.
+func filterHeaders(h http.Header, names []string) http.Header {
+	fh := make(http.Header)
+	for _, name := range names {
+		if vv := h[http.CanonicalHeaderKey(name)]; len(vv) > 0 {
+			fh[http.CanonicalHeaderKey(name)] = append([]string(nil), vv...)
+		}
+	}
+
+	return fh
+}
+
+// routeAuthHandler performs route forward auth subrequest on upgrade and saves
+// rule.AuthHeaders from auth response into request context for backend requests.
+// In browser mode ambient credentials are not sent, because upgrade precedes csrf handshake.
+func (a *App) routeAuthHandler(rule ProxyRule, client *http.Client, h http.Handler) http.Handler {
+	if rule.AuthUrl == "" {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header
+		if a.BrowserMode {
+			header = header.Clone()
+			for _, k := range ambientHeaders {
+				header.Del(k)
+			}
+		}
+
+		ah, err := forwardAuth(client, rule.AuthUrl, r.URL.RequestURI(), header)
+		if err != nil {
+			a.rejectUpgrade(w, r, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), authHeadersKey, filterHeaders(ah, rule.AuthHeaders))
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authorize performs per request forward auth for route and adds auth response headers to backend headers.
+func (hf *HttpForwarder) authorize(rule ProxyRule, uri string, clientHeader, headers http.Header) error {
+	h := make(http.Header)
+	for k, vv := range clientHeader {
+		h[k] = vv
+	}
+	for k, vv := range headers {
+		h[k] = vv
+	}
+
+	ah, err := forwardAuth(hf.authClient, rule.AuthUrl, uri, h)
+	if err != nil {
+		return err
+	}
+
+	for k, vv := range filterHeaders(ah, rule.AuthHeaders) {
+		headers[k] = vv
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouteAuthHandler(t *testing.T) {
+	var got http.Header
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		w.Header().Set("X-User", "42")
+	}))
+	defer auth.Close()
+
+	a := &App{}
+	var user string
+	h := a.routeAuthHandler(ProxyRule{AuthUrl: auth.URL, AuthHeaders: []string{"X-User"}}, http.DefaultClient, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = r.Context().Value(authHeadersKey).(http.Header).Get("X-User")
+	}))
+	upgrade := func() {
+		r := httptest.NewRequest(http.MethodGet, "/rpc?v=1", nil)
+		r.Header.Set("Cookie", "session=1")
+		r.Header.Set("Authorization", "Bearer 1")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("X-Client", "app")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	upgrade()
+	if got.Get("Cookie") == "" || got.Get("Authorization") == "" || got.Get("Upgrade") != "" || got.Get("X-Original-Uri") != "/rpc?v=1" || user != "42" {
+		t.Errorf("got %v, user %q", got, user)
+	}
+
+	// ambient credentials precede csrf handshake in browser mode
+	a.BrowserMode = true
+	upgrade()
+	if got.Get("Cookie") != "" || got.Get("Authorization") != "" || got.Get("X-Client") != "app" {
+		t.Errorf("browser mode: got %v", got)
+	}
+}
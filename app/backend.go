@@ -0,0 +1,152 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BackendRequest is a rewritten client request for backend.
+type BackendRequest struct {
+	Request JsonRpcRequest // rewritten request
+	Msg     []byte         // rewritten request as json
+	Route   string         // route src, like /rpc
+	DstUrl  string         // backend endpoint
+	Header  http.Header    // session headers snapshot, must not be modified
+}
+
+// BackendResponse is a json-rpc response from backend.
+type BackendResponse struct {
+	Body       []byte // json-rpc response
+	StatusCode int    // backend status code, like http status
+}
+
+// BackendError is returned to client as json-rpc error with Code.
+type BackendError struct {
+	Code       int
+	Err        error         // optional error for message
+	RetryAfter time.Duration // backend retry hint, returned to client in error data, 0 is not set
+}
+
+func (e *BackendError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("backend error code=%d", e.Code)
+	}
+
+	return e.Err.Error()
+}
+
+// Unwrap returns Err for network errors classification.
+func (e *BackendError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout checks Err for timeout.
+func (e *BackendError) Timeout() bool {
+	t, ok := e.Err.(errTimeout)
+	return ok && t.Timeout()
+}
+
+// Backend performs rewritten json-rpc requests. Errors are returned to client as json-rpc errors
+// with BackendError code or JsonRpcServerErr.
+type Backend interface {
+	Do(ctx context.Context, req BackendRequest) (BackendResponse, error)
+}
+
+// BackendFactory returns backend for route rule.
+type BackendFactory func(rule ProxyRule) (Backend, error)
+
+var (
+	backendsLock sync.RWMutex
+	backends     = make(map[string]BackendFactory)
+)
+
+// RegisterBackend registers backend factory for protocol. Routes with ProxyRule.Protocol = protocol
+// use backend instead of default http backend, like Thrift or gRPC adapters.
+func RegisterBackend(protocol string, f BackendFactory) {
+	backendsLock.Lock()
+	defer backendsLock.Unlock()
+	backends[protocol] = f
+}
+
+// newBackend returns registered backend for rule protocol or nil.
+func newBackend(rule ProxyRule) (Backend, error) {
+	backendsLock.RLock()
+	f, ok := backends[rule.Protocol]
+	backendsLock.RUnlock()
+	if !ok || rule.Protocol == "" {
+		return nil, nil
+	}
+
+	return f(rule)
+}
+
+// httpBackend is a default backend: json-rpc over http with route request template or protocol translator.
+type httpBackend struct {
+	hf     *HttpForwarder
+	client *http.Client // client per connection
+}
+
+func (b httpBackend) Do(ctx context.Context, req BackendRequest) (BackendResponse, error) {
+	req.Header = copyHeaders(req.Header) // translators and http request set content type
+	removeHopHeaders(req.Header)
+	body, err := b.hf.renderRequest(req)
+	if err != nil {
+		return BackendResponse{}, err
+	}
+
+	// record backend latency breakdown
+	trace := newBackendTrace()
+	if b.hf.statBackendPhases != nil {
+		ctx = trace.withContext(ctx)
+		defer b.hf.statTrace(req.DstUrl, trace)
+	}
+
+	// count pool connections usage
+	if b.hf.pool != nil {
+		var release func()
+		ctx, release = b.hf.pool.withContext(ctx, req.DstUrl)
+		defer release()
+	}
+
+	setDeadlineHeader(ctx, req.Header, b.hf.deadlineHeader)
+	setQueueHeader(ctx, req.Header, b.hf.queueHeader, b.hf.clock.Now())
+	resp, err := b.hf.doPostRequest(ctx, b.client, body, req.DstUrl, req.Header)
+	if err != nil {
+		return BackendResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	// backend http errors are returned as -1 * httpStatusCode
+	if resp.StatusCode != http.StatusOK {
+		return BackendResponse{StatusCode: resp.StatusCode}, retryAfterError(resp, b.hf.clock.Now())
+	}
+
+	bodyStart := time.Now()
+	data, err := readResponse(resp, b.hf.responseLimit(req.Route))
+	trace.observe(phaseBodyRead, bodyStart)
+	if err != nil {
+		return BackendResponse{StatusCode: resp.StatusCode}, err
+	}
+
+	data, err = b.hf.decodeResponse(req, data)
+	return BackendResponse{Body: data, StatusCode: resp.StatusCode}, err
+}
+
+// backend returns registered route backend or default http backend with connection client.
+func (hf *HttpForwarder) backend(rf *requestForwarder, srcUrl string) Backend {
+	if rs := hf.routeState(srcUrl); rs != nil && rs.backend != nil {
+		return rs.backend
+	}
+
+	// routes with TLS settings have own transport in multiple rules mode
+	if t := hf.routeTransport(srcUrl); t != hf.transport {
+		client := *rf.client
+		client.Transport = t
+		return httpBackend{hf: hf, client: &client}
+	}
+
+	return httpBackend{hf: hf, client: rf.client}
+}
@@ -0,0 +1,93 @@
+package app
+
+import (
+	"errors"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+// Blue/green destinations of route: blue is DstUrl, green is GreenUrl.
+const (
+	colorBlue  = "blue"
+	colorGreen = "green"
+
+	drainPollInterval = 10 * time.Millisecond
+)
+
+var errNoGreen = errors.New("route has no green destination")
+
+// colorUrl returns route destination for color.
+func (rs *routeState) colorUrl(color string) string {
+	if color == colorGreen {
+		return rs.rule.GreenUrl
+	}
+
+	return rs.rule.DstUrl
+}
+
+// activeColor returns active destination color of route.
+func (rs *routeState) activeColor() string {
+	rs.lock.RLock()
+	defer rs.lock.RUnlock()
+	if rs.green {
+		return colorGreen
+	}
+
+	return colorBlue
+}
+
+// acquire returns active destination for blue/green route and counts in-flight request until release is called.
+func (rs *routeState) acquire() (dstUrl string, release func()) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	color := colorBlue
+	if rs.green {
+		color = colorGreen
+	}
+	rs.inflight[color]++
+
+	return rs.colorUrl(color), func() {
+		rs.lock.Lock()
+		defer rs.lock.Unlock()
+		rs.inflight[color]--
+	}
+}
+
+// switchTo atomically switches route to color destination and returns previous color.
+// New requests are sent to color destination, in-flight requests to previous destination are not canceled.
+func (rs *routeState) switchTo(color string) (string, error) {
+	if rs.rule.GreenUrl == "" {
+		return "", errNoGreen
+	}
+
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	prev := colorBlue
+	if rs.green {
+		prev = colorGreen
+	}
+	rs.green = color == colorGreen
+
+	return prev, nil
+}
+
+// inFlight returns in-flight requests to color destination.
+func (rs *routeState) inFlight(color string) int {
+	rs.lock.RLock()
+	defer rs.lock.RUnlock()
+	return rs.inflight[color]
+}
+
+// drain waits until in-flight requests to color destination are finished or timeout of route clock,
+// returns remaining requests.
+func (rs *routeState) drain(color string, timeout time.Duration) int {
+	for deadline := rs.clock.Now().Add(timeout); ; {
+		if n := rs.inFlight(color); n == 0 || !rs.clock.Now().Before(deadline) {
+			return n
+		}
+		<-clock.After(rs.clock, drainPollInterval)
+	}
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestRouteStateSwitch(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	rss, err := newRouteStates([]ProxyRule{{Src: "/rpc", DstUrl: "http://blue/rpc", GreenUrl: "http://green/rpc"}, {Src: "/v1", DstUrl: "http://v1"}}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rss["/v1"].switchTo(colorGreen); err != errNoGreen {
+		t.Errorf("got err %v", err)
+	}
+
+	rs := rss["/rpc"]
+	dst, release := rs.acquire()
+	if dst != "http://blue/rpc" {
+		t.Errorf("got %s", dst)
+	}
+
+	if prev, err := rs.switchTo(colorGreen); err != nil || prev != colorBlue {
+		t.Fatalf("got %s, %v", prev, err)
+	}
+
+	if dst, release := rs.acquire(); dst != "http://green/rpc" {
+		t.Errorf("got %s after switch", dst)
+	} else {
+		release()
+	}
+
+	// in-flight request to blue is waited up to timeout of route clock
+	drained := make(chan int, 1)
+	drain := func(timeout time.Duration) {
+		go func() { drained <- rs.drain(colorBlue, timeout) }()
+		for c.Timers() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	drain(time.Second)
+	c.Advance(time.Second)
+	if n := <-drained; n != 1 {
+		t.Errorf("got %d in-flight", n)
+	}
+
+	drain(time.Second)
+	release()
+	c.Advance(drainPollInterval)
+	if n := <-drained; n != 0 {
+		t.Errorf("got %d in-flight after release", n)
+	}
+}
+
+func TestAdminSwitchRoute(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	rss, err := newRouteStates([]ProxyRule{{Src: "/rpc", DstUrl: "http://blue/rpc", GreenUrl: "http://green/rpc"}}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &App{Timeout: 10, routes: rss}
+	_, release := rss["/rpc"].acquire()
+	defer release()
+
+	tests := []struct {
+		name, body string
+		status     int
+		want       switchStatus
+	}{
+		{"switch with in-flight request", `{"route":"/rpc","to":"green"}`, http.StatusAccepted, switchStatus{Route: "/rpc", Active: colorGreen, DstUrl: "http://green/rpc", Previous: colorBlue, InFlight: 1}},
+		{"same color", `{"route":"/rpc","to":"green"}`, http.StatusOK, switchStatus{Route: "/rpc", Active: colorGreen, DstUrl: "http://green/rpc", Previous: colorGreen, Drained: true}},
+		{"no in-flight requests", `{"route":"/rpc","to":"blue"}`, http.StatusAccepted, switchStatus{Route: "/rpc", Active: colorBlue, DstUrl: "http://blue/rpc", Previous: colorGreen, Drained: true}},
+	}
+
+	for _, tt := range tests {
+		// switch doesn't wait for drain of previous destination
+		w := httptest.NewRecorder()
+		a.switchRoute(w, httptest.NewRequest(http.MethodPost, "/admin/switch", strings.NewReader(tt.body)))
+
+		var got switchStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || w.Code != tt.status || got != tt.want {
+			t.Errorf("%s: got %d %+v %v", tt.name, w.Code, got, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+)
+
+var errCsrfHandshake = errors.New("csrf handshake required")
+
+// ambientHeaders are client credentials sent by browser automatically.
+var ambientHeaders = []string{"Cookie", "Authorization"}
+
+// checkCsrfHandshake checks first message for "CSRF <token>" text frame in browser mode. Token must be equal to
+// csrf cookie value from upgrade request (double submit cookie), so cross-site pages can't complete handshake.
+// Returns true if message was consumed by handshake, error if handshake failed.
+func (rf *requestForwarder) checkCsrfHandshake(msg []byte, text bool) (bool, error) {
+	if rf.csrfCookie == "" || rf.handshaked {
+		return false, nil
+	}
+
+	if !text || !bytes.HasPrefix(msg, []byte("CSRF ")) || rf.ws.Request() == nil {
+		return true, errCsrfHandshake
+	}
+
+	c, err := rf.ws.Request().Cookie(rf.csrfCookie)
+	if err != nil || c.Value == "" || subtle.ConstantTimeCompare([]byte(c.Value), bytes.TrimSpace(msg[5:])) != 1 {
+		return true, errCsrfHandshake
+	}
+
+	rf.handshaked = true
+	return true, nil
+}
+
+// clientHeader returns upgrade request headers. Ambient credentials are removed until csrf handshake completes.
+func (rf *requestForwarder) clientHeader() http.Header {
+	h := make(http.Header)
+	if rf.ws.Request() == nil {
+		return h
+	}
+
+	for k, vv := range rf.ws.Request().Header {
+		h[k] = vv
+	}
+
+	if rf.csrfCookie != "" && !rf.handshaked {
+		for _, k := range ambientHeaders {
+			h.Del(k)
+		}
+	}
+
+	return h
+}
@@ -0,0 +1,162 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCheckCsrfHandshake(t *testing.T) {
+	tests := []struct {
+		name       string
+		csrfCookie string
+		cookie     string
+		msg        string
+		text       bool
+		consumed   bool
+		err        error
+	}{
+		{"disabled", "", "csrf=token", `{"method":"get"}`, true, false, nil},
+		{"handshake", "csrf", "csrf=token", "CSRF token", true, true, nil},
+		{"request before handshake", "csrf", "csrf=token", `{"method":"get"}`, true, true, errCsrfHandshake},
+		{"binary frame", "csrf", "csrf=token", "CSRF token", false, true, errCsrfHandshake},
+		{"wrong token", "csrf", "csrf=token", "CSRF other", true, true, errCsrfHandshake},
+		{"no cookie", "csrf", "", "CSRF token", true, true, errCsrfHandshake},
+		{"empty cookie", "csrf", "csrf=", "CSRF ", true, true, errCsrfHandshake},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/rpc", nil)
+		if tt.cookie != "" {
+			r.Header.Set("Cookie", tt.cookie)
+		}
+		hf := NewHttpForwarder("http://localhost", nil, 1, 1)
+		hf.SetBrowserMode(tt.csrfCookie)
+		rf := hf.newRequestForwarder(&wsConn{req: r})
+
+		consumed, err := rf.checkCsrfHandshake([]byte(tt.msg), tt.text)
+		if consumed != tt.consumed || err != tt.err || rf.handshaked != (tt.csrfCookie != "" && err == nil) {
+			t.Errorf("%s: got %v %v, handshaked %v", tt.name, consumed, err, rf.handshaked)
+		}
+	}
+}
+
+func TestRequestForwarderClientHeader(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/rpc", nil)
+	r.Header.Set("Cookie", "csrf=token; session=1")
+	r.Header.Set("Authorization", "Bearer 1")
+	r.Header.Set("X-Client", "app")
+
+	tests := []struct {
+		name        string
+		csrfCookie  string
+		handshake   bool
+		credentials bool
+	}{
+		{"browser mode disabled", "", false, true},
+		{"before handshake", "csrf", false, false},
+		{"after handshake", "csrf", true, true},
+	}
+
+	for _, tt := range tests {
+		hf := NewHttpForwarder("http://localhost", nil, 1, 1)
+		hf.SetBrowserMode(tt.csrfCookie)
+		rf := hf.newRequestForwarder(&wsConn{req: r})
+		if tt.handshake {
+			rf.checkCsrfHandshake([]byte("CSRF token"), true)
+		}
+
+		h := rf.clientHeader()
+		if (h.Get("Cookie") != "" && h.Get("Authorization") != "") != tt.credentials || h.Get("X-Client") != "app" {
+			t.Errorf("%s: got %v", tt.name, h)
+		}
+	}
+
+	// upgrade request headers are not modified
+	if r.Header.Get("Cookie") == "" || r.Header.Get("Authorization") == "" {
+		t.Errorf("got %v", r.Header)
+	}
+}
+
+func TestBrowserMode(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"` + r.Header.Get("X-User") + `"}`))
+	}))
+	defer backend.Close()
+
+	// forward auth identifies user by ambient cookie
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("session"); err == nil {
+			w.Header().Set("X-User", "42")
+		}
+	}))
+	defer auth.Close()
+
+	a := &App{
+		BrowserMode:         true,
+		AllowedOrigins:      []string{"https://app.example.com"},
+		CsrfCookie:          "csrf",
+		ControlAcks:         true,
+		Timeout:             10,
+		MaxParallelRequests: 10,
+		RedirectRules:       []ProxyRule{{Src: "/rpc", DstUrl: backend.URL, AuthUrl: auth.URL, AuthPerRequest: true, AuthHeaders: []string{"X-User"}, DisableDebug: true}},
+	}
+	h, err := a.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		origin string
+		first  string // first message
+		status int    // upgrade status
+		reply  string // reply to first message
+		resp   string // response to request after first message, empty if connection is closed
+	}{
+		{"cross-site origin", "https://evil.com", "", http.StatusForbidden, "", ""},
+		{"no origin", "", "", http.StatusForbidden, "", ""},
+		{"request before handshake", "https://app.example.com", `{"jsonrpc":"2.0","method":"get","id":1}`, http.StatusSwitchingProtocols, `{"jsonrpc":"2.0","id":1,"error":{"code":-32004,"message":"csrf handshake required"}}`, ""},
+		{"wrong token", "https://app.example.com", "CSRF other", http.StatusSwitchingProtocols, "ERR CSRF csrf handshake required", ""},
+		{"handshake", "https://app.example.com", "CSRF token", http.StatusSwitchingProtocols, "OK CSRF", `{"jsonrpc":"2.0","id":1,"result":"42"}`},
+	}
+
+	for _, tt := range tests {
+		header := http.Header{"Cookie": {"csrf=token; session=1"}}
+		if tt.origin != "" {
+			header.Set("Origin", tt.origin)
+		}
+		ws, resp, err := websocket.DefaultDialer.Dial(strings.Replace(srv.URL, "http", "ws", 1)+"/rpc", header)
+		if resp == nil || resp.StatusCode != tt.status {
+			t.Errorf("%s: got %v %v", tt.name, resp, err)
+			continue
+		} else if err != nil {
+			continue
+		}
+
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		ws.WriteMessage(websocket.TextMessage, []byte(tt.first))
+		if _, msg, err := ws.ReadMessage(); err != nil || string(msg) != tt.reply {
+			t.Errorf("%s: got reply %s %v", tt.name, msg, err)
+		}
+
+		ws.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"get","id":1}`))
+		for {
+			_, msg, err := ws.ReadMessage()
+			if tt.resp == "" && err == nil && strings.Contains(string(msg), controlErrorMethod) {
+				continue // control error notification precedes close
+			}
+			if (tt.resp == "") != (err != nil) || (err == nil && string(msg) != tt.resp) {
+				t.Errorf("%s: got response %s %v", tt.name, msg, err)
+			}
+			break
+		}
+		ws.Close()
+	}
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// tcpListener applies socket buffer sizes and TCP_NODELAY to accepted client connections.
+type tcpListener struct {
+	net.Listener
+	readBuffer, writeBuffer int  // SO_RCVBUF and SO_SNDBUF, 0 is system default
+	delay                   bool // disable TCP_NODELAY
+}
+
+// tuneListener returns listener with App socket options or ln if options are not set.
+func (a *App) tuneListener(ln net.Listener) net.Listener {
+	if a.TcpReadBuffer == 0 && a.TcpWriteBuffer == 0 && !a.TcpDelay {
+		return ln
+	}
+
+	return tcpListener{Listener: ln, readBuffer: a.TcpReadBuffer, writeBuffer: a.TcpWriteBuffer, delay: a.TcpDelay}
+}
+
+func (l tcpListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if tc, ok := c.(*net.TCPConn); ok {
+		if l.readBuffer > 0 {
+			tc.SetReadBuffer(l.readBuffer)
+		}
+		if l.writeBuffer > 0 {
+			tc.SetWriteBuffer(l.writeBuffer)
+		}
+		if l.delay {
+			tc.SetNoDelay(false)
+		}
+	}
+
+	return c, nil
+}
+
+// bufferedWriter replaces buffers of hijacked websocket connection.
+type bufferedWriter struct {
+	http.ResponseWriter
+	readSize, writeSize int
+}
+
+func (w bufferedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	c, rw, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// buffered handshake data is read through old reader
+	r, wr := rw.Reader, rw.Writer
+	if w.readSize > 0 {
+		r = bufio.NewReaderSize(rw.Reader, w.readSize)
+	}
+	if w.writeSize > 0 {
+		rw.Writer.Flush()
+		wr = bufio.NewWriterSize(c, w.writeSize)
+	}
+
+	return c, bufio.NewReadWriter(r, wr), nil
+}
+
+// bufferHandler sets ReadBufferSize and WriteBufferSize for websocket connections of h.
+func (a *App) bufferHandler(h http.Handler) http.Handler {
+	readSize, writeSize := a.ReadBufferSize, a.WriteBufferSize
+	if readSize <= 0 && writeSize <= 0 {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Hijacker); ok {
+			w = bufferedWriter{ResponseWriter: w, readSize: readSize, writeSize: writeSize}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,62 @@
+package app
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestBufferHandler(t *testing.T) {
+	a := &App{ReadBufferSize: 64 << 10, WriteBufferSize: 64 << 10, TcpReadBuffer: 1 << 20, TcpDelay: true}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln = a.tuneListener(ln)
+	if _, ok := ln.(tcpListener); !ok {
+		t.Errorf("got %T", ln)
+	}
+
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+
+		for {
+			mt, msg, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			ws.WriteMessage(mt, msg)
+		}
+	})
+	srv := &http.Server{Handler: a.bufferHandler(echo)}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	addr := ln.Addr().String()
+	ws, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/", http.Header{"Origin": {"http://" + addr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// message larger than default buffers
+	large := strings.Repeat("x", 100<<10)
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(large)); err != nil {
+		t.Fatal(err)
+	}
+	if _, msg, err := ws.ReadMessage(); err != nil || string(msg) != large {
+		t.Errorf("got %d bytes, %v", len(msg), err)
+	}
+
+	if l := (&App{}).tuneListener(ln); l != ln {
+		t.Errorf("got %T", l)
+	}
+}
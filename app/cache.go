@@ -0,0 +1,434 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/semrush/ws2http/clock"
+)
+
+const (
+	defaultCacheMaxSize    = 64 << 10 // max cached result size of rules without size
+	defaultCacheEntries    = 10000    // max entries of memory cache without entries parameter
+	responseCacheTimeout   = time.Second
+	responseCacheKeyPrefix = "ws2http:cache:" // keys are ws2http:cache:<method>:<sha256>
+
+	cacheInvalidateTimeout = 10 * time.Second
+	cacheInvalidateChannel = "ws2http:cache:invalidate" // default pub/sub channel of invalidation messages
+	cacheSubscribeRetry    = time.Second
+)
+
+var ErrUnknownCache = errors.New("unknown response cache scheme")
+
+// ResponseCache keeps results of cacheable methods by key until ttl. Get returns nil data for missing keys.
+// Invalidate removes keys matched by glob pattern like ws2http:cache:users.*:* and returns number of removed keys.
+type ResponseCache interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, data []byte, ttl time.Duration) error
+	Invalidate(ctx context.Context, pattern string) (int, error)
+}
+
+// ResponseCacheFactory returns response cache for url, like redis://localhost:6379/0.
+type ResponseCacheFactory func(u *url.URL) (ResponseCache, error)
+
+var (
+	responseCachesLock sync.RWMutex
+	responseCaches     = map[string]ResponseCacheFactory{
+		"memory": newMemoryCache,
+		"":       newMemoryCache,
+		"redis":  newRedisCache,
+	}
+)
+
+// RegisterResponseCache registers response cache factory for url scheme, like memcached adapter.
+// In-process cache is registered for memory scheme and empty url, shared cache for redis scheme.
+func RegisterResponseCache(scheme string, f ResponseCacheFactory) {
+	responseCachesLock.Lock()
+	defer responseCachesLock.Unlock()
+	responseCaches[scheme] = f
+}
+
+// OpenResponseCache returns response cache for url by registered scheme.
+func OpenResponseCache(rawurl string) (ResponseCache, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	responseCachesLock.RLock()
+	f, ok := responseCaches[u.Scheme]
+	responseCachesLock.RUnlock()
+	if !ok {
+		return nil, ErrUnknownCache
+	}
+
+	return f(u)
+}
+
+// CacheRule enables response cache for method.
+type CacheRule struct {
+	Method  string        // method or * for all methods
+	Ttl     time.Duration // result lifetime
+	MaxSize int           // max cached result size in bytes, 0 is 64KiB
+}
+
+// matches checks rule method.
+func (r CacheRule) matches(method string) bool {
+	return r.Method == "*" || r.Method == method
+}
+
+// ParseCacheRule parses rule like users.get:30s or users.get:30s:1048576 with max result size.
+func ParseCacheRule(value string) (CacheRule, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+		return CacheRule{}, fmt.Errorf("invalid cache rule %q", value)
+	}
+
+	ttl, err := time.ParseDuration(parts[1])
+	if err != nil || ttl <= 0 {
+		return CacheRule{}, fmt.Errorf("invalid cache ttl %q", parts[1])
+	}
+
+	r := CacheRule{Method: parts[0], Ttl: ttl}
+	if len(parts) == 3 {
+		if r.MaxSize, err = strconv.Atoi(parts[2]); err != nil || r.MaxSize <= 0 {
+			return CacheRule{}, fmt.Errorf("invalid cache size %q", parts[2])
+		}
+	}
+
+	return r, nil
+}
+
+// methodCache caches successful backend results of methods by rules, so repeated requests with the same params
+// and session headers are answered without backend.
+type methodCache struct {
+	rules    []CacheRule
+	store    ResponseCache
+	requests *prometheus.CounterVec // cache lookups by url, method and result: hit, miss or error
+	logger
+}
+
+// newMethodCache returns cache of methods by rules in store, metrics are optional. Nil cache is disabled.
+func newMethodCache(rules []CacheRule, store ResponseCache, requests *prometheus.CounterVec, l logger) *methodCache {
+	return &methodCache{rules: rules, store: store, requests: requests, logger: l}
+}
+
+// rule returns first rule of method.
+func (c *methodCache) rule(method string) (CacheRule, ResponseCache, bool) {
+	if c == nil || c.store == nil {
+		return CacheRule{}, nil, false
+	}
+
+	for _, r := range c.rules {
+		if r.matches(method) {
+			return r, c.store, true
+		}
+	}
+
+	return CacheRule{}, nil, false
+}
+
+func (c *methodCache) count(dstUrl, method, result string) {
+	if c.requests != nil {
+		c.requests.WithLabelValues(dstUrl, method, result).Inc()
+	}
+}
+
+// cacheKey returns key of request: backend url, method, params and session headers, so responses for different
+// users are not shared.
+func cacheKey(dstUrl string, req JsonRpcRequest, headers http.Header) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", dstUrl, req.Method)
+	if req.Params != nil {
+		h.Write(*req.Params)
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(h, "\n%s: %s", name, strings.Join(headers[name], ", "))
+	}
+
+	return responseCacheKeyPrefix + req.Method + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// lookup returns cached response for request with client id. Returns cache key for store or empty key if method
+// is not cacheable.
+func (c *methodCache) lookup(dstUrl string, req JsonRpcRequest, headers http.Header) (string, []byte) {
+	if req.Id == nil {
+		return "", nil
+	}
+	if _, store, ok := c.rule(req.Method); ok {
+		key := cacheKey(dstUrl, req, headers)
+		ctx, cancel := context.WithTimeout(context.Background(), responseCacheTimeout)
+		defer cancel()
+
+		result, err := store.Get(ctx, key)
+		switch {
+		case err != nil:
+			c.Errorf("can't get cached response method=%s err=%s", req.Method, err)
+			c.count(dstUrl, req.Method, "error")
+		case result != nil:
+			c.count(dstUrl, req.Method, "hit")
+			return key, JsonRpcResponse{Version: "2.0", Id: req.Id, Result: json.RawMessage(result)}.JSON()
+		default:
+			c.count(dstUrl, req.Method, "miss")
+		}
+
+		return key, nil
+	}
+
+	return "", nil
+}
+
+// save stores result of successful backend response within rule size limit.
+func (c *methodCache) save(key, method string, resp []byte) {
+	rule, store, ok := c.rule(method)
+	if !ok || key == "" {
+		return
+	}
+
+	var r struct {
+		Result json.RawMessage `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	maxSize := rule.MaxSize
+	if maxSize == 0 {
+		maxSize = defaultCacheMaxSize
+	}
+	if json.Unmarshal(resp, &r) != nil || r.Result == nil || r.Error != nil || len(r.Result) > maxSize {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), responseCacheTimeout)
+	defer cancel()
+	if err := store.Set(ctx, key, r.Result, rule.Ttl); err != nil {
+		c.Errorf("can't cache response method=%s err=%s", method, err)
+	}
+}
+
+// cacheInvalidation is a body of /admin/cache/invalidate request and pub/sub invalidation message: cached responses
+// of method or of methods matched by glob pattern like users.* are removed.
+type cacheInvalidation struct {
+	Method  string `json:"method,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// keyPattern returns glob pattern of cache keys.
+func (ci cacheInvalidation) keyPattern() (string, error) {
+	pattern := ci.Pattern
+	if ci.Method != "" {
+		pattern = globEscaper.Replace(ci.Method)
+	}
+	if pattern == "" || (ci.Method != "" && ci.Pattern != "") {
+		return "", errors.New("method or pattern is required")
+	} else if _, err := path.Match(pattern, ""); err != nil {
+		return "", err
+	}
+
+	return responseCacheKeyPrefix + pattern + ":*", nil
+}
+
+// globEscaper escapes special characters of glob patterns, both path.Match and redis MATCH use backslash.
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// invalidate removes cached responses matched by ci. Returns 0 if cache is not configured.
+func (c *methodCache) invalidate(ci cacheInvalidation) (int, error) {
+	pattern, err := ci.keyPattern()
+	if err != nil || c == nil || c.store == nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cacheInvalidateTimeout)
+	defer cancel()
+	return c.store.Invalidate(ctx, pattern)
+}
+
+type cacheInvalidateResponse struct {
+	Invalidated int `json:"invalidated"`
+}
+
+// cacheInvalidate removes cached responses of method or methods matched by pattern on all cluster instances.
+// Example: curl -H 'Content-Type: application/json' -d '{"pattern":"users.*"}' http://localhost:8090/admin/cache/invalidate
+func (a *App) cacheInvalidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var ci cacheInvalidation
+	if err := json.NewDecoder(r.Body).Decode(&ci); err != nil {
+		http.Error(w, "invalid cache invalidation request", http.StatusBadRequest)
+		return
+	} else if _, err := ci.keyPattern(); err != nil {
+		http.Error(w, "invalid cache invalidation request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n, err := a.cache.invalidate(ci)
+	if err != nil {
+		a.Errorf("can't invalidate cache method=%s pattern=%s err=%s", ci.Method, ci.Pattern, err)
+		http.Error(w, "can't invalidate cache", http.StatusInternalServerError)
+		return
+	}
+
+	// instances with memory cache keep own responses
+	resp := cacheInvalidateResponse{Invalidated: n}
+	a.forwardPeers(r, "/admin/cache/invalidate", sessionFilter{}, ci, func(data []byte) {
+		var pr cacheInvalidateResponse
+		if json.Unmarshal(data, &pr) == nil {
+			resp.Invalidated += pr.Invalidated
+		}
+	})
+
+	a.Printf("cache invalidate method=%s pattern=%s invalidated=%d", ci.Method, ci.Pattern, resp.Invalidated)
+	a.audit(r, "cache_invalidate", ci)
+	writeJSON(w, resp)
+}
+
+// subscribeCacheInvalidation listens invalidation messages in redis channel of url like
+// redis://localhost:6379/0?channel=ws2http:cache:invalidate, messages are JSON like {"method":"users.get"}.
+// Subscription is restored after connection errors.
+func (a *App) subscribeCacheInvalidation(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	} else if u.Scheme != "redis" {
+		return fmt.Errorf("unsupported cache invalidation url %q", rawurl)
+	}
+
+	r, err := openRedis(u)
+	if err != nil {
+		return err
+	}
+	channel := u.Query().Get("channel")
+	if channel == "" {
+		channel = cacheInvalidateChannel
+	}
+
+	go func() {
+		for {
+			err := r.subscribe(channel, func(payload string) {
+				var ci cacheInvalidation
+				if err := json.Unmarshal([]byte(payload), &ci); err != nil {
+					a.Errorf("invalid cache invalidation message=%q err=%s", payload, err)
+					return
+				}
+
+				n, err := a.cache.invalidate(ci)
+				if err != nil {
+					a.Errorf("can't invalidate cache message=%q err=%s", payload, err)
+					return
+				}
+				a.Printf("cache invalidate method=%s pattern=%s invalidated=%d", ci.Method, ci.Pattern, n)
+			})
+			a.Errorf("cache invalidation channel=%s err=%s", channel, err)
+			time.Sleep(cacheSubscribeRetry)
+		}
+	}()
+
+	return nil
+}
+
+// memoryCache is an in-process response cache with max entries, expired entries are evicted first.
+type memoryCache struct {
+	lock    sync.Mutex
+	entries map[string]memoryCacheEntry
+	max     int
+	clock   clock.Clock // expiration time source, set by App
+}
+
+type memoryCacheEntry struct {
+	data    []byte
+	expires time.Time
+}
+
+// newMemoryCache returns cache for url like memory://?entries=10000.
+func newMemoryCache(u *url.URL) (ResponseCache, error) {
+	c := &memoryCache{entries: make(map[string]memoryCacheEntry), max: defaultCacheEntries, clock: clock.Real}
+	if v := u.Query().Get("entries"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid memory cache entries %q", v)
+		}
+		c.max = n
+	}
+
+	return c, nil
+}
+
+func (c *memoryCache) setClock(clk clock.Clock) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.clock = clk
+}
+
+func (c *memoryCache) Get(_ context.Context, key string) ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, nil
+	} else if !c.clock.Now().Before(e.expires) {
+		delete(c.entries, key)
+		return nil, nil
+	}
+
+	return e.data, nil
+}
+
+func (c *memoryCache) Set(_ context.Context, key string, data []byte, ttl time.Duration) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.clock.Now()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.max {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		// evict random entry if nothing is expired
+		for k := range c.entries {
+			if len(c.entries) < c.max {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = memoryCacheEntry{data: append([]byte(nil), data...), expires: now.Add(ttl)}
+
+	return nil
+}
+
+func (c *memoryCache) Invalidate(_ context.Context, pattern string) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	n := 0
+	for k := range c.entries {
+		if ok, _ := path.Match(pattern, k); ok {
+			delete(c.entries, k)
+			n++
+		}
+	}
+
+	return n, nil
+}
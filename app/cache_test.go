@@ -0,0 +1,295 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestParseCacheRule(t *testing.T) {
+	if r, err := ParseCacheRule("users.get:30s"); err != nil || r != (CacheRule{Method: "users.get", Ttl: 30 * time.Second}) {
+		t.Errorf("got %+v %v", r, err)
+	}
+	if r, err := ParseCacheRule("*:1m:1024"); err != nil || r != (CacheRule{Method: "*", Ttl: time.Minute, MaxSize: 1024}) {
+		t.Errorf("got %+v %v", r, err)
+	}
+	for _, v := range []string{"users.get", ":30s", "users.get:0s", "users.get:30s:-1", "a:1s:2:3"} {
+		if _, err := ParseCacheRule(v); err == nil {
+			t.Errorf("%s: expected error", v)
+		}
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	c := clock.NewFake(time.Now())
+
+	cache, err := OpenResponseCache("memory://?entries=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.(clockSetter).setClock(c)
+
+	ctx := context.Background()
+	cache.Set(ctx, "a", []byte("1"), time.Second)
+	cache.Set(ctx, "b", []byte("2"), time.Minute)
+	if data, _ := cache.Get(ctx, "a"); string(data) != "1" {
+		t.Errorf("got %s", data)
+	}
+
+	// expired entries are evicted first
+	c.Advance(time.Second)
+	if data, _ := cache.Get(ctx, "a"); data != nil {
+		t.Errorf("expired: got %s", data)
+	}
+	cache.Set(ctx, "c", []byte("3"), time.Minute)
+	cache.Set(ctx, "d", []byte("4"), time.Minute)
+	if len(cache.(*memoryCache).entries) != 2 {
+		t.Errorf("got %d entries", len(cache.(*memoryCache).entries))
+	}
+	if data, _ := cache.Get(ctx, "d"); string(data) != "4" {
+		t.Errorf("got %s", data)
+	}
+
+	if _, err := OpenResponseCache("memory://?entries=0"); err == nil {
+		t.Error("expected error")
+	}
+	if _, err := OpenResponseCache("memcached://localhost"); err != ErrUnknownCache {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestRedisCache(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f := &fakeRedis{keys: make(map[string]string), zset: make(map[string]int64)}
+	go f.serve(ln)
+
+	cache, err := OpenResponseCache("redis://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if data, err := cache.Get(ctx, "k"); data != nil || err != nil {
+		t.Errorf("missing: got %s %v", data, err)
+	}
+	if err := cache.Set(ctx, "k", []byte(`{"a":1}`), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := cache.Get(ctx, "k"); string(data) != `{"a":1}` || err != nil {
+		t.Errorf("got %s %v", data, err)
+	}
+
+	cache.Set(ctx, "ws2http:cache:users.get:1", []byte("1"), time.Minute)
+	cache.Set(ctx, "ws2http:cache:users.list:1", []byte("2"), time.Minute)
+	if n, err := cache.Invalidate(ctx, "ws2http:cache:users.*:*"); n != 2 || err != nil {
+		t.Errorf("invalidate: got %d %v", n, err)
+	}
+	if data, _ := cache.Get(ctx, "k"); data == nil {
+		t.Error("other keys are invalidated")
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	store, _ := OpenResponseCache("")
+
+	ctx := context.Background()
+	for _, method := range []string{"users.get", "users.list", "users.*", "config.get"} {
+		store.Set(ctx, cacheKey("http://backend", JsonRpcRequest{Method: method}, nil), []byte("1"), time.Minute)
+	}
+
+	a := &App{sessions: newSessionRegistry(), cache: newMethodCache([]CacheRule{{Method: "*", Ttl: time.Minute}}, store, nil, logger{})}
+	invalidate := func(method, body string) (int, string) {
+		w := httptest.NewRecorder()
+		a.cacheInvalidate(w, httptest.NewRequest(method, "/admin/cache/invalidate", strings.NewReader(body)))
+		return w.Code, strings.TrimSpace(w.Body.String())
+	}
+
+	// special characters of method are not a pattern
+	if code, body := invalidate(http.MethodPost, `{"method":"users.*"}`); code != http.StatusOK || body != `{"invalidated":1}` {
+		t.Errorf("method: got %d %s", code, body)
+	}
+	if code, body := invalidate(http.MethodPost, `{"pattern":"users.*"}`); code != http.StatusOK || body != `{"invalidated":2}` {
+		t.Errorf("pattern: got %d %s", code, body)
+	}
+	if len(store.(*memoryCache).entries) != 1 {
+		t.Errorf("got %d entries", len(store.(*memoryCache).entries))
+	}
+
+	for _, body := range []string{`{}`, `{"pattern":"["}`, `{"method":"a","pattern":"b"}`, `[`} {
+		if code, _ := invalidate(http.MethodPost, body); code != http.StatusBadRequest {
+			t.Errorf("%s: got %d", body, code)
+		}
+	}
+	if code, _ := invalidate(http.MethodGet, ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("get: got %d", code)
+	}
+}
+
+func TestSubscribeCacheInvalidation(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f := &fakeRedis{keys: make(map[string]string), zset: make(map[string]int64)}
+	go f.serve(ln)
+
+	store, _ := OpenResponseCache("")
+	ctx := context.Background()
+	key := cacheKey("http://backend", JsonRpcRequest{Method: "users.get"}, nil)
+	store.Set(ctx, key, []byte("1"), time.Minute)
+	cached := func() bool {
+		data, _ := store.Get(ctx, key)
+		return data != nil
+	}
+
+	a := &App{cache: newMethodCache([]CacheRule{{Method: "*", Ttl: time.Minute}}, store, nil, logger{})}
+	if err := a.subscribeCacheInvalidation("redis://" + ln.Addr().String() + "?channel=inv"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.subscribeCacheInvalidation("memory://"); err == nil {
+		t.Error("memory: expected error")
+	}
+
+	u, _ := url.Parse("redis://" + ln.Addr().String())
+	publisher, _ := openRedis(u)
+	for i := 0; i < 100 && cached(); i++ {
+		publisher.do(ctx, "PUBLISH", "inv", `{"method":"users.get"}`)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if cached() {
+		t.Error("cache is not invalidated")
+	}
+}
+
+func TestHttpForwarderCache(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		var req JsonRpcRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Method == "big" {
+			w.Write([]byte(`{"jsonrpc":"2.0","id":` + string(req.Id) + `,"result":"` + strings.Repeat("x", 100) + `"}`))
+			return
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":` + string(req.Id) + `,"result":{"n":` + string(rune('0'+n)) + `}}`))
+	}))
+	defer srv.Close()
+
+	store, _ := OpenResponseCache("")
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "cache"}, []string{"url", "method", "result"})
+	hf := NewHttpForwarder(srv.URL, nil, 10, 1)
+	hf.cache = newMethodCache([]CacheRule{{Method: "get", Ttl: time.Minute}, {Method: "big", Ttl: time.Minute, MaxSize: 10}}, store, requests, logger{})
+	rf := hf.newRequestForwarder(&wsConn{})
+	forward := func(msg string, headers http.Header) string {
+		rpcReq, _ := rf.rewriteRequest([]byte(msg), srv.URL)
+		rf.maxParallelRequest <- struct{}{}
+		return string(hf.forward(rf, rpcReq, headers))
+	}
+
+	user1 := http.Header{"Authorization": {"Bearer 1"}}
+	if resp := forward(`{"jsonrpc":"2.0","method":"get","params":[1],"id":1}`, user1); resp != `{"jsonrpc":"2.0","id":1,"result":{"n":1}}` {
+		t.Errorf("miss: got %s", resp)
+	}
+	if resp := forward(`{"jsonrpc":"2.0","method":"get","params":[1],"id":"a"}`, user1); resp != `{"jsonrpc":"2.0","id":"a","result":{"n":1}}` {
+		t.Errorf("hit: got %s", resp)
+	}
+
+	// other params, session headers and methods are not shared
+	forward(`{"jsonrpc":"2.0","method":"get","params":[2],"id":3}`, user1)
+	forward(`{"jsonrpc":"2.0","method":"get","params":[1],"id":4}`, http.Header{"Authorization": {"Bearer 2"}})
+	forward(`{"jsonrpc":"2.0","method":"set","params":[1],"id":5}`, user1)
+	forward(`{"jsonrpc":"2.0","method":"set","params":[1],"id":6}`, user1)
+
+	// results over size limit are not cached
+	forward(`{"jsonrpc":"2.0","method":"big","id":7}`, user1)
+	forward(`{"jsonrpc":"2.0","method":"big","id":8}`, user1)
+
+	if n := atomic.LoadInt32(&calls); n != 7 {
+		t.Errorf("got %d backend calls", n)
+	}
+	if v := testutil.ToFloat64(requests.WithLabelValues(srv.URL, "get", "hit")); v != 1 {
+		t.Errorf("got hits %v", v)
+	}
+	if v := testutil.ToFloat64(requests.WithLabelValues(srv.URL, "get", "miss")); v != 3 {
+		t.Errorf("got misses %v", v)
+	}
+}
+
+func TestHttpForwarderCacheKey(t *testing.T) {
+	calls := make(map[string]int)
+	var lock sync.Mutex
+	backend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			calls[name]++
+			lock.Unlock()
+			w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"` + name + `"}`))
+		}))
+	}
+	blue, green := backend("blue"), backend("green")
+	defer blue.Close()
+	defer green.Close()
+
+	store, _ := OpenResponseCache("")
+	rss, err := newRouteStates([]ProxyRule{{Src: "/rpc", DstUrl: blue.URL, GreenUrl: green.URL}}, clock.Real)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hf := NewHttpForwarder(blue.URL, nil, 10, 1)
+	hf.route = rss["/rpc"]
+	hf.cache = newMethodCache([]CacheRule{{Method: "get", Ttl: time.Minute}}, store, nil, logger{})
+	rf := hf.newRequestForwarder(&wsConn{})
+	forward := func(features featureSet) string {
+		rpcReq, _ := rf.rewriteRequest([]byte(`{"jsonrpc":"2.0","method":"get","id":1}`), blue.URL)
+		rpcReq.features = features
+		rf.maxParallelRequest <- struct{}{}
+		return string(hf.forward(rf, rpcReq, http.Header{}))
+	}
+
+	// key is built for active blue/green destination and features header
+	tests := []struct {
+		name     string
+		switchTo string
+		features featureSet
+		want     string
+		calls    map[string]int
+	}{
+		{"blue miss", "", nil, "blue", map[string]int{"blue": 1}},
+		{"blue hit", "", nil, "blue", map[string]int{"blue": 1}},
+		{"features miss", "", featureSet{FeatureOrdered}, "blue", map[string]int{"blue": 2}},
+		{"green miss", colorGreen, nil, "green", map[string]int{"blue": 2, "green": 1}},
+		{"green hit", "", nil, "green", map[string]int{"blue": 2, "green": 1}},
+	}
+
+	for _, tt := range tests {
+		if tt.switchTo != "" {
+			hf.route.switchTo(tt.switchTo)
+		}
+		resp := forward(tt.features)
+
+		lock.Lock()
+		if resp != `{"jsonrpc":"2.0","id":1,"result":"`+tt.want+`"}` || !reflect.DeepEqual(calls, tt.calls) {
+			t.Errorf("%s: got %s, calls %v", tt.name, resp, calls)
+		}
+		lock.Unlock()
+	}
+}
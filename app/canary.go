@@ -0,0 +1,51 @@
+package app
+
+import (
+	"hash/fnv"
+	"net/http"
+)
+
+// Backend versions for canary routing, used as version label in metrics.
+const (
+	versionStable = "stable"
+	versionCanary = "canary"
+)
+
+// canaryVersion selects route destination version for request. Sessions with CanaryTag and requests with
+// CanaryHeader are always routed to canary, other sessions are split by CanaryPercent. Split is sticky per session.
+func (r ProxyRule) canaryVersion(s *session, h http.Header) string {
+	if r.CanaryUrl == "" {
+		return versionStable
+	}
+
+	if r.CanaryTag != "" && s != nil && s.hasTag(r.CanaryTag) {
+		return versionCanary
+	} else if r.CanaryHeader != "" && h.Get(r.CanaryHeader) != "" {
+		return versionCanary
+	} else if r.CanaryPercent <= 0 || s == nil {
+		return versionStable
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(r.Src + s.id))
+	if float64(hash.Sum32()%10000) < r.CanaryPercent*100 {
+		return versionCanary
+	}
+
+	return versionStable
+}
+
+// canary sets canary destination for request and returns destination version.
+func (hf *HttpForwarder) canary(rf *requestForwarder, req *BackendRequest) string {
+	rs := hf.routeState(req.Route)
+	if rs == nil {
+		return versionStable
+	}
+
+	version := rs.rule.canaryVersion(rf.session, req.Header)
+	if version == versionCanary {
+		req.DstUrl = rs.rule.CanaryUrl
+	}
+
+	return version
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestProxyRuleCanaryVersion(t *testing.T) {
+	r := ProxyRule{Src: "/rpc", CanaryUrl: "http://canary/rpc", CanaryTag: "beta", CanaryHeader: "X-Canary"}
+
+	s := newSession("/rpc", nil)
+	if v := r.canaryVersion(s, http.Header{}); v != versionStable {
+		t.Errorf("got %s for 0 percent", v)
+	}
+
+	if v := r.canaryVersion(s, http.Header{"X-Canary": {"1"}}); v != versionCanary {
+		t.Errorf("got %s for header", v)
+	}
+
+	s.addTag("beta")
+	if v := r.canaryVersion(s, http.Header{}); v != versionCanary {
+		t.Errorf("got %s for tag", v)
+	}
+
+	if v := (ProxyRule{CanaryTag: "beta"}).canaryVersion(s, http.Header{}); v != versionStable {
+		t.Errorf("got %s without canary url", v)
+	}
+
+	// sticky split
+	r = ProxyRule{Src: "/rpc", CanaryUrl: "http://canary/rpc", CanaryPercent: 20}
+	canary := 0
+	for i := 0; i < 1000; i++ {
+		s := &session{id: strconv.Itoa(i)}
+		v := r.canaryVersion(s, nil)
+		if v != r.canaryVersion(s, nil) {
+			t.Fatalf("version changed for session %s", s.id)
+		}
+		if v == versionCanary {
+			canary++
+		}
+	}
+
+	if canary < 150 || canary > 250 {
+		t.Errorf("got %d canary sessions of 1000 for 20%%", canary)
+	}
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// cancelMethod is a reserved method for cancellation of in-flight request by id: {"method":"ws2http.cancel","params":{"id":1}}.
+const cancelMethod = "ws2http.cancel"
+
+var errCancelled = errors.New("request cancelled")
+
+// inflightRequests are cancel functions of connection requests by id.
+type inflightRequests struct {
+	lock    sync.Mutex
+	cancels map[string]*context.CancelFunc
+}
+
+func newInflightRequests() *inflightRequests {
+	return &inflightRequests{cancels: make(map[string]*context.CancelFunc)}
+}
+
+// requestKey returns map key for raw json-rpc id, numbers and strings with the same value are different ids.
+func requestKey(id json.RawMessage) string {
+	return string(bytes.TrimSpace(id))
+}
+
+// add returns cancelable request context for id, release must be called after request.
+// Requests without id can't be cancelled.
+func (r *inflightRequests) add(ctx context.Context, id json.RawMessage) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	if id == nil {
+		return ctx, cancel
+	}
+
+	key, entry := requestKey(id), &cancel
+	r.lock.Lock()
+	r.cancels[key] = entry
+	r.lock.Unlock()
+
+	return ctx, func() {
+		r.lock.Lock()
+		if r.cancels[key] == entry {
+			delete(r.cancels, key)
+		}
+		r.lock.Unlock()
+		cancel()
+	}
+}
+
+// cancel cancels in-flight request by id, returns false if request is not found.
+func (r *inflightRequests) cancel(id json.RawMessage) bool {
+	r.lock.Lock()
+	cancel, ok := r.cancels[requestKey(id)]
+	delete(r.cancels, requestKey(id))
+	r.lock.Unlock()
+
+	if ok {
+		(*cancel)()
+	}
+
+	return ok
+}
+
+// checkCancel handles cancelMethod request: in-flight request is cancelled and returns JsonRpcCancelled error,
+// cancel request gets true result if request was found. Returns false for other messages.
+func (rf *requestForwarder) checkCancel(msg []byte, reply func(resp []byte) error) bool {
+	if !bytes.Contains(msg, []byte(cancelMethod)) {
+		return false
+	}
+
+	req, err := parseRequest(msg)
+	if err != nil || req.Method != cancelMethod {
+		return false
+	}
+
+	// params: {"id":1} or [1]
+	var target json.RawMessage
+	if req.Params != nil {
+		var named struct {
+			Id json.RawMessage `json:"id"`
+		}
+		var positional []json.RawMessage
+		if json.Unmarshal(*req.Params, &named) == nil {
+			target = named.Id
+		} else if json.Unmarshal(*req.Params, &positional) == nil && len(positional) > 0 {
+			target = positional[0]
+		}
+	}
+
+	cancelled := target != nil && string(target) != "null" && rf.requests.cancel(target)
+	rf.Tracef("type=cancel id=%s cancelled=%v", target, cancelled)
+	if req.Id != nil {
+		resp := JsonRpcResponse{Version: "2.0", Id: req.Id, Result: cancelled}
+		if err := reply(resp.JSON()); err != nil {
+			rf.Errorf("can't send data to client lastErr=%s", err)
+		}
+	}
+
+	return true
+}
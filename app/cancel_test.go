@@ -0,0 +1,65 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRequestForwarderCancel(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer backend.Close()
+
+	srv := httptest.NewServer(NewHttpForwarder(backend.URL, nil, 10, 2).WebsocketHandler())
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial(strings.Replace(srv.URL, "http", "ws", 1), http.Header{"Origin": {srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	ws.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"slow","id":1}`))
+	ws.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"ws2http.cancel","params":{"id":1},"id":"c"}`))
+	ws.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"ws2http.cancel","params":[42],"id":"d"}`))
+
+	got := map[string]string{}
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for len(got) < 3 {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("got %v, %v", got, err)
+		}
+
+		var resp struct {
+			Id     json.RawMessage `json:"id"`
+			Result json.RawMessage `json:"result"`
+			Error  struct {
+				Code int `json:"code"`
+			} `json:"error"`
+		}
+		json.Unmarshal(msg, &resp)
+		if resp.Error.Code != 0 {
+			got[requestKey(resp.Id)] = "error"
+			if resp.Error.Code != JsonRpcCancelled {
+				t.Errorf("got %s", msg)
+			}
+		} else {
+			got[requestKey(resp.Id)] = string(resp.Result)
+		}
+	}
+
+	if got[`"c"`] != "true" || got[`"d"`] != "false" || got["1"] != "error" {
+		t.Errorf("got %v", got)
+	}
+}
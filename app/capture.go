@@ -0,0 +1,221 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+// storageTimeFormat is a sortable time prefix for names in Storage.
+const storageTimeFormat = "2006-01-02T15-04-05.000000000"
+
+// captureRecord is a captured client request or backend response.
+type captureRecord struct {
+	Time time.Time       `json:"time"`
+	Addr string          `json:"addr"` // client address like in /debug/conns
+	Type string          `json:"type"` // request or response
+	Data json.RawMessage `json:"data"`
+}
+
+var errNoStorage = errors.New("storage is not configured")
+
+// captureStore keeps traced traffic for /debug/conns/export with retention limits: records older than maxAge
+// are purged periodically, oldest records are removed over maxSize bytes. Routes with DisableDebug are never captured.
+type captureStore struct {
+	lock    sync.Mutex
+	maxAge  time.Duration
+	maxSize int // captured data bytes, 0 disables capture
+	size    int
+	records []captureRecord // ordered by time
+	storage Storage         // storage for saved exports, optional
+	clock   clock.Clock
+}
+
+// newCaptureStore returns store with retention limits and storage for saved exports, expired records are purged
+// by clock clk. Nil store is disabled.
+func newCaptureStore(maxAge time.Duration, maxSize int, storage Storage, clk clock.Clock) *captureStore {
+	c := &captureStore{maxAge: maxAge, maxSize: maxSize, storage: storage, clock: clk}
+	if maxSize > 0 && maxAge > 0 {
+		ticker := clk.NewTicker(maxAge / 10)
+		go func() {
+			for now := range ticker.C() {
+				c.purgeExpired(now)
+			}
+		}()
+	}
+
+	return c
+}
+
+// add captures debug message if capture is enabled.
+func (c *captureStore) add(m debugMessage) {
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.maxSize <= 0 || len(m.data) > c.maxSize {
+		return
+	}
+
+	rec := captureRecord{Time: c.clock.Now(), Addr: m.req.RemoteAddr, Type: "request", Data: append(json.RawMessage(nil), m.data...)}
+	if m.msgType == httpResponse {
+		rec.Type = "response"
+	}
+	c.records = append(c.records, rec)
+	c.size += len(rec.Data)
+
+	// remove oldest records over max size
+	i := 0
+	for ; c.size > c.maxSize; i++ {
+		c.size -= len(c.records[i].Data)
+	}
+	c.records = c.records[i:]
+}
+
+// purgeExpired removes records older than max age.
+func (c *captureStore) purgeExpired(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	i := 0
+	for ; i < len(c.records) && now.Sub(c.records[i].Time) > c.maxAge; i++ {
+		c.size -= len(c.records[i].Data)
+	}
+	c.records = c.records[i:]
+}
+
+// purge removes records of client addr or all records if addr is empty. Returns number of removed records.
+func (c *captureStore) purge(addr string) int {
+	if c == nil {
+		return 0
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var kept []captureRecord
+	for _, r := range c.records {
+		if addr != "" && r.Addr != addr {
+			kept = append(kept, r)
+		} else {
+			c.size -= len(r.Data)
+		}
+	}
+
+	n := len(c.records) - len(kept)
+	c.records = kept
+	return n
+}
+
+// list returns records of client addr.
+func (c *captureStore) list(addr string) []captureRecord {
+	list := []captureRecord{}
+	if c == nil {
+		return list
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, r := range c.records {
+		if r.Addr == addr {
+			list = append(list, r)
+		}
+	}
+
+	return list
+}
+
+// save puts captured traffic of client addr to storage as captures/<addr>/<time>.json. Returns storage name.
+func (c *captureStore) save(ctx context.Context, addr string) (string, error) {
+	if c == nil || c.storage == nil {
+		return "", errNoStorage
+	}
+
+	data, err := json.Marshal(c.list(addr))
+	if err != nil {
+		return "", err
+	}
+
+	name := "captures/" + addr + "/" + c.clock.Now().UTC().Format(storageTimeFormat) + ".json"
+	return name, c.storage.Put(ctx, name, data)
+}
+
+// purgeSaved removes saved exports of client addr or all saved exports if addr is empty from storage.
+func (c *captureStore) purgeSaved(ctx context.Context, addr string) (int, error) {
+	if c == nil || c.storage == nil {
+		return 0, nil
+	}
+
+	prefix := "captures/"
+	if addr != "" {
+		prefix += addr + "/"
+	}
+
+	names, err := c.storage.List(ctx, prefix)
+	for i, name := range names {
+		if err = c.storage.Delete(ctx, name); err != nil {
+			return i, err
+		}
+	}
+
+	return len(names), err
+}
+
+// export returns captured traffic of client as json, with save=1 export is saved to storage.
+// Example: curl http://localhost:8090/debug/conns/export?addr=127.0.0.1:50000
+func (d *debugApp) export(w http.ResponseWriter, r *http.Request) {
+	addr := r.FormValue("addr")
+	if r.FormValue("save") != "1" {
+		writeJSON(w, d.captures.list(addr))
+		return
+	}
+
+	name, err := d.captures.save(r.Context(), addr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]string{"name": name})
+}
+
+// purgeRequest is a body of /admin/purge request.
+type purgeRequest struct {
+	Addr string `json:"addr"`
+	All  bool   `json:"all"`
+}
+
+// purge removes captured traffic of client or all captured traffic.
+// Example: curl -H 'Content-Type: application/json' -d '{"addr":"127.0.0.1:50000"}' http://localhost:8090/admin/purge
+func (a *App) purge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var pr purgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&pr); err != nil || (pr.Addr == "" && !pr.All) {
+		http.Error(w, "invalid purge request", http.StatusBadRequest)
+		return
+	}
+
+	n := a.captures.purge(pr.Addr)
+	saved, err := a.captures.purgeSaved(r.Context(), pr.Addr)
+	if err != nil {
+		a.Errorf("can't purge saved captures addr=%s err=%s", pr.Addr, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	a.Printf("purged captured traffic addr=%s all=%v records=%d saved=%d", pr.Addr, pr.All, n, saved)
+	a.audit(r, "purge", pr)
+	writeJSON(w, map[string]int{"purged": n, "purgedSaved": saved})
+}
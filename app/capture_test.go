@@ -0,0 +1,49 @@
+package app
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestCaptureStore(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c := newCaptureStore(time.Minute, 10, nil, clk)
+	a, b := &http.Request{RemoteAddr: "a"}, &http.Request{RemoteAddr: "b"}
+
+	c.add(debugMessage{msgType: wsRequest, req: a, data: []byte("1234")})
+	c.add(debugMessage{msgType: httpResponse, req: b, data: []byte("5678")})
+	c.add(debugMessage{msgType: wsRequest, req: a, data: []byte("90")})
+	if len(c.list("a")) != 2 || c.size != 10 {
+		t.Fatalf("add: got %d records for a, size = %d", len(c.list("a")), c.size)
+	}
+
+	// oldest record is removed over max size
+	c.add(debugMessage{msgType: wsRequest, req: b, data: []byte("x")})
+	if l := c.list("a"); len(l) != 1 || string(l[0].Data) != "90" || c.size != 7 {
+		t.Errorf("max size: got %v, size = %d", l, c.size)
+	}
+
+	if n := c.purge("b"); n != 2 || len(c.list("b")) != 0 || c.size != 2 {
+		t.Errorf("purge: got %d purged, size = %d", n, c.size)
+	}
+
+	c.purgeExpired(clk.Now().Add(2 * time.Minute))
+	if len(c.records) != 0 || c.size != 0 {
+		t.Errorf("max age: got %d records, size = %d", len(c.records), c.size)
+	}
+
+	// disabled capture
+	c = newCaptureStore(0, 0, nil, clk)
+	c.add(debugMessage{msgType: wsRequest, req: a, data: []byte("1")})
+	if len(c.records) != 0 {
+		t.Errorf("disabled: got %d records", len(c.records))
+	}
+	c = nil
+	c.add(debugMessage{msgType: wsRequest, req: a, data: []byte("1")})
+	if l := c.list("a"); len(l) != 0 {
+		t.Errorf("nil: got %v", l)
+	}
+}
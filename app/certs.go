@@ -0,0 +1,88 @@
+package app
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+// certReloader serves listener certificate and reloads it after certificate or key file change.
+type certReloader struct {
+	certFile, keyFile string
+	interval          time.Duration // min time between file checks, 0 disables reload
+	clock             clock.Clock
+
+	lock    sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // latest modification time of loaded files
+	checked time.Time
+
+	logger
+}
+
+// newCertReloader loads certificate and key files, files are checked each interval of clock clk.
+func newCertReloader(certFile, keyFile string, interval time.Duration, clk clock.Clock, l logger) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile, interval: interval, clock: clk, logger: l}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// load reads certificate and key files, must be called with lock held or before first use.
+func (c *certReloader) load() error {
+	modTime, err := c.filesModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.cert, c.modTime, c.checked = &cert, modTime, c.clock.Now()
+	return nil
+}
+
+// filesModTime returns latest modification time of certificate and key files.
+func (c *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// getCertificate returns current certificate for tls.Config. Files are checked for changes at most once per interval
+// on handshakes, certificate that fails to load is logged and previous certificate is kept.
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if now := c.clock.Now(); c.interval > 0 && now.Sub(c.checked) >= c.interval {
+		c.checked = now
+		if modTime, err := c.filesModTime(); err != nil {
+			c.Errorf("can't check tls certificate err=%s", err)
+		} else if !modTime.Equal(c.modTime) {
+			if err := c.load(); err != nil {
+				c.Errorf("can't reload tls certificate err=%s", err)
+			} else {
+				c.Printf("tls certificate reloaded cert=%s", c.certFile)
+			}
+		}
+	}
+
+	return c.cert, nil
+}
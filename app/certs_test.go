@@ -0,0 +1,91 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+// writeTestCert writes self-signed certificate with common name and its key to files.
+func writeTestCert(t *testing.T, certFile, keyFile, name string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+}
+
+func TestCertReloader(t *testing.T) {
+	c := clock.NewFake(time.Now())
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if _, err := newCertReloader(certFile, keyFile, time.Minute, c, logger{}); err == nil {
+		t.Error("missing files: got no error")
+	}
+
+	writeTestCert(t, certFile, keyFile, "old.example.com")
+	certs, err := newCertReloader(certFile, keyFile, time.Minute, c, logger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commonName := func() string {
+		cert, _ := certs.getCertificate(nil)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.Subject.CommonName
+	}
+
+	// files are checked once per interval
+	writeTestCert(t, certFile, keyFile, "new.example.com")
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(certFile, later, later)
+	if cn := commonName(); cn != "old.example.com" {
+		t.Errorf("before interval: got %s", cn)
+	}
+	c.Advance(time.Minute)
+	if cn := commonName(); cn != "new.example.com" {
+		t.Errorf("after interval: got %s", cn)
+	}
+
+	// broken files keep previous certificate
+	ioutil.WriteFile(keyFile, []byte("broken"), 0600)
+	os.Chtimes(keyFile, later.Add(time.Hour), later.Add(time.Hour))
+	c.Advance(time.Minute)
+	if cn := commonName(); cn != "new.example.com" {
+		t.Errorf("broken key: got %s", cn)
+	}
+}
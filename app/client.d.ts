@@ -0,0 +1,42 @@
+// Type declarations for ws2http /client.js.
+
+declare namespace Ws2http {
+  interface Features {
+    version: number;            // control protocol version
+    controlAcks: boolean;       // proxy acknowledges control messages
+    controlErrorMethod: string; // notification sent for rejected control messages
+    csrfCookie?: string;        // browser mode csrf cookie, sent as CSRF handshake
+    reauthMethod?: string;      // notification sent before auth token expiry
+    routes: string[];           // websocket endpoints
+  }
+
+  interface Options {
+    reconnect?: boolean; // default is true
+    minBackoff?: number; // first reconnect delay in ms, default is 100
+    maxBackoff?: number; // max reconnect delay in ms, default is 30000
+    onOpen?: () => void;
+    onClose?: (e: CloseEvent) => void;
+    onReauth?: (params: { expiresAt: string }) => void;
+    onControlError?: (params: { command: string; reason: string; message: string }) => void;
+    onNotification?: (method: string, params: unknown) => void;
+  }
+
+  interface RpcError extends Error {
+    code: number;
+    data?: unknown;
+  }
+}
+
+declare class Ws2http {
+  static features: Ws2http.Features;
+  constructor(url: string, options?: Ws2http.Options);
+  call<T = unknown>(method: string, params?: unknown, timeout?: number): Promise<T>;
+  notify(method: string, params?: unknown): void;
+  set(header: string, value: string): void;
+  auth(token: string): void;
+  tag(tag: string): void;
+  subscribe(method: string, fn: (params: unknown) => void): () => void;
+  close(): void;
+}
+
+export = Ws2http;
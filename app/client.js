@@ -0,0 +1,200 @@
+/* ws2http client: JSON-RPC over websocket with control protocol, id correlation and reconnects.
+ * Generated by ws2http for this instance, see /client.d.ts for types.
+ *
+ *   var c = new Ws2http('wss://example.com/rpc');
+ *   c.auth('Bearer token');
+ *   c.subscribe('maintenance', function (params) { console.log(params); });
+ *   c.call('users.get', {id: 1}).then(function (user) { console.log(user); });
+ */
+(function (root) {
+  'use strict';
+
+  var features = {{.}};
+
+  function Ws2http(url, options) {
+    this.url = url;
+    this.options = options || {};
+    this.minBackoff = this.options.minBackoff || 100;
+    this.maxBackoff = this.options.maxBackoff || 30000;
+    this.backoff = this.minBackoff;
+    this.seq = 0;
+    this.pending = {};
+    this.subs = {};
+    this.control = [];
+    this.queue = [];
+    this.ws = null;
+    this.closed = false;
+    this.connect();
+  }
+
+  Ws2http.features = features;
+
+  function cookie(name) {
+    var m = document.cookie.match(new RegExp('(?:^|; )' + name.replace(/[.*+?^${}()|[\]\\]/g, '\\$&') + '=([^;]*)'));
+    return m ? decodeURIComponent(m[1]) : '';
+  }
+
+  Ws2http.prototype.connect = function () {
+    var self = this;
+    var ws = new WebSocket(this.url, ['ws2http.v' + features.version]);
+
+    ws.onopen = function () {
+      self.backoff = self.minBackoff;
+      if (features.csrfCookie) {
+        ws.send('CSRF ' + cookie(features.csrfCookie));
+      }
+      self.control.forEach(function (cmd) { ws.send(cmd); });
+
+      self.ws = ws;
+      var queue = self.queue;
+      self.queue = [];
+      queue.forEach(function (frame) { self.send(frame.data, frame.id); });
+
+      if (self.options.onOpen) {
+        self.options.onOpen();
+      }
+    };
+
+    ws.onmessage = function (e) {
+      self.receive(e.data);
+    };
+
+    ws.onclose = function (e) {
+      self.ws = null;
+      Object.keys(self.pending).forEach(function (id) {
+        if (self.pending[id].sent) {
+          self.reject(id, new Error('connection lost'));
+        }
+      });
+
+      if (self.options.onClose) {
+        self.options.onClose(e);
+      }
+
+      if (!self.closed && self.options.reconnect !== false) {
+        setTimeout(function () { self.connect(); }, self.backoff);
+        self.backoff = Math.min(self.backoff * 2, self.maxBackoff);
+      }
+    };
+  };
+
+  Ws2http.prototype.send = function (data, id) {
+    if (this.ws && this.ws.readyState === WebSocket.OPEN) {
+      this.ws.send(data);
+      if (id && this.pending[id]) {
+        this.pending[id].sent = true;
+      }
+    } else {
+      this.queue.push({data: data, id: id});
+    }
+  };
+
+  Ws2http.prototype.receive = function (data) {
+    // control acks and replies (OK, ERR, VERSION) are not json
+    if (typeof data !== 'string' || (data.charAt(0) !== '{' && data.charAt(0) !== '[')) {
+      return;
+    }
+
+    var msgs;
+    try {
+      msgs = [].concat(JSON.parse(data));
+    } catch (e) {
+      return;
+    }
+
+    for (var i = 0; i < msgs.length; i++) {
+      var msg = msgs[i];
+      if (msg.method && msg.id == null) {
+        this.emit(msg.method, msg.params);
+      } else if (msg.error) {
+        var err = new Error(msg.error.message);
+        err.code = msg.error.code;
+        err.data = msg.error.data;
+        this.reject(msg.id, err);
+      } else if (this.pending[msg.id]) {
+        this.pending[msg.id].resolve(msg.result);
+        delete this.pending[msg.id];
+      }
+    }
+  };
+
+  Ws2http.prototype.reject = function (id, err) {
+    if (this.pending[id]) {
+      this.pending[id].reject(err);
+      delete this.pending[id];
+    }
+  };
+
+  Ws2http.prototype.emit = function (method, params) {
+    var subs = this.subs[method] || [];
+    subs.forEach(function (fn) { fn(params); });
+
+    if (method === features.reauthMethod && this.options.onReauth) {
+      this.options.onReauth(params);
+    } else if (method === features.controlErrorMethod && this.options.onControlError) {
+      this.options.onControlError(params);
+    } else if (subs.length === 0 && this.options.onNotification) {
+      this.options.onNotification(method, params);
+    }
+  };
+
+  // call sends request and resolves with result or rejects with error {code, message, data}.
+  Ws2http.prototype.call = function (method, params, timeout) {
+    var self = this, id = ++this.seq;
+    return new Promise(function (resolve, reject) {
+      self.pending[id] = {resolve: resolve, reject: reject, sent: false};
+      if (timeout) {
+        setTimeout(function () { self.reject(id, new Error('timeout')); }, timeout);
+      }
+      self.send(JSON.stringify({jsonrpc: '2.0', id: id, method: method, params: params}), id);
+    });
+  };
+
+  Ws2http.prototype.notify = function (method, params) {
+    this.send(JSON.stringify({jsonrpc: '2.0', method: method, params: params}));
+  };
+
+  // sendControl sends control command, it is restored on reconnect replacing previous command with prefix.
+  Ws2http.prototype.sendControl = function (cmd, prefix) {
+    this.control = this.control.filter(function (c) { return c.indexOf(prefix) !== 0; });
+    this.control.push(cmd);
+    if (this.ws) {
+      this.ws.send(cmd);
+    }
+  };
+
+  // set sets session header passed to backend, value must not contain spaces.
+  Ws2http.prototype.set = function (header, value) {
+    this.sendControl('SET ' + header + ' ' + value, 'SET ' + header + ' ');
+  };
+
+  Ws2http.prototype.auth = function (token) {
+    this.sendControl('AUTH ' + token, 'AUTH ');
+  };
+
+  Ws2http.prototype.tag = function (tag) {
+    this.sendControl('TAG ' + tag, 'TAG ' + tag);
+  };
+
+  // subscribe adds notification callback and returns unsubscribe function.
+  Ws2http.prototype.subscribe = function (method, fn) {
+    this.subs[method] = (this.subs[method] || []).concat(fn);
+    var self = this;
+    return function () {
+      self.subs[method] = (self.subs[method] || []).filter(function (f) { return f !== fn; });
+    };
+  };
+
+  Ws2http.prototype.close = function () {
+    this.closed = true;
+    if (this.ws) {
+      this.ws.close();
+    }
+  };
+
+  if (typeof module === 'object' && module.exports) {
+    module.exports = Ws2http;
+  } else {
+    root.Ws2http = Ws2http;
+  }
+})(typeof self !== 'undefined' ? self : this);
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"text/template"
+)
+
+var (
+	//go:embed client.js
+	clientJs string
+	//go:embed client.d.ts
+	clientDts string
+)
+
+// clientFeatures are instance features passed to generated /client.js.
+type clientFeatures struct {
+	Version            int      `json:"version"`
+	ControlAcks        bool     `json:"controlAcks"`
+	ControlErrorMethod string   `json:"controlErrorMethod"`
+	CsrfCookie         string   `json:"csrfCookie,omitempty"`
+	ReauthMethod       string   `json:"reauthMethod,omitempty"`
+	Routes             []string `json:"routes"`
+}
+
+// features returns client features of instance.
+func (a *App) features() clientFeatures {
+	f := clientFeatures{Version: ProtocolVersion, ControlAcks: a.ControlAcks, ControlErrorMethod: controlErrorMethod, Routes: []string{}}
+	if a.BrowserMode {
+		f.CsrfCookie = a.CsrfCookie
+	}
+	if a.TokenExpiryNotice > 0 {
+		f.ReauthMethod = reauthMethod
+	}
+	for _, r := range a.RedirectRules {
+		f.Routes = append(f.Routes, r.Src)
+	}
+
+	return f
+}
+
+// clientHandler serves JavaScript client generated for instance features and its TypeScript declarations.
+// Example: <script src="http://localhost:8090/client.js"></script>
+func (a *App) clientHandler() (http.Handler, error) {
+	features, err := json.Marshal(a.features())
+	if err != nil {
+		return nil, err
+	}
+
+	var js bytes.Buffer
+	if err := template.Must(template.New("client.js").Parse(clientJs)).Execute(&js, string(features)); err != nil {
+		return nil, err
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case a.endpoint("/client.js"):
+			w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+			w.Write(js.Bytes())
+		case a.endpoint("/client.d.ts"):
+			w.Header().Set("Content-Type", "application/typescript; charset=utf-8")
+			w.Write([]byte(clientDts))
+		default:
+			http.NotFound(w, r)
+		}
+	}), nil
+}
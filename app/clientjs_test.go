@@ -0,0 +1,31 @@
+package app
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientHandler(t *testing.T) {
+	a := &App{ControlAcks: true, BrowserMode: true, CsrfCookie: "csrf", TokenExpiryNotice: time.Minute, RedirectRules: []ProxyRule{{Src: "/rpc"}}}
+	h, err := a.clientHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/client.js", nil))
+	body, _ := ioutil.ReadAll(w.Body)
+	want := `var features = {"version":1,"controlAcks":true,"controlErrorMethod":"ws2http.controlError","csrfCookie":"csrf","reauthMethod":"ws2http.reauth","routes":["/rpc"]};`
+	if !strings.Contains(string(body), want) || w.Header().Get("Content-Type") != "application/javascript; charset=utf-8" {
+		t.Errorf("got %s", body)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/client.d.ts", nil))
+	if !strings.Contains(w.Body.String(), "declare class Ws2http") {
+		t.Errorf("got %s", w.Body)
+	}
+}
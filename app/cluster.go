@@ -0,0 +1,184 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+const (
+	clusterSessionTTL      = time.Minute      // registry entries of crashed instances expire after ttl
+	clusterRefreshInterval = 20 * time.Second // local sessions ttl refresh interval
+	clusterTimeout         = time.Second      // registry request timeout
+)
+
+// ClusterRegistry maps session ids to instances for multiple ws2http instances, so admin API of any instance
+// finds instance of session connected elsewhere and forwards admin requests to other instances.
+type ClusterRegistry interface {
+	Register(ctx context.Context, session, instance string, ttl time.Duration) error
+	Unregister(ctx context.Context, session string) error
+	Lookup(ctx context.Context, session string) (string, error) // returns empty instance for unknown session
+
+	// Heartbeat announces live instance for ttl, Instances returns live instances.
+	Heartbeat(ctx context.Context, instance string, ttl time.Duration) error
+	Instances(ctx context.Context) ([]string, error)
+}
+
+// ClusterRegistryFactory returns cluster registry for url, like redis://localhost:6379/0.
+type ClusterRegistryFactory func(u *url.URL) (ClusterRegistry, error)
+
+var (
+	clusterRegistriesLock sync.RWMutex
+	clusterRegistries     = map[string]ClusterRegistryFactory{
+		"redis": newRedisRegistry,
+	}
+)
+
+// RegisterClusterRegistry registers cluster registry factory for url scheme, like etcd or consul adapters.
+// Redis registry is registered for redis scheme.
+func RegisterClusterRegistry(scheme string, f ClusterRegistryFactory) {
+	clusterRegistriesLock.Lock()
+	defer clusterRegistriesLock.Unlock()
+	clusterRegistries[scheme] = f
+}
+
+// OpenClusterRegistry returns cluster registry for url by registered scheme.
+func OpenClusterRegistry(rawurl string) (ClusterRegistry, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	clusterRegistriesLock.RLock()
+	f, ok := clusterRegistries[u.Scheme]
+	clusterRegistriesLock.RUnlock()
+	if !ok {
+		return nil, ErrUnknownCluster
+	}
+
+	return f(u)
+}
+
+// newSessionIdPrefix returns session id prefix with instance id or random prefix for empty id.
+func newSessionIdPrefix(id string) string {
+	if id != "" {
+		return id + "-"
+	}
+
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b) + "-"
+}
+
+// sessionInstanceId returns instance id from cluster session id.
+func sessionInstanceId(session string) string {
+	if i := strings.LastIndex(session, "-"); i > 0 {
+		return session[:i]
+	}
+
+	return ""
+}
+
+// setCluster enables registration of sessions in cluster registry with instance url, heartbeats are sent
+// each clusterRefreshInterval of clock c.
+func (r *sessionRegistry) setCluster(cluster ClusterRegistry, instance string, c clock.Clock, l logger) {
+	if cs, ok := cluster.(clockSetter); ok {
+		cs.setClock(c)
+	}
+	r.cluster, r.instance, r.logger = cluster, instance, l
+	r.heartbeat()
+	ticker := c.NewTicker(clusterRefreshInterval)
+	go func() {
+		for range ticker.C() {
+			r.heartbeat()
+			for _, s := range r.find(sessionFilter{}) {
+				r.register(s)
+			}
+		}
+	}()
+}
+
+// heartbeat announces instance in cluster registry.
+func (r *sessionRegistry) heartbeat() {
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+	defer cancel()
+	if err := r.cluster.Heartbeat(ctx, r.instance, clusterSessionTTL); err != nil {
+		r.Errorf("can't announce instance=%s in cluster err=%s", r.instance, err)
+	}
+}
+
+// register saves session instance in cluster registry.
+func (r *sessionRegistry) register(s *session) {
+	if r.cluster == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+	defer cancel()
+	if err := r.cluster.Register(ctx, s.id, r.instance, clusterSessionTTL); err != nil {
+		r.Errorf("can't register session=%s in cluster err=%s", s.id, err)
+	}
+}
+
+// unregister removes session from cluster registry.
+func (r *sessionRegistry) unregister(s *session) {
+	if r.cluster == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), clusterTimeout)
+	defer cancel()
+	if err := r.cluster.Unregister(ctx, s.id); err != nil {
+		r.Errorf("can't unregister session=%s in cluster err=%s", s.id, err)
+	}
+}
+
+// sessionLocation is a result of /admin/sessions lookup.
+type sessionLocation struct {
+	Session    string `json:"session"`
+	Instance   string `json:"instance,omitempty"`   // instance url from cluster registry
+	InstanceId string `json:"instanceId,omitempty"` // instance id from session id
+	Zone       string `json:"zone,omitempty"`       // zone of local instance
+	Local      bool   `json:"local"`
+}
+
+// lookupSession returns session instance from local sessions or cluster registry.
+// Example: curl http://localhost:8090/admin/sessions?id=42
+func (a *App) lookupSession(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	} else if id == "" {
+		http.Error(w, "session id is required", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := a.sessions.get(id); ok {
+		writeJSON(w, sessionLocation{Session: id, Instance: a.sessions.instance, InstanceId: a.instance.id, Zone: a.instance.zone, Local: true})
+		return
+	} else if a.sessions.cluster == nil {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), clusterTimeout)
+	defer cancel()
+	instance, err := a.sessions.cluster.Lookup(ctx, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	} else if instance == "" {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, sessionLocation{Session: id, Instance: instance, InstanceId: sessionInstanceId(id)})
+}
@@ -0,0 +1,66 @@
+package app
+
+import (
+	"sync"
+)
+
+// Codec converts client frames into json-rpc messages and json-rpc messages into client frames,
+// like MessagePack or CBOR envelopes. Control messages (SET, TAG, CSRF) are text frames and are not decoded,
+// other text frames of binary codecs are json-rpc.
+type Codec interface {
+	Decode(frame []byte) ([]byte, error) // returns json-rpc request
+	Encode(msg []byte) ([]byte, error)   // returns frame for json-rpc response or notification
+	Binary() bool                        // send encoded frames as binary frames
+}
+
+// jsonCodec is a default codec: json-rpc in text frames.
+type jsonCodec struct{}
+
+func (jsonCodec) Decode(frame []byte) ([]byte, error) { return frame, nil }
+func (jsonCodec) Encode(msg []byte) ([]byte, error)   { return msg, nil }
+func (jsonCodec) Binary() bool                        { return false }
+
+var (
+	codecsLock sync.RWMutex
+	codecs     = map[string]Codec{"json": jsonCodec{}}
+)
+
+// RegisterCodec registers codec by name. Clients select codec by websocket subprotocol with codec name,
+// default codec is set by App.Codec.
+func RegisterCodec(name string, c Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	codecs[name] = c
+}
+
+// lookupCodec returns registered codec by name.
+func lookupCodec(name string) (Codec, bool) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	c, ok := codecs[name]
+	return c, ok
+}
+
+// decode converts client frame into json-rpc request by connection codec. Text frames of binary codecs are
+// json-rpc requests, so clients of binary codecs can send plain json-rpc.
+func (rf *requestForwarder) decode(frame []byte, text bool) ([]byte, error) {
+	if text && rf.codec.Binary() {
+		return frame, nil
+	}
+
+	return rf.codec.Decode(frame)
+}
+
+// send encodes json-rpc message by connection codec and sends it to client.
+func (rf *requestForwarder) send(msg []byte) error {
+	frame, err := rf.codec.Encode(msg)
+	if err != nil {
+		return err
+	}
+
+	if rf.codec.Binary() {
+		return rf.write(frame)
+	}
+
+	return rf.write(string(frame))
+}
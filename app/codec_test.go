@@ -0,0 +1,80 @@
+package app
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// base64Codec is a binary test codec of base64 encoded json-rpc.
+type base64Codec struct{}
+
+func (base64Codec) Decode(frame []byte) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(string(frame))
+}
+func (base64Codec) Encode(msg []byte) ([]byte, error) {
+	return []byte(base64.StdEncoding.EncodeToString(msg)), nil
+}
+func (base64Codec) Binary() bool { return true }
+
+func TestRequestForwarderDecode(t *testing.T) {
+	req := `{"jsonrpc":"2.0","method":"ping","id":1}`
+	tests := []struct {
+		name  string
+		codec Codec
+		frame string
+		text  bool
+		want  string
+		err   bool
+	}{
+		{"json text", jsonCodec{}, req, true, req, false},
+		{"json binary", jsonCodec{}, req, false, req, false},
+		{"binary codec", base64Codec{}, base64.StdEncoding.EncodeToString([]byte(req)), false, req, false},
+		{"binary codec text frame", base64Codec{}, req, true, req, false},
+		{"binary codec invalid frame", base64Codec{}, req, false, "", true},
+	}
+
+	for _, tt := range tests {
+		rf := NewHttpForwarder("http://localhost", nil, 1, 1).newRequestForwarder(&wsConn{})
+		rf.codec = tt.codec
+		got, err := rf.decode([]byte(tt.frame), tt.text)
+		if (err != nil) != tt.err || string(got) != tt.want {
+			t.Errorf("%s: got %s %v", tt.name, got, err)
+		}
+	}
+}
+
+func TestRequestForwarderSend(t *testing.T) {
+	msg := `{"jsonrpc":"2.0","id":1,"result":true}`
+	tests := []struct {
+		name  string
+		codec Codec
+		mt    int
+		want  string
+	}{
+		{"json", jsonCodec{}, websocket.TextMessage, msg},
+		{"binary codec", base64Codec{}, websocket.BinaryMessage, base64.StdEncoding.EncodeToString([]byte(msg))},
+	}
+
+	for _, tt := range tests {
+		srv := newWsServer(func(ws *wsConn) {
+			rf := NewHttpForwarder("http://localhost", nil, 1, 1).newRequestForwarder(ws)
+			rf.codec = tt.codec
+			if err := rf.send([]byte(msg)); err != nil {
+				t.Errorf("%s: send() err = %v", tt.name, err)
+			}
+		})
+
+		ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mt, frame, err := ws.ReadMessage(); err != nil || mt != tt.mt || string(frame) != tt.want {
+			t.Errorf("%s: got %d %s %v", tt.name, mt, frame, err)
+		}
+		ws.Close()
+		srv.Close()
+	}
+}
@@ -0,0 +1,44 @@
+package app
+
+import (
+	"context"
+	"net/http"
+)
+
+type connInfoKey struct{}
+
+// ConnInfo is a connection-scoped context: it is appended to every connection log line and passed to backends
+// with request context.
+type ConnInfo struct {
+	SessionId  string
+	Route      string // source handler, like / or /rpc
+	RemoteAddr string
+	Principal  string // authenticated user from route forward auth headers, optional
+}
+
+// ConnInfoFromContext returns connection info from backend request context.
+func ConnInfoFromContext(ctx context.Context) (ConnInfo, bool) {
+	ci, ok := ctx.Value(connInfoKey{}).(ConnInfo)
+	return ci, ok
+}
+
+// newConnContext returns context with connection info.
+func newConnContext(ctx context.Context, ci ConnInfo) context.Context {
+	return context.WithValue(ctx, connInfoKey{}, ci)
+}
+
+// principal returns first auth header value from forward auth response headers.
+func principal(authHeaders []string, h http.Header) string {
+	for _, name := range authHeaders {
+		if v := h.Get(name); v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// logFields returns key-value pairs for logger.
+func (ci ConnInfo) logFields() []string {
+	return []string{"session", ci.SessionId, "route", ci.Route, "ip", ci.RemoteAddr, "principal", ci.Principal}
+}
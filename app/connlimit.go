@@ -0,0 +1,31 @@
+package app
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+var errConnLimit = &UpgradeError{Status: http.StatusServiceUnavailable, Message: "connection limit reached"}
+
+// connLimitHandler rejects upgrades with 503 while App.MaxConnections websocket connections are open on all routes,
+// so instance degrades predictably instead of running out of memory or file descriptors.
+func (a *App) connLimitHandler(h http.Handler) http.Handler {
+	if a.MaxConnections <= 0 {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&a.conns, 1) > int32(a.MaxConnections) {
+			atomic.AddInt32(&a.conns, -1)
+			if a.statConnsRejected != nil {
+				a.statConnsRejected.Inc()
+			}
+			a.rejectUpgrade(w, r, errConnLimit)
+			return
+		}
+		defer atomic.AddInt32(&a.conns, -1)
+
+		// websocket handler returns after connection is closed
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,42 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestConnLimitHandler(t *testing.T) {
+	a := &App{MaxConnections: 1, statConnsRejected: prometheus.NewCounter(prometheus.CounterOpts{Name: "rejected"})}
+	opened, release := make(chan struct{}), make(chan struct{})
+	h := a.connLimitHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		opened <- struct{}{}
+		<-release
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/rpc", nil))
+		close(done)
+	}()
+	<-opened
+
+	// limit is shared by routes
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("over limit: got %d", w.Code)
+	}
+	if v := testutil.ToFloat64(a.statConnsRejected); v != 1 {
+		t.Errorf("got rejected %v", v)
+	}
+
+	// closed connection frees slot
+	close(release)
+	<-done
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/rpc", nil))
+	<-opened
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// controlErrorMethod is a notification sent to client for every rejected control message, regardless of control acks:
+// {"method":"ws2http.controlError","params":{"command":"SET","reason":"header_not_allowed","message":"header is not allowed"}}.
+const controlErrorMethod = "ws2http.controlError"
+
+// Control error reasons are machine-readable codes of controlErrorMethod notifications.
+const (
+	controlUnknownCommand     = "unknown_command"
+	controlMalformed          = "malformed"
+	controlHeaderNotAllowed   = "header_not_allowed"
+	controlHeaderLimit        = "header_limit"
+	controlUnsupportedVersion = "unsupported_version"
+	controlCsrfFailed         = "csrf_failed"
+	controlOtherError         = "error"
+)
+
+var (
+	errUnknownCommand   = errors.New("unknown control command")
+	errMalformedControl = errors.New("malformed control message")
+)
+
+// controlErrorParams are params of controlErrorMethod notification.
+type controlErrorParams struct {
+	Command string `json:"command"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// controlReason returns reason code for control message error.
+func controlReason(err error) string {
+	switch {
+	case errors.Is(err, errUnknownCommand):
+		return controlUnknownCommand
+	case errors.Is(err, errMalformedControl):
+		return controlMalformed
+	case errors.Is(err, errHeaderNotAllowed):
+		return controlHeaderNotAllowed
+	case errors.Is(err, errHeaderLimit):
+		return controlHeaderLimit
+	case errors.Is(err, errProtocolVersion):
+		return controlUnsupportedVersion
+	case errors.Is(err, errCsrfHandshake):
+		return controlCsrfFailed
+	}
+
+	return controlOtherError
+}
+
+// controlCommand returns command of control message, like SET for "SET X-User 1".
+func controlCommand(msg []byte) string {
+	command := string(msg)
+	if i := strings.IndexByte(command, ' '); i >= 0 {
+		command = command[:i]
+	}
+
+	return command
+}
+
+// isControlMessage checks if message looks like control message: upper case command followed by space or end of frame.
+// Json-rpc messages start with { or [, so they are never treated as control messages.
+func isControlMessage(msg []byte) bool {
+	command := controlCommand(bytes.TrimRight(msg, "\r\n"))
+	if command == "" {
+		return false
+	}
+
+	for i := 0; i < len(command); i++ {
+		if command[i] < 'A' || command[i] > 'Z' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// handleControl handles control message of text frame: negotiates protocol version, sets headers and rejects
+// unknown control commands instead of dropping them as invalid json-rpc. Returns false for other messages.
+func (rf *requestForwarder) handleControl(msg []byte) bool {
+	if rf.checkVersion(msg) {
+		rf.Tracef("type=control data=%s", msg)
+		return true
+	}
+
+	if ok, err := rf.checkAndSetHeaders(msg); ok {
+		rf.Tracef("type=control data=%s", msg)
+		if err != nil {
+			rf.rejectControl(msg, err)
+		} else {
+			rf.ack(msg, nil)
+		}
+		return true
+	}
+
+	if isControlMessage(msg) {
+		rf.rejectControl(msg, errUnknownCommand)
+		return true
+	}
+
+	return false
+}
+
+// rejectControl acks rejected control message and notifies client with controlErrorMethod notification.
+func (rf *requestForwarder) rejectControl(msg []byte, err error) {
+	rf.ack(msg, err)
+	rf.controlError(msg, err)
+}
+
+// controlError sends controlErrorMethod notification for rejected control message.
+func (rf *requestForwarder) controlError(msg []byte, err error) {
+	rf.Printf("control message rejected command=%s err=%s", controlCommand(msg), err)
+	params, _ := json.Marshal(controlErrorParams{Command: controlCommand(msg), Reason: controlReason(err), Message: err.Error()})
+	if err = rf.send(rf.session.notification(controlErrorMethod, params)); err != nil {
+		rf.Errorf("can't send control error err=%s", err)
+	}
+}
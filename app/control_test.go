@@ -0,0 +1,166 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestControlReason(t *testing.T) {
+	for err, want := range map[error]string{
+		errUnknownCommand:   controlUnknownCommand,
+		errMalformedControl: controlMalformed,
+		errHeaderNotAllowed: controlHeaderNotAllowed,
+		errHeaderLimit:      controlHeaderLimit,
+		errProtocolVersion:  controlUnsupportedVersion,
+		errCsrfHandshake:    controlCsrfFailed,
+		errBodyTooLarge:     controlOtherError,
+	} {
+		if got := controlReason(err); got != want {
+			t.Errorf("controlReason(%v): got %s, want %s", err, got, want)
+		}
+	}
+}
+
+func TestIsControlMessage(t *testing.T) {
+	for msg, want := range map[string]bool{
+		"SET X-Token abc":     true,
+		"PING":                true,
+		"FOO bar":             true,
+		"Set X-Token abc":     false,
+		`{"jsonrpc":"2.0"}`:   false,
+		`[{"jsonrpc":"2.0"}]`: false,
+		"":                    false,
+		" SET":                false,
+	} {
+		if got := isControlMessage([]byte(msg)); got != want {
+			t.Errorf("isControlMessage(%q): got %v, want %v", msg, got, want)
+		}
+	}
+}
+
+func TestRequestForwarderMalformedControl(t *testing.T) {
+	hf := NewHttpForwarder("/", []string{"Authorization", "X-Token"}, 0, 0)
+	rf := hf.newRequestForwarder(&wsConn{})
+
+	for _, msg := range []string{"SET X-Token", "SET  abc", "TAG  ", "AUTH  "} {
+		if ok, err := rf.checkAndSetHeaders([]byte(msg)); !ok || err != errMalformedControl {
+			t.Errorf("%q: got = %v, %v", msg, ok, err)
+		}
+	}
+
+	if ok, err := rf.checkAndSetHeaders([]byte("SET X-Token a b")); !ok || err != nil || rf.header().Get("X-Token") != "a b" {
+		t.Errorf("value with spaces: got = %v, %v, %s", ok, err, rf.header().Get("X-Token"))
+	}
+}
+
+func TestRequestForwarderControlErrors(t *testing.T) {
+	hf := NewHttpForwarder("http://localhost", []string{"X-Token"}, 1, 1)
+	hf.SetControlAcks(true)
+	srv := httptest.NewServer(hf.WebsocketHandler())
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial(strings.Replace(srv.URL, "http", "ws", 1), http.Header{"Origin": {srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	for _, c := range []struct {
+		msg, ack, reason string
+	}{
+		{"SET X-Token abc", "OK SET", ""},
+		{"SET X-Secret abc", "ERR SET " + errHeaderNotAllowed.Error(), controlHeaderNotAllowed},
+		{"SET X-Token", "ERR SET " + errMalformedControl.Error(), controlMalformed},
+		{"PING", "ERR PING " + errUnknownCommand.Error(), controlUnknownCommand},
+	} {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(c.msg)); err != nil {
+			t.Fatal(err)
+		}
+		_, reply, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		} else if string(reply) != c.ack {
+			t.Errorf("%s: got ack %s, want %s", c.msg, reply, c.ack)
+		}
+
+		if c.reason == "" {
+			continue
+		}
+
+		var notice struct {
+			Method string             `json:"method"`
+			Params controlErrorParams `json:"params"`
+		}
+		if err := ws.ReadJSON(&notice); err != nil {
+			t.Fatal(err)
+		}
+
+		want := controlErrorParams{Command: controlCommand([]byte(c.msg)), Reason: c.reason, Message: strings.TrimPrefix(c.ack, "ERR "+controlCommand([]byte(c.msg))+" ")}
+		if notice.Method != controlErrorMethod || notice.Params != want {
+			t.Errorf("%s: got notice %+v, want %+v", c.msg, notice, want)
+		}
+	}
+
+	// binary frames are never control messages
+	ws.WriteMessage(websocket.BinaryMessage, []byte("PING"))
+	ws.WriteMessage(websocket.TextMessage, []byte("SET X-Token abc"))
+	if _, reply, err := ws.ReadMessage(); err != nil || string(reply) != "OK SET" {
+		t.Errorf("binary frame: got %s %v", reply, err)
+	}
+}
+
+func TestRequestForwarderBinaryControlError(t *testing.T) {
+	hf := NewHttpForwarder("http://localhost", nil, 1, 1)
+	hf.SetControlAcks(true)
+	hf.SetBrowserMode("csrf")
+	srv := httptest.NewServer(hf.WebsocketHandler())
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial(strings.Replace(srv.URL, "http", "ws", 1), http.Header{"Origin": {srv.URL}, "Cookie": {"csrf=token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// failed handshake of binary frame is not acked and has no control error notification
+	ws.WriteMessage(websocket.BinaryMessage, []byte("CSRF token"))
+	if _, msg, err := ws.ReadMessage(); err == nil {
+		t.Errorf("got %s, expected close", msg)
+	}
+}
+
+func TestControlAckOrdering(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"` + r.Header.Get("X-Token") + `"}`))
+	}))
+	defer backend.Close()
+
+	hf := NewHttpForwarder(backend.URL, []string{"X-Token"}, 10, 10)
+	hf.SetControlAcks(true)
+	srv := httptest.NewServer(hf.WebsocketHandler())
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial(strings.Replace(srv.URL, "http", "ws", 1), http.Header{"Origin": {srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// request is sent right after SET without waiting for ack
+	for _, token := range []string{"a", "b", "c"} {
+		ws.WriteMessage(websocket.TextMessage, []byte("SET X-Token "+token))
+		ws.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"get","id":1}`))
+
+		for _, want := range []string{"OK SET", `{"jsonrpc":"2.0","id":1,"result":"` + token + `"}`} {
+			if _, msg, err := ws.ReadMessage(); err != nil || string(msg) != want {
+				t.Errorf("%s: got %s %v, want %s", token, msg, err, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const corsMaxAge = "600" // preflight cache in seconds
+
+// ErrCorsWildcardCredentials is returned for * origin with credentials, it would allow credentialed requests
+// from any site.
+var ErrCorsWildcardCredentials = errors.New("cors: * origin can't be used with credentials")
+
+// CorsConfig is a CORS policy for non-websocket http endpoints: admin APIs, debug UI and metrics.
+type CorsConfig struct {
+	Origins     []string // allowed origins, like https://dashboard.example.com, * allows any origin
+	Methods     []string // allowed methods, default is GET and POST
+	Credentials bool     // allow cookies and http auth, requires exact origins
+}
+
+// Validate checks that * origin is not combined with credentials.
+func (c CorsConfig) Validate() error {
+	if c.Credentials && contains(c.Origins, "*") {
+		return ErrCorsWildcardCredentials
+	}
+
+	return nil
+}
+
+// allowedOrigin returns Access-Control-Allow-Origin value for origin or empty string. * origin is ignored
+// with credentials.
+func (c CorsConfig) allowedOrigin(origin string) string {
+	for _, o := range c.Origins {
+		if o == "*" && !c.Credentials {
+			return "*"
+		} else if o != "*" && strings.EqualFold(o, origin) {
+			return origin
+		}
+	}
+
+	return ""
+}
+
+// corsHandler adds CORS headers for allowed origins and answers preflight requests. Requests without Origin
+// and requests from other origins are passed without CORS headers, browsers block responses for them.
+func corsHandler(c CorsConfig, h http.Handler) http.Handler {
+	if len(c.Origins) == 0 {
+		return h
+	}
+
+	methods := "GET, POST"
+	if len(c.Methods) > 0 {
+		methods = strings.Join(c.Methods, ", ")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		allowed := c.allowedOrigin(origin)
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if allowed == "" {
+			if preflight {
+				http.Error(w, "origin is not allowed", http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", allowed)
+		if c.Credentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if preflight {
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			if rh := r.Header.Get("Access-Control-Request-Headers"); rh != "" {
+				w.Header().Set("Access-Control-Allow-Headers", rh)
+			}
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCorsHandler(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+
+	for _, c := range []struct {
+		name        string
+		cfg         CorsConfig
+		method      string
+		origin      string
+		code        int
+		allowOrigin string
+	}{
+		{"no origin", CorsConfig{Origins: []string{"https://a.com"}}, "GET", "", 200, ""},
+		{"allowed", CorsConfig{Origins: []string{"https://a.com"}}, "GET", "https://a.com", 200, "https://a.com"},
+		{"not allowed", CorsConfig{Origins: []string{"https://a.com"}}, "GET", "https://b.com", 200, ""},
+		{"any", CorsConfig{Origins: []string{"*"}}, "GET", "https://b.com", 200, "*"},
+		{"any with credentials", CorsConfig{Origins: []string{"*"}, Credentials: true}, "GET", "https://b.com", 200, ""},
+		{"exact with credentials", CorsConfig{Origins: []string{"*", "https://a.com"}, Credentials: true}, "GET", "https://a.com", 200, "https://a.com"},
+		{"preflight", CorsConfig{Origins: []string{"https://a.com"}}, "OPTIONS", "https://a.com", 204, "https://a.com"},
+		{"preflight not allowed", CorsConfig{Origins: []string{"https://a.com"}}, "OPTIONS", "https://b.com", 403, ""},
+	} {
+		r := httptest.NewRequest(c.method, "/admin/routes", nil)
+		if c.origin != "" {
+			r.Header.Set("Origin", c.origin)
+		}
+		if c.method == "OPTIONS" {
+			r.Header.Set("Access-Control-Request-Method", "POST")
+			r.Header.Set("Access-Control-Request-Headers", "Content-Type")
+		}
+
+		w := httptest.NewRecorder()
+		corsHandler(c.cfg, ok).ServeHTTP(w, r)
+		if w.Code != c.code || w.Header().Get("Access-Control-Allow-Origin") != c.allowOrigin {
+			t.Errorf("%s: got code = %d, allow origin = %q", c.name, w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+		}
+		if c.code == 204 && (w.Header().Get("Access-Control-Allow-Methods") != "GET, POST" || w.Header().Get("Access-Control-Allow-Headers") != "Content-Type") {
+			t.Errorf("%s: got headers %v", c.name, w.Header())
+		}
+	}
+}
+
+func TestCorsConfigValidate(t *testing.T) {
+	if err := (CorsConfig{Origins: []string{"*"}, Credentials: true}).Validate(); err != ErrCorsWildcardCredentials {
+		t.Errorf("got %v", err)
+	}
+	if err := (CorsConfig{Origins: []string{"*"}}).Validate(); err != nil {
+		t.Errorf("got %v", err)
+	}
+	if err := (CorsConfig{Origins: []string{"https://a.com"}, Credentials: true}).Validate(); err != nil {
+		t.Errorf("got %v", err)
+	}
+}
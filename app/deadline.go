@@ -0,0 +1,37 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// grpcTimeoutHeader is a gRPC deadline header, value is sent in gRPC format like 1500m.
+const grpcTimeoutHeader = "grpc-timeout"
+
+// deadlineHeaderValue returns remaining time in milliseconds, min is 1ms. grpc-timeout uses m unit suffix.
+func deadlineHeaderValue(name string, remaining time.Duration) string {
+	ms := int64(remaining / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+
+	if strings.EqualFold(name, grpcTimeoutHeader) {
+		return strconv.FormatInt(ms, 10) + "m"
+	}
+
+	return strconv.FormatInt(ms, 10)
+}
+
+// setDeadlineHeader sets remaining time of ctx deadline to header, ctx without deadline is ignored.
+func setDeadlineHeader(ctx context.Context, h http.Header, name string) {
+	if name == "" {
+		return
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		h.Set(name, deadlineHeaderValue(name, time.Until(deadline)))
+	}
+}
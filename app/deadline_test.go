@@ -0,0 +1,38 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetDeadlineHeader(t *testing.T) {
+	if v := deadlineHeaderValue("grpc-timeout", 1500*time.Millisecond); v != "1500m" {
+		t.Errorf("grpc-timeout: got %s", v)
+	}
+
+	if v := deadlineHeaderValue("X-Request-Timeout-Ms", -time.Second); v != "1" {
+		t.Errorf("expired: got %s", v)
+	}
+
+	h := http.Header{}
+	setDeadlineHeader(context.Background(), h, "X-Request-Timeout-Ms")
+	if len(h) != 0 {
+		t.Errorf("no deadline: got %v", h)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	setDeadlineHeader(ctx, h, "X-Request-Timeout-Ms")
+	if ms, _ := strconv.Atoi(h.Get("X-Request-Timeout-Ms")); ms <= 1900 || ms > 2000 {
+		t.Errorf("got %v", h)
+	}
+
+	setDeadlineHeader(ctx, h, "grpc-timeout")
+	if !strings.HasSuffix(h.Get("grpc-timeout"), "m") {
+		t.Errorf("got %v", h)
+	}
+}
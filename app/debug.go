@@ -1,11 +1,24 @@
 package app
 
 import (
-	"golang.org/x/net/websocket"
+	"embed"
+	"encoding/json"
+	"github.com/gorilla/websocket"
 	"html/template"
-	"io"
+	"io/fs"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/semrush/ws2http/clock"
 )
 
 type debugMessageType int
@@ -16,23 +29,45 @@ const (
 	wsRequest
 	httpResponse
 
-	eventsBuffer = 1000
+	eventsBuffer   = 1000 // default size of debug events buffer and tracer buffers
+	debugPageLimit = 100  // connections per index page
 )
 
 type (
-	clientConns map[string]*http.Request
+	clientConns map[string]*debugConn
 	traceConns  map[string]map[string]traceRequest // target -> tracers -> trace chan
 
 	debugMessage struct {
 		msgType debugMessageType
 		req     *http.Request
+		session *session // set for clientConnected
 		data    []byte
 	}
 
+	debugConn struct {
+		req       *http.Request
+		session   *session
+		connected time.Time
+		traffic   int // request and response bytes
+		errors    int // json-rpc error responses
+	}
+
 	debugApp struct {
 		events        chan debugMessage
 		ops           chan func(clientConns)
 		traceRequests chan traceRequest
+		traceBuffer   int
+		once          sync.Once
+
+		templates *template.Template // index.html, trace.html and stats.html
+		static    http.FileSystem    // assets for /debug/static/
+		basePath  string             // path prefix of reverse proxy for debug UI links, like /ws2http
+
+		droppedEvents uint64 // traffic events dropped on full events buffer
+		droppedTrace  uint64 // traffic events dropped on full tracer buffer
+		statDropped   *prometheus.CounterVec
+		clock         clock.Clock   // time source of stats, nil is system clock
+		captures      *captureStore // traffic capture for export, nil is disabled
 	}
 
 	traceRequest struct {
@@ -43,20 +78,148 @@ type (
 	}
 )
 
-var debug = debugApp{
-	events:        make(chan debugMessage, eventsBuffer),
-	ops:           make(chan func(clientConns), eventsBuffer),
-	traceRequests: make(chan traceRequest, eventsBuffer),
-}
+var debug = &debugApp{}
+
+//go:embed templates static
+var debugAssets embed.FS
 
 func init() {
-	http.HandleFunc("/debug/conns/", debug.index)
-	http.HandleFunc("/debug/conns/trace", debug.trace)
-	http.Handle("/debug/conns/ws", websocket.Handler(debug.wsHandler))
-	go debug.loop()
+	if err := debug.loadTemplates(""); err != nil {
+		panic(err)
+	}
+}
+
+// handler returns debug UI handler for /debug/ paths.
+func (d *debugApp) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/static/", secureHeaders(http.StripPrefix("/debug/static/", http.FileServer(d))))
+	mux.Handle("/debug/conns/", secureHeaders(http.HandlerFunc(d.index)))
+	mux.Handle("/debug/conns/trace", secureHeaders(http.HandlerFunc(d.trace)))
+	mux.Handle("/debug/conns/export", secureHeaders(http.HandlerFunc(d.export)))
+	mux.Handle("/debug/stats", secureHeaders(http.HandlerFunc(d.stats)))
+	mux.Handle("/debug/conns/ws", http.HandlerFunc(d.wsHandler))
+	return mux
+}
+
+// debugCSP allows only debug assets and debug websocket, no inline scripts and styles.
+const debugCSP = "default-src 'none'; script-src 'self'; style-src 'self'; img-src 'self' data:; connect-src 'self' ws: wss:; " +
+	"base-uri 'none'; form-action 'self'; frame-ancestors 'none'"
+
+// secureHeaders adds Content-Security-Policy, X-Frame-Options and nosniff headers to debug and admin responses.
+func secureHeaders(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", debugCSP)
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		h.ServeHTTP(w, r)
+	})
+}
+
+// loadTemplates parses embedded debug templates and assets overridden by files from dir: *.html templates
+// with the same names and static/ assets. Empty dir is embedded templates only.
+func (d *debugApp) loadTemplates(dir string) error {
+	funcs := template.FuncMap{"sparkline": sparkline, "last": lastValue, "base": func() string { return d.basePath }}
+	t, err := template.New("debug").Funcs(funcs).ParseFS(debugAssets, "templates/*.html")
+	if err != nil {
+		return err
+	}
+
+	static, _ := fs.Sub(debugAssets, "static") // never returns error for valid path
+	d.static = http.FS(static)
+	if dir == "" {
+		d.templates = t
+		return nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return err
+	} else if len(files) > 0 {
+		if t, err = t.ParseFiles(files...); err != nil {
+			return err
+		}
+	}
+
+	d.templates, d.static = t, overlayFS{http.Dir(filepath.Join(dir, "static")), d.static}
+	return nil
+}
+
+// Open returns static asset for /debug/static/ handler.
+func (d *debugApp) Open(name string) (http.File, error) {
+	return d.static.Open(name)
+}
+
+// render executes debug template by name.
+func (d *debugApp) render(w http.ResponseWriter, name string, data interface{}) {
+	if err := d.templates.ExecuteTemplate(w, name, data); err != nil {
+		log.Print(err)
+	}
+}
+
+// overlayFS opens file from first file system that has it.
+type overlayFS []http.FileSystem
+
+func (o overlayFS) Open(name string) (http.File, error) {
+	for _, fs := range o {
+		if f, err := fs.Open(name); err == nil {
+			return f, nil
+		}
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// start creates debug buffers and starts debug loop once, zero sizes are eventsBuffer.
+// It is called by App.Run with configured sizes and lazily on first event with defaults.
+func (d *debugApp) start(events, trace int) {
+	d.once.Do(func() {
+		if events <= 0 {
+			events = eventsBuffer
+		}
+		if trace <= 0 {
+			trace = eventsBuffer
+		}
+
+		d.events = make(chan debugMessage, events)
+		d.ops = make(chan func(clientConns), eventsBuffer)
+		d.traceRequests = make(chan traceRequest, eventsBuffer)
+		d.traceBuffer = trace
+		go d.loop()
+	})
+}
+
+// publish sends event to debug loop. Traffic events are dropped on full buffer and counted,
+// connection events are always delivered to keep sessions consistent.
+func (d *debugApp) publish(m debugMessage) {
+	d.start(0, 0)
+	if m.msgType == clientConnected || m.msgType == clientDisconnected {
+		d.events <- m
+		return
+	}
+
+	select {
+	case d.events <- m:
+	default:
+		d.drop(&d.droppedEvents, "events")
+	}
+}
+
+// drop counts dropped event by buffer.
+func (d *debugApp) drop(counter *uint64, buffer string) {
+	atomic.AddUint64(counter, 1)
+	if d.statDropped != nil {
+		d.statDropped.WithLabelValues(buffer).Inc()
+	}
+}
+
+// do runs op in debug loop.
+func (d *debugApp) do(op func(clientConns)) {
+	d.start(0, 0)
+	d.ops <- op
 }
 
-func (d debugApp) loop() {
+func (d *debugApp) loop() {
 	sessions, tracers := make(clientConns), make(traceConns)
 
 	for {
@@ -64,7 +227,7 @@ func (d debugApp) loop() {
 		case e := <-d.events:
 			switch e.msgType {
 			case clientConnected:
-				sessions[e.req.RemoteAddr] = e.req
+				sessions[e.req.RemoteAddr] = &debugConn{req: e.req, session: e.session, connected: time.Now()}
 			case clientDisconnected:
 				delete(sessions, e.req.RemoteAddr)
 
@@ -74,8 +237,20 @@ func (d debugApp) loop() {
 				}
 				delete(tracers, e.req.RemoteAddr)
 			case wsRequest, httpResponse:
+				if c, ok := sessions[e.req.RemoteAddr]; ok {
+					c.traffic += len(e.data)
+					if e.msgType == httpResponse && isErrorResponse(e.data) {
+						c.errors++
+					}
+				}
+
+				d.captures.add(e)
 				for _, tracer := range tracers[e.req.RemoteAddr] {
-					tracer.Msg <- e
+					select {
+					case tracer.Msg <- e:
+					default:
+						d.drop(&d.droppedTrace, "trace")
+					}
 				}
 			}
 		case tr := <-d.traceRequests:
@@ -94,57 +269,158 @@ func (d debugApp) loop() {
 	}
 }
 
-// index shows active connections to proxy.
-func (d debugApp) index(w http.ResponseWriter, r *http.Request) {
+// isErrorResponse checks json-rpc response for error member.
+func isErrorResponse(data []byte) bool {
+	var resp struct {
+		Error json.RawMessage `json:"error"`
+	}
+
+	return json.Unmarshal(data, &resp) == nil && len(resp.Error) > 0 && string(resp.Error) != "null"
+}
+
+// debugFilter selects connections in index by tag, ip, route and user agent substring. Empty fields match all.
+type debugFilter struct {
+	Tag, IP, Route, UserAgent string
+}
+
+func (f debugFilter) match(c debugConn) bool {
+	if f.IP != "" && !strings.HasPrefix(c.req.RemoteAddr, f.IP) {
+		return false
+	} else if f.UserAgent != "" && !strings.Contains(strings.ToLower(c.req.UserAgent()), strings.ToLower(f.UserAgent)) {
+		return false
+	} else if c.session == nil {
+		return f.Route == "" && f.Tag == ""
+	}
+
+	return sessionFilter{Route: f.Route, Tag: f.Tag}.match(c.session)
+}
+
+// debugColumns are optional columns of connections index.
+var (
+	debugColumns = []string{"id", "route", "tags", "uptime", "traffic", "errors", "ua", "referrer"}
+	debugSorts   = []string{"addr", "uptime", "traffic", "errors"}
+)
+
+// index shows active connections to proxy filtered by tag, ip, route and ua query params.
+// Connections are sorted by sort param (addr, uptime, traffic or errors) and paginated by page and limit params,
+// cols param selects columns via comma.
+// Example: http://localhost:8090/debug/conns/?tag=vip&route=/rpc&sort=traffic&page=2&cols=route,traffic
+func (d *debugApp) index(w http.ResponseWriter, r *http.Request) {
 	type session struct {
 		Addr, Referrer, UserAgent string
+		Id, Route                 string
+		Tags                      []string
+		Uptime                    time.Duration
+		Traffic, Errors           int
 	}
 
+	filter := debugFilter{Tag: r.FormValue("tag"), IP: r.FormValue("ip"), Route: r.FormValue("route"), UserAgent: r.FormValue("ua")}
 	sessions := make(chan []session)
 
 	// get sessions from main "loop"
-	d.ops <- func(m clientConns) {
+	total, now := 0, time.Now()
+	d.do(func(m clientConns) {
 		var list []session
 		for k, c := range m {
-			list = append(list, session{Addr: k, Referrer: c.Referer(), UserAgent: c.UserAgent()})
+			if !filter.match(*c) {
+				continue
+			}
+
+			s := session{Addr: k, Referrer: c.req.Referer(), UserAgent: c.req.UserAgent(),
+				Uptime: now.Sub(c.connected).Truncate(time.Second), Traffic: c.traffic, Errors: c.errors}
+			if c.session != nil {
+				s.Id, s.Route, s.Tags = c.session.id, c.session.route, c.session.tagList()
+			}
+			list = append(list, s)
 		}
+		total = len(m)
 		sessions <- list
+	})
+	list := <-sessions
+
+	// sort by param, connections with the biggest values are first
+	sortBy := r.FormValue("sort")
+	sort.Slice(list, func(i, j int) bool {
+		switch sortBy {
+		case "uptime":
+			return list[i].Uptime > list[j].Uptime
+		case "traffic":
+			return list[i].Traffic > list[j].Traffic
+		case "errors":
+			return list[i].Errors > list[j].Errors
+		default:
+			return list[i].Addr < list[j].Addr
+		}
+	})
+
+	// paginate
+	page, _ := strconv.Atoi(r.FormValue("page"))
+	limit, _ := strconv.Atoi(r.FormValue("limit"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = debugPageLimit
+	}
+	from, to := (page-1)*limit, page*limit
+	if from > len(list) {
+		from = len(list)
+	}
+	if to > len(list) {
+		to = len(list)
 	}
 
+	// selected columns from checkboxes or comma list, all by default
+	cols := make(map[string]bool)
+	for _, v := range r.Form["cols"] {
+		for _, c := range strings.Split(v, ",") {
+			cols[c] = true
+		}
+	}
+	if len(cols) == 0 {
+		for _, c := range debugColumns {
+			cols[c] = true
+		}
+	}
+
+	// query without page for page links
+	q := r.URL.Query()
+	q.Del("page")
+
 	// fetch and render result
 	tmpl := struct {
-		Len  int
-		List []session
-	}{List: <-sessions}
-
-	tmpl.Len = len(tmpl.List)
-	if err := indexTmpl.Execute(w, tmpl); err != nil {
-		log.Print(err)
+		Len, Total       int
+		Filter           debugFilter
+		List             []session
+		Cols             map[string]bool
+		Columns, Sorts   []string
+		Sort, Query      string
+		Page, Prev, Next int
+
+		DroppedEvents, DroppedTrace uint64
+	}{List: list[from:to], Len: len(list), Filter: filter, Total: total, Cols: cols, Columns: debugColumns,
+		Sorts: debugSorts, Sort: sortBy, Query: q.Encode(), Page: page,
+		DroppedEvents: atomic.LoadUint64(&d.droppedEvents), DroppedTrace: atomic.LoadUint64(&d.droppedTrace)}
+
+	if page > 1 {
+		tmpl.Prev = page - 1
+	}
+	if to < len(list) {
+		tmpl.Next = page + 1
 	}
-}
 
-var indexTmpl = template.Must(template.New("index").Parse(`<html><head>
-<title>/debug/conns/</title>
-</head>
-<body>
-<p>active connections: {{.Len}}
-<table>
-{{range .List}}
-<tr><td><a href="trace?addr={{.Addr}}">{{.Addr}}</a></td><td>{{.UserAgent}}</td><td>{{.Referrer}}</td></tr>
-{{end}}
-</table>
-<br></body></html>
-`))
+	d.render(w, "index.html", tmpl)
+}
 
-func (d debugApp) trace(w http.ResponseWriter, r *http.Request) {
+func (d *debugApp) trace(w http.ResponseWriter, r *http.Request) {
 	addr := r.FormValue("addr")
 
 	// check if requested session exists
 	connected := make(chan bool)
-	d.ops <- func(m clientConns) {
+	d.do(func(m clientConns) {
 		_, ok := m[addr]
 		connected <- ok
-	}
+	})
 
 	tmpl := struct {
 		Server    string
@@ -152,85 +428,47 @@ func (d debugApp) trace(w http.ResponseWriter, r *http.Request) {
 		Connected bool
 	}{Connected: <-connected, Addr: addr}
 
-	if err := traceTmpl.Execute(w, tmpl); err != nil {
-		log.Print(err)
-	}
+	d.render(w, "trace.html", tmpl)
 }
 
-var traceTmpl = template.Must(template.New("trace").Parse(`<html><head>
-<title>/debug/conns/trace</title>
-<script src="//cdnjs.cloudflare.com/ajax/libs/highlight.js/9.12.0/highlight.min.js"></script>
-<link rel="stylesheet" href="//cdnjs.cloudflare.com/ajax/libs/highlight.js/9.12.0/styles/default.min.css">
-<style>
-	pre.hljs.json {
-		max-height: 70px;
-		overflow: hidden;
-	}
-
-	pre.hljs.json:focus {
-		max-height: 100%;
-		overflow: auto;
-	}
-</style>
-</head>
-<body>
-<p><a href="/debug/conns/">back to list</a></p>
-<strong>Addr: {{.Addr}}</strong>
-{{if .Connected}}
-<script>
-	hljs.initHighlightingOnLoad();
-
-	var tabindex = 1;
-
-	// it's a PoC. Completely rewrite it.
-	var w = new WebSocket("ws://" + document.location.host + "/debug/conns/ws?addr={{.Addr}}"); w.onmessage = function(data) {
-	    var res = JSON.parse(data.data),
-	    	isRequest = res.method  !== undefined,
-	    	reqId = 'req_' + res.id,
-	    	respId = 'resp_' + res.id,
-	    	id = isRequest ? reqId : respId,
-	    	relId = !isRequest ? reqId : respId;
-
-	    tabindex ++;
-
-	    // response line
-	    var tr = document.createElement("tr");
-	    tr.id = id;
-	    tr.innerHTML = "<td valign='top'>" + data.timeStamp + "<br/><a href='#"+relId+"'>" + ( isRequest ? res.method : 'to ' + reqId ) +  "</a></td>";
-
-	    var td = document.createElement("td"),
-	    	pre = document.createElement("pre");
-
-	    pre.tabIndex = tabindex;
-	    pre.innerText = JSON.stringify(res, undefined, 4);
-		hljs.highlightBlock(pre);
-		td.appendChild(pre);
-	    tr.appendChild(td);
-		document.getElementById("output").appendChild(tr);
-	};
-</script>
-
-<table border="1" style="border-width: 1px;"><tbody id="output"></tbody></table>
-{{else}}
-client disconnected
-{{end}}
-<br></body></html>
-`))
-
-func (d debugApp) wsHandler(ws *websocket.Conn) {
-	addr := ws.Request().FormValue("addr")
-	info := make(chan debugMessage, eventsBuffer)
+// wsHandler streams traffic of connection with addr as text frames, cross-origin upgrades are rejected.
+func (d *debugApp) wsHandler(w http.ResponseWriter, r *http.Request) {
+	ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return // upgrader replied with http error
+	}
+	defer ws.Close()
+
+	addr := r.FormValue("addr")
+	d.start(0, 0)
+	info := make(chan debugMessage, d.traceBuffer)
 
 	// register & deregister user
-	d.traceRequests <- traceRequest{Addr: ws.Request().RemoteAddr, TargetAddr: addr, Msg: info}
-	defer func() { d.traceRequests <- traceRequest{Addr: ws.Request().RemoteAddr, TargetAddr: addr, Cancel: true} }()
+	d.traceRequests <- traceRequest{Addr: r.RemoteAddr, TargetAddr: addr, Msg: info}
+	defer func() { d.traceRequests <- traceRequest{Addr: r.RemoteAddr, TargetAddr: addr, Cancel: true} }()
+
+	// read control frames, tracing is stopped on close
+	closed := make(chan struct{})
+	go func() {
+		for {
+			if _, _, err := ws.NextReader(); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}()
 
-	for m := range info {
-		if err := websocket.Message.Send(ws, string(m.data)); err != nil {
-			if err != io.EOF {
+	for {
+		select {
+		case m, ok := <-info:
+			if !ok {
+				return
+			}
+			if err := ws.WriteMessage(websocket.TextMessage, m.data); err != nil {
 				log.Println(err)
+				return
 			}
-
+		case <-closed:
 			return
 		}
 	}
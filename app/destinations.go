@@ -0,0 +1,160 @@
+package app
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+// destinationsMax limits tracked backend destinations, new destinations over limit are not tracked.
+const destinationsMax = 1000
+
+// destinationStat is a live state of backend destination: in-flight requests, passive health and rolling latency.
+type destinationStat struct {
+	inflight    int
+	requests    int
+	errors      int
+	lastStatus  string
+	lastRequest time.Time
+	latency     methodLatency
+}
+
+// destinationTracker tracks backend requests by destination url for /admin/backends.
+type destinationTracker struct {
+	lock  sync.Mutex
+	stats map[string]*destinationStat
+}
+
+var destinations = &destinationTracker{stats: make(map[string]*destinationStat)}
+
+// start counts in-flight request to dstUrl until finish is called with request status and duration, cancelled
+// requests are not counted in health and latency.
+func (t *destinationTracker) start(dstUrl string, c clock.Clock) (finish func(status string, duration time.Duration)) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	ds, ok := t.stats[dstUrl]
+	if !ok {
+		if len(t.stats) >= destinationsMax {
+			return func(string, time.Duration) {}
+		}
+		ds = new(destinationStat)
+		t.stats[dstUrl] = ds
+	}
+	ds.inflight++
+
+	return func(status string, duration time.Duration) {
+		t.lock.Lock()
+		defer t.lock.Unlock()
+
+		ds.inflight--
+		if status == "cancelled" {
+			return
+		}
+
+		ds.requests++
+		if status != "ok" {
+			ds.errors++
+		}
+		ds.lastStatus, ds.lastRequest = status, c.Now()
+		ds.latency.samples[ds.latency.count%sloWindow] = duration
+		ds.latency.count++
+	}
+}
+
+// backendInfo is a backend destination in /admin/backends.
+type backendInfo struct {
+	Url         string         `json:"url"`
+	Routes      []string       `json:"routes"`
+	Standby     bool           `json:"standby,omitempty"` // inactive blue/green destination
+	Health      string         `json:"health"`            // last request status: ok, timeout, dns_error, connection_refused, tls_error, error or unknown
+	LastRequest *time.Time     `json:"lastRequest,omitempty"`
+	Breaker     string         `json:"breaker"`               // closed, open while route is in maintenance, half_open while slow-start ramps traffic
+	Share       float64        `json:"share"`                 // admitted share of route traffic
+	HeldMethods []string       `json:"heldMethods,omitempty"` // methods delayed by backend Retry-After
+	InFlight    int            `json:"inFlight"`
+	Requests    int            `json:"requests"`
+	Errors      int            `json:"errors"`
+	P50         float64        `json:"p50"` // milliseconds, percentiles are for last 1024 requests
+	P95         float64        `json:"p95"`
+	P99         float64        `json:"p99"`
+	Probe       *backendHealth `json:"probe,omitempty"` // last active health probe
+}
+
+// backendList returns backend destinations of routes with health, breaker state, in-flight requests and latency.
+// Example: curl http://localhost:8090/admin/backends
+func (a *App) backendList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	now := a.clock().Now()
+	infos := make(map[string]*backendInfo)
+	for src, rs := range a.routes {
+		active := rs.colorUrl(rs.activeColor())
+		for _, dst := range []string{rs.rule.DstUrl, rs.rule.GreenUrl, rs.rule.CanaryUrl} {
+			if dst == "" {
+				continue
+			}
+
+			// destination shared by routes is standby only if it is inactive in all of them
+			standby := rs.rule.GreenUrl != "" && dst != active && dst != rs.rule.CanaryUrl
+			bi, ok := infos[dst]
+			if !ok {
+				bi = &backendInfo{Url: dst, Health: "unknown", Breaker: "closed", Share: 1, Standby: standby}
+				infos[dst] = bi
+			}
+			bi.Routes = append(bi.Routes, src)
+			bi.Standby = bi.Standby && standby
+
+			// maintenance and slow-start of any route limit destination traffic
+			if share := rs.trafficShare(dst, now); share < bi.Share {
+				bi.Share = share
+			}
+			if rs.maintenanceErr() != nil {
+				bi.Breaker = "open"
+			} else if bi.Share < 1 && bi.Breaker != "open" {
+				bi.Breaker = "half_open"
+			}
+			for _, m := range rs.heldMethods() {
+				if !contains(bi.HeldMethods, m) {
+					bi.HeldMethods = append(bi.HeldMethods, m)
+				}
+			}
+		}
+	}
+
+	destinations.lock.Lock()
+	for dst, bi := range infos {
+		ds, ok := destinations.stats[dst]
+		if !ok {
+			continue
+		}
+
+		bi.InFlight, bi.Requests, bi.Errors = ds.inflight, ds.requests, ds.errors
+		if ds.lastStatus != "" {
+			t := ds.lastRequest
+			bi.Health, bi.LastRequest = ds.lastStatus, &t
+		}
+		ps := ds.latency.percentiles(50, 95, 99)
+		bi.P50, bi.P95, bi.P99 = durationMs(ps[0]), durationMs(ps[1]), durationMs(ps[2])
+	}
+	destinations.lock.Unlock()
+
+	list := make([]*backendInfo, 0, len(infos))
+	for _, bi := range infos {
+		if a.healthChecker != nil {
+			bi.Probe = a.healthChecker.get(bi.Url)
+		}
+		sort.Strings(bi.Routes)
+		sort.Strings(bi.HeldMethods)
+		list = append(list, bi)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Url < list[j].Url })
+
+	writeJSON(w, list)
+}
@@ -0,0 +1,73 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestBackendList(t *testing.T) {
+	c := clock.NewFake(time.Now())
+
+	prev := destinations
+	destinations = &destinationTracker{stats: make(map[string]*destinationStat)}
+	defer func() { destinations = prev }()
+
+	routes, err := newRouteStates([]ProxyRule{
+		{Src: "/rpc", DstUrl: "http://blue/rpc", GreenUrl: "http://green/rpc", SlowStart: 10 * time.Second},
+		{Src: "/admin-rpc", DstUrl: "http://blue/rpc"},
+	}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc, _ := newHealthChecker(routes, "/health", "", time.Second, 0, c, logger{})
+	a := &App{routes: routes, healthChecker: hc, Clock: c}
+
+	for _, d := range []time.Duration{10, 20, 30, 40} {
+		destinations.start("http://blue/rpc", c)("ok", d*time.Millisecond)
+	}
+	destinations.start("http://blue/rpc", c)("timeout", 50*time.Millisecond)
+	destinations.start("http://blue/rpc", c)("cancelled", time.Second)
+	destinations.start("http://blue/rpc", c) // in-flight
+
+	// recovered destination ramps traffic, held methods are listed
+	routes["/rpc"].markRecovered("http://blue/rpc", c.Now())
+	c.Advance(5 * time.Second)
+	routes["/rpc"].holdMethod("users.get", -503, time.Minute)
+
+	w := httptest.NewRecorder()
+	a.backendList(w, httptest.NewRequest(http.MethodGet, "/admin/backends", nil))
+	var list []backendInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list) != 2 {
+		t.Fatalf("got %s", w.Body)
+	}
+
+	blue, green := list[0], list[1]
+	if blue.Url != "http://blue/rpc" || len(blue.Routes) != 2 || blue.Standby || blue.Health != "timeout" || blue.LastRequest == nil {
+		t.Errorf("blue: got %+v", blue)
+	}
+	if blue.InFlight != 1 || blue.Requests != 5 || blue.Errors != 1 || blue.P50 != 30 || blue.P99 != 50 {
+		t.Errorf("blue stats: got %+v", blue)
+	}
+	if blue.Breaker != "half_open" || blue.Share != 0.55 || len(blue.HeldMethods) != 1 {
+		t.Errorf("blue breaker: got %+v", blue)
+	}
+	if !green.Standby || green.Health != "unknown" || green.Requests != 0 {
+		t.Errorf("green: got %+v", green)
+	}
+	if blue.Probe == nil || blue.Probe.Status != "unknown" || len(blue.Probe.Routes) != 2 {
+		t.Errorf("blue probe: got %+v", blue.Probe)
+	}
+
+	routes["/admin-rpc"].setMaintenance(true, "")
+	w = httptest.NewRecorder()
+	a.backendList(w, httptest.NewRequest(http.MethodGet, "/admin/backends", nil))
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if list[0].Breaker != "open" {
+		t.Errorf("maintenance: got %+v", list[0])
+	}
+}
@@ -0,0 +1,147 @@
+package app
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/semrush/ws2http/clock"
+)
+
+var errDraining = &UpgradeError{Status: http.StatusServiceUnavailable, Message: "route is draining"}
+
+// drainRequest is a body of /admin/drain request.
+type drainRequest struct {
+	Route     string  `json:"route"`
+	Enabled   bool    `json:"enabled"`
+	Window    float64 `json:"window"`              // seconds to close existing connections over, 0 closes them at once
+	Reconnect string  `json:"reconnect,omitempty"` // suggested endpoint for reconnect, like wss://other.example.com/rpc
+}
+
+type drainStatus struct {
+	Route    string     `json:"route"`
+	Draining bool       `json:"draining"`
+	Since    *time.Time `json:"since,omitempty"`
+	Sessions int        `json:"sessions"`
+}
+
+// startDrain marks route as draining and returns false if route is already draining.
+func (rs *routeState) startDrain() bool {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	if !rs.drainSince.IsZero() {
+		return false
+	}
+
+	rs.drainSince = rs.clock.Now()
+	return true
+}
+
+// stopDrain accepts new connections again and cancels pending connection closes.
+func (rs *routeState) stopDrain() {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	for _, t := range rs.drainTimers {
+		t.Stop()
+	}
+	rs.drainSince, rs.drainTimers = time.Time{}, nil
+}
+
+// draining checks if route rejects new connections.
+func (rs *routeState) draining() bool {
+	if rs == nil {
+		return false
+	}
+
+	rs.lock.RLock()
+	defer rs.lock.RUnlock()
+	return !rs.drainSince.IsZero()
+}
+
+// drainHandler rejects upgrades for draining route with 503 status.
+func (a *App) drainHandler(src string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.routes[src].draining() {
+			a.rejectUpgrade(w, r, errDraining)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// drainSessions sends shutdownMethod notification to route sessions and closes them evenly over window,
+// so clients don't reconnect at once. Connections of "/" multi mode handler are not affected.
+func (a *App) drainSessions(rs *routeState, window time.Duration, reconnect string) int {
+	params, _ := json.Marshal(shutdownParams{In: int(math.Ceil(window.Seconds())), Reconnect: reconnect})
+
+	sessions := a.sessions.find(sessionFilter{Route: rs.rule.Src})
+	timers := make([]clock.Timer, 0, len(sessions))
+	for i, s := range sessions {
+		if err := s.send(s.notification(shutdownMethod, params)); err != nil {
+			a.Errorf("can't send drain notification session=%s err=%s", s.id, err)
+		}
+
+		ws := s.ws
+		delay := window * time.Duration(i+1) / time.Duration(len(sessions))
+		timers = append(timers, a.clock().AfterFunc(delay, func() { ws.closeWith(websocket.CloseGoingAway, "drain") }))
+	}
+
+	rs.lock.Lock()
+	rs.drainTimers = append(rs.drainTimers, timers...)
+	rs.lock.Unlock()
+
+	return len(sessions)
+}
+
+// drain starts or stops draining of route (POST) or returns drain status for all routes (GET). Draining route
+// rejects new upgrades with 503, existing connections get ws2http.shutdown notification and are closed over window.
+// Other routes are not affected, so backend of route could be maintained without instance restart.
+// Example: curl -H 'Content-Type: application/json' -d '{"route":"/rpc","enabled":true,"window":60}' http://localhost:8090/admin/drain
+func (a *App) drain(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		list := []drainStatus{}
+		for src, rs := range a.routes {
+			ds := drainStatus{Route: src, Sessions: len(a.sessions.find(sessionFilter{Route: src}))}
+			rs.lock.RLock()
+			if !rs.drainSince.IsZero() {
+				t := rs.drainSince
+				ds.Draining, ds.Since = true, &t
+			}
+			rs.lock.RUnlock()
+			list = append(list, ds)
+		}
+		writeJSON(w, list)
+		return
+	} else if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var dr drainRequest
+	if err := json.NewDecoder(r.Body).Decode(&dr); err != nil || dr.Window < 0 {
+		http.Error(w, "invalid drain request", http.StatusBadRequest)
+		return
+	}
+
+	rs, ok := a.routes[dr.Route]
+	if !ok {
+		http.Error(w, "route not found", http.StatusNotFound)
+		return
+	}
+
+	sessions := len(a.sessions.find(sessionFilter{Route: dr.Route}))
+	if !dr.Enabled {
+		rs.stopDrain()
+	} else if rs.startDrain() {
+		sessions = a.drainSessions(rs, time.Duration(dr.Window*float64(time.Second)), dr.Reconnect)
+	}
+
+	a.Printf("drain route=%s enabled=%v window=%vs sessions=%d", dr.Route, dr.Enabled, dr.Window, sessions)
+	a.audit(r, "drain", dr)
+	proxyEvents.publish(proxyEvent{Type: eventDrain, Route: dr.Route, Data: dr})
+
+	writeJSON(w, drainStatus{Route: dr.Route, Draining: dr.Enabled, Sessions: sessions})
+}
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestAppDrain(t *testing.T) {
+	c := clock.NewFake(time.Now())
+
+	rs := &routeState{rule: ProxyRule{Src: "/rpc"}, clock: c, inflight: make(map[string]int)}
+	a := &App{sessions: newSessionRegistry(), routes: map[string]*routeState{"/rpc": rs}, Clock: c}
+	hf := NewHttpForwarder("http://localhost", nil, 1, 1)
+	hf.sessions = a.sessions
+
+	srv := httptest.NewServer(a.drainHandler("/rpc", hf.WebsocketHandler()))
+	defer srv.Close()
+
+	url := strings.Replace(srv.URL, "http", "ws", 1) + "/rpc"
+	sessions := func() int { return len(a.sessions.find(sessionFilter{Route: "/rpc"})) }
+	waitSessions := func(n int) {
+		t.Helper()
+		for deadline := time.Now().Add(2 * time.Second); sessions() != n; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("got %d sessions, want %d", sessions(), n)
+			}
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		ws, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {srv.URL}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+	}
+	waitSessions(2)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		a.drain(w, httptest.NewRequest(http.MethodPost, "/admin/drain", strings.NewReader(body)))
+		return w
+	}
+
+	if w := post(`{"route":"/rpc","enabled":true,"window":10}`); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"sessions":2`) {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+
+	// new connections are rejected, existing connections are closed evenly over window
+	if _, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {srv.URL}}); err == nil {
+		t.Error("upgrade of draining route was accepted")
+	}
+	c.Advance(5 * time.Second)
+	waitSessions(1)
+
+	// pending closes are cancelled after drain stop
+	if w := post(`{"route":"/rpc","enabled":false}`); w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body)
+	}
+	c.Advance(10 * time.Second)
+	if n := sessions(); n != 1 {
+		t.Errorf("got %d sessions after drain stop", n)
+	}
+	if ws, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {srv.URL}}); err != nil {
+		t.Errorf("upgrade after drain stop: %s", err)
+	} else {
+		ws.Close()
+	}
+
+	if w := post(`{"route":"/unknown","enabled":true}`); w.Code != http.StatusNotFound {
+		t.Errorf("unknown route: got %d", w.Code)
+	}
+}
@@ -0,0 +1,39 @@
+package app
+
+import (
+	"errors"
+	"math"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+)
+
+// nextRequestId returns connection-unique request id for logs and error data, like 1a2b3c-42.7.
+func (rf *requestForwarder) nextRequestId() string {
+	return rf.conn.SessionId + "." + strconv.FormatUint(uint64(atomic.AddUint32(&rf.requestSeq, 1)), 10)
+}
+
+// errData returns structured data of error for backend request, status is backend http status or 0.
+func (hf *HttpForwarder) errData(requestId, dstUrl string, status int, err error) *JsonRpcErrData {
+	data := &JsonRpcErrData{HttpStatus: status, RequestId: requestId}
+	if hf.errorBackendHost {
+		if u, uErr := url.Parse(dstUrl); uErr == nil {
+			data.BackendHost = u.Host
+		}
+	}
+
+	var be *BackendError
+	if errors.As(err, &be) && be.RetryAfter > 0 {
+		data.RetryAfter = int(math.Ceil(be.RetryAfter.Seconds()))
+	}
+
+	return data
+}
+
+// verbose adds backend host and error details to data of requests with FeatureVerboseErrors.
+func (d *JsonRpcErrData) verbose(dstUrl string, err error) {
+	if u, uErr := url.Parse(dstUrl); uErr == nil {
+		d.BackendHost = u.Host
+	}
+	d.Detail = err.Error()
+}
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHttpForwarderErrData(t *testing.T) {
+	hf := NewHttpForwarder("/", nil, 0, 1)
+	be := &BackendError{Code: -503, RetryAfter: 1500 * time.Millisecond}
+	if d := hf.errData("s1.1", "https://rpc.internal:8443/rpc", 503, be); *d != (JsonRpcErrData{HttpStatus: 503, RequestId: "s1.1", RetryAfter: 2}) {
+		t.Errorf("got %+v", d)
+	}
+
+	hf.SetErrorBackendHost(true)
+	if d := hf.errData("s1.2", "https://rpc.internal:8443/rpc", 0, errors.New("eof")); *d != (JsonRpcErrData{BackendHost: "rpc.internal:8443", RequestId: "s1.2"}) {
+		t.Errorf("exposed host: got %+v", d)
+	}
+}
+
+func TestRequestForwarderErrData(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	hf := NewHttpForwarder(srv.URL, nil, 10, 1)
+	rf := hf.newRequestForwarder(&wsConn{})
+	rpcReq, _ := rf.rewriteRequest([]byte(`{"jsonrpc":"2.0","method":"a","id":1}`), srv.URL)
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		rf.maxParallelRequest <- struct{}{}
+		var resp JsonRpcErrResponse
+		var data JsonRpcErrData
+		resp.Error.Data = &data
+		if err := json.Unmarshal(hf.forward(rf, rpcReq, http.Header{}), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Error.Code != -502 || data.HttpStatus != 502 || data.BackendHost != "" || !strings.HasPrefix(data.RequestId, rf.conn.SessionId+".") {
+			t.Errorf("got %+v %+v", resp.Error, data)
+		}
+		ids = append(ids, data.RequestId)
+	}
+
+	if ids[0] == ids[1] {
+		t.Errorf("request ids are not unique: %v", ids)
+	}
+}
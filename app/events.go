@@ -0,0 +1,116 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Proxy event types for /admin/events.
+const (
+	eventConnect     = "connect"
+	eventDisconnect  = "disconnect"
+	eventSlowClient  = "slow_client"
+	eventHealth      = "health" // route backend status change: ok, timeout, dns_error, connection_refused, tls_error, error
+	eventMaintenance = "maintenance"
+	eventSwitch      = "switch" // blue/green route destination switch
+	eventDrain       = "drain"  // route draining started or stopped
+
+	eventsSubscriberBuffer = 100
+)
+
+// proxyEvent is a proxy lifecycle event streamed to /admin/events subscribers.
+type proxyEvent struct {
+	Time       time.Time   `json:"time"`
+	Type       string      `json:"type"`
+	Route      string      `json:"route,omitempty"`
+	Session    string      `json:"session,omitempty"`
+	RemoteAddr string      `json:"remoteAddr,omitempty"`
+	Data       interface{} `json:"data,omitempty"`
+}
+
+// eventBus delivers proxy events to subscribers, events are dropped for subscribers with full buffer.
+type eventBus struct {
+	lock        sync.RWMutex
+	subscribers map[chan proxyEvent]struct{}
+}
+
+var proxyEvents = &eventBus{subscribers: make(map[chan proxyEvent]struct{})}
+
+// publish sends event to all subscribers without blocking.
+func (b *eventBus) publish(e proxyEvent) {
+	e.Time = time.Now()
+
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// subscribe returns events channel, it must be released by unsubscribe.
+func (b *eventBus) subscribe() chan proxyEvent {
+	ch := make(chan proxyEvent, eventsSubscriberBuffer)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (b *eventBus) unsubscribe(ch chan proxyEvent) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.subscribers, ch)
+}
+
+// event returns proxy event for connection.
+func (ci ConnInfo) event(eventType string, data interface{}) proxyEvent {
+	return proxyEvent{Type: eventType, Route: ci.Route, Session: ci.SessionId, RemoteAddr: ci.RemoteAddr, Data: data}
+}
+
+// eventsHandler streams proxy events as json text frames. Origin is not checked for ops bots.
+// Example: websocat ws://localhost:8090/admin/events
+func (a *App) eventsHandler() http.Handler {
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return // upgrader replied with http error
+		}
+		defer ws.Close()
+
+		ch := proxyEvents.subscribe()
+		defer proxyEvents.unsubscribe(ch)
+
+		// detect closed connection
+		closed := make(chan struct{})
+		go func() {
+			for {
+				if _, _, err := ws.NextReader(); err != nil {
+					close(closed)
+					return
+				}
+			}
+		}()
+
+		for {
+			select {
+			case e := <-ch:
+				data, _ := json.Marshal(e)
+				if err := ws.WriteMessage(websocket.TextMessage, data); err != nil {
+					return
+				}
+			case <-closed:
+				return
+			}
+		}
+	})
+}
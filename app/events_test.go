@@ -0,0 +1,50 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestEventsHandler(t *testing.T) {
+	srv := httptest.NewServer((&App{}).eventsHandler())
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial(strings.Replace(srv.URL, "http", "ws", 1), http.Header{"Origin": {srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// wait for subscription
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		proxyEvents.lock.RLock()
+		n := len(proxyEvents.subscribers)
+		proxyEvents.lock.RUnlock()
+		if n > 0 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("no subscribers")
+		}
+	}
+
+	rs := &routeState{rule: ProxyRule{Src: "/rpc"}, clock: clock.Real}
+	rs.observe("error")
+	rs.observe("error") // not changed
+
+	var e proxyEvent
+	if err := ws.ReadJSON(&e); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := json.Marshal(e.Data)
+	if e.Type != eventHealth || e.Route != "/rpc" || string(data) != `{"prev":"","status":"error"}` {
+		t.Errorf("got %+v, data = %s", e, data)
+	}
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// extensionMember is a top-level member of client json-rpc request besides jsonrpc, id, method and params,
+// like "meta" or "trace". Extension members are kept in rewritten requests.
+type extensionMember struct {
+	key   []byte // unquoted key without escapes
+	value json.RawMessage
+}
+
+// proxyMembers are client members handled by proxy, they are never forwarded to backends.
+var proxyMembers = []string{"_timeout"}
+
+// requestMembers returns top-level members of json object msg in order of appearance with encoding/json decoder,
+// it is used for messages scanJsonRpc can't handle. Returns false for invalid json objects.
+func requestMembers(msg []byte) (members []extensionMember, ok bool) {
+	dec := json.NewDecoder(bytes.NewReader(msg))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, false
+	}
+
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, false
+		}
+
+		m := extensionMember{key: []byte(t.(string))}
+		if err = dec.Decode(&m.value); err != nil {
+			return nil, false
+		}
+		members = append(members, m)
+	}
+
+	return members, true
+}
+
+// extensionMembers returns extension members of request members allowed by allowlist, empty allowlist allows any
+// member. Returns true if request has members which must be stripped before forwarding.
+func extensionMembers(members []extensionMember, allowlist []string) (ext []extensionMember, stripped bool) {
+	for _, m := range members {
+		switch key := string(m.key); {
+		case key == "jsonrpc" || key == "id" || key == "method" || key == "params":
+		case contains(proxyMembers, key):
+			stripped = true
+		case len(allowlist) > 0 && !contains(allowlist, key):
+			stripped = true
+		default:
+			ext = append(ext, m)
+		}
+	}
+
+	return ext, stripped
+}
+
+// withExtensionMembers appends extension members to marshalled json object data.
+func withExtensionMembers(data []byte, ext []extensionMember) []byte {
+	if len(ext) == 0 || len(data) < 2 || data[len(data)-1] != '}' {
+		return data
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(data) + 64)
+	buf.Write(data[:len(data)-1])
+	for _, m := range ext {
+		key, _ := json.Marshal(string(m.key))
+		buf.WriteByte(',')
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(m.value)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes()
+}
+
+// contains checks for s in list.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
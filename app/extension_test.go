@@ -0,0 +1,50 @@
+package app
+
+import (
+	"testing"
+)
+
+func TestExtensionMembers(t *testing.T) {
+	msg := []byte(`{"jsonrpc":"2.0","meta":{"a":[1,2]},"method":"a","id":1,"trace":"x","_timeout":100}`)
+
+	// known members are not collected by scanner
+	_, members, _ := parseRequestMembers(msg)
+	if len(members) != 3 {
+		t.Errorf("scanned: got %+v", members)
+	}
+	ext, stripped := extensionMembers(members, nil)
+	if len(ext) != 2 || string(ext[0].key) != "meta" || string(ext[0].value) != `{"a":[1,2]}` || string(ext[1].key) != "trace" || !stripped {
+		t.Errorf("extensionMembers(): got %+v, %v", ext, stripped)
+	}
+
+	if ext, stripped = extensionMembers(members, []string{"trace"}); len(ext) != 1 || string(ext[0].key) != "trace" || !stripped {
+		t.Errorf("extensionMembers(allowlist): got %+v, %v", ext, stripped)
+	}
+
+	// members of messages scanner can't handle are decoded by encoding/json
+	if _, members, _ = parseRequestMembers([]byte(`{"jsonrpc":"2.0","meta":{"a":[1,2]},"Method":"a","id":1}`)); len(members) != 4 || string(members[1].key) != "meta" || string(members[1].value) != `{"a":[1,2]}` {
+		t.Errorf("fallback: got %+v", members)
+	}
+	if _, members, _ = parseRequestMembers([]byte(`[1]`)); members != nil {
+		t.Errorf("array: got %+v", members)
+	}
+
+	if got := withExtensionMembers([]byte(`{"id":1}`), []extensionMember{{key: []byte("meta"), value: []byte(`{}`)}}); string(got) != `{"id":1,"meta":{}}` {
+		t.Errorf("withExtensionMembers(): got %s", got)
+	}
+}
+
+func TestRequestForwarderExtensionAllowlist(t *testing.T) {
+	hf := NewHttpForwarder("/", nil, 0, 0)
+	hf.SetExtensionMembers([]string{"meta"})
+	rf := hf.newRequestForwarder(&wsConn{})
+
+	for in, want := range map[string]string{
+		`{"jsonrpc":"2.0","method":"a","id":1,"meta":1,"debug":true}`: `{"jsonrpc":"2.0","id":1,"method":"a","meta":1}`,
+		`{"jsonrpc":"2.0","method":"a","id":1,"meta":1}`:              `{"jsonrpc":"2.0","method":"a","id":1,"meta":1}`,
+	} {
+		if rpcReq, err := rf.rewriteRequest([]byte(in), hf.dstUrl); err != nil || string(rpcReq.msg) != want {
+			t.Errorf("rewrite(%s): got %s, %v; want %s", in, rpcReq.msg, err, want)
+		}
+	}
+}
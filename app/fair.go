@@ -0,0 +1,114 @@
+package app
+
+import (
+	"context"
+	"sync"
+)
+
+// fairScheduler limits parallel backend requests of all connections. Free slots are granted round-robin
+// between connections with waiting requests, so chatty clients don't starve quiet ones.
+type fairScheduler struct {
+	lock   sync.Mutex
+	free   int
+	queues map[interface{}][]chan struct{} // waiting requests by connection
+	order  []interface{}                   // connections with waiting requests in round-robin order
+}
+
+// newFairScheduler returns scheduler with n slots.
+func newFairScheduler(n int) *fairScheduler {
+	return &fairScheduler{free: n, queues: make(map[interface{}][]chan struct{})}
+}
+
+// acquire waits for slot for connection key. Returns ctx error if ctx is done before slot is granted.
+// Nil scheduler is unlimited.
+func (s *fairScheduler) acquire(ctx context.Context, key interface{}) error {
+	if s == nil {
+		return nil
+	}
+
+	s.lock.Lock()
+	if s.free > 0 && len(s.order) == 0 {
+		s.free--
+		s.lock.Unlock()
+		return nil
+	}
+
+	ch := make(chan struct{})
+	if len(s.queues[key]) == 0 {
+		s.order = append(s.order, key)
+	}
+	s.queues[key] = append(s.queues[key], ch)
+	s.lock.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.dequeue(key, ch) {
+		// slot was granted concurrently
+		s.grant()
+	}
+
+	return ctx.Err()
+}
+
+// release returns slot, it is passed to next connection with waiting requests.
+func (s *fairScheduler) release() {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.grant()
+}
+
+// grant passes free slot to first request of next connection, must be called with lock.
+func (s *fairScheduler) grant() {
+	if len(s.order) == 0 {
+		s.free++
+		return
+	}
+
+	key := s.order[0]
+	s.order = s.order[1:]
+	queue := s.queues[key]
+	close(queue[0])
+	if len(queue) > 1 {
+		s.queues[key] = queue[1:]
+		s.order = append(s.order, key)
+	} else {
+		delete(s.queues, key)
+	}
+}
+
+// dequeue removes waiting request ch of connection key, returns false if it was not waiting.
+func (s *fairScheduler) dequeue(key interface{}, ch chan struct{}) bool {
+	queue := s.queues[key]
+	for i, c := range queue {
+		if c != ch {
+			continue
+		}
+
+		queue = append(queue[:i:i], queue[i+1:]...)
+		if len(queue) > 0 {
+			s.queues[key] = queue
+			return true
+		}
+
+		delete(s.queues, key)
+		for j, k := range s.order {
+			if k == key {
+				s.order = append(s.order[:j:j], s.order[j+1:]...)
+				break
+			}
+		}
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFairScheduler(t *testing.T) {
+	s := newFairScheduler(1)
+	ctx := context.Background()
+	if err := s.acquire(ctx, "busy"); err != nil {
+		t.Fatal(err)
+	}
+
+	// chatty connection queues 3 requests before quiet connection
+	granted := make(chan string, 4)
+	wait := func(key string) {
+		go func() {
+			if s.acquire(ctx, key) == nil {
+				granted <- key
+			}
+		}()
+		for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+			s.lock.Lock()
+			n := len(s.queues[key])
+			s.lock.Unlock()
+			if n > 0 || time.Now().After(deadline) {
+				break
+			}
+		}
+	}
+	wait("chatty")
+	wait("chatty")
+	wait("chatty")
+	wait("quiet")
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		s.release()
+		got = append(got, <-granted)
+	}
+	if got[0] != "chatty" || got[1] != "quiet" {
+		t.Errorf("got %v", got)
+	}
+
+	// cancelled request leaves queue
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := s.acquire(ctx, "late"); err != context.DeadlineExceeded {
+		t.Errorf("got %v", err)
+	}
+	s.release()
+	if s.free != 1 || len(s.order) != 0 || len(s.queues) != 0 {
+		t.Errorf("got free=%d order=%v", s.free, s.order)
+	}
+
+	var unlimited *fairScheduler
+	if err := unlimited.acquire(ctx, "a"); err != nil {
+		t.Error(err)
+	}
+	unlimited.release()
+}
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"math"
+	"net/url"
+)
+
+// fdReserve is a number of file descriptors kept for listeners, logs, storage and cluster registry.
+const fdReserve = 64
+
+// fdBudget splits file descriptor limit between websocket connections and idle backend connections of hosts.
+// Backend pools get at most a quarter of limit, up to maxConnectionToHost per host.
+func fdBudget(limit uint64, hosts int) (conns, idlePerHost int) {
+	if limit <= fdReserve {
+		return 1, 1
+	}
+	if hosts < 1 {
+		hosts = 1
+	}
+
+	budget := int(limit - fdReserve)
+	if limit-fdReserve > math.MaxInt32 {
+		budget = math.MaxInt32
+	}
+
+	idlePerHost = budget / 4 / hosts
+	if idlePerHost > maxConnectionToHost {
+		idlePerHost = maxConnectionToHost
+	} else if idlePerHost < 1 {
+		idlePerHost = 1
+	}
+
+	conns = budget - idlePerHost*hosts
+	if conns < 1 {
+		conns = 1
+	}
+
+	return conns, idlePerHost
+}
+
+// backendHosts returns number of distinct backend hosts of rules.
+func backendHosts(rules []ProxyRule) int {
+	hosts := make(map[string]struct{})
+	for _, r := range rules {
+		for _, dst := range []string{r.DstUrl, r.GreenUrl} {
+			if u, err := url.Parse(dst); err == nil && u.Host != "" {
+				hosts[u.Host] = struct{}{}
+			}
+		}
+	}
+
+	return len(hosts)
+}
+
+// checkFileLimit derives MaxConnections and MaxIdleConnsPerHost from open files soft limit if they are not set and
+// warns if configured values exceed what the limit can support.
+func (a *App) checkFileLimit() {
+	limit, err := fileLimit()
+	if err != nil {
+		a.Errorf("can't read open files limit err=%s", err)
+		return
+	}
+
+	// route handler and "/" multiple rules handler have own backend pools
+	hosts := 2 * backendHosts(a.RedirectRules)
+	conns, idlePerHost := fdBudget(limit, hosts)
+	if a.MaxIdleConnsPerHost == 0 {
+		a.MaxIdleConnsPerHost = idlePerHost
+	}
+	if a.MaxConnections == 0 {
+		a.MaxConnections = conns
+		a.Printf("max connections derived from open files limit=%d connections=%d idle_per_host=%d", limit, conns, a.MaxIdleConnsPerHost)
+	}
+
+	if need := uint64(a.MaxConnections) + uint64(a.MaxIdleConnsPerHost*hosts) + fdReserve; need > limit {
+		a.Errorf("WARNING: open files limit=%d is lower than required=%d by max connections=%d and idle backend connections=%d per %d pools, raise ulimit -n",
+			limit, need, a.MaxConnections, a.MaxIdleConnsPerHost, hosts)
+	}
+}
@@ -0,0 +1,33 @@
+package app
+
+import "testing"
+
+func TestFdBudget(t *testing.T) {
+	tests := []struct {
+		limit              uint64
+		hosts              int
+		conns, idlePerHost int
+	}{
+		{1024, 2, 720, 120},
+		{1048576, 4, 1048000, 128},
+		{1 << 63, 1, 1<<31 - 1 - 128, 128},
+		{100, 0, 27, 9},
+		{64, 1, 1, 1},
+	}
+
+	for _, tt := range tests {
+		if conns, idle := fdBudget(tt.limit, tt.hosts); conns != tt.conns || idle != tt.idlePerHost {
+			t.Errorf("%d/%d: got %d %d, expected %d %d", tt.limit, tt.hosts, conns, idle, tt.conns, tt.idlePerHost)
+		}
+	}
+}
+
+func TestBackendHosts(t *testing.T) {
+	rules := []ProxyRule{
+		{Src: "/rpc", DstUrl: "http://backend:8080/rpc", GreenUrl: "http://green:8080/rpc"},
+		{Src: "/api", DstUrl: "http://backend:8080/api"},
+	}
+	if n := backendHosts(rules); n != 2 {
+		t.Errorf("got %d", n)
+	}
+}
@@ -0,0 +1,214 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	FeatureOrdered       = "ordered"        // responses are sent in request order
+	FeatureVerboseErrors = "verbose_errors" // proxy errors expose backend host and error details
+
+	// featuresHeader lists enabled features of request for backends, like ordered,streaming.
+	featuresHeader = "X-Ws2http-Features"
+)
+
+// FeatureRule enables feature for requests by client header, claim of bearer token verified on upgrade or session
+// tag set by /admin/tags, so features roll out to a subset of clients. Header rules are client-controlled and should
+// enable only harmless features. Features other than FeatureOrdered and FeatureVerboseErrors, like streaming, are handled
+// by backends: enabled features are sent in X-Ws2http-Features header.
+type FeatureRule struct {
+	Feature string
+	Source  string // header, claim or tag
+	Name    string // header, claim or tag name
+}
+
+// ParseFeatureRule parses rule like ordered:tag:beta, verbose_errors:header:X-Debug or streaming:claim:features.
+// Header and claim enable feature with true or 1 value or list with feature name, tag enables feature for sessions
+// tagged by admin API.
+func ParseFeatureRule(value string) (FeatureRule, error) {
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return FeatureRule{}, fmt.Errorf("invalid feature rule %q", value)
+	}
+
+	r := FeatureRule{Feature: parts[0], Source: parts[1], Name: parts[2]}
+	switch r.Source {
+	case "header":
+		r.Name = http.CanonicalHeaderKey(r.Name)
+	case "claim", "tag":
+	default:
+		return FeatureRule{}, fmt.Errorf("invalid feature source %q", r.Source)
+	}
+
+	return r, nil
+}
+
+// featureSet is a sorted list of enabled features of request.
+type featureSet []string
+
+func (fs featureSet) has(feature string) bool {
+	i := sort.SearchStrings(fs, feature)
+	return i < len(fs) && fs[i] == feature
+}
+
+// enables checks flag value: true, 1 or comma-separated list with feature.
+func enables(value, feature string) bool {
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == feature || v == "1" || strings.EqualFold(v, "true") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// claimEnables checks claim value: true, string flag value or list with feature.
+func claimEnables(claim interface{}, feature string) bool {
+	switch v := claim.(type) {
+	case bool:
+		return v
+	case string:
+		return enables(v, feature)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == feature {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// tokenClaims returns claims of JWT without signature verification, token must be verified by caller.
+// Returns nil for missing or opaque tokens.
+func tokenClaims(token string) map[string]interface{} {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil
+	}
+
+	var claims map[string]interface{}
+	json.Unmarshal(payload, &claims)
+	return claims
+}
+
+// requestFeatures returns features of request enabled by rules. Session headers take precedence over handshake
+// headers, claims are read only from token verified on upgrade and tags only from tags set by admin API, so clients
+// can't enable claim and tag features by AUTH, SET or TAG messages.
+func (hf *HttpForwarder) requestFeatures(rf *requestForwarder) featureSet {
+	if len(hf.featureRules) == 0 {
+		return nil
+	}
+
+	header := func(name string) string {
+		if v := rf.header().Get(name); v != "" {
+			return v
+		} else if rf.ws.Request() != nil {
+			return rf.ws.Request().Header.Get(name)
+		}
+		return ""
+	}
+
+	var (
+		fs     featureSet
+		claims map[string]interface{}
+		parsed bool
+	)
+	for _, r := range hf.featureRules {
+		if fs.has(r.Feature) {
+			continue
+		}
+
+		enabled := false
+		switch r.Source {
+		case "header":
+			enabled = enables(header(r.Name), r.Feature)
+		case "claim":
+			if !parsed {
+				claims, parsed = tokenClaims(rf.authToken), true
+			}
+			enabled = claimEnables(claims[r.Name], r.Feature)
+		case "tag":
+			enabled = rf.session != nil && rf.session.hasAdminTag(r.Name)
+		}
+
+		if enabled {
+			fs = append(fs, r.Feature)
+			sort.Strings(fs)
+		}
+	}
+
+	return fs
+}
+
+// orderedReplies sends responses of ordered requests of connection in request order, responses completed early
+// wait for responses of previous requests.
+type orderedReplies struct {
+	lock    sync.Mutex
+	next    uint64                  // sequence of next request
+	sent    uint64                  // sequence of next response to send
+	pending map[uint64]orderedReply // completed responses by sequence
+}
+
+type orderedReply struct {
+	resp  []byte // nil if request has no response
+	reply func(resp []byte) error
+}
+
+func newOrderedReplies() *orderedReplies {
+	return &orderedReplies{pending: make(map[uint64]orderedReply)}
+}
+
+// slot reserves position of request and returns reply for its response and done to release position of request
+// completed without response. Done after reply is no-op.
+func (o *orderedReplies) slot(reply func(resp []byte) error) (func(resp []byte) error, func()) {
+	o.lock.Lock()
+	seq := o.next
+	o.next++
+	o.lock.Unlock()
+
+	var once sync.Once
+	complete := func(resp []byte) (err error) {
+		once.Do(func() { err = o.complete(seq, orderedReply{resp: resp, reply: reply}) })
+		return err
+	}
+
+	return complete, func() { complete(nil) }
+}
+
+// complete saves response of request and sends completed responses in order. Returns send error of resp
+// if it was sent.
+func (o *orderedReplies) complete(seq uint64, r orderedReply) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.pending[seq] = r
+	var err error
+	for {
+		p, ok := o.pending[o.sent]
+		if !ok {
+			return err
+		}
+		delete(o.pending, o.sent)
+
+		if p.resp != nil {
+			if sErr := p.reply(p.resp); o.sent == seq {
+				err = sErr
+			}
+		}
+		o.sent++
+	}
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseFeatureRule(t *testing.T) {
+	if r, err := ParseFeatureRule("verbose_errors:header:x-debug"); err != nil || r != (FeatureRule{Feature: "verbose_errors", Source: "header", Name: "X-Debug"}) {
+		t.Errorf("got %+v %v", r, err)
+	}
+	if r, err := ParseFeatureRule("ordered:tag:beta:1"); err != nil || r != (FeatureRule{Feature: "ordered", Source: "tag", Name: "beta:1"}) {
+		t.Errorf("got %+v %v", r, err)
+	}
+	for _, v := range []string{"ordered", "ordered:tag", ":tag:beta", "ordered:tag:", "ordered:cookie:beta"} {
+		if _, err := ParseFeatureRule(v); err == nil {
+			t.Errorf("%s: expected error", v)
+		}
+	}
+}
+
+func TestRequestFeatures(t *testing.T) {
+	hf := NewHttpForwarder("http://backend/rpc", nil, 10, 1)
+	hf.SetFeatureRules([]FeatureRule{
+		{Feature: FeatureOrdered, Source: "tag", Name: "beta"},
+		{Feature: FeatureVerboseErrors, Source: "header", Name: "X-Debug"},
+		{Feature: "streaming", Source: "claim", Name: "features"},
+		{Feature: "compact", Source: "claim", Name: "compact"},
+	})
+	rf := hf.newRequestForwarder(&wsConn{})
+	rf.headers.Store(http.Header{})
+	rf.session = newSession("/rpc", rf.ws)
+
+	if fs := hf.requestFeatures(rf); fs != nil {
+		t.Errorf("no flags: got %v", fs)
+	}
+
+	// client tags and session Authorization header are not trusted
+	hs256 := func([]byte) []byte { return []byte("sig") }
+	token := testJwt(`{"alg":"HS256"}`, `{"features":["streaming"],"compact":false}`, hs256)
+	rf.session.addTag("beta")
+	rf.setHeader("Authorization", "Bearer "+token)
+	if fs := hf.requestFeatures(rf); fs != nil {
+		t.Errorf("client tag and token: got %v", fs)
+	}
+
+	rf.session.addAdminTag("beta")
+	rf.setHeader("X-Debug", "streaming, verbose_errors")
+	rf.authToken = token
+
+	expected := featureSet{FeatureOrdered, "streaming", FeatureVerboseErrors}
+	if fs := hf.requestFeatures(rf); !reflect.DeepEqual(fs, expected) {
+		t.Errorf("got %v", fs)
+	}
+	if !expected.has("streaming") || expected.has("compact") {
+		t.Error("has: got wrong result")
+	}
+
+	// opaque tokens have no claims
+	rf.authToken = "static"
+	rf.setHeader("X-Debug", "1")
+	if fs := hf.requestFeatures(rf); !reflect.DeepEqual(fs, featureSet{FeatureOrdered, FeatureVerboseErrors}) {
+		t.Errorf("got %v", fs)
+	}
+}
+
+func TestOrderedReplies(t *testing.T) {
+	var sent []string
+	send := func(resp []byte) error {
+		sent = append(sent, string(resp))
+		return nil
+	}
+
+	o := newOrderedReplies()
+	reply1, done1 := o.slot(send)
+	reply2, done2 := o.slot(send)
+	reply3, _ := o.slot(send)
+
+	// later responses wait for earlier ones, requests without response release their position
+	reply3([]byte("3"))
+	reply2([]byte("2"))
+	if len(sent) != 0 {
+		t.Fatalf("sent before first response: %v", sent)
+	}
+	done2()
+	done1()
+	reply1([]byte("1"))
+	if !reflect.DeepEqual(sent, []string{"2", "3"}) {
+		t.Errorf("got %v", sent)
+	}
+
+	reply4, _ := o.slot(send)
+	reply4([]byte("4"))
+	if !reflect.DeepEqual(sent, []string{"2", "3", "4"}) {
+		t.Errorf("got %v", sent)
+	}
+}
+
+func TestVerboseErrData(t *testing.T) {
+	hf := NewHttpForwarder("http://backend/rpc", nil, 10, 1)
+	d := hf.errData("s1.1", "https://rpc.internal:8443/rpc", 502, errors.New("bad gateway"))
+	d.verbose("https://rpc.internal:8443/rpc", errors.New("bad gateway"))
+	if *d != (JsonRpcErrData{HttpStatus: 502, BackendHost: "rpc.internal:8443", RequestId: "s1.1", Detail: "bad gateway"}) {
+		t.Errorf("got %+v", d)
+	}
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Modes of client identity headers, see HttpForwarder.SetForwardedHeaders.
+const (
+	ForwardedOverwrite = "overwrite" // headers describe direct client, incoming values are dropped
+	ForwardedAppend    = "append"    // client is appended to incoming X-Forwarded-For, proxy is behind trusted proxy
+)
+
+type forwardedKey struct{}
+
+// forwardedHeaders returns X-Forwarded-For, X-Real-IP and X-Forwarded-Proto for client handshake request r.
+func forwardedHeaders(r *http.Request, mode string) http.Header {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	h := http.Header{"X-Forwarded-For": {ip}, "X-Real-Ip": {ip}, "X-Forwarded-Proto": {proto}}
+	if mode != ForwardedAppend {
+		return h
+	}
+
+	if prior := r.Header["X-Forwarded-For"]; len(prior) > 0 {
+		h.Set("X-Forwarded-For", strings.Join(prior, ", ")+", "+ip)
+	}
+	for _, name := range []string{"X-Real-Ip", "X-Forwarded-Proto"} {
+		if v := r.Header.Get(name); v != "" {
+			h.Set(name, v)
+		}
+	}
+
+	return h
+}
+
+// withForwarded returns context with client identity headers for backend requests.
+func withForwarded(ctx context.Context, h http.Header) context.Context {
+	return context.WithValue(ctx, forwardedKey{}, h)
+}
+
+// setForwardedHeaders sets client identity headers from ctx, session headers with the same names are replaced,
+// so clients can't spoof their address via SET.
+func setForwardedHeaders(ctx context.Context, h http.Header) {
+	fh, ok := ctx.Value(forwardedKey{}).(http.Header)
+	if !ok {
+		return
+	}
+
+	for name, vv := range fh {
+		h[name] = vv
+	}
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardedHeaders(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/rpc", nil)
+	r.RemoteAddr = "10.0.0.2:41000"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	r.Header.Set("X-Real-IP", "203.0.113.7")
+
+	h := forwardedHeaders(r, ForwardedOverwrite)
+	if h.Get("X-Forwarded-For") != "10.0.0.2" || h.Get("X-Real-IP") != "10.0.0.2" || h.Get("X-Forwarded-Proto") != "http" {
+		t.Errorf("overwrite: got %v", h)
+	}
+
+	r.TLS = &tls.ConnectionState{}
+	h = forwardedHeaders(r, ForwardedAppend)
+	if h.Get("X-Forwarded-For") != "203.0.113.7, 10.0.0.1, 10.0.0.2" || h.Get("X-Real-IP") != "203.0.113.7" || h.Get("X-Forwarded-Proto") != "https" {
+		t.Errorf("append: got %v", h)
+	}
+
+	// append without trusted proxy headers
+	r = httptest.NewRequest(http.MethodGet, "/rpc", nil)
+	r.RemoteAddr = "10.0.0.2:41000"
+	h = forwardedHeaders(r, ForwardedAppend)
+	if h.Get("X-Forwarded-For") != "10.0.0.2" || h.Get("X-Real-IP") != "10.0.0.2" {
+		t.Errorf("append, no prior: got %v", h)
+	}
+}
+
+func TestDoPostRequestForwarded(t *testing.T) {
+	var got http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+	}))
+	defer ts.Close()
+
+	r := httptest.NewRequest(http.MethodGet, "/rpc", nil)
+	r.RemoteAddr = "10.0.0.2:41000"
+	ctx := withForwarded(context.Background(), forwardedHeaders(r, ForwardedOverwrite))
+
+	// session header can't spoof client address
+	hf := NewHttpForwarder(ts.URL, nil, 1, 1)
+	resp, err := hf.doPostRequest(ctx, http.DefaultClient, []byte(`{}`), ts.URL, http.Header{"X-Forwarded-For": {"1.2.3.4"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got.Get("X-Forwarded-For") != "10.0.0.2" || got.Get("X-Real-IP") != "10.0.0.2" || got.Get("X-Forwarded-Proto") != "http" {
+		t.Errorf("got %v", got)
+	}
+}
@@ -2,27 +2,38 @@ package app
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"io"
-	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus"
-	"golang.org/x/net/websocket"
+	"github.com/semrush/ws2http/clock"
 )
 
 const (
 	maxConnectionToHost = 128
 )
 
-var errInvalidPrefix = errors.New("invalid prefix: dstUrl was not found")
+var (
+	errInvalidPrefix      = errors.New("invalid prefix: dstUrl was not found")
+	errClientRequestLimit = errors.New("too many outstanding requests")
+	errHeaderNotAllowed   = errors.New("header is not allowed")
+	errHeaderLimit        = errors.New("header limit exceeded")
+	errBodyTooLarge       = errors.New("request body too large")
+)
 
 type errTimeout interface {
 	Timeout() bool
@@ -33,54 +44,167 @@ type rpcRequest struct {
 	srcUrl string         // source handler, like / or /rpc
 	dstUrl string         // json-rpc server endpoint
 	msg    []byte         // rewrited msg
+
+	timeout time.Duration     // client request timeout from _timeout member, 0 is server timeout
+	ctx     context.Context   // cancelable request context, nil is connection context
+	ext     []extensionMember // client extension members, like "meta", kept in rewritten msg
+
+	features featureSet // enabled features of request
 }
 
-// JSON marshals rpcRequest ignoring errors.
+// JSON marshals rpcRequest with extension members ignoring errors.
 func (r rpcRequest) JSON() []byte {
 	data, err := json.Marshal(r.req)
 	if err != nil {
 		log.Println(err)
 	}
 
-	return data
+	return withExtensionMembers(data, r.ext)
 }
 
 // requestForwarder is a struct for handling every client connection and request.
 type requestForwarder struct {
 	client             *http.Client
 	maxParallelRequest chan struct{}
-	headers            http.Header
-	headersLock        *sync.RWMutex
+	maxClientRequests  int32        // max outstanding requests per connection, 0 is unlimited
+	rateLimit          *tokenBucket // requests rate limit of connection, nil if disabled
+	outstanding        int32        // current outstanding requests
+	requestSeq         uint32       // last request id sequence of connection
+	headers            atomic.Value // http.Header snapshot, replaced on SET/AUTH, must not be modified
+	headersLock        *sync.Mutex  // serializes snapshot updates
 	allowedHeaders     []string
+	extensionMembers   []string             // allowed client extension members, empty allows any
+	maxHeaders         int                  // max session headers, 0 is unlimited
+	maxHeadersSize     int                  // max total size of session header names and values, 0 is unlimited
+	token              *tokenWatch          // auth token expiry, nil if disabled
+	ordered            *orderedReplies      // responses of requests with FeatureOrdered, nil without feature rules
 	multipleRules      map[string]ProxyRule // special multiple rules mode
-	ws                 *websocket.Conn
+	rule               ProxyRule            // route rule in single mode
+	ws                 *wsConn
+	session            *session
+	csrfCookie         string // browser mode csrf cookie name, empty if disabled
+	authToken          string // bearer token verified by TokenAuthHook on upgrade, source of feature claims
+	handshaked         bool   // csrf handshake completed
+	codec              Codec
+	conn               ConnInfo        // connection fields for logs
+	ctx                context.Context // backend requests context with ConnInfo
+	controlAcks        bool            // acknowledge control messages
+	version            int             // negotiated control protocol version
+	queue              *sendQueue      // outbound frames, nil while testing
+	requests           *inflightRequests
+	correlation        *correlationCache // outstanding and answered request ids, nil if disabled
+	goroutines         *goroutineTracker // connection goroutines for leak detection
 
 	logger
 }
 
 // newRequestForwarder returns new request forwarder with predefined http.Client and logger from HTTP Forwarder.
-func (hf *HttpForwarder) newRequestForwarder(ws *websocket.Conn) requestForwarder {
-	rf := requestForwarder{
+func (hf *HttpForwarder) newRequestForwarder(ws *wsConn) *requestForwarder {
+	rf := &requestForwarder{
 		client: &http.Client{
 			Timeout:   time.Duration(hf.timeout) * time.Second,
 			Transport: hf.transport,
 		},
 		maxParallelRequest: make(chan struct{}, hf.maxParallelRequests),
-		headers:            make(http.Header),
+		maxClientRequests:  int32(hf.maxClientRequests),
+		rateLimit:          newRateLimit(hf.rateLimit, hf.rateBurst, hf.clock),
 		ws:                 ws,
 		allowedHeaders:     hf.allowedHeaders,
+		extensionMembers:   hf.extensionMembers,
+		maxHeaders:         hf.maxHeaders,
+		maxHeadersSize:     hf.maxHeadersSize,
 		multipleRules:      hf.multipleRules,
-		headersLock:        &sync.RWMutex{},
+		headersLock:        &sync.Mutex{},
+		csrfCookie:         hf.csrfCookie,
+		codec:              hf.codec,
+		controlAcks:        hf.controlAcks,
+		version:            MinProtocolVersion,
+		requests:           newInflightRequests(),
+		correlation:        newCorrelationCache(hf.correlationTtl, hf.clock),
+		goroutines:         newGoroutineTracker(),
+	}
+
+	if len(hf.featureRules) > 0 {
+		rf.ordered = newOrderedReplies()
+		rf.authToken = verifiedToken(ws.Request())
+	}
+
+	// select codec or protocol version by websocket subprotocol
+	if p := ws.Subprotocol(); p != "" {
+		if c, ok := lookupCodec(p); ok {
+			rf.codec = c
+		} else if v := parseVersionSubprotocol(p); v > 0 {
+			rf.version = v
+		}
+	}
+
+	if hf.route != nil {
+		rf.rule = hf.route.rule
+	}
+
+	route, remoteAddr, headers := "/", "", make(http.Header)
+	if ws.Request() != nil { // could be nil while testing
+		route, remoteAddr = ws.Request().URL.Path, ws.Request().RemoteAddr
+
+		// set client locale headers from handshake
+		for _, name := range hf.localeHeaders {
+			if vv := ws.Request().Header[http.CanonicalHeaderKey(name)]; len(vv) > 0 && !isHopHeader(name) {
+				headers[http.CanonicalHeaderKey(name)] = vv
+			}
+		}
+
+		// set headers from route forward auth response
+		if ah, ok := ws.Request().Context().Value(authHeadersKey).(http.Header); ok {
+			for k, vv := range ah {
+				headers[k] = vv
+			}
+		}
+	}
+	rf.headers.Store(headers)
+	if hf.tokenNotice > 0 {
+		rf.token = &tokenWatch{notice: hf.tokenNotice, clock: hf.clock}
+	}
+	rf.session = hf.sessions.newSession(route, ws)
+	rf.session.send = rf.send
+	rf.session.messageIds = hf.messageIds
+	rf.session.addHeaderTags(rf.rule.TagHeaders, headers)
+	rf.conn = ConnInfo{SessionId: rf.session.id, Route: route, RemoteAddr: remoteAddr, Principal: principal(rf.rule.AuthHeaders, headers)}
+	rf.ctx = newConnContext(context.Background(), rf.conn)
+	if hf.forwardedMode != "" && ws.Request() != nil {
+		rf.ctx = withForwarded(rf.ctx, forwardedHeaders(ws.Request(), hf.forwardedMode))
+	}
+
+	// store backend cookies per connection
+	if hf.cookieJar {
+		rf.client.Jar, _ = cookiejar.New(nil) // never returns error
 	}
 
-	rf.SetLogLevel(hf.logLevel)
-	rf.SetLoggers(hf.warn, hf.log, hf.trace)
+	rf.logger = hf.logger.withFields(rf.conn.logFields()...)
 
 	return rf
 }
 
+// acquireClientSlot increments outstanding requests counter and returns false if client limit was reached.
+func (rf *requestForwarder) acquireClientSlot() bool {
+	if atomic.AddInt32(&rf.outstanding, 1) > rf.maxClientRequests && rf.maxClientRequests > 0 {
+		atomic.AddInt32(&rf.outstanding, -1)
+		return false
+	}
+
+	return true
+}
+
+// releaseClientSlot decrements outstanding requests counter.
+func (rf *requestForwarder) releaseClientSlot() {
+	atomic.AddInt32(&rf.outstanding, -1)
+}
+
 // isAllowedHeader is a function that checks existence of header in allowedHeaders
 func (rf *requestForwarder) isAllowedHeader(header string) bool {
+	if isHopHeader(header) {
+		return false
+	}
+
 	for _, h := range rf.allowedHeaders {
 		if h == header {
 			return true
@@ -90,48 +214,158 @@ func (rf *requestForwarder) isAllowedHeader(header string) bool {
 	return false
 }
 
-// checkAndSetHeaders checks message for SET prefix. If message contains header then set it and return true.
-func (rf *requestForwarder) checkAndSetHeaders(msg []byte) bool {
+// checkAndSetHeaders checks message for SET or TAG prefix. If message contains header or tag then set it and return true.
+// Returns error if header is not allowed or message is malformed.
+func (rf *requestForwarder) checkAndSetHeaders(msg []byte) (bool, error) {
 	// TODO(sergeyfast): deprecated, remove before merging into master, check \n problem?
 	if bytes.HasPrefix(msg, []byte("AUTH ")) {
-		if rf.isAllowedHeader("Authorization") {
-			rf.headersLock.Lock()
-			defer rf.headersLock.Unlock()
-			rf.headers.Set("Authorization", string(msg[5:]))
+		if len(bytes.TrimSpace(msg[5:])) == 0 {
+			return true, errMalformedControl
+		} else if !rf.isAllowedHeader("Authorization") {
+			return true, errHeaderNotAllowed
 		}
 
-		return true
+		err := rf.setHeader("Authorization", string(msg[5:]))
+		if err == nil {
+			rf.watchToken()
+		}
+		return true, err
 	}
 
 	// set custom headers for session
 	if bytes.HasPrefix(msg, []byte("SET ")) {
-		hv := strings.Split(string(msg[4:]), " ")
-		if rf.isAllowedHeader(hv[0]) {
-			rf.headersLock.Lock()
-			defer rf.headersLock.Unlock()
-			rf.headers.Set(hv[0], hv[1])
-		} else {
-			rf.Printf("failed to add custom header=%v value=%v ip=%s", hv[0], hv[1], rf.ws.Request().RemoteAddr)
+		hv := strings.SplitN(string(msg[4:]), " ", 2)
+		if len(hv) != 2 || hv[0] == "" || hv[1] == "" {
+			return true, errMalformedControl
+		} else if !rf.isAllowedHeader(hv[0]) {
+			rf.Printf("failed to add custom header=%v value=%v", hv[0], hv[1])
+			return true, errHeaderNotAllowed
 		}
 
-		return true
+		if err := rf.setHeader(hv[0], hv[1]); err != nil {
+			rf.Printf("failed to add custom header=%v err=%s", hv[0], err)
+			return true, err
+		}
+		if http.CanonicalHeaderKey(hv[0]) == "Authorization" {
+			rf.watchToken()
+		}
+		return true, nil
 	}
 
-	return false
+	// tag session for broadcasts
+	if bytes.HasPrefix(msg, []byte("TAG ")) {
+		tag := strings.TrimSpace(string(msg[4:]))
+		if tag == "" {
+			return true, errMalformedControl
+		}
+
+		rf.session.addTag(tag)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// ack acknowledges control message with "OK <command>" or "ERR <command> <error>" if control acks are enabled.
+// Acks are queued by reading goroutine, so they are delivered before responses for next requests.
+func (rf *requestForwarder) ack(msg []byte, err error) {
+	if !rf.controlAcks {
+		return
+	}
+
+	command := controlCommand(msg)
+	ack := "OK " + command
+	if err != nil {
+		ack = "ERR " + command + " " + err.Error()
+	}
+
+	if err = rf.write(ack); err != nil {
+		rf.Errorf("can't send ack err=%s", err)
+	}
 }
 
-// copyHeaders returns new copy from rf.headers.
-func (rf *requestForwarder) copyHeaders() http.Header {
-	rf.headersLock.RLock()
-	defer rf.headersLock.RUnlock()
+// header returns current session headers snapshot. Snapshot must not be modified, use copyHeaders.
+func (rf *requestForwarder) header() http.Header {
+	return rf.headers.Load().(http.Header)
+}
 
-	locHeaders := make(http.Header)
-	for k, vv := range rf.headers {
+// setHeader replaces session headers snapshot with new copy with header.
+// Snapshot is not changed if new headers exceed header count or size limits.
+func (rf *requestForwarder) setHeader(key, value string) error {
+	rf.headersLock.Lock()
+	defer rf.headersLock.Unlock()
+
+	h := copyHeaders(rf.header())
+	h.Set(key, value)
+	if rf.maxHeaders > 0 && len(h) > rf.maxHeaders {
+		return errHeaderLimit
+	} else if rf.maxHeadersSize > 0 && headersSize(h) > rf.maxHeadersSize {
+		return errHeaderLimit
+	}
+
+	rf.headers.Store(h)
+	return nil
+}
+
+// headersSize returns total size of header names and values.
+func headersSize(h http.Header) int {
+	size := 0
+	for k, vv := range h {
 		for _, v := range vv {
-			locHeaders.Add(k, v)
+			size += len(k) + len(v)
+		}
+	}
+
+	return size
+}
+
+// hopHeaders are hop-by-hop headers of client connection, they are never forwarded to backends.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// isHopHeader checks header name for hop-by-hop headers.
+func isHopHeader(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	for _, h := range hopHeaders {
+		if h == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// removeHopHeaders removes hop-by-hop headers and headers listed in Connection from h.
+func removeHopHeaders(h http.Header) {
+	for _, v := range h["Connection"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
 		}
 	}
 
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// copyHeaders returns new copy of h.
+func copyHeaders(h http.Header) http.Header {
+	locHeaders := make(http.Header, len(h))
+	for k, vv := range h {
+		locHeaders[k] = append([]string(nil), vv...)
+	}
+
 	return locHeaders
 }
 
@@ -139,8 +373,8 @@ func (rf *requestForwarder) copyHeaders() http.Header {
 // Errors could be: unmarshal request, method not found, invalid prefix for routing.
 // TODO(sergeyfast): add batch support
 func (rf *requestForwarder) rewriteRequest(msg []byte, defaultDstUrl string) (rpcReq rpcRequest, err error) {
-	var req JsonRpcRequest
-	if err = json.Unmarshal(msg, &req); err != nil {
+	req, members, err := parseRequestMembers(msg)
+	if err != nil {
 		return // invalid json-rpc request
 	}
 
@@ -155,9 +389,24 @@ func (rf *requestForwarder) rewriteRequest(msg []byte, defaultDstUrl string) (rp
 		srcUrl: srcUrl,
 	}
 
+	// keep client extension members in rewritten msg, strip proxy members and members out of allowlist
+	var stripped bool
+	if rpcReq.ext, stripped = extensionMembers(members, rf.extensionMembers); stripped {
+		rpcReq.msg = rpcReq.JSON()
+	}
+
+	// client timeout is stripped as proxy member
+	if timeout, ok := requestTimeout(members); ok {
+		rpcReq.timeout = timeout
+	}
+
 	// check for current requestForwarder mode: normal method without routing prefix
 	if len(rf.multipleRules) == 0 {
 		rpcReq.dstUrl = defaultDstUrl
+		if m := rf.rule.normalizeMethod(req.Method); m != req.Method {
+			rpcReq.req.Method = m
+			rpcReq.msg = rpcReq.JSON()
+		}
 		return
 	}
 
@@ -176,44 +425,161 @@ func (rf *requestForwarder) rewriteRequest(msg []byte, defaultDstUrl string) (rp
 		return
 	} else {
 		rpcReq.dstUrl = r.DstUrl
-		rpcReq.req.Method = m[1]
+		rpcReq.req.Method = r.normalizeMethod(m[1])
 		rpcReq.msg = rpcReq.JSON()
 	}
 
 	return
 }
 
+// requestTimeout returns client timeout from "_timeout" request member in milliseconds. Returns true if member
+// is present, last value is used for repeated members.
+func requestTimeout(members []extensionMember) (time.Duration, bool) {
+	var value []byte
+	for _, m := range members {
+		if string(m.key) == "_timeout" {
+			value = m.value
+		}
+	}
+
+	// null and non-number values are ignored
+	timeout, err := strconv.ParseFloat(string(value), 64)
+	if err != nil {
+		return 0, false
+	} else if timeout <= 0 {
+		return 0, true
+	}
+
+	return time.Duration(timeout * float64(time.Millisecond)), true
+}
+
 // HttpForwarder is a struct for unique endpoint.
 type HttpForwarder struct {
 	dstUrl                       string
 	allowedHeaders               []string
+	localeHeaders                []string // handshake headers forwarded with every backend request
+	forwardedMode                string   // client identity headers mode, empty doesn't send them
+	extensionMembers             []string // allowed client extension members, empty allows any
+	maxHeaders, maxHeadersSize   int      // session headers limits for SET
+	tokenNotice                  time.Duration
+	messageIds                   IdGenerator   // ids of proxy notifications, nil sends no ids
+	deadlineHeader               string        // backend header with remaining request time, like X-Request-Timeout-Ms
+	queueHeader                  string        // backend header with time request waited in proxy, like X-WS2HTTP-Queue-Ms
+	retryAfterHold               time.Duration // max delay of methods after backend Retry-After, 0 is disabled
+	clock                        clock.Clock   // time source of timers, default is system clock
+	errorBackendHost             bool          // expose backend host in error data
+	mirrorSlots                  chan struct{} // parallel mirrored requests
 	timeout, maxParallelRequests int
+	maxClientRequests            int
+	maxResponseSize              int     // max backend response size in bytes, 0 is unlimited
+	rateLimit                    float64 // max requests per second per connection, 0 is unlimited
+	rateBurst                    int
+	featureRules                 []FeatureRule
+	cookieJar                    bool
+	csrfCookie                   string
+	allowMissingOrigin           bool // upgrades without Origin are checked by origin upgrade hook
+	codec                        Codec
+	mqttBridge                   bool
+	stomp                        bool
+	slowClientGrace              time.Duration
+	leakGrace                    time.Duration // goroutine leak detection delay after disconnect, 0 is disabled
+	correlationTtl               time.Duration // request ids tracking for orphan responses, 0 is disabled
+	writeTimeout                 time.Duration
+	pingInterval                 time.Duration // client ping period, 0 is disabled
+	controlAcks                  bool
 	transport                    *http.Transport
+	authClient                   *http.Client // client for forward auth subrequests
 
-	multipleRules map[string]ProxyRule // special multiple rules mode
+	multipleRules map[string]ProxyRule   // special multiple rules mode
+	sessions      *sessionRegistry       // registry for broadcasts, optional
+	shutdown      *shutdownState         // in-flight requests for graceful shutdown, optional
+	instance      instance               // identity in close frame reasons
+	route         *routeState            // runtime route state for single mode, optional
+	routes        map[string]*routeState // runtime route states by src, optional
+	cache         *methodCache           // response cache, optional
+	slots         *destinationSlots      // parallel requests limits per destination, optional
+	slos          *sloTracker            // method latencies, optional
+
+	// transports of routes with TLS settings in multiple rules mode
+	routeTransports map[string]*http.Transport
 
 	logger
 
 	statBackendRequests  *prometheus.CounterVec
 	statBackendDurations *prometheus.SummaryVec
 	statActiveConns      *prometheus.GaugeVec
+	statSlowClients      *prometheus.CounterVec
+	statGoroutineLeaks   *prometheus.CounterVec
+	statBackendPhases    *prometheus.HistogramVec
+	statBackendVersions  *prometheus.CounterVec
+	statBodySizes        *prometheus.HistogramVec
+	statOrphanResponses  *prometheus.CounterVec
+	statNotifications    *prometheus.CounterVec
+	statRateLimited      *prometheus.CounterVec
+	pool                 *poolStats
 }
 
 // NewHttpForwarder returns new single instance HttpForwarder for connection.
 func NewHttpForwarder(dstUrl string, allowedHeaders []string, timeout, maxParallelRequests int) *HttpForwarder {
-	return &HttpForwarder{
+	hf := &HttpForwarder{
+		codec:               jsonCodec{},
 		dstUrl:              dstUrl,
 		allowedHeaders:      allowedHeaders,
 		timeout:             timeout,
 		maxParallelRequests: maxParallelRequests,
+		clock:               clock.Real,
 		transport: &http.Transport{
 			MaxIdleConnsPerHost: maxConnectionToHost,
 			TLSClientConfig: &tls.Config{
 				ClientSessionCache: tls.NewLRUClientSessionCache(maxConnectionToHost),
-				InsecureSkipVerify: true,
 			},
 		},
 	}
+
+	hf.mirrorSlots = make(chan struct{}, maxParallelRequests+1)
+	hf.authClient = &http.Client{
+		Timeout:   time.Duration(timeout) * time.Second,
+		Transport: hf.transport,
+	}
+
+	return hf
+}
+
+// SetClock sets time source of keepalives, token expiry, rate limits and slow client detection, nil is system clock.
+func (hf *HttpForwarder) SetClock(c clock.Clock) {
+	hf.clock = clock.OrReal(c)
+}
+
+// SetIdleConnsPerHost sets max idle backend connections per host, 0 keeps default.
+func (hf *HttpForwarder) SetIdleConnsPerHost(n int) {
+	if n > 0 {
+		hf.transport.MaxIdleConnsPerHost = n
+	}
+}
+
+// SetTlsConfig sets backend TLS client config, default config verifies backend certificates with system roots.
+func (hf *HttpForwarder) SetTlsConfig(c *tls.Config) {
+	hf.transport.TLSClientConfig = c
+}
+
+// setRouteTlsConfig sets backend TLS client config of route in multiple rules mode with separate transport.
+func (hf *HttpForwarder) setRouteTlsConfig(src string, c *tls.Config) {
+	if hf.routeTransports == nil {
+		hf.routeTransports = make(map[string]*http.Transport)
+	}
+
+	t := hf.transport.Clone()
+	t.TLSClientConfig = c
+	hf.routeTransports[src] = t
+}
+
+// routeTransport returns transport for route backend requests.
+func (hf *HttpForwarder) routeTransport(src string) *http.Transport {
+	if t, ok := hf.routeTransports[src]; ok {
+		return t
+	}
+
+	return hf.transport
 }
 
 func (hf *HttpForwarder) SetStats(requests *prometheus.CounterVec, durations *prometheus.SummaryVec, conns *prometheus.GaugeVec) {
@@ -222,6 +588,198 @@ func (hf *HttpForwarder) SetStats(requests *prometheus.CounterVec, durations *pr
 	hf.statActiveConns = conns
 }
 
+// setPoolStats enables backend connections counting for transport.
+func (hf *HttpForwarder) setPoolStats(p *poolStats) {
+	if p == nil {
+		return
+	}
+
+	hf.pool = p
+	hf.transport.DialContext = p.dialContext((&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext)
+}
+
+// SetMaxClientRequests sets max outstanding requests per connection. Requests over limit are rejected with error.
+func (hf *HttpForwarder) SetMaxClientRequests(n int) {
+	hf.maxClientRequests = n
+}
+
+// SetRateLimit sets max requests per second per connection with burst, 0 burst is one second of rate.
+// Requests over limit are rejected with JsonRpcRateLimited error.
+func (hf *HttpForwarder) SetRateLimit(rate float64, burst int) {
+	hf.rateLimit, hf.rateBurst = rate, burst
+}
+
+// SetFeatureRules sets rules enabling request features by client header, token claim or session tag.
+func (hf *HttpForwarder) SetFeatureRules(rules []FeatureRule) {
+	hf.featureRules = rules
+}
+
+// SetExtensionMembers sets allowlist of client json-rpc extension members, like meta and trace. Extension members
+// are kept in rewritten requests, members out of non-empty allowlist are stripped before forwarding.
+func (hf *HttpForwarder) SetExtensionMembers(members []string) {
+	hf.extensionMembers = members
+}
+
+// SetLocaleHeaders sets client locale headers, like Accept-Language and X-Timezone, which are captured
+// at handshake and forwarded with every backend request. Clients can override them via SET if allowed.
+func (hf *HttpForwarder) SetLocaleHeaders(names []string) {
+	hf.localeHeaders = names
+}
+
+// SetForwardedHeaders enables X-Forwarded-For, X-Real-IP and X-Forwarded-Proto of websocket client in backend
+// requests: ForwardedOverwrite ignores incoming values, ForwardedAppend keeps them for proxy behind another proxy.
+// Empty mode disables headers.
+func (hf *HttpForwarder) SetForwardedHeaders(mode string) {
+	hf.forwardedMode = mode
+}
+
+// SetDeadlineHeader sets backend request header with remaining request time in milliseconds, like
+// X-Request-Timeout-Ms, so backends can abort work after client timeout. grpc-timeout is sent in gRPC format.
+// Registered backends get request deadline from context.
+func (hf *HttpForwarder) SetDeadlineHeader(name string) {
+	hf.deadlineHeader = name
+}
+
+// SetErrorBackendHost exposes backend host in JsonRpcErrData of errors, host is omitted by default.
+func (hf *HttpForwarder) SetErrorBackendHost(enabled bool) {
+	hf.errorBackendHost = enabled
+}
+
+// SetRetryAfterHold delays requests of method after backend 429 or 503 response with Retry-After for hinted time
+// up to hold, so clients retrying at once don't hit overloaded backend. Held requests still fail after timeout.
+func (hf *HttpForwarder) SetRetryAfterHold(hold time.Duration) {
+	hf.retryAfterHold = hold
+}
+
+// SetQueueHeader sets backend request header with time in milliseconds the request waited in proxy since client
+// message receive, like X-WS2HTTP-Queue-Ms, so backend latency could be told apart from proxy queuing.
+// Registered backends get receive time with ReceivedFromContext.
+func (hf *HttpForwarder) SetQueueHeader(name string) {
+	hf.queueHeader = name
+}
+
+// SetHeaderLimits sets max session headers count and total size of names and values, 0 is unlimited.
+// SET over limits is rejected with "header limit exceeded" error.
+func (hf *HttpForwarder) SetHeaderLimits(count, size int) {
+	hf.maxHeaders, hf.maxHeadersSize = count, size
+}
+
+// SetTokenExpiry enables JWT expiry tracking for session Authorization header: client receives
+// ws2http.reauth notification notice before exp claim, requests are rejected with JsonRpcTokenExpired
+// error after expiry until client sends AUTH with new token. Tokens are not validated.
+func (hf *HttpForwarder) SetTokenExpiry(notice time.Duration) {
+	hf.tokenNotice = notice
+}
+
+// SetMessageIds sets generator of messageId member of proxy notifications, nil sends notifications without ids.
+func (hf *HttpForwarder) SetMessageIds(gen IdGenerator) {
+	hf.messageIds = gen
+}
+
+// SetCookieJar enables cookie jar per connection: cookies from backend responses are sent with next requests of connection.
+func (hf *HttpForwarder) SetCookieJar(enabled bool) {
+	hf.cookieJar = enabled
+}
+
+// SetSlowClientGrace enables slow clients disconnection: clients with half full send queue or blocked writes
+// are disconnected with policy violation close code after grace period.
+func (hf *HttpForwarder) SetSlowClientGrace(grace time.Duration) {
+	hf.slowClientGrace = grace
+}
+
+// SetWriteTimeout sets write deadline for every frame sent to client, client is disconnected on deadline violation.
+func (hf *HttpForwarder) SetWriteTimeout(timeout time.Duration) {
+	hf.writeTimeout = timeout
+}
+
+// SetPingInterval enables ping frames to client every interval, clients without pong for two intervals
+// are disconnected.
+func (hf *HttpForwarder) SetPingInterval(interval time.Duration) {
+	hf.pingInterval = interval
+}
+
+// SetControlAcks enables acks for control messages: "OK SET" or "ERR SET header is not allowed".
+// Acks and responses are written in order through connection send queue, so ack is received before
+// response for any request sent after control message.
+func (hf *HttpForwarder) SetControlAcks(enabled bool) {
+	hf.controlAcks = enabled
+}
+
+// SetBrowserMode enables csrf handshake: first message must be "CSRF <csrfCookie value>",
+// other messages are rejected and ambient credentials are not forwarded until handshake completes.
+func (hf *HttpForwarder) SetBrowserMode(csrfCookie string) {
+	hf.csrfCookie = csrfCookie
+}
+
+// SetAllowMissingOrigin accepts upgrades without Origin header from non-browser clients, otherwise they are rejected
+// by handshake. It is enabled by App if allowed origins are checked by upgrade hook outside browser mode.
+func (hf *HttpForwarder) SetAllowMissingOrigin(allowed bool) {
+	hf.allowMissingOrigin = allowed
+}
+
+// SetCodec sets default codec for client frames.
+func (hf *HttpForwarder) SetCodec(c Codec) {
+	hf.codec = c
+}
+
+// SetMqttBridge enables MQTT-over-WebSocket sessions for clients with mqtt subprotocol:
+// PUBLISH to rpc/users/get is json-rpc call rpc.users.get, response is published to rpc/users/get/response.
+func (hf *HttpForwarder) SetMqttBridge(enabled bool) {
+	hf.mqttBridge = enabled
+}
+
+// SetStomp enables STOMP sessions for clients with v10.stomp, v11.stomp or v12.stomp subprotocols:
+// SEND to /rpc/users/get is json-rpc call rpc.users.get, response is sent to subscribers of reply-to
+// header or /rpc/users/get/response destination.
+func (hf *HttpForwarder) SetStomp(enabled bool) {
+	hf.stomp = enabled
+}
+
+// handshake requires valid Origin unless missing Origin is allowed and selects STOMP or ws2http.v<n> subprotocol from client offer, because clients
+// offer all supported versions. Single offered subprotocol, like codec name or mqtt, is accepted as is.
+func (hf *HttpForwarder) handshake(r *http.Request) (string, error) {
+	if origin := r.Header.Get("Origin"); origin == "" {
+		if !hf.allowMissingOrigin {
+			return "", errNullOrigin
+		}
+	} else if _, err := url.ParseRequestURI(origin); err != nil {
+		return "", err
+	}
+
+	offer := websocket.Subprotocols(r)
+	if p := selectStompProtocol(offer); hf.stomp && p != "" {
+		return p, nil
+	} else if p := selectVersionProtocol(offer); p != "" {
+		return p, nil
+	} else if len(offer) > 1 {
+		return "", errors.New("unsupported subprotocols")
+	} else if len(offer) == 1 {
+		return offer[0], nil
+	}
+
+	return "", nil
+}
+
+// WebsocketHandler returns http handler upgrading connections for Handler. Failed handshakes are rejected with 403.
+func (hf *HttpForwarder) WebsocketHandler() http.Handler {
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }} // checked by handshake
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protocol, err := hf.handshake(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		ws, err := upgrader.Upgrade(w, r, http.Header{"Sec-Websocket-Protocol": {protocol}})
+		if err != nil {
+			return // upgrader replied with http error
+		}
+
+		hf.Handler(ws, r)
+	})
+}
+
 // SetMultiMode handles incoming requests and routes it into dstUrl by "src" prefix in method.
 // For example:
 // 	src = /rpc; dstUrl = http://localhost/rpc-service
@@ -234,114 +792,476 @@ func (hf *HttpForwarder) SetMultiMode(rules []ProxyRule) {
 	}
 }
 
-// Handler is a handler function for handling connection from WS.
-func (hf *HttpForwarder) Handler(ws *websocket.Conn) {
+// routeState returns runtime state for srcUrl or nil if it is not found.
+func (hf *HttpForwarder) routeState(srcUrl string) *routeState {
+	if hf.route != nil {
+		return hf.route
+	}
+
+	return hf.routes[srcUrl]
+}
+
+// debugEnabled checks connection and request route for /debug/conns tracing.
+func (hf *HttpForwarder) debugEnabled(rf *requestForwarder, srcUrl string) bool {
+	if rf.rule.DisableDebug {
+		return false
+	}
+
+	rs := hf.routeState(srcUrl)
+	return rs == nil || !rs.rule.DisableDebug
+}
+
+// Handler is a handler function for handling connection from WS, r is upgrade request of conn.
+func (hf *HttpForwarder) Handler(conn *websocket.Conn, r *http.Request) {
 	// todo check input url
 
+	var (
+		ws  = &wsConn{conn: conn, req: r, instance: hf.instance}
+		mt  int                          // incoming WS message type
+		msg []byte                       // incoming WS message
+		err error                        // last error
+		rf  = hf.newRequestForwarder(ws) // forwarder per connection for handling custom headers, max parallel requests
+	)
+
+	// close frame is sent after queued frames are written
+	defer ws.Close()
+
+	// report goroutines left after disconnect
+	defer hf.checkLeaks(rf)
+
 	// count active conns for srcUrl
 	if hf.statActiveConns != nil {
-		hf.statActiveConns.WithLabelValues(ws.Request().URL.Path).Inc()
-		defer hf.statActiveConns.WithLabelValues(ws.Request().URL.Path).Dec()
+		hf.statActiveConns.WithLabelValues(rf.conn.Route).Inc()
+		defer hf.statActiveConns.WithLabelValues(rf.conn.Route).Dec()
 	}
 
 	// send debug events
-	debug.events <- debugMessage{msgType: clientConnected, req: ws.Request()}
-	defer func() { debug.events <- debugMessage{msgType: clientDisconnected, req: ws.Request()} }()
+	if !rf.rule.DisableDebug {
+		debug.publish(debugMessage{msgType: clientConnected, req: ws.Request(), session: rf.session})
+		defer func() { debug.publish(debugMessage{msgType: clientDisconnected, req: ws.Request()}) }()
+	}
+
+	// write all frames through send queue with write deadline, disconnect slow clients
+	rf.queue = newSendQueue(ws, hf.clock, hf.slowClientGrace, hf.writeTimeout, rf.goroutines, func() {
+		rf.Errorf("slow client disconnected grace=%s", hf.slowClientGrace)
+		proxyEvents.publish(rf.conn.event(eventSlowClient, nil))
+		if hf.statSlowClients != nil {
+			hf.statSlowClients.WithLabelValues(rf.conn.Route).Inc()
+		}
+	})
+	defer func() {
+		if err := rf.queue.flush(); err != nil && err != errSlowClient {
+			rf.Errorf("client disconnected on write error err=%s", err)
+		}
+	}()
+
+	// detect dead clients with ping frames
+	if hf.pingInterval > 0 {
+		stopPing := make(chan struct{})
+		defer close(stopPing)
+		ws.startPing(hf.pingInterval, hf.clock, rf.goroutines, stopPing)
+	}
+
+	// notify client before auth token expiry
+	if rf.token != nil {
+		rf.watchToken()
+		defer rf.token.stop()
+	}
 
+	// MQTT-over-WebSocket bridge and STOMP modes
 	var (
-		msg []byte                       // incoming WS message
-		err error                        // last error
-		rf  = hf.newRequestForwarder(ws) // forwarder per connection for handling custom headers, max parallel requests
+		mc *mqttConn
+		sc *stompConn
 	)
+	if hf.mqttBridge && isMqttConn(ws) {
+		mc = newMqttConn(rf)
+	} else if hf.stomp && isStompConn(ws) {
+		sc = newStompConn(rf)
+	}
+
+	if hf.sessions != nil {
+		hf.sessions.add(rf.session)
+		defer hf.sessions.remove(rf.session)
+	}
+
+	proxyEvents.publish(rf.conn.event(eventConnect, nil))
+	defer proxyEvents.publish(rf.conn.event(eventDisconnect, nil))
+
+	if mc != nil {
+		hf.mqttLoop(mc)
+		return
+	}
+	if sc != nil {
+		hf.stompLoop(sc)
+		return
+	}
 
 	for {
 		// read incoming messages
-		if err = websocket.Message.Receive(ws, &msg); err != nil {
+		if mt, msg, err = ws.Receive(); err != nil {
 			if err != io.EOF {
-				hf.Errorf("error while receiving data from client=%s err=%s data=%s", ws.Request().RemoteAddr, err, msg)
+				rf.Errorf("error while receiving data from client err=%s data=%s", err, msg)
 			}
 			break
 		}
 
-		hf.Tracef("type=request ip=%s data=%s custom_header=%+v", ws.Request().RemoteAddr, msg, rf.headers)
-		debug.events <- debugMessage{msgType: wsRequest, req: ws.Request(), data: msg}
+		// control messages are text frames, binary frames are always client requests
+		text := mt == websocket.TextMessage
 
-		// check for SET prefix and set headers if needed
-		if rf.checkAndSetHeaders(msg) {
+		// check csrf handshake in browser mode, close connection on failure
+		if ok, err := rf.checkCsrfHandshake(msg, text); err != nil {
+			rf.Errorf("csrf handshake failed")
+			if text && bytes.HasPrefix(msg, []byte("CSRF ")) {
+				rf.rejectControl(msg, err)
+			}
+			if msg, dErr := rf.decode(msg, text); dErr == nil {
+				if req, _ := rf.rewriteRequest(msg, hf.dstUrl); req.req.Id != nil {
+					rf.send(NewJsonRpcErr(req.req, JsonRpcCsrfHandshake, err).JSON())
+				}
+			}
+			break
+		} else if ok {
+			rf.ack(msg, nil)
 			continue
 		}
 
-		// check for multiple mode and rewrite message if needed
-		rpcReq, err := rf.rewriteRequest(msg, hf.dstUrl)
-		if err != nil {
-			hf.Errorf("error while rewriting msg from client=%s err=%s data=%s", ws.Request().RemoteAddr, err, msg)
-			if rpcReq.req.Id != nil {
-				websocket.Message.Send(ws, string(NewJsonRpcErr(rpcReq.req, JsonRpcMethodNotFound, err).JSON()))
-			}
+		// handle VERSION, SET and unknown control commands
+		if text && rf.handleControl(msg) {
 			continue
 		}
 
-		// perform http request to backend
-		rf.maxParallelRequest <- struct{}{}
-		go func(rpcReq rpcRequest, headers http.Header) {
-			var resp []byte
-			now := time.Now()
+		// decode client frame into json-rpc request
+		if msg, err = rf.decode(msg, text); err != nil {
+			rf.Errorf("error while decoding msg from client err=%s", err)
+			continue
+		}
 
-			// do post request
-			rc, err, rpcErr := hf.doPostRequest(rf.client, rpcReq.msg, rpcReq.dstUrl, headers)
-			duration := time.Since(now)
-			<-rf.maxParallelRequest
+		hf.handleRequest(rf, msg, rf.send)
+	}
+}
 
-			// save stat
-			hf.statRequest(rpcReq.srcUrl, rpcReq.req.Method, duration, err, rpcErr)
+// handleRequest rewrites json-rpc request msg, performs backend request in new goroutine and sends response with reply.
+func (hf *HttpForwarder) handleRequest(rf *requestForwarder, msg []byte, reply func(resp []byte) error) {
+	ws, received := rf.ws, hf.clock.Now()
+	rf.Tracef("type=request data=%s custom_header=%+v", msg, rf.header())
 
-			// process response
-			if rpcErr != nil {
-				// go
-			} else if err != nil {
-				if err != io.EOF {
-					hf.Errorf("not eof err=%v", err)
-				}
-				return
-			} else if resp, err = ioutil.ReadAll(rc); err != nil {
-				hf.Errorf("read err=%v", err)
-				rpcErr = NewJsonRpcErr(rpcReq.req, 200, err)
-			}
+	// cancel in-flight request
+	if rf.checkCancel(msg, reply) {
+		return
+	}
 
-			if rpcErr != nil {
-				resp = rpcErr.JSON()
-				hf.Errorf("rpc err=%v", rpcErr)
-			}
+	// answer system methods without backend
+	if hf.checkSystemMethod(rf, msg, reply) {
+		return
+	}
+
+	// check for multiple mode and rewrite message if needed
+	rpcReq, err := rf.rewriteRequest(msg, hf.dstUrl)
+	traced := hf.debugEnabled(rf, rpcReq.srcUrl)
+	if traced {
+		debug.publish(debugMessage{msgType: wsRequest, req: ws.Request(), data: msg})
+	}
 
-			// trace events
-			hf.Tracef("type=response ip=%s duration=%s data=%s", ws.Request().RemoteAddr, duration, resp)
-			debug.events <- debugMessage{msgType: httpResponse, req: ws.Request(), data: resp}
+	if err != nil {
+		rf.Errorf("error while rewriting msg from client err=%s data=%s", err, msg)
+		if rpcReq.req.Id != nil {
+			reply(NewJsonRpcErr(rpcReq.req, JsonRpcMethodNotFound, err).JSON())
+		}
+		return
+	}
 
-			// send response
-			if err = websocket.Message.Send(ws, string(resp)); err != nil {
-				hf.Errorf("can't send data to client=%s lastErr=%s", ws.RemoteAddr().String(), err)
+	// send responses and errors of ordered requests in request order
+	rpcReq.features = hf.requestFeatures(rf)
+	skip, spawned := func() {}, false
+	if rpcReq.features.has(FeatureOrdered) && rpcReq.req.Id != nil {
+		reply, skip = rf.ordered.slot(reply)
+		defer func() {
+			if !spawned {
+				skip()
 			}
+		}()
+	}
+
+	// reject requests over route body size limit
+	if err = hf.checkBodySize(rpcReq); err != nil {
+		rf.Errorf("request body size limit reached size=%d route=%s", len(rpcReq.msg), rpcReq.srcUrl)
+		if rpcReq.req.Id != nil {
+			reply(NewJsonRpcErr(rpcReq.req, JsonRpcInvalidRequest, err).JSON())
+		}
+		return
+	}
+
+	// reject requests to routes under maintenance without touching backend
+	if err = hf.routeState(rpcReq.srcUrl).maintenanceErr(); err != nil {
+		rf.Tracef("type=maintenance dst_route=%s data=%s", rpcReq.srcUrl, msg)
+		if rpcReq.req.Id != nil {
+			reply(NewJsonRpcErr(rpcReq.req, JsonRpcMaintenance, err).JSON())
+		}
+		return
+	}
+
+	// reject requests while in-flight requests are drained on shutdown
+	if err = hf.shutdown.err(); err != nil {
+		if rpcReq.req.Id != nil {
+			reply(NewJsonRpcErr(rpcReq.req, JsonRpcMaintenance, err).JSON())
+		}
+		return
+	}
+
+	// reject requests with expired auth token, client must send AUTH with new token
+	if err = rf.token.err(hf.clock.Now()); err != nil {
+		rf.Tracef("type=token_expired data=%s", msg)
+		if rpcReq.req.Id != nil {
+			reply(NewJsonRpcErr(rpcReq.req, JsonRpcTokenExpired, err).JSON())
+		}
+		return
+	}
+
+	// reject requests over connection or route rate limit instead of queueing them
+	if err = hf.checkRateLimit(rf, rpcReq); err != nil {
+		rf.Tracef("type=rate_limited dst_route=%s data=%s", rpcReq.srcUrl, msg)
+		if rpcReq.req.Id != nil {
+			reply(rateLimitedErr(rpcReq.req, err).JSON())
+		}
+		return
+	}
 
+	// reject request if client has too many outstanding requests
+	if !rf.acquireClientSlot() {
+		rf.Errorf("client request limit reached limit=%d", hf.maxClientRequests)
+		if rpcReq.req.Id != nil {
+			reply(NewJsonRpcErr(rpcReq.req, JsonRpcClientRequestLimit, errClientRequestLimit).JSON())
+		}
+		return
+	}
+
+	// perform http request to backend
+	var release func()
+	rpcReq.ctx, release = rf.requests.add(rf.ctx, rpcReq.req.Id)
+	rpcReq.ctx = withReceived(rpcReq.ctx, received)
+	inflight := hf.shutdown.start()
+	rf.correlation.request(rpcReq.req.Id)
+	rf.maxParallelRequest <- struct{}{}
+	headers := rf.header()
+	spawned = true
+	rf.goroutines.spawn("request", func() {
+		defer skip()
+		defer rf.releaseClientSlot()
+		defer release()
+		defer inflight()
+
+		now := time.Now()
+		resp := hf.forward(rf, rpcReq, headers)
+		hf.correlate(rf, rpcReq, resp)
+		if resp == nil {
 			return
-		}(rpcReq, rf.copyHeaders())
+		}
+
+		// notifications expect no response, backend response is swallowed
+		if rpcReq.req.Id == nil {
+			rf.Tracef("type=notification_response duration=%s data=%s", time.Since(now), resp)
+			return
+		}
+
+		// trace events
+		rf.Tracef("type=response duration=%s data=%s", time.Since(now), resp)
+		if traced {
+			debug.publish(debugMessage{msgType: httpResponse, req: ws.Request(), data: resp})
+		}
+
+		// send response
+		if err := reply(resp); err != nil {
+			rf.Errorf("can't send data to client lastErr=%s", err)
+		}
+	})
+}
+
+// forward performs request to route backend and returns response for client or nil if response must not be sent.
+// Releases rf.maxParallelRequest slot after backend request. Headers are session headers snapshot.
+func (hf *HttpForwarder) forward(rf *requestForwarder, rpcReq rpcRequest, headers http.Header) []byte {
+	ws := rf.ws
+
+	// check route forward auth for request
+	if rs := hf.routeState(rpcReq.srcUrl); rs != nil && rs.rule.AuthUrl != "" && (rs.rule.AuthPerRequest || len(hf.multipleRules) > 0) {
+		headers = copyHeaders(headers) // auth headers are added to copy
+		if err := hf.authorize(rs.rule, ws.Request().URL.RequestURI(), rf.clientHeader(), headers); err != nil {
+			<-rf.maxParallelRequest
+			rf.Errorf("request auth failed method=%s err=%s", rpcReq.req.Method, err)
+			if rpcReq.req.Id == nil {
+				return nil
+			}
+			return NewJsonRpcErr(rpcReq.req, JsonRpcAuthFailed, errAuthFailed).JSON()
+		}
+	}
+
+	// enabled features for backend behaviors, like streaming
+	if len(rpcReq.features) > 0 {
+		headers = copyHeaders(headers)
+		headers.Set(featuresHeader, strings.Join(rpcReq.features, ","))
+	}
+
+	// do backend request
+	breq := BackendRequest{
+		Request: rpcReq.req,
+		Msg:     rpcReq.msg,
+		Route:   rpcReq.srcUrl,
+		DstUrl:  rpcReq.dstUrl,
+		Header:  headers,
+	}
+	if rs := hf.routeState(rpcReq.srcUrl); rs != nil && rs.rule.GreenUrl != "" {
+		var release func()
+		breq.DstUrl, release = rs.acquire()
+		defer release()
+	}
+	version := hf.canary(rf, &breq)
+
+	// answer cacheable methods from response cache of final destination and backend headers
+	key, cached := hf.cache.lookup(breq.DstUrl, breq.Request, breq.Header)
+	if cached != nil {
+		<-rf.maxParallelRequest
+		rf.Tracef("type=cache_hit method=%s", rpcReq.req.Method)
+		return cached
+	}
+
+	// reject requests over traffic share of recovering destination
+	if err := hf.routeState(rpcReq.srcUrl).warmUpErr(breq.DstUrl); err != nil {
+		<-rf.maxParallelRequest
+		rf.Tracef("type=warming_up dst_url=%s method=%s", breq.DstUrl, rpcReq.req.Method)
+		if rpcReq.req.Id == nil {
+			return nil
+		}
+		return NewJsonRpcErr(rpcReq.req, JsonRpcWarmingUp, err).JSON()
+	}
+	hf.mirror(rf, breq)
+
+	// client timeout is bounded by server timeout
+	ctx, timeout := rf.ctx, time.Duration(hf.timeout)*time.Second
+	if rpcReq.ctx != nil {
+		ctx = rpcReq.ctx
+	}
+	if rpcReq.timeout > 0 && (timeout == 0 || rpcReq.timeout < timeout) {
+		timeout = rpcReq.timeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// delay method held by backend Retry-After, then wait for destination slot shared by all connections
+	// in round-robin order of connections
+	var (
+		br      BackendResponse
+		release func()
+		rs      = hf.routeState(rpcReq.srcUrl)
+	)
+	err := hf.waitRetryAfter(ctx, rs, rpcReq.req.Method)
+	if err == nil {
+		release, err = hf.slots.acquire(ctx, breq.DstUrl, rf)
+	}
+	now := time.Now()
+	finish := func(string, time.Duration) {}
+	if err == nil {
+		finish = destinations.start(breq.DstUrl, hf.clock)
+		br, err = hf.backend(rf, rpcReq.srcUrl).Do(ctx, breq)
+		release()
+	}
+	duration := time.Since(now)
+	<-rf.maxParallelRequest
+
+	var rpcErr *JsonRpcErrResponse
+	if err != nil && rpcReq.ctx != nil && rpcReq.ctx.Err() == context.Canceled {
+		err = errCancelled
+		rpcErr = NewJsonRpcErr(rpcReq.req, JsonRpcCancelled, err)
+	} else if be, ok := err.(*BackendError); ok {
+		rpcErr = NewJsonRpcErr(rpcReq.req, be.Code, be.Err)
+		if hf.retryAfterHold > 0 {
+			rs.holdMethod(rpcReq.req.Method, be.Code, minDuration(be.RetryAfter, hf.retryAfterHold))
+		}
+	} else if err != nil {
+		code, clientErr := backendErrorCode(err)
+		rpcErr = NewJsonRpcErr(rpcReq.req, code, clientErr)
+	}
+
+	// save stat
+	notification := rpcReq.req.Id == nil && hf.routeState(rpcReq.srcUrl).countNotifications()
+	hf.statRequest(rpcReq.srcUrl, rpcReq.req.Method, notification, duration, err, rpcErr)
+	status, _ := requestStatus(err, rpcErr)
+	finish(status, duration)
+	rs.observeDestination(breq.DstUrl, status)
+	hf.statVersion(rpcReq.srcUrl, version, err, rpcErr)
+
+	if rpcErr != nil {
+		requestId := rf.nextRequestId()
+		data := hf.errData(requestId, breq.DstUrl, br.StatusCode, err)
+		if rpcReq.features.has(FeatureVerboseErrors) {
+			data.verbose(breq.DstUrl, err)
+		}
+		rpcErr.Error.Data = data
+		rf.Errorf("rpc err=%v url=%s method=%s request_id=%s", err, breq.DstUrl, rpcReq.req.Method, requestId)
+		return rpcErr.JSON()
 	}
+	hf.cache.save(key, rpcReq.req.Method, br.Body)
+
+	return br.Body
 }
 
-// statRequest logs requests durations.
-func (hf *HttpForwarder) statRequest(srcUrl, method string, duration time.Duration, err error, rpcErr *JsonRpcErrResponse) {
-	if hf.statBackendDurations == nil && hf.statBackendRequests == nil {
+// requestStatus returns backend request status (ok, timeout, dns_error, connection_refused, tls_error, error,
+// cancelled) and code for metrics.
+func requestStatus(err error, rpcErr *JsonRpcErrResponse) (status, code string) {
+	status, code = "ok", "200"
+	if rpcErr != nil {
+		status, code = "error", strconv.Itoa(rpcErr.Error.Code)
+	}
+
+	if err == errCancelled {
+		status = "cancelled"
+	} else if s := netErrorStatus(err); s != "" {
+		status = s
+	}
+
+	return status, code
+}
+
+// statVersion counts requests by destination version for canary routes.
+func (hf *HttpForwarder) statVersion(srcUrl, version string, err error, rpcErr *JsonRpcErrResponse) {
+	if hf.statBackendVersions == nil {
+		return
+	}
+	if rs := hf.routeState(srcUrl); rs == nil || rs.rule.CanaryUrl == "" {
 		return
 	}
 
-	status, httpCode := "ok", "200"
-	if rpcErr != nil {
-		status, httpCode = "error", strconv.Itoa(rpcErr.Error.Code)
+	status, _ := requestStatus(err, rpcErr)
+	hf.statBackendVersions.WithLabelValues(srcUrl, version, status).Inc()
+}
+
+// checkBodySize observes forwarded request size and checks route MaxBodySize.
+func (hf *HttpForwarder) checkBodySize(rpcReq rpcRequest) error {
+	if hf.statBodySizes != nil {
+		hf.statBodySizes.WithLabelValues(rpcReq.srcUrl).Observe(float64(len(rpcReq.msg)))
 	}
 
-	if err != nil {
-		if t, ok := err.(errTimeout); ok && t.Timeout() {
-			status = "timeout"
-		}
+	if rs := hf.routeState(rpcReq.srcUrl); rs != nil && rs.rule.MaxBodySize > 0 && len(rpcReq.msg) > rs.rule.MaxBodySize {
+		return errBodyTooLarge
+	}
+
+	return nil
+}
+
+// statRequest logs requests durations.
+func (hf *HttpForwarder) statRequest(srcUrl, method string, notification bool, duration time.Duration, err error, rpcErr *JsonRpcErrResponse) {
+	status, httpCode := requestStatus(err, rpcErr)
+	if status != "cancelled" { // client cancellations are not backend failures
+		hf.routeState(srcUrl).observe(status)
+		stats.observe(srcUrl, status != "ok", duration, hf.clock.Now())
+		hf.slos.observe(srcUrl, method, duration)
+	}
+	if notification && hf.statNotifications != nil {
+		hf.statNotifications.WithLabelValues(srcUrl, method, status).Inc()
+		return
+	} else if hf.statBackendDurations == nil && hf.statBackendRequests == nil {
+		return
 	}
 
 	hf.statBackendRequests.WithLabelValues(srcUrl, method, status).Inc()
@@ -349,34 +1269,25 @@ func (hf *HttpForwarder) statRequest(srcUrl, method string, duration time.Durati
 }
 
 // doPostRequest sends http post request to json-rpc 2.0 endpoint.
-func (hf *HttpForwarder) doPostRequest(client *http.Client, postData []byte, dstUrl string, headers http.Header) (rc io.ReadCloser, err error, rpcErr *JsonRpcErrResponse) {
-	var httpCode int
+func (hf *HttpForwarder) doPostRequest(ctx context.Context, client *http.Client, postData []byte, dstUrl string, headers http.Header) (*http.Response, error) {
 	req, err := http.NewRequest("POST", dstUrl, bytes.NewBuffer(postData))
-	defer func() {
-		if err == nil && httpCode == http.StatusOK {
-			return
-		}
-
-		rpcErr = NewJsonRpcErrResponse(postData, httpCode, err)
-		return
-	}()
-
 	if err != nil {
 		hf.Errorf("http new request err=%s", err)
-		return
+		return nil, err
 	}
 
+	req = req.WithContext(ctx)
 	req.Header = headers
-	req.Header.Add("Content-Type", "application/json")
+	setForwardedHeaders(ctx, req.Header)
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
 		hf.Errorf("client.Do() request failed url=%s err=%s data=%s", dstUrl, err, postData)
-		return
+		return nil, err
 	}
 
-	httpCode = resp.StatusCode
-	rc = resp.Body
-
-	return
+	return resp, nil
 }
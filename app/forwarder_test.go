@@ -1,8 +1,19 @@
 package app
 
 import (
-	"golang.org/x/net/websocket"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
 )
 
 func TestRequestForwarderRewrite(t *testing.T) {
@@ -36,16 +47,47 @@ func TestRequestForwarderRewrite(t *testing.T) {
 			out: []byte(`{}`),
 			src: "/", m: "", err: errMethodFormat,
 		},
+		{
+			in:  []byte(`{"jsonrpc":"2.0","method":"alias.getUser","params":[42],"id":1}`),
+			out: []byte(`{"jsonrpc":"2.0","id":1,"method":"users.get","params":[42]}`),
+			src: "/alias", m: "users.get", dst: "http://alias",
+		},
+		{
+			in:  []byte(`{"jsonrpc":"2.0","method":"alias.GETUSER","params":[42],"id":1}`),
+			out: []byte(`{"jsonrpc":"2.0","id":1,"method":"users.get","params":[42]}`),
+			src: "/alias", m: "users.get", dst: "http://alias",
+		},
+		{
+			in:  []byte(`{"jsonrpc":"2.0","method":"alias.Users.Find","params":[42],"id":1}`),
+			out: []byte(`{"jsonrpc":"2.0","id":1,"method":"users.find","params":[42]}`),
+			src: "/alias", m: "users.find", dst: "http://alias",
+		},
+		{
+			in:  []byte(`{"jsonrpc":"2.0","method":"rpc.test.subtract","id":9007199254740993}`),
+			out: []byte(`{"jsonrpc":"2.0","id":9007199254740993,"method":"test.subtract"}`),
+			src: "/rpc", m: "test.subtract", dst: "http://rpc",
+		},
+		{
+			in:  []byte(`{"jsonrpc":"2.0","method":"rpc.test.subtract","id":"0001"}`),
+			out: []byte(`{"jsonrpc":"2.0","id":"0001","method":"test.subtract"}`),
+			src: "/rpc", m: "test.subtract", dst: "http://rpc",
+		},
+		{
+			in:  []byte(`{"jsonrpc":"2.0","method":"rpc.test.subtract","params":[42,23],"id":1,"meta":{"a":1},"trace":"abc"}`),
+			out: []byte(`{"jsonrpc":"2.0","id":1,"method":"test.subtract","params":[42,23],"meta":{"a":1},"trace":"abc"}`),
+			src: "/rpc", m: "test.subtract", dst: "http://rpc",
+		},
 	}
 
 	hf := NewHttpForwarder("/", nil, 0, 0)
 	hf.SetMultiMode(
 		[]ProxyRule{
-			{"/rpc", "http://rpc"},
-			{"/test", "http://test"},
+			{Src: "/rpc", DstUrl: "http://rpc"},
+			{Src: "/test", DstUrl: "http://test"},
+			{Src: "/alias", DstUrl: "http://alias", MethodCase: MethodCaseLower, MethodAliases: map[string]string{"getUser": "users.get"}},
 		},
 	)
-	rf := hf.newRequestForwarder(&websocket.Conn{})
+	rf := hf.newRequestForwarder(&wsConn{})
 
 	for _, c := range tc {
 		rpcReq, err := rf.rewriteRequest(c.in, hf.dstUrl)
@@ -86,10 +128,15 @@ func TestRequestForwarderNoRewrite(t *testing.T) {
 			out: []byte(`{}`),
 			src: "/", m: "",
 		},
+		{
+			in:  []byte(`{"jsonrpc":"2.0","method":"subtract","params":[42,23],"id":1,"_timeout":500}`),
+			out: []byte(`{"jsonrpc":"2.0","id":1,"method":"subtract","params":[42,23]}`),
+			src: "/", m: "subtract",
+		},
 	}
 
 	hf := NewHttpForwarder("/", nil, 0, 0)
-	rf := hf.newRequestForwarder(&websocket.Conn{})
+	rf := hf.newRequestForwarder(&wsConn{})
 
 	for _, c := range tc {
 		rpcReq, err := rf.rewriteRequest(c.in, hf.dstUrl)
@@ -98,3 +145,448 @@ func TestRequestForwarderNoRewrite(t *testing.T) {
 		}
 	}
 }
+
+func TestRequestTimeout(t *testing.T) {
+	timeout := func(msg string) (time.Duration, bool) {
+		_, members, _ := parseRequestMembers([]byte(msg))
+		return requestTimeout(members)
+	}
+
+	if d, ok := timeout(`{"method":"a","_timeout":1500}`); !ok || d != 1500*time.Millisecond {
+		t.Errorf("got %v, %v", d, ok)
+	}
+
+	if d, ok := timeout(`{"method":"a","params":{"_timeout":1}}`); ok {
+		t.Errorf("nested: got %v, %v", d, ok)
+	}
+
+	if d, ok := timeout(`{"method":"a","_timeout":null}`); ok {
+		t.Errorf("null: got %v, %v", d, ok)
+	}
+
+	// escaped key is decoded by encoding/json
+	if d, ok := timeout(`{"method":"a","\u005ftimeout":0}`); !ok || d != 0 {
+		t.Errorf("escaped: got %v, %v", d, ok)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":true}`))
+	}))
+	defer srv.Close()
+
+	// client timeout is shorter than server timeout
+	hf := NewHttpForwarder(srv.URL, nil, 10, 1)
+	rf := hf.newRequestForwarder(&wsConn{})
+	rpcReq, _ := rf.rewriteRequest([]byte(`{"jsonrpc":"2.0","method":"a","id":1,"_timeout":20}`), srv.URL)
+	rf.maxParallelRequest <- struct{}{}
+	if resp := hf.forward(rf, rpcReq, http.Header{}); !bytes.Contains(resp, []byte(`"error"`)) {
+		t.Errorf("got %s", resp)
+	}
+}
+
+func TestRequestForwarderClientSlots(t *testing.T) {
+	hf := NewHttpForwarder("/", nil, 0, 0)
+	hf.SetMaxClientRequests(2)
+	rf := hf.newRequestForwarder(&wsConn{})
+
+	if !rf.acquireClientSlot() || !rf.acquireClientSlot() {
+		t.Fatal("acquireClientSlot(): expected free slots")
+	}
+
+	if rf.acquireClientSlot() {
+		t.Error("acquireClientSlot(): expected limit")
+	}
+
+	rf.releaseClientSlot()
+	if !rf.acquireClientSlot() {
+		t.Error("acquireClientSlot(): expected free slot after release")
+	}
+}
+
+func TestHttpForwarderRenderRequest(t *testing.T) {
+	routes, err := newRouteStates([]ProxyRule{
+		{Src: "/rpc", DstUrl: "http://rpc", RequestTemplate: `{"auth":{"user":{{json (.Header.Get "X-User")}}},"id":{{.Id}},"payload":{{.Request}}}`},
+	}, clock.Real)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hf := NewHttpForwarder("/", nil, 0, 0)
+	hf.routes = routes
+	rf := hf.newRequestForwarder(&wsConn{})
+
+	rpcReq, _ := rf.rewriteRequest([]byte(`{"jsonrpc":"2.0","method":"test","id":1}`), hf.dstUrl)
+	body, err := hf.renderRequest(BackendRequest{Request: rpcReq.req, Msg: rpcReq.msg, Route: "/rpc", Header: http.Header{"X-User": []string{"admin"}}})
+	expected := `{"auth":{"user":"admin"},"id":1,"payload":{"jsonrpc":"2.0","method":"test","id":1}}`
+	if err != nil || string(body) != expected {
+		t.Errorf("renderRequest(): got = %s, %v; expected = %s", body, err, expected)
+	}
+}
+
+func TestRequestForwarderSetHeader(t *testing.T) {
+	hf := NewHttpForwarder("/", []string{"X-Token"}, 0, 0)
+	rf := hf.newRequestForwarder(&wsConn{})
+
+	before := rf.header()
+	if ok, err := rf.checkAndSetHeaders([]byte("SET X-Token abc")); !ok || err != nil {
+		t.Fatalf("checkAndSetHeaders(): got = %v, %v", ok, err)
+	}
+
+	if v := rf.header().Get("X-Token"); v != "abc" {
+		t.Errorf("header(): got = %v; expected = abc", v)
+	}
+
+	if before.Get("X-Token") != "" {
+		t.Error("header(): previous snapshot was modified")
+	}
+}
+
+func BenchmarkRequestForwarderHeaders(b *testing.B) {
+	hf := NewHttpForwarder("/", []string{"Authorization", "X-Token"}, 0, 0)
+	rf := hf.newRequestForwarder(&wsConn{})
+	rf.setHeader("Authorization", "Bearer token")
+	rf.setHeader("X-Token", "abc")
+
+	// snapshot: atomic pointer read per message
+	b.Run("snapshot", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				_ = rf.header()
+			}
+		})
+	})
+
+	// copy: previous copy of headers under read lock per message
+	b.Run("copy", func(b *testing.B) {
+		var lock sync.RWMutex
+		h := rf.header()
+
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				lock.RLock()
+				_ = copyHeaders(h)
+				lock.RUnlock()
+			}
+		})
+	})
+}
+
+func TestLoggerWithFields(t *testing.T) {
+	var l logger
+	l = l.withFields(ConnInfo{SessionId: "1", Route: "/rpc", Principal: "admin"}.logFields()...)
+	if expected := " session=1 route=/rpc principal=admin"; l.fields != expected {
+		t.Errorf("withFields(): got = %q; expected = %q", l.fields, expected)
+	}
+}
+
+func TestHttpForwarderDebugEnabled(t *testing.T) {
+	routes, err := newRouteStates([]ProxyRule{{Src: "/rpc", DstUrl: "http://rpc"}, {Src: "/pay", DstUrl: "http://pay", DisableDebug: true}}, clock.Real)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hf := NewHttpForwarder("*", nil, 0, 0)
+	hf.routes = routes
+	rf := hf.newRequestForwarder(&wsConn{})
+
+	if !hf.debugEnabled(rf, "/rpc") || hf.debugEnabled(rf, "/pay") {
+		t.Errorf("debugEnabled(): got = %v, %v; expected = true, false", hf.debugEnabled(rf, "/rpc"), hf.debugEnabled(rf, "/pay"))
+	}
+}
+
+func TestDebugFilter(t *testing.T) {
+	s := newSession("/rpc", nil)
+	s.addHeaderTags([]string{"x-roles"}, http.Header{"X-Roles": {"admin, vip"}})
+	req := &http.Request{RemoteAddr: "10.0.0.1:5000", Header: http.Header{"User-Agent": {"Mozilla/5.0"}}}
+	c := debugConn{req: req, session: s}
+
+	if tags := s.tagList(); len(tags) != 2 || tags[0] != "admin" || tags[1] != "vip" {
+		t.Errorf("tags: got %v", tags)
+	}
+
+	for _, f := range []debugFilter{{}, {Tag: "vip"}, {IP: "10.0.0.1"}, {Route: "/rpc"}, {UserAgent: "mozilla"}} {
+		if !f.match(c) {
+			t.Errorf("%+v: not matched", f)
+		}
+	}
+
+	for _, f := range []debugFilter{{Tag: "user"}, {IP: "10.0.0.2"}, {Route: "/"}, {UserAgent: "curl"}} {
+		if f.match(c) {
+			t.Errorf("%+v: matched", f)
+		}
+	}
+}
+
+func TestDebugIndex(t *testing.T) {
+	for i, addr := range []string{"10.0.0.1:1", "10.0.0.2:1", "10.0.0.3:1"} {
+		req := &http.Request{RemoteAddr: addr, Header: http.Header{}}
+		debug.publish(debugMessage{msgType: clientConnected, req: req})
+		for j := 0; j <= i; j++ {
+			debug.publish(debugMessage{msgType: httpResponse, req: req, data: []byte(`{"id":1,"error":{"code":-32000}}`)})
+		}
+		defer func() { debug.publish(debugMessage{msgType: clientDisconnected, req: req}) }()
+	}
+
+	// wait for events, ops are not ordered with events
+	for errors := 0; errors != 6; {
+		done := make(chan int)
+		debug.do(func(m clientConns) {
+			n := 0
+			for _, c := range m {
+				n += c.errors
+			}
+			done <- n
+		})
+		errors = <-done
+	}
+
+	w := httptest.NewRecorder()
+	debug.index(w, httptest.NewRequest("GET", "/debug/conns/?ip=10.0.0.&sort=errors&limit=2&page=1&cols=errors", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, "10.0.0.3:1") || !strings.Contains(body, "10.0.0.2:1") || strings.Contains(body, "10.0.0.1:1") {
+		t.Errorf("first page: got %s", body)
+	}
+	if !strings.Contains(body, "page=2") || strings.Contains(body, "<th>route</th>") {
+		t.Errorf("next page and columns: got %s", body)
+	}
+
+	if isErrorResponse([]byte(`{"id":1,"result":null,"error":null}`)) {
+		t.Error("null error is error response")
+	}
+}
+
+func TestDebugStats(t *testing.T) {
+	s := &debugStats{routes: make(map[string]*routeStats)}
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		s.observe("/rpc", i == 0, 100*time.Millisecond, now.Add(-statsBucketWidth))
+	}
+	s.observe("/rpc", false, time.Second, now.Add(-(statsBuckets+2)*statsBucketWidth)) // outside of window, same ring slot as now - 2 buckets
+
+	list := s.series(now)
+	if len(list) != 1 || list[0].Route != "/rpc" {
+		t.Fatalf("got %+v", list)
+	}
+
+	sr := list[0]
+	if rate, errRate, latency := lastValue(sr.Rates), lastValue(sr.ErrorRates), lastValue(sr.Latencies); rate != 0.4 || errRate != 25 || latency != 100 {
+		t.Errorf("got rate = %v, error rate = %v, latency = %v", rate, errRate, latency)
+	}
+	if sr.Rates[statsBuckets-2] != 0 {
+		t.Errorf("expired bucket: got %v", sr.Rates[statsBuckets-2])
+	}
+}
+
+func TestDebugPublishDrop(t *testing.T) {
+	d := &debugApp{}
+	d.once.Do(func() { d.events = make(chan debugMessage, 1) }) // loop is not started
+
+	d.publish(debugMessage{msgType: wsRequest})
+	d.publish(debugMessage{msgType: httpResponse})
+	if d.droppedEvents != 1 || len(d.events) != 1 {
+		t.Errorf("got dropped = %d, buffered = %d", d.droppedEvents, len(d.events))
+	}
+}
+
+func TestDebugTemplatesOverride(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ws2http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "static"), 0700)
+	ioutil.WriteFile(filepath.Join(dir, "stats.html"), []byte(`custom {{len .List}}`), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "static", "logo.svg"), []byte(`<svg/>`), 0600)
+
+	d := &debugApp{}
+	if err := d.loadTemplates(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	d.stats(w, httptest.NewRequest("GET", "/debug/stats", nil))
+	if !strings.HasPrefix(w.Body.String(), "custom") {
+		t.Errorf("stats: got %s", w.Body)
+	}
+
+	// overridden and embedded assets
+	for name, want := range map[string]string{"/logo.svg": "<svg/>", "/trace.js": "function highlight"} {
+		w = httptest.NewRecorder()
+		http.FileServer(d).ServeHTTP(w, httptest.NewRequest("GET", name, nil))
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("%s: got %d %s", name, w.Code, w.Body)
+		}
+	}
+}
+
+func TestDebugInjection(t *testing.T) {
+	const payload = `"><script>alert(1)</script>`
+
+	req := &http.Request{RemoteAddr: payload, Header: http.Header{"User-Agent": {payload}}}
+	s := newSession("/rpc", nil)
+	s.addTag(payload)
+	debug.publish(debugMessage{msgType: clientConnected, req: req, session: s})
+	defer debug.publish(debugMessage{msgType: clientDisconnected, req: req})
+
+	// wait for session in index
+	for connected := false; !connected; {
+		done := make(chan bool)
+		debug.do(func(m clientConns) {
+			_, ok := m[payload]
+			done <- ok
+		})
+		connected = <-done
+	}
+
+	for _, u := range []string{"/debug/conns/", "/debug/conns/trace?addr=" + url.QueryEscape(payload), "/debug/stats"} {
+		w := httptest.NewRecorder()
+		debug.handler().ServeHTTP(w, httptest.NewRequest("GET", u, nil))
+
+		if strings.Contains(w.Body.String(), "<script>alert") {
+			t.Errorf("%s: not escaped: %s", u, w.Body)
+		}
+		if csp := w.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "script-src 'self'") {
+			t.Errorf("%s: got csp %q", u, csp)
+		}
+		if w.Header().Get("X-Frame-Options") != "DENY" || w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s: got headers %v", u, w.Header())
+		}
+	}
+}
+
+func TestDebugBasePath(t *testing.T) {
+	d := &debugApp{basePath: "/ops"}
+	if err := d.loadTemplates(""); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	d.render(w, "trace.html", struct {
+		Addr      string
+		Connected bool
+	}{Addr: "127.0.0.1:5000", Connected: true})
+
+	for _, want := range []string{`href="/ops/debug/static/debug.css"`, `data-base="/ops"`, `src="/ops/debug/static/trace.js"`} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("%s not found in %s", want, w.Body)
+		}
+	}
+}
+
+func TestRequestForwarderLocaleHeaders(t *testing.T) {
+	hf := NewHttpForwarder("/", nil, 0, 0)
+	hf.SetLocaleHeaders([]string{"accept-language", "X-Timezone"})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header = http.Header{"Accept-Language": {"de-DE"}, "X-Timezone": {"Europe/Berlin"}, "X-Other": {"1"}}
+
+	h := hf.newRequestForwarder(&wsConn{req: r}).header()
+	if h.Get("Accept-Language") != "de-DE" || h.Get("X-Timezone") != "Europe/Berlin" || h.Get("X-Other") != "" {
+		t.Errorf("got %v", h)
+	}
+}
+
+func TestHopHeaders(t *testing.T) {
+	hf := NewHttpForwarder("/", []string{"X-Token", "Connection", "Transfer-Encoding", "upgrade"}, 0, 0)
+	rf := hf.newRequestForwarder(&wsConn{})
+
+	for _, msg := range []string{"SET Connection close", "SET Transfer-Encoding chunked", "SET upgrade h2c"} {
+		if ok, err := rf.checkAndSetHeaders([]byte(msg)); !ok || err != errHeaderNotAllowed {
+			t.Errorf("%s: got = %v, %v", msg, ok, err)
+		}
+	}
+
+	h := http.Header{"Connection": {"close, X-Private"}, "X-Private": {"1"}, "Te": {"trailers"}, "X-Token": {"abc"}}
+	removeHopHeaders(h)
+	if len(h) != 1 || h.Get("X-Token") != "abc" {
+		t.Errorf("removeHopHeaders(): got %v", h)
+	}
+}
+
+func TestRequestForwarderHeaderLimits(t *testing.T) {
+	hf := NewHttpForwarder("/", []string{"A", "B", "C"}, 0, 0)
+	hf.SetHeaderLimits(2, 10)
+	rf := hf.newRequestForwarder(&wsConn{})
+
+	for _, c := range []struct {
+		msg string
+		err error
+	}{
+		{"SET A 1", nil},
+		{"SET B 2", nil},
+		{"SET C 3", errHeaderLimit},        // count
+		{"SET B 23456789", errHeaderLimit}, // size
+		{"SET B 3", nil},                   // replace
+	} {
+		if ok, err := rf.checkAndSetHeaders([]byte(c.msg)); !ok || err != c.err {
+			t.Errorf("%s: got = %v, %v; expected = %v", c.msg, ok, err, c.err)
+		}
+	}
+
+	if h := rf.header(); len(h) != 2 || h.Get("B") != "3" {
+		t.Errorf("header(): got %v", h)
+	}
+}
+
+func TestHttpForwarderCheckBodySize(t *testing.T) {
+	hf := NewHttpForwarder("/", nil, 0, 0)
+	hf.route = &routeState{rule: ProxyRule{Src: "/", MaxBodySize: 16}, clock: clock.Real}
+
+	if err := hf.checkBodySize(rpcRequest{srcUrl: "/", msg: []byte(`{"method":"a"}`)}); err != nil {
+		t.Errorf("got %v", err)
+	}
+
+	if err := hf.checkBodySize(rpcRequest{srcUrl: "/", msg: []byte(`{"method":"a","params":[1]}`)}); err != errBodyTooLarge {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestRequestForwarderCookieJar(t *testing.T) {
+	var (
+		lock    sync.Mutex
+		cookies []string
+	)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		cookies = append(cookies, r.Header.Get("Cookie"))
+		lock.Unlock()
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "1"})
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":true}`))
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		name string
+		jar  bool
+		want []string // cookies of two requests of first connection and request of second connection
+	}{
+		{"disabled", false, []string{"", "", ""}},
+		{"enabled", true, []string{"", "sid=1", ""}},
+	}
+
+	for _, tt := range tests {
+		cookies = nil
+		hf := NewHttpForwarder(backend.URL, nil, 10, 1)
+		hf.SetCookieJar(tt.jar)
+		forward := func(rf *requestForwarder) {
+			rpcReq, _ := rf.rewriteRequest([]byte(`{"jsonrpc":"2.0","method":"get","id":1}`), backend.URL)
+			rf.maxParallelRequest <- struct{}{}
+			hf.forward(rf, rpcReq, http.Header{})
+		}
+
+		rf := hf.newRequestForwarder(&wsConn{})
+		forward(rf)
+		forward(rf)
+		forward(hf.newRequestForwarder(&wsConn{}))
+
+		lock.Lock()
+		if strings.Join(cookies, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got %q", tt.name, cookies)
+		}
+		lock.Unlock()
+	}
+}
@@ -0,0 +1,272 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/semrush/ws2http/clock"
+)
+
+const (
+	healthStatusOk          = "ok"
+	healthStatusUnavailable = "unavailable"
+)
+
+// backendHealth is a result of last active health probe of backend destination.
+type backendHealth struct {
+	Url       string     `json:"url"`
+	Routes    []string   `json:"routes"`
+	Healthy   bool       `json:"healthy"`
+	Status    string     `json:"status"` // ok, http_<code>, timeout, dns_error, connection_refused, tls_error, error or unknown
+	CheckedAt *time.Time `json:"checkedAt,omitempty"`
+	Latency   float64    `json:"latency"` // milliseconds
+}
+
+// healthTarget is a probed backend destination.
+type healthTarget struct {
+	url    string
+	routes []string
+	client *http.Client
+}
+
+// healthChecker probes backend destinations of routes each interval with GET of path or json-rpc notification
+// with method. Probes are sent outside of client traffic, so health is known for idle and standby backends.
+type healthChecker struct {
+	path, method string
+	interval     time.Duration
+	targets      []healthTarget
+	routes       map[string]*routeState // recovered destinations start slow-start ramp of their routes
+	clock        clock.Clock
+
+	lock   sync.RWMutex
+	health map[string]*backendHealth
+
+	statHealthy *prometheus.GaugeVec
+	statLatency *prometheus.GaugeVec
+
+	logger
+}
+
+// newHealthChecker returns checker for destinations of routes (DstUrl, GreenUrl and CanaryUrl), nil if interval
+// is not positive. Probe timeout is interval limited by timeout, probes are scheduled by clock c.
+func newHealthChecker(routes map[string]*routeState, path, method string, interval, timeout time.Duration, c clock.Clock, l logger) (*healthChecker, error) {
+	if interval <= 0 {
+		return nil, nil
+	} else if path == "" && method == "" {
+		return nil, fmt.Errorf("health check path or method is required")
+	}
+	if timeout <= 0 || timeout > interval {
+		timeout = interval
+	}
+
+	hc := &healthChecker{path: path, method: method, interval: interval, routes: routes, clock: c, health: make(map[string]*backendHealth), logger: l}
+	srcs := make([]string, 0, len(routes))
+	for src := range routes {
+		srcs = append(srcs, src)
+	}
+	sort.Strings(srcs)
+
+	index := make(map[string]int)
+	for _, src := range srcs {
+		rs := routes[src]
+		for _, dst := range []string{rs.rule.DstUrl, rs.rule.GreenUrl, rs.rule.CanaryUrl} {
+			if dst == "" {
+				continue
+			} else if i, ok := index[dst]; ok {
+				if !contains(hc.targets[i].routes, src) {
+					hc.targets[i].routes = append(hc.targets[i].routes, src)
+					hc.health[dst].Routes = hc.targets[i].routes
+				}
+				continue
+			}
+
+			index[dst] = len(hc.targets)
+			client := &http.Client{Timeout: timeout, Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: rs.tlsConfig}}
+			hc.targets = append(hc.targets, healthTarget{url: dst, routes: []string{src}, client: client})
+			hc.health[dst] = &backendHealth{Url: dst, Routes: []string{src}, Status: "unknown"}
+		}
+	}
+
+	return hc, nil
+}
+
+// setStats sets health and probe latency gauges by url.
+func (hc *healthChecker) setStats(healthy, latency *prometheus.GaugeVec) {
+	hc.statHealthy, hc.statLatency = healthy, latency
+}
+
+// start probes destinations immediately and then each interval.
+func (hc *healthChecker) start() {
+	ticker := hc.clock.NewTicker(hc.interval)
+	go func() {
+		hc.check()
+		for range ticker.C() {
+			hc.check()
+		}
+	}()
+}
+
+// check probes all destinations in parallel and saves results.
+func (hc *healthChecker) check() {
+	var wg sync.WaitGroup
+	for _, t := range hc.targets {
+		wg.Add(1)
+		go func(t healthTarget) {
+			defer wg.Done()
+
+			start := hc.clock.Now()
+			status := hc.probe(t)
+			now := hc.clock.Now()
+			latency := now.Sub(start)
+
+			hc.lock.Lock()
+			h := hc.health[t.url]
+			if h.Status != status && h.CheckedAt != nil {
+				hc.Printf("backend health changed url=%s status=%s prev=%s", t.url, status, h.Status)
+			}
+			recovered := status == healthStatusOk && !h.Healthy && h.CheckedAt != nil
+			h.Healthy, h.Status, h.CheckedAt, h.Latency = status == healthStatusOk, status, &now, durationMs(latency)
+			healthy := h.Healthy
+			hc.lock.Unlock()
+
+			if recovered {
+				for _, src := range t.routes {
+					hc.routes[src].markRecovered(t.url, now)
+				}
+			}
+
+			if hc.statHealthy != nil {
+				v := 0.0
+				if healthy {
+					v = 1
+				}
+				hc.statHealthy.WithLabelValues(t.url).Set(v)
+				hc.statLatency.WithLabelValues(t.url).Set(latency.Seconds())
+			}
+		}(t)
+	}
+	wg.Wait()
+}
+
+// probe sends health request to target and returns ok, http_<code> for statuses from 400 or network error status.
+func (hc *healthChecker) probe(t healthTarget) string {
+	var req *http.Request
+	if hc.path != "" {
+		u, err := url.Parse(t.url)
+		if err != nil {
+			return "error"
+		}
+		u.Path, u.RawQuery = hc.path, ""
+		req, _ = http.NewRequestWithContext(context.Background(), http.MethodGet, u.String(), nil)
+	} else {
+		body, _ := json.Marshal(JsonRpcRequest{JsonRpc: "2.0", Method: hc.method})
+		var err error
+		if req, err = http.NewRequestWithContext(context.Background(), http.MethodPost, t.url, bytes.NewReader(body)); err != nil {
+			return "error"
+		}
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		hc.Tracef("type=healthcheck url=%s err=%s", t.url, err)
+		if status := netErrorStatus(err); status != "" {
+			return status
+		}
+		return "error"
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	hc.Tracef("type=healthcheck url=%s status=%d", t.url, resp.StatusCode)
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Sprintf("http_%d", resp.StatusCode)
+	}
+	return healthStatusOk
+}
+
+// healthy reports whether last probe of dstUrl succeeded.
+func (hc *healthChecker) healthy(dstUrl string) bool {
+	hc.lock.RLock()
+	defer hc.lock.RUnlock()
+
+	h, ok := hc.health[dstUrl]
+	return ok && h.Healthy
+}
+
+// get returns copy of dstUrl health, nil for unknown destination.
+func (hc *healthChecker) get(dstUrl string) *backendHealth {
+	hc.lock.RLock()
+	defer hc.lock.RUnlock()
+
+	h, ok := hc.health[dstUrl]
+	if !ok {
+		return nil
+	}
+	c := *h
+	return &c
+}
+
+// healthResponse is a body of /healthz and /readyz.
+type healthResponse struct {
+	Status    string          `json:"status"` // ok or unavailable
+	Listening bool            `json:"listening"`
+	Routes    map[string]bool `json:"routes,omitempty"` // health of route active destination, informational
+}
+
+// handleHealth adds /healthz and /readyz endpoints to mux.
+func (a *App) handleHealth(mux *http.ServeMux) {
+	mux.HandleFunc(a.endpoint("/healthz"), a.healthz)
+	mux.HandleFunc(a.endpoint("/readyz"), a.readyz)
+}
+
+// healthz returns 200 while listener accepts connections and 503 before listen and after Shutdown.
+// Example: curl http://localhost:8090/healthz
+func (a *App) healthz(w http.ResponseWriter, r *http.Request) {
+	resp := healthResponse{Status: healthStatusOk, Listening: atomic.LoadInt32(&a.listening) == 1}
+	if !resp.Listening {
+		resp.Status = healthStatusUnavailable
+	}
+
+	a.writeHealth(w, resp)
+}
+
+// readyz returns 200 while listener accepts connections, otherwise 503. Body reports whether active destination
+// of every route passed last health probe, unhealthy backends don't fail readiness, because every instance would
+// be removed from balancer at once. Routes are healthy without health checks, probe details are in /admin/backends.
+// Example: curl http://localhost:8090/readyz
+func (a *App) readyz(w http.ResponseWriter, r *http.Request) {
+	resp := healthResponse{Status: healthStatusOk, Listening: atomic.LoadInt32(&a.listening) == 1}
+	if !resp.Listening {
+		resp.Status = healthStatusUnavailable
+	}
+
+	resp.Routes = make(map[string]bool, len(a.routes))
+	for src, rs := range a.routes {
+		resp.Routes[src] = a.healthChecker == nil || a.healthChecker.healthy(rs.colorUrl(rs.activeColor()))
+	}
+
+	a.writeHealth(w, resp)
+}
+
+func (a *App) writeHealth(w http.ResponseWriter, resp healthResponse) {
+	if resp.Status != healthStatusOk {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	writeJSON(w, resp)
+}
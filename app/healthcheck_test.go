@@ -0,0 +1,118 @@
+package app
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestHealthChecker(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	routes, err := newRouteStates([]ProxyRule{
+		{Src: "/rpc", DstUrl: up.URL + "/rpc"},
+		{Src: "/v2", DstUrl: up.URL + "/rpc", CanaryUrl: down.URL + "/rpc"},
+	}, clock.Real)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hc, err := newHealthChecker(routes, "", "", 0, 0, clock.Real, logger{}); hc != nil || err != nil {
+		t.Errorf("disabled: got %v %v", hc, err)
+	}
+	if _, err := newHealthChecker(routes, "", "", time.Second, 0, clock.Real, logger{}); err == nil {
+		t.Error("no path and method: expected error")
+	}
+
+	hc, err := newHealthChecker(routes, "/health", "", time.Second, 0, clock.Real, logger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthy := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "healthy"}, []string{"url"})
+	hc.setStats(healthy, prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "latency"}, []string{"url"}))
+
+	a := &App{routes: routes, healthChecker: hc}
+	get := func(h http.HandlerFunc) (int, healthResponse) {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var resp healthResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	// not ready before listen and first probe
+	if code, resp := get(a.healthz); code != http.StatusServiceUnavailable || resp.Listening {
+		t.Errorf("healthz: got %d %+v", code, resp)
+	}
+	a.listening = 1
+	if code, _ := get(a.healthz); code != http.StatusOK {
+		t.Errorf("healthz: got %d", code)
+	}
+	if code, resp := get(a.readyz); code != http.StatusOK || resp.Routes["/rpc"] || hc.get(up.URL+"/rpc").Status != "unknown" {
+		t.Errorf("readyz: got %d %+v", code, resp)
+	}
+
+	hc.check()
+	if code, resp := get(a.readyz); code != http.StatusOK || !resp.Routes["/rpc"] || !resp.Routes["/v2"] {
+		t.Fatalf("readyz: got %d %+v", code, resp)
+	}
+	if b := hc.get(up.URL + "/rpc"); !b.Healthy || b.Status != "ok" || len(b.Routes) != 2 || b.CheckedAt == nil {
+		t.Errorf("up: got %+v", b)
+	}
+	if b := hc.get(down.URL + "/rpc"); b.Healthy || b.Status != "http_503" {
+		t.Errorf("down: got %+v", b)
+	}
+	if b := hc.get("http://unknown"); b != nil {
+		t.Errorf("unknown: got %+v", b)
+	}
+	if v := testutil.ToFloat64(healthy.WithLabelValues(up.URL + "/rpc")); v != 1 {
+		t.Errorf("got healthy %v", v)
+	}
+	if v := testutil.ToFloat64(healthy.WithLabelValues(down.URL + "/rpc")); v != 0 {
+		t.Errorf("got healthy %v", v)
+	}
+
+	// unhealthy backends are reported, but readiness follows listener only
+	up.Close()
+	hc.check()
+	if code, resp := get(a.readyz); code != http.StatusOK || resp.Routes["/rpc"] || hc.get(up.URL+"/rpc").Status != statusConnRefused {
+		t.Errorf("readyz: got %d %+v", code, resp)
+	}
+	a.listening = 0
+	if code, _ := get(a.readyz); code != http.StatusServiceUnavailable {
+		t.Errorf("not listening: got %d", code)
+	}
+}
+
+func TestHealthCheckMethod(t *testing.T) {
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+	}))
+	defer srv.Close()
+
+	routes, _ := newRouteStates([]ProxyRule{{Src: "/rpc", DstUrl: srv.URL + "/rpc"}}, clock.Real)
+	hc, _ := newHealthChecker(routes, "", "system.ping", time.Second, 0, clock.Real, logger{})
+	hc.check()
+	if !hc.healthy(srv.URL+"/rpc") || !strings.Contains(body, `"method":"system.ping"`) || strings.Contains(body, `"id"`) {
+		t.Errorf("got %v %s", hc.healthy(srv.URL+"/rpc"), body)
+	}
+}
@@ -0,0 +1,335 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/semrush/ws2http/clock"
+)
+
+const (
+	http2wsMaxBody        = 1 << 20 // max http request body
+	http2wsMinReconnect   = time.Second
+	http2wsMaxReconnect   = 30 * time.Second
+	http2wsIdPrefix       = "h2w-" // upstream request ids, like h2w-42
+	http2wsDefaultTimeout = 30 * time.Second
+)
+
+var (
+	errUpstreamDown   = errors.New("upstream websocket is not connected")
+	errUpstreamClosed = errors.New("upstream websocket connection closed")
+	errInvalidJsonRpc = errors.New("invalid json-rpc request")
+)
+
+// Http2WsRoute accepts http POST requests with json-rpc body at Src and forwards them over maintained websocket
+// connection to DstUrl, so clients that can't speak websocket reach websocket-only backends.
+type Http2WsRoute struct {
+	Src    string // http endpoint, like /http2ws
+	DstUrl string // websocket backend, like ws://localhost:8080/rpc
+	Origin string // Origin of upstream handshake, default is http://<DstUrl host>
+}
+
+// wsUpstream is a websocket connection to backend shared by http requests. Request ids are replaced with
+// connection-unique ids and restored in responses, connection is restored after errors with backoff.
+type wsUpstream struct {
+	url     string
+	header  http.Header // handshake headers with Origin
+	timeout time.Duration
+	clock   clock.Clock // reconnect backoff time source
+	ctx     context.Context
+	stop    context.CancelFunc // stops run, called by close
+
+	lock      sync.Mutex
+	conn      *websocket.Conn
+	seq       uint64
+	pending   map[string]chan []byte // responses by upstream id, closed on disconnect
+	writeLock sync.Mutex             // serializes writes of conn, lock is not held while writing
+
+	logger
+}
+
+// newWsUpstream returns upstream for route with reconnect backoff on clock c, connection is started by run.
+func newWsUpstream(route Http2WsRoute, timeout time.Duration, c clock.Clock, l logger) (*wsUpstream, error) {
+	u, err := url.Parse(route.DstUrl)
+	if err != nil {
+		return nil, err
+	} else if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, fmt.Errorf("http2ws %s: invalid websocket url %q", route.Src, route.DstUrl)
+	}
+
+	origin := route.Origin
+	if origin == "" {
+		origin = "http://" + u.Host
+	}
+	if timeout <= 0 {
+		timeout = http2wsDefaultTimeout
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	return &wsUpstream{
+		url:     route.DstUrl,
+		header:  http.Header{"Origin": {origin}},
+		timeout: timeout,
+		clock:   c,
+		ctx:     ctx,
+		stop:    stop,
+		pending: make(map[string]chan []byte),
+		logger:  l,
+	}, nil
+}
+
+// run maintains upstream connection until close, reconnects are delayed with exponential backoff up to
+// http2wsMaxReconnect.
+func (u *wsUpstream) run() {
+	backoff := http2wsMinReconnect
+	for {
+		conn, _, err := websocket.DefaultDialer.DialContext(u.ctx, u.url, u.header)
+		if err != nil {
+			if u.ctx.Err() != nil {
+				return
+			}
+			u.Errorf("can't connect http2ws upstream url=%s err=%s", u.url, err)
+			if !u.sleep(backoff) {
+				return
+			}
+			if backoff *= 2; backoff > http2wsMaxReconnect {
+				backoff = http2wsMaxReconnect
+			}
+			continue
+		}
+
+		u.lock.Lock()
+		if u.ctx.Err() != nil {
+			u.lock.Unlock()
+			conn.Close()
+			return
+		}
+		u.conn = conn
+		u.lock.Unlock()
+		u.Printf("http2ws upstream connected url=%s", u.url)
+		backoff = http2wsMinReconnect
+
+		err = u.receive(conn)
+		u.disconnect(conn)
+		if u.ctx.Err() != nil {
+			return
+		}
+		u.Errorf("http2ws upstream disconnected url=%s err=%s", u.url, err)
+		if !u.sleep(backoff) {
+			return
+		}
+	}
+}
+
+// sleep waits for d, returns false if upstream is closed.
+func (u *wsUpstream) sleep(d time.Duration) bool {
+	select {
+	case <-clock.After(u.clock, d):
+		return true
+	case <-u.ctx.Done():
+		return false
+	}
+}
+
+// close stops run and closes connection, pending requests are failed.
+func (u *wsUpstream) close() {
+	u.stop()
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.conn != nil {
+		u.conn.Close()
+	}
+}
+
+// receive reads upstream messages and delivers responses to pending requests until connection error.
+func (u *wsUpstream) receive(conn *websocket.Conn) error {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		var msg map[string]json.RawMessage
+		if json.Unmarshal(data, &msg) != nil {
+			continue
+		}
+		var id string
+		if json.Unmarshal(msg["id"], &id) != nil {
+			u.Tracef("type=http2ws_message url=%s data=%s", u.url, data)
+			continue
+		}
+
+		u.lock.Lock()
+		ch, ok := u.pending[id]
+		delete(u.pending, id)
+		u.lock.Unlock()
+		if ok {
+			ch <- data
+		}
+	}
+}
+
+// disconnect closes connection and fails pending requests.
+func (u *wsUpstream) disconnect(conn *websocket.Conn) {
+	conn.Close()
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.conn = nil
+	for id, ch := range u.pending {
+		close(ch)
+		delete(u.pending, id)
+	}
+}
+
+// do sends request with upstream id and waits for response with client id. Notifications return nil response
+// after send.
+func (u *wsUpstream) do(ctx context.Context, req JsonRpcRequest, body []byte) ([]byte, error) {
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, err
+	}
+
+	var ch chan []byte
+	u.lock.Lock()
+	conn := u.conn
+	if conn == nil {
+		u.lock.Unlock()
+		return nil, errUpstreamDown
+	}
+	id := ""
+	if req.Id != nil {
+		u.seq++
+		id = http2wsIdPrefix + strconv.FormatUint(u.seq, 10)
+		msg["id"], _ = json.Marshal(id)
+		ch = make(chan []byte, 1)
+		u.pending[id] = ch
+	}
+	u.lock.Unlock()
+
+	if err := u.write(ctx, conn, msg); err != nil || ch == nil {
+		u.forget(id)
+		return nil, err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, errUpstreamClosed
+		}
+		return restoreId(resp, req.Id), nil
+	case <-ctx.Done():
+		u.forget(id)
+		return nil, ctx.Err()
+	}
+}
+
+// write sends message to conn with deadline of ctx or upstream timeout. Connection is closed after failed write,
+// because partially written frame can't be followed by another frame, so run reconnects.
+func (u *wsUpstream) write(ctx context.Context, conn *websocket.Conn, msg map[string]json.RawMessage) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(u.timeout)
+	}
+	data, _ := json.Marshal(msg)
+
+	u.writeLock.Lock()
+	defer u.writeLock.Unlock()
+	conn.SetWriteDeadline(deadline)
+	err := conn.WriteMessage(websocket.TextMessage, data)
+	if err != nil {
+		conn.Close()
+	}
+
+	return err
+}
+
+// forget removes pending request.
+func (u *wsUpstream) forget(id string) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	delete(u.pending, id)
+}
+
+// restoreId replaces upstream id of response with client id.
+func restoreId(resp []byte, id json.RawMessage) []byte {
+	var msg map[string]json.RawMessage
+	if json.Unmarshal(resp, &msg) != nil {
+		return resp
+	}
+	msg["id"] = id
+	data, _ := json.Marshal(msg)
+
+	return data
+}
+
+// ServeHTTP forwards json-rpc request from POST body to upstream and writes response. Upstream errors are returned
+// as json-rpc errors with 503 if upstream is not connected and 504 on timeout, notifications get 204.
+func (u *wsUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeErr := func(status int, req JsonRpcRequest, code int, err error) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(NewJsonRpcErr(req, code, err))
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, http2wsMaxBody))
+	if err != nil {
+		writeErr(http.StatusRequestEntityTooLarge, JsonRpcRequest{}, JsonRpcInvalidRequest, err)
+		return
+	}
+	var req JsonRpcRequest
+	if err := json.Unmarshal(body, &req); err != nil || req.Method == "" {
+		writeErr(http.StatusBadRequest, req, JsonRpcInvalidRequest, errInvalidJsonRpc)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), u.timeout)
+	defer cancel()
+	resp, err := u.do(ctx, req, body)
+	switch {
+	case err == errUpstreamDown || err == errUpstreamClosed:
+		writeErr(http.StatusServiceUnavailable, req, JsonRpcServerErr, err)
+	case err == context.DeadlineExceeded:
+		writeErr(http.StatusGatewayTimeout, req, JsonRpcTimeout, err)
+	case err != nil:
+		u.Errorf("http2ws request failed url=%s method=%s err=%s", u.url, req.Method, err)
+		writeErr(http.StatusBadGateway, req, JsonRpcServerErr, err)
+	case resp == nil:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+	}
+}
+
+// handleHttp2Ws registers http2ws routes in mux and connects their upstreams, upstreams are closed by Shutdown.
+func (a *App) handleHttp2Ws(mux *http.ServeMux) error {
+	for _, route := range a.Http2WsRoutes {
+		u, err := newWsUpstream(route, time.Duration(a.Timeout)*time.Second, a.clock(), a.logger)
+		if err != nil {
+			return err
+		}
+
+		a.Printf("adding http2ws rule from=http://%s%s to=%s", a.ListenAddr, route.Src, route.DstUrl)
+		mux.Handle(route.Src, u)
+		a.upstreams = append(a.upstreams, u)
+		go u.run()
+	}
+
+	return nil
+}
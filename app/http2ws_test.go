@@ -0,0 +1,108 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestHttp2Ws(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+
+		for {
+			var req JsonRpcRequest
+			if err := ws.ReadJSON(&req); err != nil {
+				return
+			} else if req.Id == nil || req.Method == "slow" {
+				continue
+			}
+			ws.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":`+string(req.Id)+`,"result":"`+req.Method+`"}`))
+		}
+	}))
+	defer backend.Close()
+
+	wsUrl := "ws" + strings.TrimPrefix(backend.URL, "http")
+	u, err := newWsUpstream(Http2WsRoute{Src: "/http2ws", DstUrl: wsUrl}, 100*time.Millisecond, clock.Real, logger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(method, body string) (int, string) {
+		w := httptest.NewRecorder()
+		u.ServeHTTP(w, httptest.NewRequest(method, "/http2ws", strings.NewReader(body)))
+		return w.Code, strings.TrimSpace(w.Body.String())
+	}
+
+	if code, _ := post(http.MethodPost, `{"jsonrpc":"2.0","method":"a","id":1}`); code != http.StatusServiceUnavailable {
+		t.Errorf("disconnected: got %d", code)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		u.run()
+		close(stopped)
+	}()
+	for i := 0; i < 100; i++ {
+		u.lock.Lock()
+		connected := u.conn != nil
+		u.lock.Unlock()
+		if connected {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// client ids are restored in responses
+	for _, id := range []string{`1`, `"a"`} {
+		code, body := post(http.MethodPost, `{"jsonrpc":"2.0","method":"users.get","id":`+id+`}`)
+		var resp map[string]json.RawMessage
+		json.Unmarshal([]byte(body), &resp)
+		if code != http.StatusOK || string(resp["id"]) != id || string(resp["result"]) != `"users.get"` {
+			t.Errorf("%s: got %d %s", id, code, body)
+		}
+	}
+
+	if code, body := post(http.MethodPost, `{"jsonrpc":"2.0","method":"notify"}`); code != http.StatusNoContent || body != "" {
+		t.Errorf("notification: got %d %s", code, body)
+	}
+	if code, body := post(http.MethodPost, `{"jsonrpc":"2.0","method":"slow","id":2}`); code != http.StatusGatewayTimeout || !strings.Contains(body, `"code":-32008`) {
+		t.Errorf("timeout: got %d %s", code, body)
+	}
+	if code, _ := post(http.MethodPost, `[`); code != http.StatusBadRequest {
+		t.Errorf("invalid: got %d", code)
+	}
+	if code, _ := post(http.MethodGet, ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("get: got %d", code)
+	}
+
+	u.lock.Lock()
+	if len(u.pending) != 0 {
+		t.Errorf("got %d pending requests", len(u.pending))
+	}
+	u.lock.Unlock()
+
+	// close stops reconnects
+	u.close()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Error("run is not stopped by close")
+	}
+	if code, _ := post(http.MethodPost, `{"jsonrpc":"2.0","method":"a","id":3}`); code != http.StatusServiceUnavailable {
+		t.Errorf("closed: got %d", code)
+	}
+
+	if _, err := newWsUpstream(Http2WsRoute{Src: "/http2ws", DstUrl: "http://backend/rpc"}, 0, clock.Real, logger{}); err == nil {
+		t.Error("http url: expected error")
+	}
+}
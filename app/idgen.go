@@ -0,0 +1,129 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+// IdGenerator returns id of proxy-originated message sent to session, seq is a per-session message number starting
+// with 1. Ids are sent in messageId member of broadcast, shutdown, drain, reauth and control error notifications, so
+// clients can deduplicate and order them, embedders can set own generator with App.MessageIds.
+type IdGenerator func(sessionId string, seq uint64) string
+
+// snowflakeEpoch is a start of snowflake id timestamps.
+var snowflakeEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// proxyNotification is a JSON-RPC notification of proxy with message id.
+type proxyNotification struct {
+	JsonRpc   string          `json:"jsonrpc"`
+	Method    string          `json:"method"`
+	Params    json.RawMessage `json:"params,omitempty"`
+	MessageId string          `json:"messageId,omitempty"`
+}
+
+// NewIdGenerator returns generator by name: seq, uuid7 or snowflake, empty name returns nil generator.
+// Snowflake node is derived from instance id, default is hostname.
+func NewIdGenerator(name, instance string) (IdGenerator, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "seq":
+		return SequenceIds, nil
+	case "uuid7":
+		return UuidV7Ids, nil
+	case "snowflake":
+		if instance == "" {
+			instance, _ = os.Hostname()
+		}
+		h := fnv.New32a()
+		h.Write([]byte(instance))
+		return SnowflakeIds(int64(h.Sum32() % 1024)), nil
+	}
+
+	return nil, fmt.Errorf("unknown message id generator %q", name)
+}
+
+// SequenceIds returns seq, ids are monotonic and unique per session only.
+func SequenceIds(_ string, seq uint64) string {
+	return strconv.FormatUint(seq, 10)
+}
+
+// UuidV7Ids returns RFC 9562 UUIDv7 with system clock milliseconds and random bits, ids are unique and roughly time
+// ordered between sessions and instances.
+func UuidV7Ids(string, uint64) string {
+	return uuidV7(clock.Real)
+}
+
+// uuidV7 returns UUIDv7 with milliseconds of clock c.
+func uuidV7(c clock.Clock) string {
+	var u [16]byte
+	rand.Read(u[6:])
+	binary.BigEndian.PutUint64(u[:8], uint64(c.Now().UnixNano()/int64(time.Millisecond))<<16|uint64(binary.BigEndian.Uint16(u[6:8])))
+	u[6] = u[6]&0x0f | 0x70 // version 7
+	u[8] = u[8]&0x3f | 0x80 // variant 10
+
+	b := make([]byte, 36)
+	hex.Encode(b, u[:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], u[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], u[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], u[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], u[10:])
+
+	return string(b)
+}
+
+// SnowflakeIds returns generator of 63-bit ids: 41 bits of milliseconds since 2020, 10 bits of node and 12 bits of
+// sequence. Ids are unique between instances with different nodes and monotonic per instance, sequence overflow
+// borrows next millisecond instead of waiting. Timestamps are taken from system clock.
+func SnowflakeIds(node int64) IdGenerator {
+	return snowflakeIds(node, clock.Real)
+}
+
+// snowflakeIds is SnowflakeIds with timestamps of clock c.
+func snowflakeIds(node int64, c clock.Clock) IdGenerator {
+	var (
+		lock    sync.Mutex
+		last    int64
+		counter int64
+	)
+
+	return func(string, uint64) string {
+		lock.Lock()
+		defer lock.Unlock()
+
+		ms := c.Now().Sub(snowflakeEpoch).Milliseconds()
+		if ms > last {
+			last, counter = ms, 0
+		} else if counter++; counter > 0xfff {
+			last, counter = last+1, 0
+		}
+
+		return strconv.FormatInt(last<<22|(node&0x3ff)<<12|counter, 10)
+	}
+}
+
+// notification returns proxy JSON-RPC notification for session with message id of session generator.
+func (s *session) notification(method string, params []byte) []byte {
+	n := proxyNotification{JsonRpc: "2.0", Method: method, Params: params}
+	if s != nil && s.messageIds != nil {
+		n.MessageId = s.messageIds(s.id, atomic.AddUint64(&s.messageSeq, 1))
+	}
+
+	msg, _ := json.Marshal(n)
+	return msg
+}
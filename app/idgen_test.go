@@ -0,0 +1,104 @@
+package app
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestSessionNotification(t *testing.T) {
+	s := newSession("/rpc", nil)
+	if got := string(s.notification("ws2http.shutdown", []byte(`{"in":10}`))); got != `{"jsonrpc":"2.0","method":"ws2http.shutdown","params":{"in":10}}` {
+		t.Errorf("no generator: got %s", got)
+	}
+
+	s.messageIds = SequenceIds
+	for i := 1; i <= 2; i++ {
+		var n proxyNotification
+		if err := json.Unmarshal(s.notification("maintenance", nil), &n); err != nil {
+			t.Fatal(err)
+		}
+		if n.MessageId != strconv.Itoa(i) || n.Params != nil {
+			t.Errorf("message %d: got %+v", i, n)
+		}
+	}
+
+	// sequence is per session
+	other := newSession("/rpc", nil)
+	other.messageIds = SequenceIds
+	if got := string(other.notification("maintenance", nil)); got != `{"jsonrpc":"2.0","method":"maintenance","messageId":"1"}` {
+		t.Errorf("other session: got %s", got)
+	}
+}
+
+func TestUuidV7Ids(t *testing.T) {
+	c := clock.NewFake(time.Unix(1700000000, 123e6))
+
+	id := uuidV7(c)
+	if !regexp.MustCompile(`^018bcfe5-687b-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(id) {
+		t.Errorf("got %s", id)
+	}
+	if id == uuidV7(c) {
+		t.Errorf("duplicate id %s", id)
+	}
+}
+
+func TestSnowflakeIds(t *testing.T) {
+	c := clock.NewFake(snowflakeEpoch.Add(time.Second))
+
+	gen := snowflakeIds(5, c)
+	if got := gen("", 1); got != strconv.FormatInt(1000<<22|5<<12, 10) {
+		t.Errorf("got %s", got)
+	}
+
+	// ids are monotonic, sequence overflow borrows next millisecond
+	last := int64(0)
+	for i := 0; i < 5000; i++ {
+		id, _ := strconv.ParseInt(gen("", 1), 10, 64)
+		if id <= last {
+			t.Fatalf("id %d: %d after %d", i, id, last)
+		}
+		last = id
+	}
+	if ms := last >> 22; ms != 1001 {
+		t.Errorf("got last ms %d", ms)
+	}
+
+	// clock going back doesn't repeat ids
+	c.Advance(-time.Second)
+	if id, _ := strconv.ParseInt(gen("", 1), 10, 64); id <= last {
+		t.Errorf("clock back: got %d after %d", id, last)
+	}
+}
+
+func TestNewIdGenerator(t *testing.T) {
+	for _, name := range []string{"seq", "uuid7", "snowflake"} {
+		if gen, err := NewIdGenerator(name, "ws1"); err != nil || gen == nil {
+			t.Errorf("%s: got err %v", name, err)
+		}
+	}
+	if gen, err := NewIdGenerator("", ""); err != nil || gen != nil {
+		t.Errorf("empty: got err %v", err)
+	}
+	if _, err := NewIdGenerator("uuid4", ""); err == nil {
+		t.Error("unknown: expected error")
+	}
+}
+
+func TestForwarderMessageIds(t *testing.T) {
+	hf := NewHttpForwarder("http://localhost", nil, 1, 1)
+	hf.SetMessageIds(SequenceIds)
+	if got := string(hf.newRequestForwarder(&wsConn{}).session.notification("maintenance", nil)); got != `{"jsonrpc":"2.0","method":"maintenance","messageId":"1"}` {
+		t.Errorf("got %s", got)
+	}
+
+	// generators are set per forwarder
+	other := NewHttpForwarder("http://localhost", nil, 1, 1)
+	if got := string(other.newRequestForwarder(&wsConn{}).session.notification("maintenance", nil)); got != `{"jsonrpc":"2.0","method":"maintenance"}` {
+		t.Errorf("other forwarder: got %s", got)
+	}
+}
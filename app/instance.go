@@ -0,0 +1,53 @@
+package app
+
+import (
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxCloseReason is a max length of close frame reason, control frame payload is limited to 125 bytes with status.
+const maxCloseReason = 123
+
+// instance identifies instance in metrics, logs, close frames and cluster session ids.
+type instance struct {
+	id, zone string
+}
+
+// newInstance returns instance identity, id is hostname by default.
+func newInstance(id, zone string) instance {
+	if id == "" {
+		id, _ = os.Hostname()
+	}
+
+	return instance{id: id, zone: zone}
+}
+
+// labels returns constant labels for all metrics. Labels are named instance_id and zone, instance label
+// is set by prometheus for scrape target.
+func (i instance) labels() prometheus.Labels {
+	labels := prometheus.Labels{}
+	if i.id != "" {
+		labels["instance_id"] = i.id
+	}
+	if i.zone != "" {
+		labels["zone"] = i.zone
+	}
+
+	return labels
+}
+
+// closeReason returns close frame reason with instance identity, like "slow client instance=ws-1 zone=eu-1".
+func (i instance) closeReason(reason string) string {
+	if i.id != "" {
+		reason += " instance=" + i.id
+	}
+	if i.zone != "" {
+		reason += " zone=" + i.zone
+	}
+	if len(reason) > maxCloseReason {
+		reason = reason[:maxCloseReason]
+	}
+
+	return reason
+}
@@ -0,0 +1,49 @@
+package app
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCloseReason(t *testing.T) {
+	i := newInstance("ws-1", "eu-1")
+	if r := i.closeReason("slow client"); r != "slow client instance=ws-1 zone=eu-1" {
+		t.Errorf("got %q", r)
+	} else if l := i.labels(); len(l) != 2 || l["instance_id"] != "ws-1" || l["zone"] != "eu-1" {
+		t.Errorf("got %v", l)
+	}
+
+	if r := i.closeReason(strings.Repeat("x", 200)); len(r) != maxCloseReason {
+		t.Errorf("got %d", len(r))
+	}
+
+	if id := sessionInstanceId(newSessionIdPrefix("ws-1") + "42"); id != "ws-1" {
+		t.Errorf("got %q", id)
+	}
+
+	// hostname is default id, zero instance has no identity
+	if host, _ := os.Hostname(); newInstance("", "").id != host {
+		t.Errorf("got %q", newInstance("", "").id)
+	} else if r := (instance{}).closeReason("shutdown"); r != "shutdown" {
+		t.Errorf("got %q", r)
+	}
+}
+
+func TestSessionIdPrefix(t *testing.T) {
+	r1, r2 := newSessionRegistry(), newSessionRegistry()
+	r1.idPrefix, r2.idPrefix = newSessionIdPrefix("ws-1"), newSessionIdPrefix("ws-2")
+
+	if id := sessionInstanceId(r1.newSession("/rpc", nil).id); id != "ws-1" {
+		t.Errorf("got %q", id)
+	}
+	if id := sessionInstanceId(r2.newSession("/rpc", nil).id); id != "ws-2" {
+		t.Errorf("got %q", id)
+	}
+
+	// sessions without registry have no prefix
+	var r *sessionRegistry
+	if id := r.newSession("/rpc", nil).id; strings.Contains(id, "-") {
+		t.Errorf("got %q", id)
+	}
+}
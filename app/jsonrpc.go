@@ -7,28 +7,61 @@ import (
 )
 
 const (
-	JsonRpcServerErr      = -32000
-	JsonRpcMethodNotFound = -32601
+	JsonRpcServerErr          = -32000
+	JsonRpcMaintenance        = -32001
+	JsonRpcClientRequestLimit = -32002
+	JsonRpcAuthFailed         = -32003
+	JsonRpcCsrfHandshake      = -32004
+	JsonRpcTokenExpired       = -32005
+	JsonRpcCancelled          = -32006
+	JsonRpcWarmingUp          = -32007
+	JsonRpcTimeout            = -32008
+	JsonRpcDnsError           = -32009
+	JsonRpcConnRefused        = -32010
+	JsonRpcTlsError           = -32011
+	JsonRpcResponseTooLarge   = -32012
+	JsonRpcRateLimited        = -32029 // connection or route request rate limit, like http 429
+	JsonRpcInvalidRequest     = -32600
+	JsonRpcMethodNotFound     = -32601
 )
 
 var errMethodFormat = errors.New("method has no prefix with .")
 
+// JsonRpcRequest is a json-rpc request. Id is kept as raw json, so ids like 9007199254740993 or "0001" are forwarded
+// and returned to clients byte-exact, nil Id is a notification.
 type JsonRpcRequest struct {
 	JsonRpc string           `json:"jsonrpc"`
-	Id      interface{}      `json:"id,omitempty"`
+	Id      json.RawMessage  `json:"id,omitempty"`
 	Method  string           `json:"method"`
 	Params  *json.RawMessage `json:"params,omitempty"`
 }
 
+type JsonRpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+}
+
 type JsonRpcErrResponse struct {
-	Version string      `json:"jsonrpc"`
-	Id      interface{} `json:"id"`
+	Version string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
 	Error   struct {
-		Code    int    `json:"code"`
-		Message string `json:"message"`
+		Code    int         `json:"code"`
+		Message string      `json:"message"`
+		Data    interface{} `json:"data,omitempty"`
 	} `json:"error"`
 }
 
+// JsonRpcErrData is a structured data member of proxy-generated errors for backend requests, so clients can react
+// without parsing messages.
+type JsonRpcErrData struct {
+	HttpStatus  int    `json:"httpStatus,omitempty"`  // backend http status
+	BackendHost string `json:"backendHost,omitempty"` // backend host, omitted unless exposed by App.ErrorBackendHost
+	RequestId   string `json:"requestId,omitempty"`   // proxy request id from error logs
+	RetryAfter  int    `json:"retryAfter,omitempty"`  // backend retry hint in seconds
+	Detail      string `json:"detail,omitempty"`      // backend error details, only with verbose_errors feature
+}
+
 // NewJsonRpcErrResponse returns new JsonRPC lastErr object with correct ID from postData.
 // If httpCode is set then it will be multiply by -1.
 func NewJsonRpcErrResponse(postData []byte, httpCode int, err error) (rpcErr *JsonRpcErrResponse) {
@@ -62,6 +95,16 @@ func NewJsonRpcErr(req JsonRpcRequest, code int, err error) *JsonRpcErrResponse
 	return rpcErr
 }
 
+// JSON marshals response to JSON and logs error if needed.
+func (r JsonRpcResponse) JSON() []byte {
+	resp, err := json.Marshal(r)
+	if err != nil {
+		log.Println(err)
+	}
+
+	return resp
+}
+
 // JSON is a function that marshals error response to JSON and logs error if needed.
 func (r *JsonRpcErrResponse) JSON() []byte {
 	resp, err := json.Marshal(r)
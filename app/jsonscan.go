@@ -0,0 +1,187 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// jsonRpcFields are raw top-level values of json-rpc request, slices of scanned message.
+type jsonRpcFields struct {
+	jsonrpc, id, method, params []byte
+	members                     []extensionMember // other members in order of appearance
+}
+
+// scanJsonRpc extracts top-level jsonrpc, id, method and params values and other members from valid json object
+// without decoding values. Returns false if message must be decoded by encoding/json: invalid json, escaped or
+// case-insensitive keys.
+func scanJsonRpc(msg []byte) (f jsonRpcFields, ok bool) {
+	if !json.Valid(msg) {
+		return f, false
+	}
+
+	i := skipSpace(msg, 0)
+	if i >= len(msg) || msg[i] != '{' {
+		return f, false
+	}
+
+	for i = skipSpace(msg, i+1); i < len(msg) && msg[i] != '}'; i = skipSpace(msg, i) {
+		if msg[i] == ',' {
+			i = skipSpace(msg, i+1)
+		}
+
+		// key without escapes
+		end := bytes.IndexByte(msg[i+1:], '"') + i + 1
+		key := msg[i+1 : end]
+		if bytes.IndexByte(key, '\\') >= 0 {
+			return f, false
+		}
+
+		// value after colon
+		start := skipSpace(msg, skipSpace(msg, end+1)+1)
+		i = skipValue(msg, start)
+		value := msg[start:i]
+
+		// last value is used for repeated keys like in encoding/json
+		switch string(key) {
+		case "jsonrpc":
+			f.jsonrpc = value
+		case "id":
+			f.id = value
+		case "method":
+			f.method = value
+		case "params":
+			f.params = value
+		default:
+			if bytes.EqualFold(key, []byte("jsonrpc")) || bytes.EqualFold(key, []byte("id")) ||
+				bytes.EqualFold(key, []byte("method")) || bytes.EqualFold(key, []byte("params")) {
+				return f, false
+			}
+			f.members = append(f.members, extensionMember{key: key, value: value})
+		}
+	}
+
+	return f, true
+}
+
+// request returns JsonRpcRequest from fields. Params refers to scanned message.
+// Returns false if values must be decoded by encoding/json.
+func (f jsonRpcFields) request() (req JsonRpcRequest, ok bool) {
+	if req.JsonRpc, ok = rawString(f.jsonrpc); !ok {
+		return
+	}
+	if req.Method, ok = rawString(f.method); !ok {
+		return
+	}
+
+	// ids are kept raw: numbers and strings, null is no id
+	switch {
+	case len(f.id) == 0 || string(f.id) == "null":
+	case f.id[0] == '"' || f.id[0] == '-' || (f.id[0] >= '0' && f.id[0] <= '9'):
+		req.Id = json.RawMessage(f.id)
+	default:
+		return req, false
+	}
+
+	if len(f.params) > 0 && string(f.params) != "null" {
+		params := json.RawMessage(f.params)
+		req.Params = &params
+	}
+
+	return req, true
+}
+
+// parseRequest returns request from scanned top-level fields, full decode is used only if scanner can't handle msg.
+func parseRequest(msg []byte) (req JsonRpcRequest, err error) {
+	if f, ok := scanJsonRpc(msg); ok {
+		if req, ok = f.request(); ok {
+			return req, nil
+		}
+	}
+
+	return unmarshalRequest(msg)
+}
+
+// parseRequestMembers returns request and other top-level members of msg like parseRequest, members are decoded
+// by encoding/json only if scanner can't handle msg.
+func parseRequestMembers(msg []byte) (req JsonRpcRequest, members []extensionMember, err error) {
+	f, scanned := scanJsonRpc(msg)
+	if scanned {
+		if req, ok := f.request(); ok {
+			return req, f.members, nil
+		}
+	}
+
+	if req, err = unmarshalRequest(msg); err != nil {
+		return req, nil, err
+	} else if scanned {
+		return req, f.members, nil
+	}
+
+	members, _ = requestMembers(msg)
+	return req, members, nil
+}
+
+// unmarshalRequest decodes msg by encoding/json, null id is no id.
+func unmarshalRequest(msg []byte) (req JsonRpcRequest, err error) {
+	err = json.Unmarshal(msg, &req)
+	if string(req.Id) == "null" {
+		req.Id = nil
+	}
+	return req, err
+}
+
+// rawString returns unquoted json string without escapes, absent value is empty string.
+func rawString(raw []byte) (string, bool) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", true
+	} else if len(raw) < 2 || raw[0] != '"' || bytes.IndexByte(raw, '\\') >= 0 {
+		return "", false
+	}
+
+	return string(raw[1 : len(raw)-1]), true
+}
+
+// skipSpace returns index of first non-whitespace byte from i.
+func skipSpace(data []byte, i int) int {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\n' || data[i] == '\r') {
+		i++
+	}
+
+	return i
+}
+
+// skipValue returns index after json value starting at i, data must be valid json.
+func skipValue(data []byte, i int) int {
+	depth := 0
+	for ; i < len(data); i++ {
+		switch data[i] {
+		case '"':
+			for i++; i < len(data) && data[i] != '"'; i++ {
+				if data[i] == '\\' {
+					i++
+				}
+			}
+		case '{', '[':
+			depth++
+			continue
+		case '}', ']':
+			depth--
+		case ',', ' ', '\t', '\n', '\r':
+			if depth == 0 {
+				return i
+			}
+			continue
+		default:
+			continue
+		}
+
+		if depth <= 0 {
+			if depth < 0 { // closing bracket of parent object
+				return i
+			}
+			return i + 1
+		}
+	}
+
+	return i
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseRequest(t *testing.T) {
+	var tc = []string{
+		`{"jsonrpc":"2.0","method":"rpc.test","params":[42,{"a":"}"}],"id":1}`,
+		` { "id" : "a1" , "method" : "test" , "params" : { "b" : [1, 2, "x,]"] } } `,
+		`{"method":"test","id":null,"params":null}`,
+		`{"method":"te\"st","id":-1.5e3}`,
+		`{"method":"test","id":9007199254740993}`,
+		`{"method":"test","id":"0001"}`,
+		`{"Method":"test","id":{"a":1}}`,
+		`{"method":"a","method":"b","extra":true,"id":"xA"}`,
+		`{"method":1}`,
+		`{}`,
+		`[]`,
+		`{"method":"test"`,
+	}
+
+	for _, c := range tc {
+		var expected JsonRpcRequest
+		expectedErr := json.Unmarshal([]byte(c), &expected)
+		if string(expected.Id) == "null" {
+			expected.Id = nil // null id is no id
+		}
+
+		req, err := parseRequest([]byte(c))
+		if (err == nil) != (expectedErr == nil) || !reflect.DeepEqual(req, expected) {
+			t.Errorf("parseRequest(%s): got = %+v, %v; expected = %+v, %v", c, req, err, expected, expectedErr)
+		}
+	}
+}
+
+func BenchmarkParseRequest(b *testing.B) {
+	msg := []byte(`{"jsonrpc":"2.0","method":"rpc.users.get","params":{"ids":[1,2,3],"fields":["name","email"]},"id":1}`)
+
+	b.Run("scan", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			scanJsonRpc(msg)
+		}
+	})
+
+	b.Run("parse", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			parseRequest(msg)
+		}
+	})
+
+	b.Run("unmarshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var req JsonRpcRequest
+			json.Unmarshal(msg, &req)
+		}
+	})
+}
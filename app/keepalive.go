@@ -0,0 +1,94 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// keepAliveTargets returns json-rpc over http backend rules of forwarder with unique urls.
+func (hf *HttpForwarder) keepAliveTargets() []ProxyRule {
+	var rules []ProxyRule
+	if hf.route != nil {
+		rules = append(rules, hf.route.rule)
+	} else if len(hf.multipleRules) == 0 {
+		rules = append(rules, ProxyRule{DstUrl: hf.dstUrl})
+	}
+	for _, r := range hf.multipleRules {
+		rules = append(rules, r)
+	}
+
+	var (
+		targets []ProxyRule
+		seen    = make(map[string]bool)
+	)
+	for _, r := range rules {
+		if (r.Protocol == "" || r.Protocol == ProtocolJsonRpc) && !seen[r.DstUrl] {
+			seen[r.DstUrl] = true
+			targets = append(targets, r)
+		}
+	}
+
+	return targets
+}
+
+// StartKeepAlive sends json-rpc notification with method to every backend each interval over idle pooled connections,
+// so stale connections are detected by probes instead of client requests.
+// Probes count is number of idle connections to host if pool stats are enabled, otherwise 1.
+func (hf *HttpForwarder) StartKeepAlive(method string, interval time.Duration) {
+	if method == "" || interval <= 0 {
+		return
+	}
+
+	body, _ := json.Marshal(JsonRpcRequest{JsonRpc: "2.0", Method: method})
+	targets := hf.keepAliveTargets()
+	clients := make([]*http.Client, len(targets))
+	for i, r := range targets {
+		clients[i] = &http.Client{Timeout: time.Duration(hf.timeout) * time.Second, Transport: hf.routeTransport(r.Src)}
+	}
+
+	ticker := hf.clock.NewTicker(interval)
+	go func() {
+		for range ticker.C() {
+			for i, r := range targets {
+				dstUrl := r.DstUrl
+				n := 1
+				if hf.pool != nil {
+					if idle := hf.pool.idle(poolHost(dstUrl)); idle > 1 {
+						n = idle
+					}
+				}
+				if n > hf.maxParallelRequests && hf.maxParallelRequests > 0 {
+					n = hf.maxParallelRequests
+				}
+
+				hf.probe(clients[i], dstUrl, body, n)
+			}
+		}
+	}()
+}
+
+// probe sends n parallel keep-alive requests to dstUrl.
+func (hf *HttpForwarder) probe(client *http.Client, dstUrl string, body []byte, n int) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := hf.doPostRequest(context.Background(), client, body, dstUrl, make(http.Header))
+			if err != nil {
+				return // logged by doPostRequest
+			}
+
+			// read body for connection reuse
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			hf.Tracef("type=keepalive url=%s status=%d", dstUrl, resp.StatusCode)
+		}()
+	}
+	wg.Wait()
+}
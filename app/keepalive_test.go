@@ -0,0 +1,99 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestKeepAliveTargets(t *testing.T) {
+	tests := []struct {
+		name  string
+		dst   string
+		rules []ProxyRule
+		want  []string
+	}{
+		{"single", "http://a/rpc", nil, []string{"http://a/rpc"}},
+		{"multi", "", []ProxyRule{
+			{Src: "/a", DstUrl: "http://a/rpc"},
+			{Src: "/b", DstUrl: "http://b/rpc", Protocol: ProtocolJsonRpc},
+			{Src: "/c", DstUrl: "http://a/rpc"},
+			{Src: "/x", DstUrl: "http://x/rpc", Protocol: ProtocolXmlRpc},
+			{Src: "/s", DstUrl: "http://s/rpc", Protocol: ProtocolSoap},
+		}, []string{"http://a/rpc", "http://b/rpc"}},
+	}
+
+	for _, tt := range tests {
+		hf := NewHttpForwarder(tt.dst, nil, 1, 1)
+		if tt.rules != nil {
+			hf.SetMultiMode(tt.rules)
+		}
+
+		var got []string
+		for _, r := range hf.keepAliveTargets() {
+			got = append(got, r.DstUrl)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStartKeepAlive(t *testing.T) {
+	probes := make(chan JsonRpcRequest, 10)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req JsonRpcRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		probes <- req
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		name     string
+		method   string
+		interval time.Duration
+		probe    bool
+	}{
+		{"disabled by method", "", time.Second, false},
+		{"disabled by interval", "system.ping", 0, false},
+		{"enabled", "system.ping", time.Second, true},
+	}
+
+	for _, tt := range tests {
+		c := clock.NewFake(time.Unix(0, 0))
+		hf := NewHttpForwarder(backend.URL, nil, 1, 1)
+		hf.SetClock(c)
+		hf.StartKeepAlive(tt.method, tt.interval)
+		if (c.Timers() == 1) != tt.probe {
+			t.Errorf("%s: got %d tickers", tt.name, c.Timers())
+			continue
+		} else if !tt.probe {
+			continue
+		}
+
+		// no probes before interval
+		c.Advance(tt.interval / 2)
+		select {
+		case req := <-probes:
+			t.Errorf("%s: unexpected probe %+v", tt.name, req)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		c.Advance(tt.interval / 2)
+		select {
+		case req := <-probes:
+			if req.Method != tt.method || req.Id != nil {
+				t.Errorf("%s: got probe %+v, want %s notification", tt.name, req, tt.method)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: probe was not sent", tt.name)
+		}
+	}
+}
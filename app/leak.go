@@ -0,0 +1,82 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// goroutineTracker counts running goroutines of connection by name: request, mirror, writer, slow_watch.
+type goroutineTracker struct {
+	lock    sync.Mutex
+	running map[string]int
+}
+
+func newGoroutineTracker() *goroutineTracker {
+	return &goroutineTracker{running: make(map[string]int)}
+}
+
+// spawn runs f in tracked goroutine. Nil tracker runs untracked goroutine.
+func (g *goroutineTracker) spawn(name string, f func()) {
+	if g == nil {
+		go f()
+		return
+	}
+
+	g.lock.Lock()
+	g.running[name]++
+	g.lock.Unlock()
+
+	go func() {
+		defer func() {
+			g.lock.Lock()
+			defer g.lock.Unlock()
+			if g.running[name]--; g.running[name] == 0 {
+				delete(g.running, name)
+			}
+		}()
+		f()
+	}()
+}
+
+// count returns running goroutines and sorted names with counts, like mirror=1 request=2.
+func (g *goroutineTracker) count() (int, string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	total, names := 0, make([]string, 0, len(g.running))
+	for name, n := range g.running {
+		total += n
+		names = append(names, fmt.Sprintf("%s=%d", name, n))
+	}
+	sort.Strings(names)
+
+	return total, strings.Join(names, " ")
+}
+
+// SetLeakGrace enables goroutine leak detection: connection goroutines still running after grace since
+// disconnect are logged and counted in goroutine_leaks_total, 0 is disabled. Grace must exceed request timeout.
+func (hf *HttpForwarder) SetLeakGrace(grace time.Duration) {
+	hf.leakGrace = grace
+}
+
+// checkLeaks reports connection goroutines running after leak grace, called on disconnect.
+func (hf *HttpForwarder) checkLeaks(rf *requestForwarder) {
+	if hf.leakGrace <= 0 {
+		return
+	}
+
+	hf.clock.AfterFunc(hf.leakGrace, func() {
+		n, running := rf.goroutines.count()
+		if n == 0 {
+			return
+		}
+
+		rf.Errorf("goroutine leak goroutines=%d running=%q grace=%s", n, running, hf.leakGrace)
+		if hf.statGoroutineLeaks != nil {
+			hf.statGoroutineLeaks.WithLabelValues(rf.conn.Route).Inc()
+		}
+	})
+}
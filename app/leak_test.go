@@ -0,0 +1,49 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestCheckLeaks(t *testing.T) {
+	c := clock.NewFake(time.Now())
+
+	leaks := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "leaks"}, []string{"uri"})
+	hf := NewHttpForwarder("/", nil, 0, 1)
+	hf.SetClock(c)
+	hf.SetLeakGrace(time.Minute)
+	hf.statGoroutineLeaks = leaks
+
+	rf := &requestForwarder{goroutines: newGoroutineTracker(), conn: ConnInfo{Route: "/rpc"}}
+	block, done := make(chan struct{}), make(chan struct{})
+	rf.goroutines.spawn("request", func() { <-block })
+	rf.goroutines.spawn("writer", func() { close(done) })
+	<-done
+	for n, _ := rf.goroutines.count(); n != 1; n, _ = rf.goroutines.count() {
+		time.Sleep(time.Millisecond)
+	}
+	if _, running := rf.goroutines.count(); running != "request=1" {
+		t.Errorf("got %q", running)
+	}
+
+	hf.checkLeaks(rf)
+	c.Advance(time.Minute)
+	if n := testutil.ToFloat64(leaks.WithLabelValues("/rpc")); n != 1 {
+		t.Errorf("got %v leaks", n)
+	}
+
+	// no leak after goroutine exit
+	close(block)
+	for n, _ := rf.goroutines.count(); n != 0; n, _ = rf.goroutines.count() {
+		time.Sleep(time.Millisecond)
+	}
+	hf.checkLeaks(rf)
+	c.Advance(time.Minute)
+	if n := testutil.ToFloat64(leaks.WithLabelValues("/rpc")); n != 1 {
+		t.Errorf("got %v leaks", n)
+	}
+}
@@ -22,29 +22,41 @@ type Logger interface {
 type logger struct {
 	logLevel         LogLevel
 	warn, log, trace Logger
+	fields           string // key=value pairs appended to every message
 }
 
 // Tracef prints message to Stdout (l.trace variable).
 func (l logger) Tracef(format string, v ...interface{}) {
 	if l.trace != nil && l.logLevel >= LogTrace {
-		l.trace.Output(2, fmt.Sprintf(format, v...))
+		l.trace.Output(2, fmt.Sprintf(format, v...)+l.fields)
 	}
 }
 
 // Printf prints message to Stdout (l.log variable).
 func (l logger) Printf(format string, v ...interface{}) {
 	if l.log != nil && l.logLevel >= LogVerbose {
-		l.log.Output(2, fmt.Sprintf(format, v...))
+		l.log.Output(2, fmt.Sprintf(format, v...)+l.fields)
 	}
 }
 
 // Errorf prints message to Stderr (l.warn variable an logLevel is set).
 func (l logger) Errorf(format string, v ...interface{}) {
 	if l.warn != nil && l.logLevel >= LogError {
-		l.warn.Output(2, fmt.Sprintf(format, v...))
+		l.warn.Output(2, fmt.Sprintf(format, v...)+l.fields)
 	}
 }
 
+// withFields returns copy of logger with key=value pairs appended to every message. Empty values are skipped.
+func (l logger) withFields(kv ...string) logger {
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] != "" {
+			l.fields += " " + kv[i] + "=" + kv[i+1]
+		}
+	}
+
+	return l
+}
+
 // SetStdLoggers initializes trace,log,warn with std loggers.
 func (l *logger) SetStdLoggers() {
 	l.trace = log.New(os.Stdout, "T", log.LstdFlags|log.Lshortfile)
@@ -0,0 +1,40 @@
+package app
+
+import (
+	"math/rand"
+	"time"
+)
+
+// mirror asynchronously sends copy of request to route MirrorUrl for MirrorPercent of requests, responses
+// are ignored. Mirrored requests are limited by max parallel requests, requests over limit are not mirrored.
+func (hf *HttpForwarder) mirror(rf *requestForwarder, req BackendRequest) {
+	rs := hf.routeState(req.Route)
+	if rs == nil || rs.rule.MirrorUrl == "" || rand.Float64()*100 >= rs.rule.MirrorPercent {
+		return
+	}
+
+	select {
+	case hf.mirrorSlots <- struct{}{}:
+	default:
+		rf.Tracef("type=mirror_skipped url=%s method=%s", rs.rule.MirrorUrl, req.Request.Method)
+		return
+	}
+
+	// shared client with request timeout and without connection cookies
+	var backend Backend = httpBackend{hf: hf, client: hf.authClient}
+	if rs.backend != nil {
+		backend = rs.backend
+	}
+	req.DstUrl = rs.rule.MirrorUrl
+
+	rf.goroutines.spawn("mirror", func() {
+		defer func() { <-hf.mirrorSlots }()
+
+		now := time.Now()
+		if _, err := backend.Do(rf.ctx, req); err != nil {
+			rf.Tracef("type=mirror url=%s method=%s duration=%s err=%s", req.DstUrl, req.Request.Method, time.Since(now), err)
+			return
+		}
+		rf.Tracef("type=mirror url=%s method=%s duration=%s", req.DstUrl, req.Request.Method, time.Since(now))
+	})
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestHttpForwarderMirror(t *testing.T) {
+	mirrored := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mirrored <- body
+	}))
+	defer srv.Close()
+
+	hf := NewHttpForwarder("/", nil, 1, 1)
+	rf := hf.newRequestForwarder(&wsConn{})
+	req := BackendRequest{Request: JsonRpcRequest{JsonRpc: "2.0", Method: "users.get"}, Msg: []byte(`{"jsonrpc":"2.0","method":"users.get"}`), Route: "/rpc", Header: http.Header{}}
+
+	// disabled
+	hf.route = &routeState{rule: ProxyRule{Src: "/rpc", MirrorUrl: srv.URL, MirrorPercent: 0}, clock: clock.Real}
+	hf.mirror(rf, req)
+
+	hf.route = &routeState{rule: ProxyRule{Src: "/rpc", MirrorUrl: srv.URL, MirrorPercent: 100}, clock: clock.Real}
+	hf.mirror(rf, req)
+
+	select {
+	case body := <-mirrored:
+		if string(body) != string(req.Msg) {
+			t.Errorf("got %s", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request was not mirrored")
+	}
+
+	select {
+	case body := <-mirrored:
+		t.Errorf("mirrored with 0 percent: %s", body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,333 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// MQTT 3.1.1 control packet types.
+const (
+	mqttConnect     = 1
+	mqttConnack     = 2
+	mqttPublish     = 3
+	mqttPuback      = 4
+	mqttSubscribe   = 8
+	mqttSuback      = 9
+	mqttUnsubscribe = 10
+	mqttUnsuback    = 11
+	mqttPingreq     = 12
+	mqttPingresp    = 13
+	mqttDisconnect  = 14
+
+	mqttSubprotocol    = "mqtt"
+	mqttResponseSuffix = "/response"
+	mqttMaxPacketSize  = 1 << 20 // max remaining length of client packets
+)
+
+var (
+	errMqttPacket     = errors.New("malformed mqtt packet")
+	errMqttPacketSize = errors.New("mqtt packet size limit exceeded")
+)
+
+// mqttPacket is a parsed MQTT control packet.
+type mqttPacket struct {
+	kind  byte
+	flags byte
+	body  []byte // variable header and payload
+}
+
+// readMqttPacket reads one packet from buf. Returns false if packet is incomplete. Packets with remaining length
+// over mqttMaxPacketSize are rejected before they are buffered.
+func readMqttPacket(buf *bytes.Buffer) (mqttPacket, bool, error) {
+	data := buf.Bytes()
+	if len(data) < 2 {
+		return mqttPacket{}, false, nil
+	}
+
+	// remaining length is variable byte integer
+	length, mul, i := 0, 1, 1
+	for ; ; i++ {
+		if i >= len(data) {
+			return mqttPacket{}, false, nil
+		} else if i > 4 {
+			return mqttPacket{}, false, errMqttPacket
+		}
+
+		length += int(data[i]&0x7f) * mul
+		mul *= 128
+		if data[i]&0x80 == 0 {
+			break
+		}
+	}
+
+	if length > mqttMaxPacketSize {
+		return mqttPacket{}, false, errMqttPacketSize
+	} else if len(data) < i+1+length {
+		return mqttPacket{}, false, nil
+	}
+
+	p := mqttPacket{kind: data[0] >> 4, flags: data[0] & 0x0f, body: append([]byte(nil), data[i+1:i+1+length]...)}
+	buf.Next(i + 1 + length)
+	return p, true, nil
+}
+
+// encode returns packet with fixed header.
+func (p mqttPacket) encode() []byte {
+	b := []byte{p.kind<<4 | p.flags}
+	for l := len(p.body); ; {
+		d := byte(l % 128)
+		if l /= 128; l > 0 {
+			d |= 0x80
+		}
+		b = append(b, d)
+		if l == 0 {
+			break
+		}
+	}
+
+	return append(b, p.body...)
+}
+
+// mqttString reads length-prefixed string from b.
+func mqttString(b []byte) (string, []byte, error) {
+	if len(b) < 2 || len(b) < 2+int(binary.BigEndian.Uint16(b)) {
+		return "", nil, errMqttPacket
+	}
+
+	l := int(binary.BigEndian.Uint16(b))
+	return string(b[2 : 2+l]), b[2+l:], nil
+}
+
+// appendMqttString appends length-prefixed string to b.
+func appendMqttString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
+
+// topicMethod converts topic into json-rpc method: rpc/users/get -> rpc.users.get.
+func topicMethod(topic string) string {
+	return strings.Replace(strings.Trim(topic, "/"), "/", ".", -1)
+}
+
+// methodTopic converts json-rpc method into topic: rpc.users.get -> rpc/users/get.
+func methodTopic(method string) string {
+	return strings.Replace(method, ".", "/", -1)
+}
+
+// matchTopic checks topic by MQTT topic filter with + and # wildcards.
+func matchTopic(filter, topic string) bool {
+	fl, tl := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, f := range fl {
+		if f == "#" {
+			return true
+		} else if i >= len(tl) || (f != "+" && f != tl[i]) {
+			return false
+		}
+	}
+
+	return len(fl) == len(tl)
+}
+
+// mqttConn is a MQTT-over-WebSocket client session. Published messages are json-rpc calls with
+// topic as method and payload as params, responses are published to topic + /response.
+// Notifications (broadcasts) are published to subscribers with method as topic.
+type mqttConn struct {
+	rf *requestForwarder
+
+	lock          sync.RWMutex
+	subscriptions map[string]struct{}
+	lastId        int
+}
+
+// write sends packet as binary frame.
+func (c *mqttConn) write(p mqttPacket) error {
+	return c.rf.write(p.encode())
+}
+
+// publish sends QoS 0 PUBLISH packet.
+func (c *mqttConn) publish(topic string, payload []byte) error {
+	return c.write(mqttPacket{kind: mqttPublish, body: append(appendMqttString(nil, topic), payload...)})
+}
+
+// subscribed checks topic by session subscriptions.
+func (c *mqttConn) subscribed(topic string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for f := range c.subscriptions {
+		if matchTopic(f, topic) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notify publishes json-rpc notification params to subscribers of method topic.
+func (c *mqttConn) notify(msg []byte) error {
+	var n JsonRpcRequest
+	if err := json.Unmarshal(msg, &n); err != nil {
+		return err
+	}
+
+	topic := methodTopic(n.Method)
+	if !c.subscribed(topic) {
+		return nil
+	}
+
+	payload := []byte("null")
+	if n.Params != nil {
+		payload = *n.Params
+	}
+
+	return c.publish(topic, payload)
+}
+
+// newMqttConn returns MQTT session for connection, session notifications are published to subscribers.
+func newMqttConn(rf *requestForwarder) *mqttConn {
+	c := &mqttConn{rf: rf, subscriptions: make(map[string]struct{})}
+	rf.session.send = c.notify
+	return c
+}
+
+// isMqttConn checks websocket subprotocol for MQTT.
+func isMqttConn(ws *wsConn) bool {
+	return ws.Subprotocol() == mqttSubprotocol
+}
+
+// mqttLoop handles MQTT-over-WebSocket session.
+func (hf *HttpForwarder) mqttLoop(c *mqttConn) {
+	var (
+		rf  = c.rf
+		buf bytes.Buffer
+		msg []byte
+		err error
+	)
+
+	for {
+		if _, msg, err = rf.ws.Receive(); err != nil {
+			if err != io.EOF {
+				rf.Errorf("error while receiving mqtt data from client err=%s", err)
+			}
+			return
+		}
+
+		buf.Write(msg)
+		for {
+			p, ok, err := readMqttPacket(&buf)
+			if err == errMqttPacketSize {
+				rf.Errorf("mqtt err=%s", err)
+				rf.ws.closeWith(websocket.CloseMessageTooBig, "packet too big")
+				return
+			} else if err != nil {
+				rf.Errorf("mqtt err=%s", err)
+				return
+			} else if !ok {
+				break
+			}
+
+			if err = hf.handleMqttPacket(c, p); err == io.EOF {
+				return
+			} else if err != nil {
+				rf.Errorf("mqtt err=%s packet=%d", err, p.kind)
+				return
+			}
+		}
+	}
+}
+
+// handleMqttPacket handles one MQTT packet. Returns io.EOF on DISCONNECT.
+func (hf *HttpForwarder) handleMqttPacket(c *mqttConn, p mqttPacket) error {
+	switch p.kind {
+	case mqttConnect:
+		return c.write(mqttPacket{kind: mqttConnack, body: []byte{0, 0}})
+	case mqttPingreq:
+		return c.write(mqttPacket{kind: mqttPingresp})
+	case mqttDisconnect:
+		return io.EOF
+	case mqttPublish:
+		topic, rest, err := mqttString(p.body)
+		if err != nil {
+			return err
+		}
+
+		// QoS 1: acknowledge with packet id, QoS 2 is not supported
+		if qos := (p.flags >> 1) & 0x03; qos == 1 {
+			if len(rest) < 2 {
+				return errMqttPacket
+			}
+			if err = c.write(mqttPacket{kind: mqttPuback, body: rest[:2]}); err != nil {
+				return err
+			}
+			rest = rest[2:]
+		} else if qos > 1 {
+			return errMqttPacket
+		}
+
+		return hf.mqttCall(c, topic, rest)
+	case mqttSubscribe, mqttUnsubscribe:
+		if len(p.body) < 2 {
+			return errMqttPacket
+		}
+
+		pid, rest, codes := p.body[:2], p.body[2:], []byte{}
+		for len(rest) > 0 {
+			filter, r, err := mqttString(rest)
+			if err != nil {
+				return err
+			}
+
+			c.lock.Lock()
+			if p.kind == mqttSubscribe {
+				if len(r) < 1 {
+					c.lock.Unlock()
+					return errMqttPacket
+				}
+				c.subscriptions[filter] = struct{}{}
+				codes, r = append(codes, 0), r[1:] // granted QoS 0
+			} else {
+				delete(c.subscriptions, filter)
+			}
+			c.lock.Unlock()
+			rest = r
+		}
+
+		if p.kind == mqttSubscribe {
+			return c.write(mqttPacket{kind: mqttSuback, body: append(append([]byte{}, pid...), codes...)})
+		}
+		return c.write(mqttPacket{kind: mqttUnsuback, body: pid})
+	}
+
+	return nil
+}
+
+// mqttCall converts PUBLISH into json-rpc request and publishes response to topic + /response.
+func (hf *HttpForwarder) mqttCall(c *mqttConn, topic string, payload []byte) error {
+	params := json.RawMessage(payload)
+	if !json.Valid(payload) {
+		b, _ := json.Marshal(string(payload))
+		params = b
+	}
+
+	c.lock.Lock()
+	c.lastId++
+	id := c.lastId
+	c.lock.Unlock()
+
+	msg, err := json.Marshal(JsonRpcRequest{JsonRpc: "2.0", Id: json.RawMessage(strconv.Itoa(id)), Method: topicMethod(topic), Params: &params})
+	if err != nil {
+		return err
+	}
+
+	hf.handleRequest(c.rf, msg, func(resp []byte) error {
+		return c.publish(strings.TrimSuffix(topic, "/")+mqttResponseSuffix, resp)
+	})
+	return nil
+}
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMqttPacket(t *testing.T) {
+	p := mqttPacket{kind: mqttPublish, body: append(appendMqttString(nil, "rpc/test"), bytes.Repeat([]byte("a"), 200)...)}
+
+	var buf bytes.Buffer
+	data := p.encode()
+	buf.Write(data[:2])
+	if _, ok, err := readMqttPacket(&buf); ok || err != nil {
+		t.Fatalf("readMqttPacket(): expected incomplete packet, got ok=%v err=%v", ok, err)
+	}
+
+	buf.Write(data[2:])
+	rp, ok, err := readMqttPacket(&buf)
+	if !ok || err != nil || rp.kind != p.kind || !bytes.Equal(rp.body, p.body) || buf.Len() != 0 {
+		t.Errorf("readMqttPacket(): got = %v, %v, %v", rp, ok, err)
+	}
+
+	if topic, _, err := mqttString(rp.body); topic != "rpc/test" || err != nil {
+		t.Errorf("mqttString(): got = %v, %v", topic, err)
+	}
+
+	// oversized packet is rejected by header
+	buf.Reset()
+	buf.Write(mqttPacket{kind: mqttPublish, body: make([]byte, mqttMaxPacketSize+1)}.encode()[:5])
+	if _, ok, err := readMqttPacket(&buf); ok || err != errMqttPacketSize {
+		t.Errorf("readMqttPacket(): expected size error, got ok=%v err=%v", ok, err)
+	}
+}
+
+func TestMatchTopic(t *testing.T) {
+	var tc = []struct {
+		filter, topic string
+		match         bool
+	}{
+		{"rpc/users/get", "rpc/users/get", true},
+		{"rpc/+/get", "rpc/users/get", true},
+		{"rpc/#", "rpc/users/get", true},
+		{"rpc/+", "rpc/users/get", false},
+		{"rpc/users", "rpc/users/get", false},
+	}
+
+	for _, c := range tc {
+		if m := matchTopic(c.filter, c.topic); m != c.match {
+			t.Errorf("matchTopic(%s, %s): got = %v; expected = %v", c.filter, c.topic, m, c.match)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// Backend network failure statuses for metrics and health.
+const (
+	statusTimeout     = "timeout"
+	statusDnsError    = "dns_error"
+	statusConnRefused = "connection_refused"
+	statusTlsError    = "tls_error"
+)
+
+// Client errors for backend network failures, they don't disclose backend urls.
+var (
+	errBackendTimeout = errors.New("backend request timeout")
+	errBackendDns     = errors.New("backend host resolution failed")
+	errBackendRefused = errors.New("backend connection refused")
+	errBackendTls     = errors.New("backend tls handshake failed")
+)
+
+// netErrorStatus classifies backend request error: dns_error, connection_refused, tls_error, timeout or empty
+// string for other errors.
+func netErrorStatus(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var (
+		dnsErr      *net.DNSError
+		authErr     x509.UnknownAuthorityError
+		hostErr     x509.HostnameError
+		certErr     x509.CertificateInvalidError
+		verifyErr   *tls.CertificateVerificationError
+		recordErr   tls.RecordHeaderError
+		timeoutErr  errTimeout
+		alertErr    tls.AlertError
+		opErr       *net.OpError
+		isTlsFailed = errors.As(err, &authErr) || errors.As(err, &hostErr) || errors.As(err, &certErr) ||
+			errors.As(err, &verifyErr) || errors.As(err, &recordErr) || errors.As(err, &alertErr)
+	)
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return statusDnsError
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return statusConnRefused
+	case isTlsFailed, errors.As(err, &opErr) && strings.HasPrefix(opErr.Op, "remote error"):
+		return statusTlsError
+	case errors.As(err, &timeoutErr) && timeoutErr.Timeout():
+		return statusTimeout
+	}
+
+	return ""
+}
+
+// backendErrorCode returns json-rpc error code and client error for backend request error.
+func backendErrorCode(err error) (int, error) {
+	switch netErrorStatus(err) {
+	case statusTimeout:
+		return JsonRpcTimeout, errBackendTimeout
+	case statusDnsError:
+		return JsonRpcDnsError, errBackendDns
+	case statusConnRefused:
+		return JsonRpcConnRefused, errBackendRefused
+	case statusTlsError:
+		return JsonRpcTlsError, errBackendTls
+	}
+
+	return JsonRpcServerErr, err
+}
@@ -0,0 +1,52 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNetErrorStatus(t *testing.T) {
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsSrv.Close()
+
+	slowSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { time.Sleep(100 * time.Millisecond) }))
+	defer slowSrv.Close()
+
+	get := func(url string, timeout time.Duration) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	tests := []struct {
+		err    error
+		status string
+		code   int
+	}{
+		{&net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "backend.local", IsNotFound: true}}, statusDnsError, JsonRpcDnsError},
+		{get("http://127.0.0.1:1", time.Second), statusConnRefused, JsonRpcConnRefused},
+		{get(tlsSrv.URL, time.Second), statusTlsError, JsonRpcTlsError},
+		{get(slowSrv.URL, 10*time.Millisecond), statusTimeout, JsonRpcTimeout},
+		{&BackendError{Code: -32000, Err: context.DeadlineExceeded}, statusTimeout, JsonRpcTimeout},
+		{errors.New("invalid response"), "", JsonRpcServerErr},
+	}
+
+	for _, tt := range tests {
+		if status := netErrorStatus(tt.err); status != tt.status {
+			t.Errorf("got %q for %v, want %q", status, tt.err, tt.status)
+		}
+		if code, _ := backendErrorCode(tt.err); code != tt.code {
+			t.Errorf("got %d for %v, want %d", code, tt.err, tt.code)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestHttpForwarderNotifications(t *testing.T) {
+	var notified int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req JsonRpcRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Id == nil {
+			atomic.AddInt32(&notified, 1)
+			w.Write([]byte(`{"jsonrpc":"2.0","id":null,"result":"ignored"}`))
+			return
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":` + string(req.Id) + `,"result":true}`))
+	}))
+	defer backend.Close()
+
+	routes, _ := newRouteStates([]ProxyRule{{Src: "/", DstUrl: backend.URL, CountNotifications: true}}, clock.Real)
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests"}, []string{"url", "method", "status"})
+	durations := prometheus.NewSummaryVec(prometheus.SummaryOpts{Name: "durations"}, []string{"url", "method", "code"})
+	notifications := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "notifications"}, []string{"url", "method", "status"})
+
+	hf := NewHttpForwarder(backend.URL, nil, 10, 2)
+	hf.route = routes["/"]
+	hf.SetStats(requests, durations, nil)
+	hf.statNotifications = notifications
+	srv := httptest.NewServer(hf.WebsocketHandler())
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial(strings.Replace(srv.URL, "http", "ws", 1), http.Header{"Origin": {srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// backend response to notification is not sent to client
+	ws.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"log"}`))
+	for deadline := time.Now().Add(2 * time.Second); atomic.LoadInt32(&notified) == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("notification was not forwarded")
+		}
+	}
+	ws.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"get","id":7}`))
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, msg, err := ws.ReadMessage(); err != nil {
+		t.Fatal(err)
+	} else if string(msg) != `{"jsonrpc":"2.0","id":7,"result":true}` {
+		t.Errorf("got %s", msg)
+	}
+
+	for deadline := time.Now().Add(2 * time.Second); testutil.ToFloat64(notifications.WithLabelValues("/", "log", "ok")) != 1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("notification was not counted")
+		}
+	}
+	if n := testutil.CollectAndCount(requests); n != 1 {
+		t.Errorf("got %d request series", n)
+	}
+}
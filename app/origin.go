@@ -0,0 +1,107 @@
+package app
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxOriginLabels limits distinct origin label values of rejected origins metric, other origins are counted as "other".
+const maxOriginLabels = 100
+
+// originPattern matches Origin header values: exact origin, wildcard like https://*.example.com or regex prefixed
+// with ~, like ~^https://app[0-9]+\.example\.com$.
+type originPattern struct {
+	exact string
+	re    *regexp.Regexp
+}
+
+// compileOrigins returns patterns of allowed origins, empty values are skipped.
+func compileOrigins(origins []string) ([]originPattern, error) {
+	var patterns []originPattern
+	for _, o := range origins {
+		switch {
+		case o == "":
+			continue
+		case o == "*":
+			patterns = append(patterns, originPattern{re: regexp.MustCompile(".")})
+		case strings.HasPrefix(o, "~"):
+			re, err := regexp.Compile(o[1:])
+			if err != nil {
+				return nil, err
+			}
+			patterns = append(patterns, originPattern{re: re})
+		case strings.Contains(o, "*"):
+			parts := strings.Split(o, "*")
+			for i := range parts {
+				parts[i] = regexp.QuoteMeta(parts[i])
+			}
+			// wildcard matches one or more host labels, not scheme or port
+			patterns = append(patterns, originPattern{re: regexp.MustCompile("^" + strings.Join(parts, `[^/:]+`) + "$")})
+		default:
+			patterns = append(patterns, originPattern{exact: o})
+		}
+	}
+
+	return patterns, nil
+}
+
+func (p originPattern) match(origin string) bool {
+	if p.re != nil {
+		return p.re.MatchString(origin)
+	}
+
+	return p.exact == origin
+}
+
+// originHook rejects upgrades with Origin not matching any of patterns. Upgrades without Origin header are allowed
+// with allowMissing, they come from non-browser clients. Rejections are counted by origin in rejected, optional.
+func originHook(status int, allowMissing bool, patterns []originPattern, rejected *prometheus.CounterVec) UpgradeHook {
+	var (
+		lock   sync.Mutex
+		labels = make(map[string]struct{})
+	)
+	label := func(origin string) string {
+		lock.Lock()
+		defer lock.Unlock()
+		if _, ok := labels[origin]; !ok && len(labels) >= maxOriginLabels {
+			return "other"
+		}
+		labels[origin] = struct{}{}
+		return origin
+	}
+
+	return func(r *http.Request) error {
+		origin := r.Header.Get("Origin")
+		if origin == "" && allowMissing {
+			return nil
+		}
+		for _, p := range patterns {
+			if p.match(origin) {
+				return nil
+			}
+		}
+
+		if rejected != nil {
+			rejected.WithLabelValues(label(origin)).Inc()
+		}
+
+		return &UpgradeError{Status: status, Message: "origin is not allowed: " + origin}
+	}
+}
+
+// originCheckHook returns origin hook of App: browser mode requires allowed Origin, otherwise allowed origins are
+// checked for upgrades with Origin header. Returns nil hook if origins are not checked.
+func (a *App) originCheckHook() (UpgradeHook, error) {
+	patterns, err := compileOrigins(a.AllowedOrigins)
+	if err != nil {
+		return nil, err
+	} else if !a.BrowserMode && len(patterns) == 0 {
+		return nil, nil
+	}
+
+	return originHook(http.StatusForbidden, !a.BrowserMode, patterns, a.statOriginRejected), nil
+}
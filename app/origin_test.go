@@ -0,0 +1,135 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCompileOrigins(t *testing.T) {
+	patterns, err := compileOrigins([]string{"", "https://example.com", "https://*.example.org", `~^https://app[0-9]+\.example\.net$`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]bool{
+		"https://example.com":           true,
+		"http://example.com":            false,
+		"https://a.example.org":         true,
+		"https://a.b.example.org":       true,
+		"https://example.org":           false,
+		"https://evil.com/.example.org": false,
+		"https://a.example.org:8443":    false,
+		"https://app12.example.net":     true,
+		"https://app.example.net":       false,
+		"":                              false,
+	}
+	for origin, expected := range tests {
+		matched := false
+		for _, p := range patterns {
+			matched = matched || p.match(origin)
+		}
+		if matched != expected {
+			t.Errorf("%q: got %v", origin, matched)
+		}
+	}
+
+	if _, err := compileOrigins([]string{"~(["}); err == nil {
+		t.Error("invalid regex: expected error")
+	}
+}
+
+func TestOriginCheckHook(t *testing.T) {
+	upgrade := func(hook UpgradeHook, origin string) error {
+		r := httptest.NewRequest(http.MethodGet, "/rpc", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return hook(r)
+	}
+
+	// origins are not checked by default
+	if hook, err := (&App{AllowedOrigins: []string{""}}).originCheckHook(); hook != nil || err != nil {
+		t.Errorf("no origins: got %v", err)
+	}
+
+	rejected := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "rejected"}, []string{"origin"})
+	a := &App{AllowedOrigins: []string{"https://*.example.com"}, statOriginRejected: rejected}
+	hook, err := a.originCheckHook()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upgrade(hook, ""); err != nil {
+		t.Errorf("non-browser client: got %v", err)
+	}
+	if err := upgrade(hook, "https://app.example.com"); err != nil {
+		t.Errorf("allowed: got %v", err)
+	}
+	if err, ok := upgrade(hook, "https://evil.com").(*UpgradeError); !ok || err.Status != http.StatusForbidden {
+		t.Errorf("not allowed: got %v", err)
+	}
+	if v := testutil.ToFloat64(rejected.WithLabelValues("https://evil.com")); v != 1 {
+		t.Errorf("got rejected %v", v)
+	}
+
+	// origin labels are limited
+	for i := 0; i < maxOriginLabels; i++ {
+		upgrade(hook, "https://evil"+strconv.Itoa(i)+".com")
+	}
+	if v := testutil.ToFloat64(rejected.WithLabelValues("other")); v != 1 {
+		t.Errorf("got other %v", v)
+	}
+
+	// browser mode requires Origin
+	a.BrowserMode = true
+	hook, _ = a.originCheckHook()
+	if err := upgrade(hook, ""); err == nil {
+		t.Error("browser mode: expected error")
+	}
+}
+
+func TestAllowMissingOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		browser bool
+		origins []string
+		origin  string
+		allowed bool
+	}{
+		{"allow-list without origin", false, []string{"https://*.example.com"}, "", true},
+		{"allow-list allowed", false, []string{"https://*.example.com"}, "https://app.example.com", true},
+		{"allow-list rejected", false, []string{"https://*.example.com"}, "https://evil.com", false},
+		{"browser mode without origin", true, []string{"https://*.example.com"}, "", false},
+		{"no allow-list without origin", false, nil, "", false},
+	}
+
+	for _, tt := range tests {
+		a := &App{BrowserMode: tt.browser, AllowedOrigins: tt.origins, RedirectRules: []ProxyRule{{Src: "/rpc", DstUrl: "http://localhost/rpc"}}}
+		h, err := a.Handler()
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := httptest.NewServer(h)
+
+		header := http.Header{}
+		if tt.origin != "" {
+			header.Set("Origin", tt.origin)
+		}
+		ws, resp, err := websocket.DefaultDialer.Dial(strings.Replace(srv.URL, "http", "ws", 1)+"/rpc", header)
+		if tt.allowed && err != nil {
+			t.Errorf("%s: got %v", tt.name, err)
+		} else if !tt.allowed && (err == nil || resp.StatusCode != http.StatusForbidden) {
+			t.Errorf("%s: expected 403, got %v", tt.name, err)
+		}
+		if ws != nil {
+			ws.Close()
+		}
+		srv.Close()
+	}
+}
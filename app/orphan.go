@@ -0,0 +1,152 @@
+package app
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+// Orphan backend response reasons.
+const (
+	orphanUnknownId  = "unknown_id" // response id was never requested on connection
+	orphanDuplicate  = "duplicate"  // response id was already answered
+	orphanMismatched = "mismatched" // response id belongs to other outstanding request
+)
+
+// correlationEntry is an outstanding request id, count is more than 1 if client reuses ids.
+type correlationEntry struct {
+	count int
+	at    time.Time
+}
+
+// correlationCache tracks outstanding request ids of connection with request times and recently answered ids.
+// Responses are matched against cache to catch backend id bugs, entries expire after ttl.
+type correlationCache struct {
+	ttl   time.Duration
+	clock clock.Clock
+
+	lock     sync.Mutex
+	pending  map[string]correlationEntry
+	answered map[string]time.Time
+	swept    time.Time
+}
+
+// newCorrelationCache returns cache with ttl on clock c or nil if ttl is 0, nil cache is disabled.
+func newCorrelationCache(ttl time.Duration, c clock.Clock) *correlationCache {
+	if ttl <= 0 {
+		return nil
+	}
+
+	return &correlationCache{ttl: ttl, clock: c, pending: make(map[string]correlationEntry), answered: make(map[string]time.Time)}
+}
+
+// request adds outstanding request id, notifications are not tracked.
+func (c *correlationCache) request(id json.RawMessage) {
+	if c == nil || id == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.clock.Now()
+	c.expire(now)
+
+	key := requestKey(id)
+	e := c.pending[key]
+	e.count++
+	e.at = now
+	c.pending[key] = e
+}
+
+// response settles requested id by response with id got and returns orphan reason or empty string.
+// Responses without id, like empty responses for notifications, only settle requested id.
+func (c *correlationCache) response(requested, got json.RawMessage) string {
+	if c == nil || requested == nil {
+		return ""
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := requestKey(requested)
+	_, outstanding := c.pending[key]
+	if outstanding {
+		c.settle(key)
+	}
+
+	if got == nil || string(got) == "null" {
+		return ""
+	}
+
+	gotKey := requestKey(got)
+	if gotKey == key && outstanding {
+		return ""
+	} else if _, ok := c.answered[gotKey]; ok {
+		return orphanDuplicate
+	} else if _, ok := c.pending[gotKey]; ok {
+		return orphanMismatched
+	} else if gotKey == key {
+		return "" // requested id was expired as stale
+	}
+
+	return orphanUnknownId
+}
+
+// settle moves outstanding id into answered ids, must be called under lock.
+func (c *correlationCache) settle(key string) {
+	if e := c.pending[key]; e.count > 1 {
+		e.count--
+		c.pending[key] = e
+		return
+	}
+
+	delete(c.pending, key)
+	c.answered[key] = c.clock.Now()
+}
+
+// expire removes answered and stale outstanding ids older than ttl, must be called under lock.
+func (c *correlationCache) expire(now time.Time) {
+	if now.Sub(c.swept) < c.ttl {
+		return
+	}
+
+	c.swept = now
+	for key, at := range c.answered {
+		if now.Sub(at) >= c.ttl {
+			delete(c.answered, key)
+		}
+	}
+	for key, e := range c.pending {
+		if now.Sub(e.at) >= c.ttl {
+			delete(c.pending, key)
+		}
+	}
+}
+
+// SetCorrelationTtl enables orphan backend responses detection: request ids are tracked per connection, responses
+// with never requested, already answered or other outstanding ids are logged and counted in orphan_responses_total.
+// Answered and stale ids expire after ttl, 0 is disabled.
+func (hf *HttpForwarder) SetCorrelationTtl(ttl time.Duration) {
+	hf.correlationTtl = ttl
+}
+
+// correlate matches response for client with request id and reports orphan responses.
+func (hf *HttpForwarder) correlate(rf *requestForwarder, rpcReq rpcRequest, resp []byte) {
+	var got json.RawMessage
+	if f, ok := scanJsonRpc(resp); ok && len(f.id) > 0 {
+		got = f.id
+	}
+
+	reason := rf.correlation.response(rpcReq.req.Id, got)
+	if reason == "" {
+		return
+	}
+
+	rf.Errorf("orphan response reason=%s id=%s requested=%s method=%s", reason, got, rpcReq.req.Id, rpcReq.req.Method)
+	if hf.statOrphanResponses != nil {
+		hf.statOrphanResponses.WithLabelValues(rpcReq.srcUrl, reason).Inc()
+	}
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestCorrelationCache(t *testing.T) {
+	c := clock.NewFake(time.Now())
+
+	id := func(s string) json.RawMessage { return json.RawMessage(s) }
+	cc := newCorrelationCache(time.Minute, c)
+	cc.request(id(`1`))
+	cc.request(id(`"a"`))
+	cc.request(id(`2`))
+
+	for _, tc := range []struct {
+		requested, got json.RawMessage
+		reason         string
+	}{
+		{id(`1`), id(`1`), ""},
+		{id(`"a"`), id(`1`), orphanDuplicate},
+		{id(`2`), id(`3`), orphanUnknownId},
+		{id(`4`), id(`4`), ""}, // not tracked
+		{id(`5`), nil, ""},
+	} {
+		if reason := cc.response(tc.requested, tc.got); reason != tc.reason {
+			t.Errorf("response(%s, %s): got %q, want %q", tc.requested, tc.got, reason, tc.reason)
+		}
+	}
+
+	// response with id of other outstanding request
+	cc.request(id(`6`))
+	cc.request(id(`7`))
+	if reason := cc.response(id(`6`), id(`7`)); reason != orphanMismatched {
+		t.Errorf("mismatched: got %q", reason)
+	}
+
+	// reused ids are counted
+	cc.request(id(`8`))
+	cc.request(id(`8`))
+	if cc.response(id(`8`), id(`8`)) != "" || cc.response(id(`8`), id(`8`)) != "" {
+		t.Error("reused id: got orphan")
+	}
+
+	// answered and stale ids expire
+	c.Advance(time.Minute)
+	cc.request(id(`9`))
+	if _, ok := cc.answered[requestKey(id(`1`))]; ok {
+		t.Error("answered id was not expired")
+	}
+	if _, ok := cc.pending[requestKey(id(`7`))]; ok {
+		t.Error("stale id was not expired")
+	}
+
+	if newCorrelationCache(0, c).response(id(`1`), id(`2`)) != "" {
+		t.Error("disabled cache: got orphan")
+	}
+}
+
+func TestHttpForwarderCorrelate(t *testing.T) {
+	orphans := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "orphans"}, []string{"url", "reason"})
+	hf := NewHttpForwarder("/", nil, 0, 1)
+	hf.statOrphanResponses = orphans
+
+	rf := &requestForwarder{correlation: newCorrelationCache(time.Minute, hf.clock)}
+	rpcReq := rpcRequest{req: JsonRpcRequest{Id: json.RawMessage(`1`), Method: "a"}, srcUrl: "/rpc"}
+	rf.correlation.request(rpcReq.req.Id)
+
+	hf.correlate(rf, rpcReq, []byte(`{"jsonrpc":"2.0","id":2,"result":true}`))
+	hf.correlate(rf, rpcReq, []byte(`{"jsonrpc":"2.0","id":1,"result":true}`))
+	if n := testutil.ToFloat64(orphans.WithLabelValues("/rpc", orphanUnknownId)); n != 1 {
+		t.Errorf("got %v unknown", n)
+	}
+	if n := testutil.ToFloat64(orphans.WithLabelValues("/rpc", orphanDuplicate)); n != 1 {
+		t.Errorf("got %v duplicates", n)
+	}
+}
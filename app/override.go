@@ -0,0 +1,68 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+var errNoCaCerts = errors.New("no certificates found")
+
+// routeSettings returns allowed headers, timeout and max parallel requests of route handler: ProxyRule overrides
+// or App defaults. "/" multiple rules handler always uses App settings.
+func (a *App) routeSettings(src string) (headers []string, timeout, parallel int) {
+	headers, timeout, parallel = a.Headers, a.Timeout, a.MaxParallelRequests
+	rs, ok := a.routes[src]
+	if !ok {
+		return
+	}
+
+	if rs.rule.Headers != nil {
+		headers = rs.rule.Headers
+	}
+	if rs.rule.Timeout > 0 {
+		timeout = rs.rule.Timeout
+	}
+	if rs.rule.MaxParallelRequests > 0 {
+		parallel = rs.rule.MaxParallelRequests
+	}
+
+	return
+}
+
+// tlsConfig returns backend TLS client config of rule or nil if rule has no TLS settings.
+func (r ProxyRule) tlsConfig() (*tls.Config, error) {
+	if r.TlsCaFile == "" && r.TlsCertFile == "" && r.TlsServerName == "" && !r.TlsInsecure {
+		return nil, nil
+	}
+
+	c := &tls.Config{
+		ServerName:         r.TlsServerName,
+		InsecureSkipVerify: r.TlsInsecure,
+		ClientSessionCache: tls.NewLRUClientSessionCache(maxConnectionToHost),
+	}
+
+	if r.TlsCaFile != "" {
+		pem, err := ioutil.ReadFile(r.TlsCaFile)
+		if err != nil {
+			return nil, err
+		}
+
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: %w", r.TlsCaFile, errNoCaCerts)
+		}
+	}
+
+	if r.TlsCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(r.TlsCertFile, r.TlsKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+
+	return c, nil
+}
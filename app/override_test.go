@@ -0,0 +1,128 @@
+package app
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestAppRouteSettings(t *testing.T) {
+	a := &App{
+		Headers:             []string{"Authorization"},
+		Timeout:             20,
+		MaxParallelRequests: 10,
+		RedirectRules: []ProxyRule{
+			{Src: "/rpc", DstUrl: "http://rpc"},
+			{Src: "/slow", DstUrl: "http://slow", Timeout: 60, Headers: []string{"X-Token"}, MaxParallelRequests: 2},
+		},
+	}
+	if err := a.initRoutes(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		src               string
+		headers           []string
+		timeout, parallel int
+	}{
+		{"/rpc", []string{"Authorization"}, 20, 10},
+		{"/slow", []string{"X-Token"}, 60, 2},
+		{"/", []string{"Authorization"}, 20, 10},
+	} {
+		headers, timeout, parallel := a.routeSettings(tc.src)
+		if !reflect.DeepEqual(headers, tc.headers) || timeout != tc.timeout || parallel != tc.parallel {
+			t.Errorf("%s: got %v %d %d", tc.src, headers, timeout, parallel)
+		}
+	}
+
+	hf := a.newHttpForwarder("/slow", "http://slow")
+	if hf.timeout != 60 || hf.maxParallelRequests != 2 || !reflect.DeepEqual(hf.allowedHeaders, []string{"X-Token"}) {
+		t.Errorf("forwarder: got %d %d %v", hf.timeout, hf.maxParallelRequests, hf.allowedHeaders)
+	}
+}
+
+func TestProxyRuleTlsConfig(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	dir := t.TempDir()
+	ca := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw}), 0600)
+	invalid := filepath.Join(dir, "invalid.pem")
+	ioutil.WriteFile(invalid, []byte("not a certificate"), 0600)
+
+	if c, err := (ProxyRule{}).tlsConfig(); c != nil || err != nil {
+		t.Errorf("no settings: got %v %v", c, err)
+	}
+
+	get := func(r ProxyRule) error {
+		c, err := r.tlsConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: c}}
+		resp, err := client.Get(backend.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if err := get(ProxyRule{TlsCaFile: ca, TlsServerName: "example.com"}); err != nil {
+		t.Errorf("route ca: %s", err)
+	}
+	if err := get(ProxyRule{TlsServerName: "example.com"}); err == nil {
+		t.Error("system roots: self-signed backend was verified")
+	}
+	if err := get(ProxyRule{TlsInsecure: true}); err != nil {
+		t.Errorf("insecure: %s", err)
+	}
+
+	for _, r := range []ProxyRule{{TlsCaFile: filepath.Join(dir, "missing.pem")}, {TlsCaFile: invalid}, {TlsCertFile: invalid, TlsKeyFile: invalid}} {
+		if _, err := newRouteStates([]ProxyRule{r}, clock.Real); err == nil {
+			t.Errorf("%+v: got no error", r)
+		}
+	}
+}
+
+func TestAppRouteTlsMultiMode(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":true}`))
+	}))
+	defer backend.Close()
+
+	a := &App{
+		Timeout:             5,
+		MaxParallelRequests: 1,
+		RedirectRules: []ProxyRule{
+			{Src: "/rpc", DstUrl: backend.URL, TlsInsecure: true},
+			{Src: "/pay", DstUrl: backend.URL},
+		},
+	}
+	if err := a.initRoutes(); err != nil {
+		t.Fatal(err)
+	}
+
+	hf := a.newHttpForwarder("/", "*", a.RedirectRules...)
+	rf := hf.newRequestForwarder(&wsConn{})
+	do := func(src string) error {
+		breq := BackendRequest{Msg: []byte(`{"jsonrpc":"2.0","method":"a","id":1}`), Route: src, DstUrl: backend.URL, Header: http.Header{}}
+		_, err := hf.backend(rf, src).Do(context.Background(), breq)
+		return err
+	}
+
+	// route with insecure opt-in has own transport, other routes verify certificates
+	if err := do("/rpc"); err != nil {
+		t.Errorf("insecure route: %s", err)
+	}
+	if err := do("/pay"); netErrorStatus(err) != statusTlsError {
+		t.Errorf("default route: got %v", err)
+	}
+}
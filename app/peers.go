@@ -0,0 +1,159 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// forwardedHeader marks admin requests forwarded from other instance, they are applied to local sessions only.
+	forwardedHeader = "X-Ws2http-Forwarded"
+
+	peerTimeout = 5 * time.Second
+)
+
+var peerClient = &http.Client{Timeout: peerTimeout}
+
+// peerTargets returns instances for cluster-wide admin request: session instance from registry for session filter
+// or all other instances. Forwarded requests and requests without cluster registry are local only.
+func (a *App) peerTargets(r *http.Request, f sessionFilter) ([]string, error) {
+	cluster := a.sessions.cluster
+	if cluster == nil || r.Header.Get(forwardedHeader) != "" {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), clusterTimeout)
+	defer cancel()
+
+	if f.Session != "" {
+		if _, ok := a.sessions.get(f.Session); ok {
+			return nil, nil
+		}
+
+		instance, err := cluster.Lookup(ctx, f.Session)
+		if err != nil || instance == "" || instance == a.sessions.instance {
+			return nil, err
+		}
+		return []string{instance}, nil
+	}
+
+	instances, err := cluster.Instances(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	peers := instances[:0]
+	for _, instance := range instances {
+		if instance != a.sessions.instance {
+			peers = append(peers, instance)
+		}
+	}
+
+	return peers, nil
+}
+
+// forwardPeers sends admin request to other instances in parallel and calls result with response body of every
+// instance. Failed instances are logged.
+func (a *App) forwardPeers(r *http.Request, path string, f sessionFilter, body interface{}, result func(data []byte)) {
+	peers, err := a.peerTargets(r, f)
+	if err != nil {
+		a.Errorf("can't get cluster instances err=%s", err)
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil || len(peers) == 0 {
+		return
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			resp, err := a.forwardPeer(r.Context(), peer, path, data)
+			if err != nil {
+				a.Errorf("can't forward %s to instance=%s err=%s", path, peer, err)
+				return
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			result(resp)
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// forwardPeer posts admin request to instance url.
+func (a *App) forwardPeer(ctx context.Context, instance, path string, data []byte) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, instance+a.endpoint(path), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(forwardedHeader, a.sessions.instance)
+	if a.AdminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+a.AdminToken)
+	}
+
+	resp, err := peerClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+
+	return body, nil
+}
+
+// disconnectResponse is a result of /admin/disconnect request.
+type disconnectResponse struct {
+	Closed int `json:"closed"`
+}
+
+// disconnect closes sessions matched by session id, route and tag on all cluster instances.
+// Example: curl -H 'Content-Type: application/json' -d '{"session":"a1b2c3d4-42"}' http://localhost:8090/admin/disconnect
+func (a *App) disconnect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var f sessionFilter
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil || f == (sessionFilter{}) {
+		http.Error(w, "invalid disconnect request", http.StatusBadRequest)
+		return
+	}
+
+	var resp disconnectResponse
+	for _, s := range a.sessions.find(f) {
+		s.ws.closeWith(websocket.CloseNormalClosure, "disconnected")
+		resp.Closed++
+	}
+
+	a.forwardPeers(r, "/admin/disconnect", f, f, func(data []byte) {
+		var pr disconnectResponse
+		if json.Unmarshal(data, &pr) == nil {
+			resp.Closed += pr.Closed
+		}
+	})
+
+	a.Printf("disconnect session=%s route=%s tag=%s closed=%d", f.Session, f.Route, f.Tag, resp.Closed)
+	a.audit(r, "disconnect", f)
+	writeJSON(w, resp)
+}
@@ -0,0 +1,122 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memCluster is an in-memory cluster registry.
+type memCluster struct {
+	lock      sync.Mutex
+	sessions  map[string]string
+	instances map[string]bool
+}
+
+func (m *memCluster) Register(ctx context.Context, session, instance string, ttl time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.sessions[session] = instance
+	return nil
+}
+
+func (m *memCluster) Unregister(ctx context.Context, session string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.sessions, session)
+	return nil
+}
+
+func (m *memCluster) Lookup(ctx context.Context, session string) (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.sessions[session], nil
+}
+
+func (m *memCluster) Heartbeat(ctx context.Context, instance string, ttl time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.instances[instance] = true
+	return nil
+}
+
+func (m *memCluster) Instances(ctx context.Context) ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var list []string
+	for instance := range m.instances {
+		list = append(list, instance)
+	}
+	return list, nil
+}
+
+func TestForwardPeers(t *testing.T) {
+	cluster := &memCluster{sessions: make(map[string]string), instances: make(map[string]bool)}
+
+	// two instances with one session each
+	var lock sync.Mutex
+	received := make(map[string]string)
+	apps := make([]*App, 2)
+	for i := range apps {
+		a := &App{sessions: newSessionRegistry()}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/admin/broadcast", a.broadcast)
+		mux.HandleFunc("/admin/disconnect", a.disconnect)
+		srv := httptest.NewServer(mux)
+		defer srv.Close()
+
+		a.sessions.cluster, a.sessions.instance = cluster, srv.URL
+		a.sessions.heartbeat()
+
+		s := &session{id: string('a' + byte(i)), route: "/rpc", tags: make(map[string]struct{})}
+		s.send = func(msg []byte) error {
+			lock.Lock()
+			defer lock.Unlock()
+			received[s.id] = string(msg)
+			return nil
+		}
+		a.sessions.add(s)
+		apps[i] = a
+	}
+
+	broadcast := func(body string) (resp broadcastResponse) {
+		w := httptest.NewRecorder()
+		apps[0].broadcast(w, httptest.NewRequest(http.MethodPost, "/admin/broadcast", strings.NewReader(body)))
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(w.Body.String())
+		}
+		return resp
+	}
+
+	// cluster-wide broadcast
+	if resp := broadcast(`{"method":"hi","route":"/rpc"}`); resp != (broadcastResponse{Total: 2, Delivered: 2}) {
+		t.Errorf("got %+v", resp)
+	}
+	if received["a"] != `{"jsonrpc":"2.0","method":"hi"}` || received["b"] != received["a"] {
+		t.Errorf("got %v", received)
+	}
+
+	// push to session on other instance
+	if resp := broadcast(`{"method":"push","session":"b"}`); resp != (broadcastResponse{Total: 1, Delivered: 1}) {
+		t.Errorf("got %+v", resp)
+	}
+	if received["b"] != `{"jsonrpc":"2.0","method":"push"}` || received["a"] == received["b"] {
+		t.Errorf("got %v", received)
+	}
+
+	// unknown session is not forwarded
+	if resp := broadcast(`{"method":"push","session":"c"}`); resp != (broadcastResponse{}) {
+		t.Errorf("got %+v", resp)
+	}
+
+	w := httptest.NewRecorder()
+	apps[0].disconnect(w, httptest.NewRequest(http.MethodPost, "/admin/disconnect", strings.NewReader(`{"tag":"none"}`)))
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != `{"closed":0}` {
+		t.Errorf("got %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,154 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolStats tracks outbound connections per backend host (host:port): opened, closed, active and idle connections
+// and tls handshakes. Idle connections are open connections without requests.
+type poolStats struct {
+	lock  sync.Mutex
+	open  map[string]int
+	inUse map[string]int
+
+	opened, closed, handshakes *prometheus.CounterVec
+	conns                      *prometheus.GaugeVec
+}
+
+// newPoolStats returns pool stats with metrics in namespace.
+func newPoolStats(namespace string) *poolStats {
+	counter := func(name, help string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "pool",
+			Name:      name,
+			Help:      help,
+		}, []string{"host"})
+	}
+
+	return &poolStats{
+		open:       make(map[string]int),
+		inUse:      make(map[string]int),
+		opened:     counter("connections_opened_total", "Opened backend connections by host."),
+		closed:     counter("connections_closed_total", "Closed backend connections by host."),
+		handshakes: counter("tls_handshakes_total", "TLS handshakes with backend by host."),
+		conns: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "pool",
+			Name:      "connections",
+			Help:      "Current backend connections by host/state: active, idle.",
+		}, []string{"host", "state"}),
+	}
+}
+
+// collectors returns metrics for registration.
+func (p *poolStats) collectors() []prometheus.Collector {
+	return []prometheus.Collector{p.opened, p.closed, p.handshakes, p.conns}
+}
+
+// add changes open and in use connections for host and updates gauges.
+func (p *poolStats) add(host string, open, inUse int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.open[host] += open
+	p.inUse[host] += inUse
+	active, idle := p.inUse[host], p.open[host]-p.inUse[host]
+	if idle < 0 {
+		idle = 0
+	}
+
+	p.conns.WithLabelValues(host, "active").Set(float64(active))
+	p.conns.WithLabelValues(host, "idle").Set(float64(idle))
+}
+
+// idle returns idle connections to host.
+func (p *poolStats) idle(host string) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.open[host] - p.inUse[host]
+}
+
+// dialContext wraps transport dial func with connection counting.
+func (p *poolStats) dialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		p.opened.WithLabelValues(addr).Inc()
+		p.add(addr, 1, 0)
+		return &poolConn{Conn: c, host: addr, pool: p}, nil
+	}
+}
+
+// withContext returns context with client trace counting connection usage and tls handshakes for dstUrl.
+// Returned release func must be called after response body is closed.
+func (p *poolStats) withContext(ctx context.Context, dstUrl string) (context.Context, func()) {
+	host := poolHost(dstUrl)
+
+	var once sync.Once
+	got := make(chan struct{})
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) {
+			once.Do(func() {
+				p.add(host, 0, 1)
+				close(got)
+			})
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				p.handshakes.WithLabelValues(host).Inc()
+			}
+		},
+	})
+
+	return ctx, func() {
+		select {
+		case <-got:
+			p.add(host, 0, -1)
+		default:
+		}
+	}
+}
+
+// poolHost returns host:port of url like http.Transport connection key.
+func poolHost(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return ""
+	}
+
+	if u.Port() != "" {
+		return u.Host
+	} else if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// poolConn counts closed connections.
+type poolConn struct {
+	net.Conn
+	host string
+	pool *poolStats
+	once sync.Once
+}
+
+func (c *poolConn) Close() error {
+	c.once.Do(func() {
+		c.pool.closed.WithLabelValues(c.host).Inc()
+		c.pool.add(c.host, -1, 0)
+	})
+
+	return c.Conn.Close()
+}
@@ -0,0 +1,42 @@
+package app
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPoolStats(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	p := newPoolStats("test")
+	tr := &http.Transport{DialContext: p.dialContext((&net.Dialer{}).DialContext)}
+	host := poolHost(srv.URL)
+
+	ctx, release := p.withContext(context.Background(), srv.URL)
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	resp, err := (&http.Client{Transport: tr}).Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.open[host] != 1 || p.inUse[host] != 1 {
+		t.Errorf("active: got open = %d, in use = %d", p.open[host], p.inUse[host])
+	}
+
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	release()
+	if p.open[host] != 1 || p.inUse[host] != 0 {
+		t.Errorf("idle: got open = %d, in use = %d", p.open[host], p.inUse[host])
+	}
+
+	tr.CloseIdleConnections()
+	if p.open[host] != 0 {
+		t.Errorf("closed: got open = %d", p.open[host])
+	}
+}
@@ -0,0 +1,38 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type receivedKey struct{}
+
+// withReceived returns context with time the client message was received by proxy.
+func withReceived(ctx context.Context, received time.Time) context.Context {
+	return context.WithValue(ctx, receivedKey{}, received)
+}
+
+// ReceivedFromContext returns time the client message of backend request was received by proxy, so registered
+// backends could report proxy queue time.
+func ReceivedFromContext(ctx context.Context) (time.Time, bool) {
+	t, ok := ctx.Value(receivedKey{}).(time.Time)
+	return t, ok
+}
+
+// setQueueHeader sets time in milliseconds the request waited in proxy since client message receive until now
+// to header, ctx without receive time is ignored.
+func setQueueHeader(ctx context.Context, h http.Header, name string, now time.Time) {
+	if name == "" {
+		return
+	}
+
+	if received, ok := ReceivedFromContext(ctx); ok {
+		ms := int64(now.Sub(received) / time.Millisecond)
+		if ms < 0 {
+			ms = 0
+		}
+		h.Set(name, strconv.FormatInt(ms, 10))
+	}
+}
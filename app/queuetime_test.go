@@ -0,0 +1,36 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestSetQueueHeader(t *testing.T) {
+	c := clock.NewFake(time.Now())
+
+	h := http.Header{}
+	setQueueHeader(context.Background(), h, "X-WS2HTTP-Queue-Ms", c.Now())
+	if len(h) != 0 {
+		t.Errorf("no receive time: got %v", h)
+	}
+
+	ctx := withReceived(context.Background(), c.Now())
+	c.Advance(1500 * time.Millisecond)
+	setQueueHeader(ctx, h, "", c.Now())
+	if len(h) != 0 {
+		t.Errorf("disabled: got %v", h)
+	}
+
+	setQueueHeader(ctx, h, "X-WS2HTTP-Queue-Ms", c.Now())
+	if v := h.Get("X-WS2HTTP-Queue-Ms"); v != "1500" {
+		t.Errorf("got %q", v)
+	}
+
+	if received, ok := ReceivedFromContext(ctx); !ok || c.Now().Sub(received) != 1500*time.Millisecond {
+		t.Errorf("got %v %v", received, ok)
+	}
+}
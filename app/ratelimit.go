@@ -0,0 +1,135 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+// tokenBucket is a token bucket rate limiter on clock.
+type tokenBucket struct {
+	clock  clock.Clock
+	lock   sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, c clock.Clock) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{clock: c, rate: rate, burst: float64(burst), tokens: float64(burst), last: c.Now()}
+}
+
+// take takes token from bucket. Returns false and time until next token if bucket is empty.
+func (b *tokenBucket) take() (bool, time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := b.clock.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// UpgradeRateHook limits accepted websocket upgrades to rate per second with burst, overflow is rejected with 503
+// and Retry-After, so reconnect storms after restart don't stampede backends with first requests. Retry-After is
+// spread over burst refill time, so rejected clients don't come back at once. Bucket is refilled by system clock.
+func UpgradeRateHook(rate float64, burst int) UpgradeHook {
+	return upgradeRateHook(rate, burst, clock.Real)
+}
+
+// upgradeRateHook is UpgradeRateHook on clock c.
+func upgradeRateHook(rate float64, burst int, c clock.Clock) UpgradeHook {
+	bucket := newTokenBucket(rate, burst, c)
+	spread := time.Duration(bucket.burst / rate * float64(time.Second))
+	if spread < time.Second {
+		spread = time.Second
+	}
+
+	return func(r *http.Request) error {
+		if ok, wait := bucket.take(); !ok {
+			return &UpgradeError{
+				Status:     http.StatusServiceUnavailable,
+				Message:    "upgrade rate limit reached",
+				RetryAfter: wait + time.Duration(rand.Int63n(int64(spread))),
+			}
+		}
+
+		return nil
+	}
+}
+
+// rateLimitError is a request rejected by connection or route rate limit.
+type rateLimitError struct {
+	scope      string        // connection or route
+	retryAfter time.Duration // time until next token
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("%s request rate limit reached", e.scope)
+}
+
+// newRateLimit returns requests rate limiter on clock c with burst, 0 burst is one second of rate. Returns nil
+// if rate is 0.
+func newRateLimit(rate float64, burst int, c clock.Clock) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	} else if burst == 0 {
+		burst = int(math.Ceil(rate))
+	}
+
+	return newTokenBucket(rate, burst, c)
+}
+
+// checkRateLimit takes tokens of connection and route rate limits, requests over connection limit don't consume
+// route tokens. Rejected requests are counted by route and scope.
+func (hf *HttpForwarder) checkRateLimit(rf *requestForwarder, rpcReq rpcRequest) error {
+	var err *rateLimitError
+	if rf.rateLimit != nil {
+		if ok, wait := rf.rateLimit.take(); !ok {
+			err = &rateLimitError{scope: "connection", retryAfter: wait}
+		}
+	}
+	if rs := hf.routeState(rpcReq.srcUrl); err == nil && rs != nil && rs.rateLimit != nil {
+		if ok, wait := rs.rateLimit.take(); !ok {
+			err = &rateLimitError{scope: "route", retryAfter: wait}
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	if hf.statRateLimited != nil {
+		hf.statRateLimited.WithLabelValues(rpcReq.srcUrl, err.scope).Inc()
+	}
+
+	return err
+}
+
+// rateLimitedErr returns JsonRpcRateLimited error with retry hint in seconds.
+func rateLimitedErr(req JsonRpcRequest, err error) *JsonRpcErrResponse {
+	rpcErr := NewJsonRpcErr(req, JsonRpcRateLimited, err)
+	if re, ok := err.(*rateLimitError); ok {
+		rpcErr.Error.Data = &JsonRpcErrData{RetryAfter: int(math.Ceil(re.retryAfter.Seconds()))}
+	}
+
+	return rpcErr
+}
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestUpgradeRateHook(t *testing.T) {
+	c := clock.NewFake(time.Now())
+
+	a := &App{UpgradeHooks: []UpgradeHook{upgradeRateHook(10, 2, c)}}
+	h := a.upgradeHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	upgrade := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/rpc", nil))
+		return w
+	}
+
+	// burst is accepted at once, overflow is rejected
+	for i := 0; i < 2; i++ {
+		if w := upgrade(); w.Code != http.StatusOK {
+			t.Fatalf("burst %d: got %d", i, w.Code)
+		}
+	}
+	w := upgrade()
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("overflow: got %d", w.Code)
+	}
+	if s, _ := strconv.Atoi(w.Header().Get("Retry-After")); s < 1 {
+		t.Errorf("got Retry-After %q", w.Header().Get("Retry-After"))
+	}
+
+	// bucket is refilled at rate
+	c.Advance(100 * time.Millisecond)
+	if w := upgrade(); w.Code != http.StatusOK {
+		t.Errorf("after refill: got %d", w.Code)
+	}
+	if w := upgrade(); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("after refill overflow: got %d", w.Code)
+	}
+
+	c.Advance(time.Hour)
+	for i := 0; i < 2; i++ {
+		if w := upgrade(); w.Code != http.StatusOK {
+			t.Errorf("refill is capped by burst: got %d", w.Code)
+		}
+	}
+	if w := upgrade(); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("refill is capped by burst: got %d", w.Code)
+	}
+}
+
+func TestCheckRateLimit(t *testing.T) {
+	c := clock.NewFake(time.Now())
+
+	limited := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "limited"}, []string{"url", "scope"})
+	hf := NewHttpForwarder("http://backend/rpc", nil, 10, 1)
+	hf.SetClock(c)
+	hf.SetRateLimit(2, 0)
+	hf.statRateLimited = limited
+	hf.routes = map[string]*routeState{"/rpc": {rateLimit: newRateLimit(3, 0, c)}}
+	rf1, rf2 := hf.newRequestForwarder(&wsConn{}), hf.newRequestForwarder(&wsConn{})
+	rpcReq := rpcRequest{srcUrl: "/rpc"}
+
+	// connection burst is one second of rate
+	for i := 0; i < 2; i++ {
+		if err := hf.checkRateLimit(rf1, rpcReq); err != nil {
+			t.Fatalf("connection burst %d: got %v", i, err)
+		}
+	}
+	err := hf.checkRateLimit(rf1, rpcReq)
+	if re, ok := err.(*rateLimitError); !ok || re.scope != "connection" {
+		t.Fatalf("connection limit: got %v", err)
+	}
+
+	// route limit is shared by connections, rejected connection requests don't consume route tokens
+	if err := hf.checkRateLimit(rf2, rpcReq); err != nil {
+		t.Errorf("route: got %v", err)
+	}
+	err = hf.checkRateLimit(rf2, rpcReq)
+	if re, ok := err.(*rateLimitError); !ok || re.scope != "route" {
+		t.Fatalf("route limit: got %v", err)
+	}
+
+	if v := testutil.ToFloat64(limited.WithLabelValues("/rpc", "connection")); v != 1 {
+		t.Errorf("got connection rejections %v", v)
+	}
+	if v := testutil.ToFloat64(limited.WithLabelValues("/rpc", "route")); v != 1 {
+		t.Errorf("got route rejections %v", v)
+	}
+
+	resp := rateLimitedErr(JsonRpcRequest{Id: []byte("1")}, err)
+	if data, ok := resp.Error.Data.(*JsonRpcErrData); resp.Error.Code != JsonRpcRateLimited || !ok || data.RetryAfter != 1 {
+		t.Errorf("got %+v", resp)
+	}
+
+	c.Advance(time.Second)
+	if err := hf.checkRateLimit(rf1, rpcReq); err != nil {
+		t.Errorf("after refill: got %v", err)
+	}
+
+	if newRateLimit(0, 10, c) != nil {
+		t.Error("zero rate: expected nil limit")
+	}
+}
@@ -0,0 +1,336 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+const (
+	redisSessionPrefix = "ws2http:session:"
+	redisInstancesKey  = "ws2http:instances" // sorted set of instances scored by expiry time in ms
+	redisPoolSize      = 8
+	redisScanCount     = 1000 // keys per SCAN call
+)
+
+// redisError is an error reply from redis.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisRegistry is a cluster registry in redis with session keys like ws2http:session:<id> and live instances
+// in ws2http:instances sorted set. It uses minimal RESP client for SET, GET, DEL and sorted set commands.
+type redisRegistry struct {
+	addr, password string
+	db             int
+	pool           chan *redisConn
+	clock          clock.Clock // time source of instance heartbeats, set by App
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// newRedisRegistry returns registry for url like redis://:password@localhost:6379/0.
+func newRedisRegistry(u *url.URL) (ClusterRegistry, error) {
+	return openRedis(u)
+}
+
+// openRedis returns redis client for url like redis://:password@localhost:6379/0.
+func openRedis(u *url.URL) (*redisRegistry, error) {
+	r := &redisRegistry{addr: u.Host, pool: make(chan *redisConn, redisPoolSize), clock: clock.Real}
+	if !strings.Contains(r.addr, ":") {
+		r.addr += ":6379"
+	}
+	if u.User != nil {
+		r.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		var err error
+		if r.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid redis db %q", db)
+		}
+	}
+
+	return r, nil
+}
+
+func (r *redisRegistry) setClock(c clock.Clock) {
+	r.clock = c
+}
+
+func (r *redisRegistry) Register(ctx context.Context, session, instance string, ttl time.Duration) error {
+	_, err := r.do(ctx, "SET", redisSessionPrefix+session, instance, "PX", strconv.FormatInt(int64(ttl/time.Millisecond), 10))
+	return err
+}
+
+func (r *redisRegistry) Unregister(ctx context.Context, session string) error {
+	_, err := r.do(ctx, "DEL", redisSessionPrefix+session)
+	return err
+}
+
+func (r *redisRegistry) Lookup(ctx context.Context, session string) (string, error) {
+	reply, err := r.do(ctx, "GET", redisSessionPrefix+session)
+	if err != nil || reply == nil {
+		return "", err
+	}
+
+	return reply.(string), nil
+}
+
+func (r *redisRegistry) Heartbeat(ctx context.Context, instance string, ttl time.Duration) error {
+	_, err := r.do(ctx, "ZADD", redisInstancesKey, redisMs(r.clock.Now().Add(ttl)), instance)
+	return err
+}
+
+func (r *redisRegistry) Instances(ctx context.Context) ([]string, error) {
+	now := redisMs(r.clock.Now())
+	if _, err := r.do(ctx, "ZREMRANGEBYSCORE", redisInstancesKey, "-inf", "("+now); err != nil {
+		return nil, err
+	}
+
+	reply, err := r.do(ctx, "ZRANGEBYSCORE", redisInstancesKey, now, "+inf")
+	if err != nil {
+		return nil, err
+	}
+
+	items, _ := reply.([]interface{})
+	instances := make([]string, 0, len(items))
+	for _, item := range items {
+		if instance, ok := item.(string); ok {
+			instances = append(instances, instance)
+		}
+	}
+
+	return instances, nil
+}
+
+// redisCache is a response cache shared by instances in redis with keys like ws2http:cache:<method>:<sha256>.
+type redisCache struct {
+	*redisRegistry
+}
+
+// newRedisCache returns response cache for url like redis://:password@localhost:6379/0.
+func newRedisCache(u *url.URL) (ResponseCache, error) {
+	r, err := openRedis(u)
+	if err != nil {
+		return nil, err
+	}
+
+	return redisCache{r}, nil
+}
+
+func (c redisCache) Get(ctx context.Context, key string) ([]byte, error) {
+	reply, err := c.do(ctx, "GET", key)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+
+	return []byte(reply.(string)), nil
+}
+
+func (c redisCache) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	_, err := c.do(ctx, "SET", key, string(data), "PX", strconv.FormatInt(int64(ttl/time.Millisecond), 10))
+	return err
+}
+
+// Invalidate scans keys matched by pattern and removes them in batches.
+func (c redisCache) Invalidate(ctx context.Context, pattern string) (int, error) {
+	n, cursor := 0, "0"
+	for {
+		reply, err := c.do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", strconv.Itoa(redisScanCount))
+		if err != nil {
+			return n, err
+		}
+
+		page, _ := reply.([]interface{})
+		if len(page) != 2 {
+			return n, errors.New("redis: invalid scan reply")
+		}
+		cursor, _ = page[0].(string)
+		keys, _ := page[1].([]interface{})
+		if len(keys) > 0 {
+			args := []string{"DEL"}
+			for _, k := range keys {
+				if key, ok := k.(string); ok {
+					args = append(args, key)
+				}
+			}
+			deleted, err := c.do(ctx, args...)
+			if err != nil {
+				return n, err
+			}
+			d, _ := deleted.(int64)
+			n += int(d)
+		}
+
+		if cursor == "0" || cursor == "" {
+			return n, nil
+		}
+	}
+}
+
+// subscribe reads messages of channel on dedicated connection and calls handler with message payload.
+// Returns connection error.
+func (r *redisRegistry) subscribe(channel string, handler func(payload string)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), responseCacheTimeout)
+	defer cancel()
+	c, err := r.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if _, err := c.do("SUBSCRIBE", channel); err != nil {
+		return err
+	}
+	c.SetDeadline(time.Time{})
+
+	for {
+		reply, err := readRedisReply(c.r)
+		if err != nil {
+			return err
+		}
+
+		// pushed messages are ["message", channel, payload]
+		if msg, ok := reply.([]interface{}); ok && len(msg) == 3 && msg[0] == "message" {
+			if payload, ok := msg[2].(string); ok {
+				handler(payload)
+			}
+		}
+	}
+}
+
+// redisMs returns unix time in milliseconds for sorted set scores.
+func redisMs(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+}
+
+// conn returns pooled or new connection, new connections are authenticated and select db.
+func (r *redisRegistry) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case c := <-r.pool:
+		return c, nil
+	default:
+	}
+
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", r.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &redisConn{Conn: nc, r: bufio.NewReader(nc)}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	}
+	if r.password != "" {
+		if _, err := c.do("AUTH", r.password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if r.db != 0 {
+		if _, err := c.do("SELECT", strconv.Itoa(r.db)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// do sends command and returns reply: string, int64, []interface{} or nil.
+func (r *redisRegistry) do(ctx context.Context, args ...string) (interface{}, error) {
+	c, err := r.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline, _ := ctx.Deadline()
+	c.SetDeadline(deadline)
+	reply, err := c.do(args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		c.Close()
+		return nil, err
+	}
+
+	select {
+	case r.pool <- c:
+	default:
+		c.Close()
+	}
+
+	return reply, err
+}
+
+// do writes command as array of bulk strings and reads reply.
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+
+	if _, err := io.WriteString(c, b.String()); err != nil {
+		return nil, err
+	}
+
+	return readRedisReply(c.r)
+}
+
+// readRedisReply reads RESP reply. Error replies are returned as redisError.
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	} else if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, errors.New("redis: invalid reply")
+	}
+
+	kind, value := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return nil, redisError(value)
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			if list[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	}
+
+	return nil, errors.New("redis: invalid reply")
+}
@@ -0,0 +1,170 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a test redis server with SET, GET, DEL, SCAN, single sorted set and pub/sub commands.
+type fakeRedis struct {
+	lock sync.Mutex
+	keys map[string]string
+	zset map[string]int64
+	subs map[string][]net.Conn // subscribers by channel
+}
+
+func (f *fakeRedis) serve(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer c.Close()
+			r := bufio.NewReader(c)
+			for {
+				cmd, err := readRedisReply(r)
+				if err != nil {
+					return
+				}
+
+				args := cmd.([]interface{})
+				f.lock.Lock()
+				switch args[0] {
+				case "SET":
+					f.keys[args[1].(string)] = args[2].(string)
+					fmt.Fprint(c, "+OK\r\n")
+				case "GET":
+					if v, ok := f.keys[args[1].(string)]; ok {
+						fmt.Fprintf(c, "$%d\r\n%s\r\n", len(v), v)
+					} else {
+						fmt.Fprint(c, "$-1\r\n")
+					}
+				case "DEL":
+					n := 0
+					for _, k := range args[1:] {
+						if _, ok := f.keys[k.(string)]; ok {
+							delete(f.keys, k.(string))
+							n++
+						}
+					}
+					fmt.Fprintf(c, ":%d\r\n", n)
+				case "SCAN":
+					var keys []string
+					for k := range f.keys {
+						if ok, _ := path.Match(args[3].(string), k); ok {
+							keys = append(keys, k)
+						}
+					}
+					fmt.Fprintf(c, "*2\r\n$1\r\n0\r\n*%d\r\n", len(keys))
+					for _, k := range keys {
+						fmt.Fprintf(c, "$%d\r\n%s\r\n", len(k), k)
+					}
+				case "SUBSCRIBE":
+					ch := args[1].(string)
+					if f.subs == nil {
+						f.subs = make(map[string][]net.Conn)
+					}
+					f.subs[ch] = append(f.subs[ch], c)
+					fmt.Fprintf(c, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(ch), ch)
+				case "PUBLISH":
+					ch, msg := args[1].(string), args[2].(string)
+					for _, sc := range f.subs[ch] {
+						fmt.Fprintf(sc, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(ch), ch, len(msg), msg)
+					}
+					fmt.Fprintf(c, ":%d\r\n", len(f.subs[ch]))
+				case "ZADD":
+					f.zset[args[3].(string)], _ = strconv.ParseInt(args[2].(string), 10, 64)
+					fmt.Fprint(c, ":1\r\n")
+				case "ZREMRANGEBYSCORE":
+					max, _ := strconv.ParseInt(strings.TrimPrefix(args[3].(string), "("), 10, 64)
+					for m, score := range f.zset {
+						if score < max {
+							delete(f.zset, m)
+						}
+					}
+					fmt.Fprint(c, ":0\r\n")
+				case "ZRANGEBYSCORE":
+					fmt.Fprintf(c, "*%d\r\n", len(f.zset))
+					for m := range f.zset {
+						fmt.Fprintf(c, "$%d\r\n%s\r\n", len(m), m)
+					}
+				default:
+					fmt.Fprint(c, "-ERR unknown command\r\n")
+				}
+				f.lock.Unlock()
+			}
+		}()
+	}
+}
+
+func TestRedisRegistry(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f := &fakeRedis{keys: make(map[string]string), zset: make(map[string]int64)}
+	go f.serve(ln)
+
+	r, err := OpenClusterRegistry("redis://" + ln.Addr().String() + "/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := r.Register(ctx, "a-1", "http://10.0.0.1:8090", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	f.lock.Lock()
+	if f.keys[redisSessionPrefix+"a-1"] != "http://10.0.0.1:8090" {
+		t.Errorf("got %v", f.keys)
+	}
+	f.lock.Unlock()
+
+	if instance, err := r.Lookup(ctx, "a-1"); err != nil || instance != "http://10.0.0.1:8090" {
+		t.Errorf("got %s, %v", instance, err)
+	}
+
+	if err := r.Unregister(ctx, "a-1"); err != nil {
+		t.Fatal(err)
+	}
+	if instance, err := r.Lookup(ctx, "a-1"); err != nil || instance != "" {
+		t.Errorf("after unregister got %s, %v", instance, err)
+	}
+
+	if err := r.Heartbeat(ctx, "http://10.0.0.1:8090", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	f.lock.Lock()
+	f.zset["http://10.0.0.2:8090"] = 1 // expired
+	f.lock.Unlock()
+	if instances, err := r.Instances(ctx); err != nil || len(instances) != 1 || instances[0] != "http://10.0.0.1:8090" {
+		t.Errorf("got %v, %v", instances, err)
+	}
+
+	// error replies don't close connection
+	if _, err := r.(*redisRegistry).do(ctx, "PING"); err != redisError("ERR unknown command") {
+		t.Errorf("got %v", err)
+	}
+
+	if _, err := OpenClusterRegistry("etcd://localhost"); err != ErrUnknownCluster {
+		t.Errorf("got %v", err)
+	}
+
+	if _, err := newRedisRegistry(&url.URL{Host: "localhost", Path: "/x"}); err == nil {
+		t.Error("no error for invalid db")
+	}
+}
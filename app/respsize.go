@@ -0,0 +1,47 @@
+package app
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+var errResponseTooLarge = errors.New("backend response too large")
+
+// SetMaxResponseSize sets max backend response size in bytes for routes without own ProxyRule.MaxResponseSize.
+// Larger responses are rejected with JsonRpcResponseTooLarge error, 0 is unlimited.
+func (hf *HttpForwarder) SetMaxResponseSize(n int) {
+	hf.maxResponseSize = n
+}
+
+// responseLimit returns max backend response size of route, 0 is unlimited.
+func (hf *HttpForwarder) responseLimit(srcUrl string) int {
+	if rs := hf.routeState(srcUrl); rs != nil && rs.rule.MaxResponseSize > 0 {
+		return rs.rule.MaxResponseSize
+	}
+
+	return hf.maxResponseSize
+}
+
+// readResponse reads backend response body up to limit, 0 is unlimited. Responses with larger Content-Length are
+// rejected without reading, chunked responses are read up to limit, so large bodies are never buffered whole.
+func readResponse(resp *http.Response, limit int) ([]byte, error) {
+	if limit <= 0 {
+		return ioutil.ReadAll(resp.Body)
+	}
+
+	tooLarge := &BackendError{Code: JsonRpcResponseTooLarge, Err: errResponseTooLarge}
+	if resp.ContentLength > int64(limit) {
+		return nil, tooLarge
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	} else if len(data) > limit {
+		return nil, tooLarge
+	}
+
+	return data, nil
+}
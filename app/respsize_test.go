@@ -0,0 +1,48 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaxResponseSize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req JsonRpcRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		resp := `{"jsonrpc":"2.0","id":` + string(req.Id) + `,"result":"` + strings.Repeat("x", 100) + `"}`
+		if req.Method == "chunked" {
+			// flushed response has no Content-Length
+			w.Write([]byte(resp[:10]))
+			w.(http.Flusher).Flush()
+			resp = resp[10:]
+		}
+		w.Write([]byte(resp))
+	}))
+	defer srv.Close()
+
+	hf := NewHttpForwarder(srv.URL, nil, 10, 1)
+	hf.SetMaxResponseSize(100)
+	rf := hf.newRequestForwarder(&wsConn{})
+	forward := func(msg string) *JsonRpcErrResponse {
+		rpcReq, _ := rf.rewriteRequest([]byte(msg), srv.URL)
+		rf.maxParallelRequest <- struct{}{}
+		var resp JsonRpcErrResponse
+		json.Unmarshal(hf.forward(rf, rpcReq, http.Header{}), &resp)
+		return &resp
+	}
+
+	for _, method := range []string{"get", "chunked"} {
+		if resp := forward(`{"jsonrpc":"2.0","method":"` + method + `","id":1}`); resp.Error.Code != JsonRpcResponseTooLarge {
+			t.Errorf("%s: got %+v", method, resp)
+		}
+	}
+
+	// route limit overrides default
+	hf.routes = map[string]*routeState{srv.URL: {rule: ProxyRule{MaxResponseSize: 1024}}}
+	if hf.responseLimit(srv.URL) != 1024 || hf.responseLimit("/other") != 100 {
+		t.Errorf("got limits %d %d", hf.responseLimit(srv.URL), hf.responseLimit("/other"))
+	}
+}
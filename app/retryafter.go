@@ -0,0 +1,127 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryHold is a delay of method requests until backend Retry-After passes.
+type retryHold struct {
+	until time.Time
+	code  int // backend error code of held method
+}
+
+// parseRetryAfter returns delay from Retry-After header in seconds or http date, 0 if header is missing or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	} else if s, err := strconv.Atoi(value); err == nil {
+		if s < 0 {
+			return 0
+		}
+		return time.Duration(s) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+
+	return 0
+}
+
+// retryAfterError returns BackendError for backend 429 and 503 responses with Retry-After hint relative to now,
+// other statuses get plain BackendError.
+func retryAfterError(resp *http.Response, now time.Time) *BackendError {
+	be := &BackendError{Code: -1 * resp.StatusCode}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		be.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	}
+
+	return be
+}
+
+// holdMethod delays requests of method for d, holds are replaced by later ones.
+func (rs *routeState) holdMethod(method string, code int, d time.Duration) {
+	if rs == nil || d <= 0 {
+		return
+	}
+
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	if rs.holds == nil {
+		rs.holds = make(map[string]retryHold)
+	}
+
+	if until := rs.clock.Now().Add(d); until.After(rs.holds[method].until) {
+		rs.holds[method] = retryHold{until: until, code: code}
+	}
+}
+
+// methodHold returns remaining delay of method and backend error code, expired holds are removed.
+func (rs *routeState) methodHold(method string) (time.Duration, int) {
+	if rs == nil {
+		return 0, 0
+	}
+
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	h, ok := rs.holds[method]
+	if !ok {
+		return 0, 0
+	}
+
+	remaining := h.until.Sub(rs.clock.Now())
+	if remaining <= 0 {
+		delete(rs.holds, method)
+		return 0, 0
+	}
+
+	return remaining, h.code
+}
+
+// heldMethods returns methods with active holds.
+func (rs *routeState) heldMethods() []string {
+	rs.lock.RLock()
+	defer rs.lock.RUnlock()
+
+	var methods []string
+	now := rs.clock.Now()
+	for method, h := range rs.holds {
+		if h.until.After(now) {
+			methods = append(methods, method)
+		}
+	}
+
+	return methods
+}
+
+// waitRetryAfter delays request of method held by backend Retry-After. Returns BackendError with remaining hint
+// if ctx is done before hold is over.
+func (hf *HttpForwarder) waitRetryAfter(ctx context.Context, rs *routeState, method string) error {
+	wait, _ := rs.methodHold(method)
+	if wait <= 0 {
+		return nil
+	}
+
+	done := make(chan struct{})
+	t := hf.clock.AfterFunc(wait, func() { close(done) })
+	defer t.Stop()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		if remaining, code := rs.methodHold(method); remaining > 0 {
+			return &BackendError{Code: code, RetryAfter: remaining}
+		}
+		return ctx.Err()
+	}
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for v, want := range map[string]time.Duration{
+		"":                              0,
+		"120":                           2 * time.Minute,
+		"-1":                            0,
+		"soon":                          0,
+		"Wed, 01 May 2024 12:00:30 GMT": 30 * time.Second,
+		"Wed, 01 May 2024 11:00:00 GMT": 0,
+	} {
+		if got := parseRetryAfter(v, now); got != want {
+			t.Errorf("%q: got %s, want %s", v, got, want)
+		}
+	}
+}
+
+func TestRouteStateMethodHold(t *testing.T) {
+	c := clock.NewFake(time.Now())
+
+	rs := &routeState{clock: c}
+	rs.holdMethod("a", -503, 2*time.Second)
+	rs.holdMethod("a", -429, time.Second) // shorter hold is ignored
+	if d, code := rs.methodHold("a"); d != 2*time.Second || code != -503 {
+		t.Errorf("got %s %d", d, code)
+	}
+	if d, _ := rs.methodHold("b"); d != 0 {
+		t.Errorf("other method: got %s", d)
+	}
+
+	// request waits for hold end
+	hf := NewHttpForwarder("/", nil, 0, 1)
+	hf.SetClock(c)
+	done := make(chan error, 1)
+	go func() { done <- hf.waitRetryAfter(context.Background(), rs, "a") }()
+	for c.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	c.Advance(2 * time.Second)
+	if err := <-done; err != nil {
+		t.Errorf("after hold: got %s", err)
+	}
+
+	// request context is done before hold end
+	rs.holdMethod("a", -503, 5*time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := hf.waitRetryAfter(ctx, rs, "a")
+	if be, ok := err.(*BackendError); !ok || be.Code != -503 || be.RetryAfter != 5*time.Second {
+		t.Errorf("done context: got %v", err)
+	}
+}
+
+func TestHttpForwarderRetryAfter(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "3")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":true}`))
+	}))
+	defer srv.Close()
+
+	routes, _ := newRouteStates([]ProxyRule{{Src: "/", DstUrl: srv.URL}}, clock.Real)
+	hf := NewHttpForwarder(srv.URL, nil, 10, 1)
+	hf.route = routes["/"]
+	hf.SetRetryAfterHold(50 * time.Millisecond)
+	rf := hf.newRequestForwarder(&wsConn{})
+	rpcReq, _ := rf.rewriteRequest([]byte(`{"jsonrpc":"2.0","method":"a","id":1}`), srv.URL)
+
+	rf.maxParallelRequest <- struct{}{}
+	if resp := hf.forward(rf, rpcReq, http.Header{}); !bytes.Contains(resp, []byte(`"code":-503`)) || !bytes.Contains(resp, []byte(`"retryAfter":3}`)) {
+		t.Errorf("got %s", resp)
+	}
+
+	// next request of method is held up to hold limit
+	start := time.Now()
+	rf.maxParallelRequest <- struct{}{}
+	if resp := hf.forward(rf, rpcReq, http.Header{}); !bytes.Contains(resp, []byte(`"result":true`)) {
+		t.Errorf("got %s", resp)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("request was not held: %s", d)
+	}
+}
@@ -0,0 +1,35 @@
+package app
+
+import (
+	"context"
+	"net"
+)
+
+// listen opens ListenAddr listeners: Acceptors sockets with SO_REUSEPORT for independent accept loops
+// or one socket.
+func (a *App) listen() ([]net.Listener, error) {
+	if a.Acceptors <= 1 {
+		ln, err := net.Listen("tcp", a.ListenAddr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{ln}, nil
+	}
+
+	lc := net.ListenConfig{Control: reusePort}
+	addr, listeners := a.ListenAddr, make([]net.Listener, 0, a.Acceptors)
+	for i := 0; i < a.Acceptors; i++ {
+		ln, err := lc.Listen(context.Background(), "tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+
+		addr = ln.Addr().String() // same port for :0
+		listeners = append(listeners, ln)
+	}
+
+	return listeners, nil
+}
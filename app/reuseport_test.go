@@ -0,0 +1,54 @@
+package app
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenReusePort(t *testing.T) {
+	a := &App{ListenAddr: "127.0.0.1:0", Acceptors: 4}
+	listeners, err := a.listen()
+	if err != nil {
+		t.Skipf("SO_REUSEPORT: %s", err)
+	}
+	defer func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}()
+
+	if len(listeners) != 4 {
+		t.Fatalf("got %d listeners", len(listeners))
+	}
+
+	addr := listeners[0].Addr().String()
+	for _, ln := range listeners[1:] {
+		if ln.Addr().String() != addr {
+			t.Errorf("got %s, want %s", ln.Addr(), addr)
+		}
+	}
+
+	// every connect is accepted by one of listeners
+	accepted := make(chan struct{}, 10)
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			for {
+				c, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				c.Close()
+				accepted <- struct{}{}
+			}
+		}(ln)
+	}
+
+	for i := 0; i < 10; i++ {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+		<-accepted
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package app
+
+import "errors"
+
+// fileLimit returns error on platforms without RLIMIT_NOFILE.
+func fileLimit() (uint64, error) {
+	return 0, errors.New("RLIMIT_NOFILE is not supported on this platform")
+}
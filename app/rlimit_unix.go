@@ -0,0 +1,15 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package app
+
+import "golang.org/x/sys/unix"
+
+// fileLimit returns soft limit of open file descriptors.
+func fileLimit() (uint64, error) {
+	var l unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &l); err != nil {
+		return 0, err
+	}
+
+	return uint64(l.Cur), nil
+}
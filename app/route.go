@@ -0,0 +1,146 @@
+package app
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+var errMaintenance = errors.New("route is under maintenance")
+
+// Method case normalization modes.
+const (
+	MethodCaseLower = "lower"
+	MethodCaseUpper = "upper"
+)
+
+// normalizeMethod applies MethodCase and MethodAliases to method. Aliases are case insensitive if MethodCase is set.
+func (r ProxyRule) normalizeMethod(method string) string {
+	switch r.MethodCase {
+	case MethodCaseLower:
+		method = strings.ToLower(method)
+	case MethodCaseUpper:
+		method = strings.ToUpper(method)
+	}
+
+	for alias, m := range r.MethodAliases {
+		if alias == method || (r.MethodCase != "" && strings.EqualFold(alias, method)) {
+			return m
+		}
+	}
+
+	return method
+}
+
+// routeState is a runtime state of ProxyRule shared between all forwarders.
+type routeState struct {
+	rule        ProxyRule
+	requestTmpl *template.Template  // backend request envelope, optional
+	translator  translator          // backend protocol translator, nil for json-rpc
+	backend     Backend             // registered backend for protocol, nil for http
+	windows     []maintenanceWindow // scheduled maintenance windows of rule
+	tlsConfig   *tls.Config         // backend TLS client config of rule, nil is default
+	clock       clock.Clock         // time source of health, drain, holds and maintenance windows
+
+	lock               sync.RWMutex
+	maintenance        bool
+	maintenanceMessage string
+
+	lastStatus  string // last backend request status: ok, timeout, dns_error, connection_refused, tls_error, error
+	lastRequest time.Time
+	recovered   map[string]time.Time  // recovery times of destinations for SlowStart
+	failing     map[string]failStreak // failed requests to destinations since last success
+
+	green    bool           // GreenUrl is active destination
+	inflight map[string]int // in-flight requests by destination color: blue or green
+
+	drainSince  time.Time     // route rejects new connections since, zero if route is not draining
+	drainTimers []clock.Timer // pending closes of drained connections
+
+	holds map[string]retryHold // methods delayed by backend Retry-After
+
+	rateLimit *tokenBucket // requests rate limit of route, nil if disabled
+}
+
+// newRouteStates returns route states for rules by src on clock c.
+func newRouteStates(rules []ProxyRule, c clock.Clock) (map[string]*routeState, error) {
+	states := make(map[string]*routeState)
+	for _, r := range rules {
+		tmpl, err := parseRequestTemplate(r.Src, r.RequestTemplate)
+		if err != nil {
+			return nil, err
+		}
+
+		rs := &routeState{rule: r, requestTmpl: tmpl, clock: c, inflight: make(map[string]int), rateLimit: newRateLimit(r.RateLimit, r.RateBurst, c)}
+		if rs.windows, err = parseMaintenanceWindows(r.MaintenanceWindows); err != nil {
+			return nil, fmt.Errorf("route %s: %w", r.Src, err)
+		} else if rs.tlsConfig, err = r.tlsConfig(); err != nil {
+			return nil, fmt.Errorf("route %s: tls: %w", r.Src, err)
+		} else if rs.backend, err = newBackend(r); err != nil {
+			return nil, err
+		} else if rs.backend == nil {
+			if rs.translator, err = newTranslator(r); err != nil {
+				return nil, err
+			}
+		}
+
+		states[r.Src] = rs
+	}
+
+	return states, nil
+}
+
+// setMaintenance enables or disables maintenance mode with custom error message.
+func (rs *routeState) setMaintenance(enabled bool, message string) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	rs.maintenance, rs.maintenanceMessage = enabled, message
+}
+
+// maintenanceErr returns error if route is under maintenance or inside scheduled maintenance window.
+func (rs *routeState) maintenanceErr() error {
+	if rs == nil {
+		return nil
+	}
+
+	rs.lock.RLock()
+	defer rs.lock.RUnlock()
+	if rs.maintenance && rs.maintenanceMessage != "" {
+		return errors.New(rs.maintenanceMessage)
+	} else if rs.maintenance {
+		return errMaintenance
+	} else if !rs.scheduledMaintenance() {
+		return nil
+	} else if rs.rule.MaintenanceMessage != "" {
+		return errors.New(rs.rule.MaintenanceMessage)
+	}
+
+	return errMaintenance
+}
+
+// countNotifications checks if route notifications are counted separately from requests.
+func (rs *routeState) countNotifications() bool {
+	return rs != nil && rs.rule.CountNotifications
+}
+
+// observe saves last backend request status as passive backend health.
+func (rs *routeState) observe(status string) {
+	if rs == nil {
+		return
+	}
+
+	rs.lock.Lock()
+	prev := rs.lastStatus
+	rs.lastStatus, rs.lastRequest = status, rs.clock.Now()
+	rs.lock.Unlock()
+
+	if prev != status {
+		proxyEvents.publish(proxyEvent{Type: eventHealth, Route: rs.rule.Src, Data: map[string]string{"status": status, "prev": prev}})
+	}
+}
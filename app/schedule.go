@@ -0,0 +1,141 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// maintenanceWindow is a daily time window of scheduled route maintenance.
+type maintenanceWindow struct {
+	days       [7]bool       // weekdays of window start
+	start, end time.Duration // since midnight, end before start crosses midnight
+	loc        *time.Location
+}
+
+// parseMaintenanceWindow parses window like "02:00-04:00", "mon-fri 22:00-02:00" or "sat,sun 01:00-05:00 Europe/Moscow".
+// Days are optional and apply to window start, time zone is UTC by default.
+func parseMaintenanceWindow(s string) (maintenanceWindow, error) {
+	w := maintenanceWindow{loc: time.UTC}
+	fields := strings.Fields(s)
+	if len(fields) > 0 && !strings.Contains(fields[0], ":") {
+		if err := w.parseDays(fields[0]); err != nil {
+			return w, fmt.Errorf("maintenance window %q: %w", s, err)
+		}
+		fields = fields[1:]
+	} else {
+		w.days = [7]bool{true, true, true, true, true, true, true}
+	}
+
+	if len(fields) == 0 || len(fields) > 2 {
+		return w, fmt.Errorf("maintenance window %q: invalid syntax", s)
+	}
+
+	hours := strings.SplitN(fields[0], "-", 2)
+	if len(hours) != 2 {
+		return w, fmt.Errorf("maintenance window %q: invalid time range", s)
+	}
+
+	var err error
+	if w.start, err = parseClock(hours[0]); err != nil {
+		return w, fmt.Errorf("maintenance window %q: %w", s, err)
+	} else if w.end, err = parseClock(hours[1]); err != nil {
+		return w, fmt.Errorf("maintenance window %q: %w", s, err)
+	} else if w.start == w.end {
+		return w, fmt.Errorf("maintenance window %q: empty time range", s)
+	}
+
+	if len(fields) == 2 {
+		if w.loc, err = time.LoadLocation(fields[1]); err != nil {
+			return w, fmt.Errorf("maintenance window %q: %w", s, err)
+		}
+	}
+
+	return w, nil
+}
+
+// parseDays parses weekdays like "sat", "mon-fri" or "sat,sun".
+func (w *maintenanceWindow) parseDays(s string) error {
+	for _, part := range strings.Split(strings.ToLower(s), ",") {
+		span := strings.SplitN(part, "-", 2)
+		from, to := weekday(span[0]), weekday(span[len(span)-1])
+		if from < 0 || to < 0 {
+			return fmt.Errorf("invalid weekday %q", part)
+		}
+
+		for d := from; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func weekday(s string) int {
+	for i, d := range weekdays {
+		if d == s {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// parseClock parses time of day like 02:30.
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// active checks if t is inside window.
+func (w maintenanceWindow) active(t time.Time) bool {
+	t = t.In(w.loc)
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	day := int(t.Weekday())
+
+	if w.start < w.end {
+		return w.days[day] && now >= w.start && now < w.end
+	}
+
+	// window crosses midnight: evening part belongs to today, morning part to previous day
+	return w.days[day] && now >= w.start || w.days[(day+6)%7] && now < w.end
+}
+
+// parseMaintenanceWindows parses ProxyRule.MaintenanceWindows.
+func parseMaintenanceWindows(list []string) ([]maintenanceWindow, error) {
+	windows := make([]maintenanceWindow, 0, len(list))
+	for _, s := range list {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+
+		w, err := parseMaintenanceWindow(s)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+
+	return windows, nil
+}
+
+// scheduledMaintenance checks if route is inside one of its maintenance windows.
+func (rs *routeState) scheduledMaintenance() bool {
+	now := rs.clock.Now()
+	for _, w := range rs.windows {
+		if w.active(now) {
+			return true
+		}
+	}
+
+	return false
+}
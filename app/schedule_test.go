@@ -0,0 +1,87 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestMaintenanceWindow(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse("Mon 2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	for _, tc := range []struct {
+		window string
+		at     string
+		active bool
+	}{
+		{"02:00-04:00", "Wed 2024-05-01 02:00", true},
+		{"02:00-04:00", "Wed 2024-05-01 04:00", false},
+		{"02:00-04:00", "Wed 2024-05-01 01:59", false},
+		{"mon-fri 02:00-04:00", "Sat 2024-05-04 03:00", false},
+		{"mon-fri 02:00-04:00", "Fri 2024-05-03 03:00", true},
+		{"sat,sun 02:00-04:00", "Sun 2024-05-05 03:00", true},
+		{"fri-mon 02:00-04:00", "Mon 2024-05-06 03:00", true},
+		{"fri-mon 02:00-04:00", "Tue 2024-05-07 03:00", false},
+		{"fri 22:00-02:00", "Fri 2024-05-03 23:00", true},
+		{"fri 22:00-02:00", "Sat 2024-05-04 01:00", true},
+		{"fri 22:00-02:00", "Fri 2024-05-03 01:00", false},
+		{"02:00-04:00 Europe/Moscow", "Wed 2024-05-01 00:30", true},
+		{"02:00-04:00 Europe/Moscow", "Wed 2024-05-01 02:30", false},
+	} {
+		w, err := parseMaintenanceWindow(tc.window)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.window, err)
+		}
+		if active := w.active(at(tc.at)); active != tc.active {
+			t.Errorf("%s at %s: got %v, want %v", tc.window, tc.at, active, tc.active)
+		}
+	}
+
+	for _, s := range []string{"", "02:00", "2am-4am", "02:00-02:00", "someday 02:00-04:00", "02:00-04:00 Mars/Base", "mon 02:00-04:00 UTC x"} {
+		if _, err := parseMaintenanceWindow(s); err == nil {
+			t.Errorf("%q: got no error", s)
+		}
+	}
+}
+
+func TestRouteStateScheduledMaintenance(t *testing.T) {
+	c := clock.NewFake(time.Date(2024, 5, 1, 1, 0, 0, 0, time.UTC))
+
+	routes, err := newRouteStates([]ProxyRule{{Src: "/rpc", MaintenanceWindows: []string{"02:00-04:00"}, MaintenanceMessage: "nightly batch"}}, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs := routes["/rpc"]
+	if err := rs.maintenanceErr(); err != nil {
+		t.Errorf("before window: got %s", err)
+	}
+
+	c.Advance(90 * time.Minute)
+	if err := rs.maintenanceErr(); err == nil || err.Error() != "nightly batch" {
+		t.Errorf("in window: got %v", err)
+	}
+
+	// manual maintenance takes precedence
+	rs.setMaintenance(true, "")
+	if err := rs.maintenanceErr(); err != errMaintenance {
+		t.Errorf("manual maintenance: got %v", err)
+	}
+	rs.setMaintenance(false, "")
+
+	c.Advance(2 * time.Hour)
+	if err := rs.maintenanceErr(); err != nil {
+		t.Errorf("after window: got %s", err)
+	}
+
+	if _, err := newRouteStates([]ProxyRule{{Src: "/rpc", MaintenanceWindows: []string{"02:00"}}}, c); err == nil {
+		t.Error("invalid window: got no error")
+	}
+}
@@ -0,0 +1,214 @@
+package app
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/semrush/ws2http/clock"
+)
+
+const (
+	sendQueueSize          = 256 // outbound frames per connection
+	slowClientCloseTimeout = time.Second
+	sendQueueFlushTimeout  = time.Second
+)
+
+var (
+	errQueueClosed = errors.New("connection send queue is closed")
+	errSlowClient  = errors.New("slow client")
+)
+
+// sendQueue is a per-connection outbound queue: frames are written by single writer goroutine in push order.
+// Client is slow while queue is half full or write is blocked, slow clients are disconnected with
+// policy violation close code after grace period.
+type sendQueue struct {
+	ws      *wsConn
+	clock   clock.Clock
+	frames  chan interface{} // string for text frames, []byte for binary frames
+	done    chan struct{}
+	stopped chan struct{} // closed on writer exit
+	once    sync.Once
+
+	grace        time.Duration // slow client grace period, 0 disables detection
+	writeTimeout time.Duration // write deadline for every frame, 0 is disabled
+	writeStart   int64         // start of current write in unix nanoseconds, 0 if writer is idle
+	slow         int32         // slow client flag, set before disconnection
+	onSlow       func()        // called once on slow client disconnection
+	err          error         // writer error, read after writer exit
+}
+
+// newSendQueue returns started send queue for ws with slow client detection on clock c, queue goroutines are
+// tracked by optional goroutines tracker.
+func newSendQueue(ws *wsConn, c clock.Clock, grace, writeTimeout time.Duration, goroutines *goroutineTracker, onSlow func()) *sendQueue {
+	q := &sendQueue{
+		ws:           ws,
+		clock:        c,
+		frames:       make(chan interface{}, sendQueueSize),
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+		grace:        grace,
+		writeTimeout: writeTimeout,
+		onSlow:       onSlow,
+	}
+
+	goroutines.spawn("writer", q.writer)
+	if grace > 0 {
+		goroutines.spawn("slow_watch", q.watch)
+	}
+
+	return q
+}
+
+// push adds frame to queue, blocks while queue is full.
+func (q *sendQueue) push(frame interface{}) error {
+	select {
+	case <-q.done:
+		return errQueueClosed
+	default:
+	}
+
+	select {
+	case q.frames <- frame:
+		return nil
+	case <-q.done:
+		return errQueueClosed
+	}
+}
+
+// close stops accepting new frames, writer writes queued frames and exits.
+func (q *sendQueue) close() {
+	q.once.Do(func() { close(q.done) })
+}
+
+// flush closes queue, waits for writer and returns write error.
+func (q *sendQueue) flush() error {
+	q.close()
+	<-q.stopped
+	return q.err
+}
+
+// writer writes queued frames, connection is closed on write errors.
+func (q *sendQueue) writer() {
+	defer close(q.stopped)
+	for {
+		select {
+		case frame := <-q.frames:
+			if err := q.send(frame); err != nil {
+				q.err = err
+				q.close()
+				return
+			}
+		case <-q.done:
+			// write queued frames before closing connection, like errors for last requests
+			q.ws.SetWriteDeadline(time.Now().Add(sendQueueFlushTimeout))
+			for {
+				select {
+				case frame := <-q.frames:
+					if q.err = q.send(frame); q.err != nil {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// send writes frame to client with write deadline, slow clients are closed with policy violation code.
+// Connection is closed without close frame after failed or interrupted write, error is returned.
+func (q *sendQueue) send(frame interface{}) error {
+	if q.writeTimeout > 0 {
+		q.ws.SetWriteDeadline(time.Now().Add(q.writeTimeout))
+	}
+
+	// slow flag is set before watch resets deadline, so deadline is not extended for slow client
+	var err error
+	if atomic.LoadInt32(&q.slow) == 0 {
+		atomic.StoreInt64(&q.writeStart, q.clock.Now().UnixNano())
+		err = q.ws.Send(frame)
+		atomic.StoreInt64(&q.writeStart, 0)
+	}
+
+	if err != nil {
+		q.ws.abort()
+	}
+	if atomic.LoadInt32(&q.slow) == 1 {
+		if err == nil {
+			q.ws.closeWith(websocket.ClosePolicyViolation, "slow client")
+		}
+		return errSlowClient
+	}
+
+	return err
+}
+
+// congested checks queue depth and current write duration.
+func (q *sendQueue) congested(now time.Time) bool {
+	if len(q.frames) >= cap(q.frames)/2 {
+		return true
+	}
+
+	start := atomic.LoadInt64(&q.writeStart)
+	return start != 0 && now.Sub(time.Unix(0, start)) >= q.grace/4
+}
+
+// watch checks queue every quarter of grace period and disconnects client if it stays congested for grace period.
+func (q *sendQueue) watch() {
+	period := q.grace / 4
+	if period <= 0 {
+		period = q.grace
+	}
+	t := q.clock.NewTicker(period)
+	defer t.Stop()
+
+	var slowSince time.Time
+	for {
+		select {
+		case <-q.done:
+			return
+		case now := <-t.C():
+			if !q.congested(now) {
+				slowSince = time.Time{}
+				continue
+			} else if slowSince.IsZero() {
+				slowSince = now
+			}
+
+			if now.Sub(slowSince) < q.grace {
+				continue
+			}
+
+			// unblock writer, it closes connection with policy violation code
+			atomic.StoreInt32(&q.slow, 1)
+			if q.onSlow != nil {
+				q.onSlow()
+			}
+			q.ws.interrupt()
+			select {
+			case q.frames <- []byte(nil): // wake up idle writer
+			default:
+			}
+
+			// writer could start new write before interruption
+			select {
+			case <-q.stopped:
+			case <-clock.After(q.clock, slowClientCloseTimeout):
+				q.ws.abort()
+			}
+			return
+		}
+	}
+}
+
+// write sends frame to client through connection send queue if it is started.
+func (rf *requestForwarder) write(frame interface{}) error {
+	if rf.queue == nil {
+		return rf.ws.Send(frame)
+	}
+
+	return rf.queue.push(frame)
+}
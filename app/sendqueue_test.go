@@ -0,0 +1,73 @@
+package app
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestSendQueueSlowClient(t *testing.T) {
+	slow := make(chan struct{})
+	srv := newWsServer(func(ws *wsConn) {
+		q := newSendQueue(ws, clock.Real, 200*time.Millisecond, 0, nil, func() { close(slow) })
+		defer q.close()
+
+		frame := strings.Repeat("a", 64*1024)
+		for q.push(frame) == nil {
+		}
+	})
+	defer srv.Close()
+
+	// client never reads
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), http.Header{"Origin": {srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	select {
+	case <-slow:
+	case <-time.After(5 * time.Second):
+		t.Error("slow client was not disconnected")
+	}
+}
+
+func TestSendQueueWatchPeriod(t *testing.T) {
+	// grace shorter than 4ns must not make zero ticker period
+	q := &sendQueue{clock: clock.NewFake(time.Now()), grace: 3, done: make(chan struct{})}
+	close(q.done)
+	q.watch()
+}
+
+func TestSendQueueWriteTimeout(t *testing.T) {
+	errc := make(chan error, 1)
+	srv := newWsServer(func(ws *wsConn) {
+		q := newSendQueue(ws, clock.Real, 0, 100*time.Millisecond, nil, nil)
+
+		frame := strings.Repeat("a", 64*1024)
+		for q.push(frame) == nil {
+		}
+		errc <- q.flush()
+	})
+	defer srv.Close()
+
+	// client never reads
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), http.Header{"Origin": {srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	select {
+	case err := <-errc:
+		if te, ok := err.(errTimeout); !ok || !te.Timeout() {
+			t.Errorf("flush(): expected timeout error, got = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("write deadline was not applied")
+	}
+}
@@ -0,0 +1,176 @@
+package app
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var sessionSeq uint64
+
+// session is a registered client connection.
+type session struct {
+	id    string
+	route string // source handler, like / or /rpc
+	ws    *wsConn
+	send  func(msg []byte) error // sends json-rpc message with connection codec
+
+	messageIds IdGenerator // ids of proxy messages, nil sends messages without ids
+	messageSeq uint64      // last proxy message number, see IdGenerator
+
+	tagsLock  sync.RWMutex
+	tags      map[string]struct{}
+	adminTags map[string]struct{} // tags set by /admin/tags, clients can't set them
+}
+
+// newSession returns new session with unique id.
+func newSession(route string, ws *wsConn) *session {
+	return &session{
+		id:        strconv.FormatUint(atomic.AddUint64(&sessionSeq, 1), 10),
+		route:     route,
+		ws:        ws,
+		tags:      make(map[string]struct{}),
+		adminTags: make(map[string]struct{}),
+	}
+}
+
+// addTag marks session with tag.
+func (s *session) addTag(tag string) {
+	s.tagsLock.Lock()
+	defer s.tagsLock.Unlock()
+	s.tags[tag] = struct{}{}
+}
+
+// addAdminTag marks session with tag set by admin API.
+func (s *session) addAdminTag(tag string) {
+	s.tagsLock.Lock()
+	defer s.tagsLock.Unlock()
+	s.tags[tag] = struct{}{}
+	s.adminTags[tag] = struct{}{}
+}
+
+// hasAdminTag checks existence of tag set by admin API.
+func (s *session) hasAdminTag(tag string) bool {
+	s.tagsLock.RLock()
+	defer s.tagsLock.RUnlock()
+	_, ok := s.adminTags[tag]
+	return ok
+}
+
+// hasTag checks existence of tag in session tags.
+func (s *session) hasTag(tag string) bool {
+	s.tagsLock.RLock()
+	defer s.tagsLock.RUnlock()
+	_, ok := s.tags[tag]
+	return ok
+}
+
+// tagList returns sorted session tags.
+func (s *session) tagList() []string {
+	s.tagsLock.RLock()
+	defer s.tagsLock.RUnlock()
+
+	list := make([]string, 0, len(s.tags))
+	for tag := range s.tags {
+		list = append(list, tag)
+	}
+	sort.Strings(list)
+
+	return list
+}
+
+// addHeaderTags marks session with comma-separated values of headers, like X-Roles from forward auth claims.
+func (s *session) addHeaderTags(names []string, h http.Header) {
+	for _, name := range names {
+		for _, v := range h[http.CanonicalHeaderKey(name)] {
+			for _, tag := range strings.Split(v, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					s.addTag(tag)
+				}
+			}
+		}
+	}
+}
+
+// sessionFilter selects sessions by id, route and tag. Empty fields match all sessions.
+type sessionFilter struct {
+	Session string `json:"session,omitempty"`
+	Route   string `json:"route,omitempty"`
+	Tag     string `json:"tag,omitempty"`
+}
+
+func (f sessionFilter) match(s *session) bool {
+	if f.Session != "" && f.Session != s.id {
+		return false
+	} else if f.Route != "" && f.Route != s.route {
+		return false
+	}
+
+	return f.Tag == "" || s.hasTag(f.Tag)
+}
+
+// sessionRegistry is a storage for all active sessions.
+type sessionRegistry struct {
+	lock     sync.RWMutex
+	sessions map[string]*session
+
+	cluster  ClusterRegistry // session instances of all ws2http instances, optional
+	instance string          // instance url in cluster registry
+	idPrefix string          // makes session ids unique between cluster instances, see newSessionIdPrefix
+
+	logger
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{sessions: make(map[string]*session)}
+}
+
+// newSession returns new session with id prefix of registry, nil registry returns session without prefix.
+func (r *sessionRegistry) newSession(route string, ws *wsConn) *session {
+	s := newSession(route, ws)
+	if r != nil {
+		s.id = r.idPrefix + s.id
+	}
+
+	return s
+}
+
+func (r *sessionRegistry) add(s *session) {
+	r.lock.Lock()
+	r.sessions[s.id] = s
+	r.lock.Unlock()
+	r.register(s)
+}
+
+func (r *sessionRegistry) remove(s *session) {
+	r.lock.Lock()
+	delete(r.sessions, s.id)
+	r.lock.Unlock()
+	r.unregister(s)
+}
+
+// get returns session by id.
+func (r *sessionRegistry) get(id string) (*session, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	s, ok := r.sessions[id]
+	return s, ok
+}
+
+// find returns sessions matched by filter.
+func (r *sessionRegistry) find(f sessionFilter) []*session {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	var list []*session
+	for _, s := range r.sessions {
+		if f.match(s) {
+			list = append(list, s)
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,179 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/semrush/ws2http/clock"
+)
+
+const (
+	// shutdownMethod is a notification sent to sessions on graceful shutdown before close frame.
+	shutdownMethod = "ws2http.shutdown"
+
+	shutdownPollInterval = 100 * time.Millisecond
+)
+
+var errShuttingDown = errors.New("server is shutting down, reconnect")
+
+// shutdownState tracks in-flight backend requests of all connections, new requests are rejected while
+// in-flight requests are drained on shutdown. Nil state is disabled.
+type shutdownState struct {
+	inflight int64
+	draining int32
+}
+
+// start counts in-flight backend request, release must be called after request.
+func (s *shutdownState) start() (release func()) {
+	if s == nil {
+		return func() {}
+	}
+
+	atomic.AddInt64(&s.inflight, 1)
+	return func() { atomic.AddInt64(&s.inflight, -1) }
+}
+
+// err returns errShuttingDown if in-flight requests are drained.
+func (s *shutdownState) err() error {
+	if s != nil && atomic.LoadInt32(&s.draining) == 1 {
+		return errShuttingDown
+	}
+
+	return nil
+}
+
+// drain rejects new requests and waits for in-flight requests up to timeout of clock c, returns requests left.
+func (s *shutdownState) drain(timeout time.Duration, c clock.Clock) int64 {
+	atomic.StoreInt32(&s.draining, 1)
+	for deadline := c.Now().Add(timeout); atomic.LoadInt64(&s.inflight) > 0 && c.Now().Before(deadline); {
+		<-clock.After(c, shutdownPollInterval)
+	}
+
+	return atomic.LoadInt64(&s.inflight)
+}
+
+// shutdownParams are params of shutdownMethod notification.
+type shutdownParams struct {
+	In        int    `json:"in"`                  // seconds before connection close
+	Reconnect string `json:"reconnect,omitempty"` // suggested endpoint for reconnect, like wss://other.example.com/rpc
+}
+
+// Shutdown gracefully stops server: listener is closed, sessions get ws2http.shutdown notification with
+// ShutdownGrace seconds and ReconnectUrl. New requests of sessions left after ShutdownGrace are rejected,
+// in-flight backend requests are waited up to DrainTimeout, then sessions are closed with close frame.
+// Run returns after Shutdown is completed, next calls return nil.
+func (a *App) Shutdown() error {
+	a.serverLock.Lock()
+	defer a.serverLock.Unlock()
+	if a.server == nil {
+		return nil
+	}
+	server, stopped := a.server, a.stopped
+	a.server = nil
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.ShutdownGrace)
+	defer cancel()
+	defer close(stopped)
+	atomic.StoreInt32(&a.listening, 0)
+
+	// stop accepting connections, hijacked websocket connections are not tracked by server
+	var err error
+	done := make(chan struct{})
+	go func() {
+		err = server.Shutdown(ctx)
+		close(done)
+	}()
+
+	a.notifyShutdown()
+
+	// wait for clients reconnect
+	for len(a.sessions.find(sessionFilter{})) > 0 && ctx.Err() == nil {
+		<-clock.After(a.clock(), shutdownPollInterval)
+	}
+
+	// deliver responses of in-flight requests before close
+	if a.shutdownState != nil {
+		if n := a.shutdownState.drain(a.DrainTimeout, a.clock()); n > 0 {
+			a.Errorf("shutdown drain timeout inflight=%d timeout=%s", n, a.DrainTimeout)
+		}
+	}
+
+	sessions := a.sessions.find(sessionFilter{})
+	a.Printf("shutdown closing sessions=%d", len(sessions))
+	for _, s := range sessions {
+		s.ws.closeWith(websocket.CloseGoingAway, "shutdown")
+	}
+	for _, u := range a.upstreams {
+		u.close()
+	}
+
+	// keep counters of completed requests for restart
+	if a.snapshots != nil {
+		a.snapshots.save()
+	}
+
+	<-done
+	return err
+}
+
+// notifyShutdown sends shutdownMethod notification to all sessions.
+func (a *App) notifyShutdown() {
+	params, _ := json.Marshal(shutdownParams{In: int(math.Ceil(a.ShutdownGrace.Seconds())), Reconnect: a.ReconnectUrl})
+	for _, s := range a.sessions.find(sessionFilter{}) {
+		if err := s.send(s.notification(shutdownMethod, params)); err != nil {
+			a.Errorf("can't send shutdown notification session=%s err=%s", s.id, err)
+		}
+	}
+}
+
+// serve starts http listener or https listener with TlsCertFile, returns nil after Shutdown.
+func (a *App) serve() error {
+	var tlsConfig *tls.Config
+	if a.TlsCertFile != "" {
+		certs, err := newCertReloader(a.TlsCertFile, a.TlsKeyFile, a.TlsReload, a.clock(), a.logger)
+		if err != nil {
+			return err
+		}
+		tlsConfig = &tls.Config{GetCertificate: certs.getCertificate}
+	}
+
+	listeners, err := a.listen()
+	if err != nil {
+		return err
+	}
+
+	server, stopped := &http.Server{Addr: a.ListenAddr, TLSConfig: tlsConfig}, make(chan struct{})
+	a.serverLock.Lock()
+	a.server, a.stopped = server, stopped
+	a.serverLock.Unlock()
+	atomic.StoreInt32(&a.listening, 1)
+
+	// independent accept loops for SO_REUSEPORT listeners
+	errs := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			if tlsConfig != nil {
+				errs <- server.ServeTLS(a.tuneListener(ln), "", "")
+			} else {
+				errs <- server.Serve(a.tuneListener(ln))
+			}
+		}(ln)
+	}
+	for range listeners {
+		if err := <-errs; err != http.ErrServerClosed {
+			server.Close()
+			return err
+		}
+	}
+
+	<-stopped
+	return nil
+}
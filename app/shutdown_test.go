@@ -0,0 +1,100 @@
+package app
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestAppShutdown(t *testing.T) {
+	a := &App{ShutdownGrace: 200 * time.Millisecond, ReconnectUrl: "ws://other/rpc", sessions: newSessionRegistry()}
+	hf := NewHttpForwarder("http://localhost", nil, 1, 1)
+	hf.sessions = a.sessions
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.server, a.stopped = &http.Server{Handler: hf.WebsocketHandler()}, make(chan struct{})
+	go a.server.Serve(ln)
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/rpc", http.Header{"Origin": {"http://" + ln.Addr().String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	for len(a.sessions.find(sessionFilter{})) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	started := time.Now()
+	done := make(chan error)
+	go func() { done <- a.Shutdown() }()
+
+	var n struct {
+		Method string         `json:"method"`
+		Params shutdownParams `json:"params"`
+	}
+	if err := ws.ReadJSON(&n); err != nil {
+		t.Fatal(err)
+	} else if n.Method != shutdownMethod || n.Params != (shutdownParams{In: 1, Reconnect: "ws://other/rpc"}) {
+		t.Errorf("got %+v", n)
+	}
+
+	// connection is closed with going away code after grace
+	var msg json.RawMessage
+	if err := ws.ReadJSON(&msg); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("got %s, %v", msg, err)
+	}
+
+	if err := <-done; err != nil {
+		t.Error(err)
+	} else if time.Since(started) < a.ShutdownGrace {
+		t.Errorf("closed after %s", time.Since(started))
+	}
+
+	select {
+	case <-a.stopped:
+	default:
+		t.Error("not stopped")
+	}
+
+	// repeated shutdown is a no-op
+	if err := a.Shutdown(); err != nil {
+		t.Errorf("second shutdown: got %v", err)
+	}
+}
+
+func TestShutdownStateDrain(t *testing.T) {
+	var s *shutdownState
+	s.start()()
+	if s.err() != nil {
+		t.Error("nil state: got error")
+	}
+
+	s = &shutdownState{}
+	release := s.start()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		release()
+	}()
+
+	if n := s.drain(time.Second, clock.Real); n != 0 {
+		t.Errorf("got %d in-flight requests", n)
+	} else if s.err() != errShuttingDown {
+		t.Errorf("got %v", s.err())
+	}
+
+	// drain timeout
+	s = &shutdownState{}
+	s.start()
+	if n := s.drain(0, clock.Real); n != 1 {
+		t.Errorf("got %d in-flight requests", n)
+	}
+}
@@ -0,0 +1,209 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/semrush/ws2http/clock"
+)
+
+const (
+	sloWindow        = 1024             // last request durations per method for percentiles
+	sloMaxMethods    = 1000             // tracked methods limit, new methods over limit are ignored
+	sloCheckInterval = 10 * time.Second // objectives check interval
+)
+
+// SloObjective is a latency objective for method percentile, like users.get p99 < 300ms.
+type SloObjective struct {
+	Method     string        // method or * for all methods
+	Percentile float64       // 50, 95 or 99
+	Threshold  time.Duration // max percentile latency
+}
+
+func (o SloObjective) String() string {
+	return fmt.Sprintf("p%g<%s", o.Percentile, o.Threshold)
+}
+
+// matches checks objective method.
+func (o SloObjective) matches(method string) bool {
+	return o.Method == "*" || o.Method == method
+}
+
+// sloKey is a tracked method of route.
+type sloKey struct {
+	route, method string
+}
+
+// methodLatency is a ring of last request durations.
+type methodLatency struct {
+	samples [sloWindow]time.Duration
+	count   int // total requests
+}
+
+// percentiles returns percentiles of window durations.
+func (ml *methodLatency) percentiles(ps ...float64) []time.Duration {
+	n := ml.count
+	if n > sloWindow {
+		n = sloWindow
+	}
+
+	sorted := append([]time.Duration(nil), ml.samples[:n]...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	res := make([]time.Duration, len(ps))
+	for i, p := range ps {
+		if n == 0 {
+			continue
+		}
+		idx := int(p/100*float64(n)+0.5) - 1
+		if idx < 0 {
+			idx = 0
+		} else if idx >= n {
+			idx = n - 1
+		}
+		res[i] = sorted[idx]
+	}
+
+	return res
+}
+
+// sloTracker is an in-process rolling latency percentiles by method for /admin/slo.
+type sloTracker struct {
+	lock       sync.Mutex
+	methods    map[sloKey]*methodLatency
+	objectives []SloObjective
+	violations *prometheus.CounterVec
+}
+
+// newSloTracker returns tracker with objectives and violations counter, objectives are checked every
+// sloCheckInterval of clock c. Nil tracker is disabled.
+func newSloTracker(objectives []SloObjective, violations *prometheus.CounterVec, c clock.Clock) *sloTracker {
+	t := &sloTracker{methods: make(map[sloKey]*methodLatency), objectives: objectives, violations: violations}
+	if len(objectives) > 0 {
+		ticker := c.NewTicker(sloCheckInterval)
+		go func() {
+			for range ticker.C() {
+				t.check()
+			}
+		}()
+	}
+
+	return t
+}
+
+// observe saves request duration for route method.
+func (t *sloTracker) observe(route, method string, duration time.Duration) {
+	if t == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	key := sloKey{route: route, method: method}
+	ml, ok := t.methods[key]
+	if !ok {
+		if len(t.methods) >= sloMaxMethods {
+			return
+		}
+		ml = new(methodLatency)
+		t.methods[key] = ml
+	}
+
+	ml.samples[ml.count%sloWindow] = duration
+	ml.count++
+}
+
+// objectiveStatus is a result of objective check.
+type objectiveStatus struct {
+	Objective string `json:"objective"`
+	Ok        bool   `json:"ok"`
+}
+
+// methodSlo is a latency report of method.
+type methodSlo struct {
+	Route      string            `json:"route"`
+	Method     string            `json:"method"`
+	Requests   int               `json:"requests"` // total requests, percentiles are for last sloWindow requests
+	P50        float64           `json:"p50"`      // milliseconds
+	P95        float64           `json:"p95"`
+	P99        float64           `json:"p99"`
+	Objectives []objectiveStatus `json:"objectives,omitempty"`
+}
+
+// report returns latency percentiles and objectives status by method sorted by route and method.
+func (t *sloTracker) report() []methodSlo {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	list := make([]methodSlo, 0, len(t.methods))
+	for key, ml := range t.methods {
+		ps := ml.percentiles(50, 95, 99)
+		ms := methodSlo{Route: key.route, Method: key.method, Requests: ml.count, P50: durationMs(ps[0]), P95: durationMs(ps[1]), P99: durationMs(ps[2])}
+		for _, o := range t.objectives {
+			if o.matches(key.method) {
+				ms.Objectives = append(ms.Objectives, objectiveStatus{Objective: o.String(), Ok: ml.percentiles(o.Percentile)[0] <= o.Threshold})
+			}
+		}
+		list = append(list, ms)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Route < list[j].Route || (list[i].Route == list[j].Route && list[i].Method < list[j].Method)
+	})
+
+	return list
+}
+
+// check counts objectives violations by method.
+func (t *sloTracker) check() {
+	for _, ms := range t.report() {
+		for _, o := range ms.Objectives {
+			if !o.Ok && t.violations != nil {
+				t.violations.WithLabelValues(ms.Route, ms.Method, o.Objective).Inc()
+			}
+		}
+	}
+}
+
+// durationMs returns duration in milliseconds.
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// ParseSloObjective parses objective like users.get:p99:300ms or *:p95:1s.
+func ParseSloObjective(value string) (SloObjective, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 || !strings.HasPrefix(parts[1], "p") {
+		return SloObjective{}, fmt.Errorf("invalid slo objective %q", value)
+	}
+
+	var o SloObjective
+	if _, err := fmt.Sscanf(parts[1], "p%g", &o.Percentile); err != nil || o.Percentile <= 0 || o.Percentile > 100 {
+		return SloObjective{}, fmt.Errorf("invalid slo percentile %q", parts[1])
+	}
+
+	threshold, err := time.ParseDuration(parts[2])
+	if err != nil {
+		return SloObjective{}, err
+	}
+	o.Method, o.Threshold = parts[0], threshold
+
+	return o, nil
+}
+
+// slo returns method latency percentiles and objectives status.
+// Example: curl http://localhost:8090/admin/slo
+func (a *App) slo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, a.slos.report())
+}
@@ -0,0 +1,39 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSloTracker(t *testing.T) {
+	o, err := ParseSloObjective("users.get:p99:50ms")
+	if err != nil || o.Method != "users.get" || o.Percentile != 99 || o.Threshold != 50*time.Millisecond {
+		t.Fatalf("got %+v, %v", o, err)
+	}
+
+	if _, err := ParseSloObjective("users.get:99:50ms"); err == nil {
+		t.Error("no error for invalid percentile")
+	}
+
+	st := &sloTracker{methods: make(map[sloKey]*methodLatency), objectives: []SloObjective{o, {Method: "*", Percentile: 50, Threshold: time.Second}}}
+	for i := 1; i <= 100; i++ {
+		st.observe("/rpc", "users.get", time.Duration(i)*time.Millisecond)
+	}
+
+	r := st.report()
+	if len(r) != 1 || r[0].Requests != 100 || r[0].P50 != 50 || r[0].P95 != 95 || r[0].P99 != 99 {
+		t.Fatalf("got %+v", r)
+	}
+
+	if len(r[0].Objectives) != 2 || r[0].Objectives[0] != (objectiveStatus{Objective: "p99<50ms", Ok: false}) || !r[0].Objectives[1].Ok {
+		t.Errorf("got %+v", r[0].Objectives)
+	}
+
+	// window keeps last requests
+	for i := 0; i < sloWindow; i++ {
+		st.observe("/rpc", "users.get", time.Millisecond)
+	}
+	if r = st.report(); r[0].P99 != 1 || r[0].Requests != 100+sloWindow {
+		t.Errorf("got %+v", r)
+	}
+}
@@ -0,0 +1,85 @@
+package app
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/semrush/ws2http/clock"
+)
+
+// destinationSlots limits parallel backend requests per destination url for all connections and routes, so slow
+// backend saturates only own budget. Waiting requests are served round-robin by connection with fairScheduler.
+type destinationSlots struct {
+	lock       sync.Mutex
+	limit      int // max parallel requests per destination, 0 is unlimited
+	schedulers map[string]*fairScheduler
+
+	queued, inUse *prometheus.GaugeVec     // waiting and running requests by url
+	waits         *prometheus.HistogramVec // slot wait durations by url
+	clock         clock.Clock
+}
+
+// newDestinationSlots returns slots with limit per destination, queue metrics and clock of wait durations, metrics
+// are optional. Nil slots are unlimited.
+func newDestinationSlots(limit int, queued, inUse *prometheus.GaugeVec, waits *prometheus.HistogramVec, c clock.Clock) *destinationSlots {
+	return &destinationSlots{limit: limit, schedulers: make(map[string]*fairScheduler), queued: queued, inUse: inUse, waits: waits, clock: c}
+}
+
+// scheduler returns scheduler for destination url or nil without limit.
+func (d *destinationSlots) scheduler(url string) *fairScheduler {
+	if d == nil {
+		return nil
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.limit <= 0 {
+		return nil
+	}
+
+	s, ok := d.schedulers[url]
+	if !ok {
+		s = newFairScheduler(d.limit)
+		d.schedulers[url] = s
+	}
+
+	return s
+}
+
+// acquire waits for destination url slot for connection key and returns slot release func.
+func (d *destinationSlots) acquire(ctx context.Context, url string, key interface{}) (func(), error) {
+	s := d.scheduler(url)
+	if s == nil {
+		return func() {}, nil
+	}
+
+	d.lock.Lock()
+	queued, inUse, waits, c := d.queued, d.inUse, d.waits, d.clock
+	d.lock.Unlock()
+
+	if queued != nil {
+		queued.WithLabelValues(url).Inc()
+	}
+	start := c.Now()
+	err := s.acquire(ctx, key)
+	if queued != nil {
+		queued.WithLabelValues(url).Dec()
+	}
+	if waits != nil {
+		waits.WithLabelValues(url).Observe(c.Now().Sub(start).Seconds())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if inUse != nil {
+		inUse.WithLabelValues(url).Inc()
+	}
+	return func() {
+		if inUse != nil {
+			inUse.WithLabelValues(url).Dec()
+		}
+		s.release()
+	}, nil
+}
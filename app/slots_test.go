@@ -0,0 +1,53 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestDestinationSlots(t *testing.T) {
+	queued := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "queued"}, []string{"url"})
+	inUse := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "in_use"}, []string{"url"})
+	d := newDestinationSlots(1, queued, inUse, nil, clock.Real)
+
+	gauge := func(g *prometheus.GaugeVec, url string) float64 {
+		return testutil.ToFloat64(g.WithLabelValues(url))
+	}
+
+	release, err := d.acquire(context.Background(), "http://slow", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// other destination has own budget
+	releaseFast, err := d.acquire(context.Background(), "http://fast", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseFast()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := d.acquire(ctx, "http://slow", "b"); err != context.DeadlineExceeded {
+		t.Errorf("got %v", err)
+	}
+
+	if gauge(inUse, "http://slow") != 1 || gauge(inUse, "http://fast") != 0 || gauge(queued, "http://slow") != 0 {
+		t.Errorf("got in use %v, queued %v", gauge(inUse, "http://slow"), gauge(queued, "http://slow"))
+	}
+	release()
+
+	// no limit
+	for _, d := range []*destinationSlots{newDestinationSlots(0, nil, nil, nil, clock.Real), nil} {
+		if release, err := d.acquire(context.Background(), "http://slow", "a"); err != nil {
+			t.Error(err)
+		} else {
+			release()
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+const (
+	// slowStartMinShare is a share of destination traffic admitted right after backend recovery.
+	slowStartMinShare = 0.1
+
+	// slowStartOutage and slowStartFailures are min duration and count of consecutive failed requests to destination
+	// before successful request starts recovery ramp, so single errors between successes don't throttle traffic.
+	slowStartOutage   = 5 * time.Second
+	slowStartFailures = 3
+)
+
+var errWarmingUp = errors.New("backend is warming up after recovery, retry later")
+
+// failStreak is a run of consecutive failed requests to destination.
+type failStreak struct {
+	since    time.Time
+	failures int
+}
+
+// observeDestination tracks failed requests to dstUrl and starts its recovery ramp on successful request after
+// outage of slowStartOutage with slowStartFailures failed requests. Cancelled requests are ignored.
+func (rs *routeState) observeDestination(dstUrl, status string) {
+	if rs == nil || rs.rule.SlowStart <= 0 || status == "cancelled" {
+		return
+	}
+
+	now := rs.clock.Now()
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	streak := rs.failing[dstUrl]
+	if status != "ok" {
+		if streak.failures == 0 {
+			streak.since = now
+		}
+		streak.failures++
+		if rs.failing == nil {
+			rs.failing = make(map[string]failStreak)
+		}
+		rs.failing[dstUrl] = streak
+		return
+	}
+
+	delete(rs.failing, dstUrl)
+	if streak.failures >= slowStartFailures && now.Sub(streak.since) >= slowStartOutage {
+		rs.setRecovered(dstUrl, now)
+	}
+}
+
+// markRecovered starts recovery ramp of dstUrl at now, it is called by health checker when destination becomes
+// healthy after failed probes.
+func (rs *routeState) markRecovered(dstUrl string, now time.Time) {
+	if rs == nil || rs.rule.SlowStart <= 0 {
+		return
+	}
+
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	delete(rs.failing, dstUrl)
+	rs.setRecovered(dstUrl, now)
+}
+
+// setRecovered saves recovery time of dstUrl, must be called with lock.
+func (rs *routeState) setRecovered(dstUrl string, now time.Time) {
+	if rs.recovered == nil {
+		rs.recovered = make(map[string]time.Time)
+	}
+	rs.recovered[dstUrl] = now
+}
+
+// trafficShare returns admitted share of dstUrl traffic at now: it grows linearly from slowStartMinShare
+// to 1 during SlowStart after destination recovery.
+func (rs *routeState) trafficShare(dstUrl string, now time.Time) float64 {
+	if rs == nil || rs.rule.SlowStart <= 0 {
+		return 1
+	}
+
+	rs.lock.RLock()
+	recovered := rs.recovered[dstUrl]
+	rs.lock.RUnlock()
+
+	elapsed := now.Sub(recovered)
+	if recovered.IsZero() || elapsed >= rs.rule.SlowStart {
+		return 1
+	}
+
+	return slowStartMinShare + (1-slowStartMinShare)*float64(elapsed)/float64(rs.rule.SlowStart)
+}
+
+// warmUpErr returns errWarmingUp for requests over traffic share of recovering dstUrl.
+func (rs *routeState) warmUpErr(dstUrl string) error {
+	if rs == nil || rs.rule.SlowStart <= 0 {
+		return nil
+	} else if share := rs.trafficShare(dstUrl, rs.clock.Now()); share < 1 && rand.Float64() >= share {
+		return errWarmingUp
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestRouteTrafficShare(t *testing.T) {
+	const dst, other = "http://blue/rpc", "http://canary/rpc"
+	type step struct {
+		status  string
+		advance time.Duration
+	}
+	tests := []struct {
+		name      string
+		steps     []step
+		recovered bool
+	}{
+		{"single error between successes", []step{{"ok", time.Second}, {"error", time.Second}, {"ok", 0}}, false},
+		{"short failure burst", []step{{"error", 100 * time.Millisecond}, {"timeout", 100 * time.Millisecond}, {"error", 100 * time.Millisecond}, {"ok", 0}}, false},
+		{"long outage with few requests", []step{{"error", 10 * time.Second}, {"ok", 0}}, false},
+		{"cancelled requests are ignored", []step{{"error", 3 * time.Second}, {"cancelled", 0}, {"error", 3 * time.Second}, {"cancelled", 0}, {"ok", 0}}, false},
+		{"outage", []step{{"error", 3 * time.Second}, {"timeout", 3 * time.Second}, {"connection_refused", 0}, {"ok", 0}}, true},
+	}
+	for _, tt := range tests {
+		c := clock.NewFake(time.Now())
+		rs := &routeState{rule: ProxyRule{Src: "/rpc", SlowStart: 10 * time.Second}, clock: c}
+		for _, s := range tt.steps {
+			rs.observeDestination(dst, s.status)
+			c.Advance(s.advance)
+		}
+
+		share := rs.trafficShare(dst, c.Now())
+		if tt.recovered && share != slowStartMinShare || !tt.recovered && share != 1 {
+			t.Errorf("%s: got share %v", tt.name, share)
+		}
+		if share := rs.trafficShare(other, c.Now()); share != 1 {
+			t.Errorf("%s: got other destination share %v", tt.name, share)
+		}
+		for i := 0; i < 100 && !tt.recovered; i++ {
+			if err := rs.warmUpErr(dst); err != nil {
+				t.Fatalf("%s: request is throttled: %v", tt.name, err)
+			}
+		}
+	}
+
+	// ramp grows linearly during SlowStart
+	c := clock.NewFake(time.Now())
+	rs := &routeState{rule: ProxyRule{Src: "/rpc", SlowStart: 10 * time.Second}, clock: c}
+	rs.markRecovered(dst, c.Now())
+	ramp := []struct {
+		elapsed time.Duration
+		share   float64
+	}{
+		{0, slowStartMinShare},
+		{5 * time.Second, 0.55},
+		{10 * time.Second, 1},
+		{time.Minute, 1},
+	}
+	for _, tt := range ramp {
+		if share := rs.trafficShare(dst, c.Now().Add(tt.elapsed)); share < tt.share-1e-9 || share > tt.share+1e-9 {
+			t.Errorf("got %v after %s, want %v", share, tt.elapsed, tt.share)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		if err := rs.warmUpErr(other); err != nil {
+			t.Fatalf("healthy destination is throttled: %v", err)
+		}
+	}
+
+	if share := (&routeState{}).trafficShare(dst, c.Now()); share != 1 {
+		t.Errorf("got %v without slow start", share)
+	}
+}
+
+func TestHealthCheckSlowStart(t *testing.T) {
+	var down int32 = 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	c := clock.NewFake(time.Now())
+	dst := srv.URL + "/rpc"
+	routes, _ := newRouteStates([]ProxyRule{{Src: "/rpc", DstUrl: dst, SlowStart: 10 * time.Second}}, c)
+	hc, _ := newHealthChecker(routes, "/health", "", time.Second, 0, c, logger{})
+
+	// first healthy probe is not recovery
+	atomic.StoreInt32(&down, 0)
+	hc.check()
+	if share := routes["/rpc"].trafficShare(dst, c.Now()); share != 1 {
+		t.Errorf("first probe: got share %v", share)
+	}
+
+	atomic.StoreInt32(&down, 1)
+	hc.check()
+	atomic.StoreInt32(&down, 0)
+	hc.check()
+	if share := routes["/rpc"].trafficShare(dst, c.Now()); share != slowStartMinShare {
+		t.Errorf("after recovery: got share %v", share)
+	}
+}
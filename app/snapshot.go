@@ -0,0 +1,188 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/semrush/ws2http/clock"
+)
+
+const (
+	metricsSnapshotInterval = time.Minute
+	metricsSnapshotTimeout  = 10 * time.Second // storage request timeout
+)
+
+// errSnapshotName is returned without stable snapshot name, host names and instance ids change on restarts.
+var errSnapshotName = errors.New("metrics snapshot name is required and can't contain path separators")
+
+// metricsSnapshot is a persisted state of counters, values are added to counters by labels at startup.
+type metricsSnapshot struct {
+	Time     time.Time                  `json:"time"`
+	Counters map[string][]snapshotValue `json:"counters"` // by counter name without namespace, like proxy_requests_total
+}
+
+type snapshotValue struct {
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+// snapshotCounters returns counters available for MetricsSnapshot by name without namespace.
+func (a *App) snapshotCounters() map[string]*prometheus.CounterVec {
+	return map[string]*prometheus.CounterVec{
+		"proxy_requests_total":          a.statBackendRequests,
+		"proxy_notifications_total":     a.statNotifications,
+		"proxy_cache_requests_total":    a.statCacheRequests,
+		"proxy_rate_limited_total":      a.statRateLimited,
+		"proxy_version_requests_total":  a.statBackendVersions,
+		"proxy_orphan_responses_total":  a.statOrphanResponses,
+		"slo_violation_total":           a.statSloViolations,
+		"ws_origin_rejected_total":      a.statOriginRejected,
+		"ws_slow_clients_total":         a.statSlowClients,
+		"ws_goroutine_leaks_total":      a.statGoroutineLeaks,
+		"debug_dropped_events_total":    a.statDebugDropped,
+		"pool_connections_opened_total": a.pool.opened,
+		"pool_connections_closed_total": a.pool.closed,
+		"pool_tls_handshakes_total":     a.pool.handshakes,
+	}
+}
+
+// metricsSnapshotter saves selected counters to storage as metrics/<MetricsSnapshotName>.json, so short restarts
+// don't reset long-horizon dashboards built on raw counters.
+type metricsSnapshotter struct {
+	storage  Storage
+	name     string
+	clock    clock.Clock
+	names    map[string]string // counter names without namespace by full name
+	counters map[string]*prometheus.CounterVec
+	registry *prometheus.Registry // selected counters without instance labels
+
+	logger
+}
+
+// startMetricsSnapshot restores MetricsSnapshot counters from storage and saves them every MetricsSnapshotInterval.
+func (a *App) startMetricsSnapshot() error {
+	if a.storage == nil {
+		return errNoStorage
+	} else if a.MetricsSnapshotName == "" || strings.ContainsAny(a.MetricsSnapshotName, `/\`) {
+		return errSnapshotName
+	}
+
+	available := a.snapshotCounters()
+	s := &metricsSnapshotter{
+		storage:  a.storage,
+		name:     "metrics/" + a.MetricsSnapshotName + ".json",
+		clock:    a.clock(),
+		names:    make(map[string]string),
+		counters: make(map[string]*prometheus.CounterVec),
+		registry: prometheus.NewRegistry(),
+		logger:   a.logger,
+	}
+	for _, name := range a.MetricsSnapshot {
+		c, ok := available[name]
+		if !ok {
+			names := make([]string, 0, len(available))
+			for n := range available {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("unknown snapshot counter %q, available: %s", name, strings.Join(names, ","))
+		} else if _, ok := s.counters[name]; ok {
+			continue
+		}
+
+		s.counters[name] = c
+		s.names[prometheus.BuildFQName(a.AppName, "", name)] = name
+		s.registry.MustRegister(c)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), metricsSnapshotTimeout)
+	defer cancel()
+	if err := s.restore(ctx); err != nil {
+		a.Errorf("can't restore metrics snapshot name=%s err=%s", s.name, err)
+	}
+
+	interval := a.MetricsSnapshotInterval
+	if interval <= 0 {
+		interval = metricsSnapshotInterval
+	}
+	ticker := a.clock().NewTicker(interval)
+	go func() {
+		for range ticker.C() {
+			s.save()
+		}
+	}()
+	a.snapshots = s
+
+	return nil
+}
+
+// restore adds saved values to counters, missing snapshot is not an error.
+func (s *metricsSnapshotter) restore(ctx context.Context) error {
+	data, err := s.storage.Get(ctx, s.name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var snapshot metricsSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+
+	restored := 0
+	for name, values := range snapshot.Counters {
+		c, ok := s.counters[name]
+		if !ok {
+			continue
+		}
+
+		for _, v := range values {
+			counter, err := c.GetMetricWith(v.Labels)
+			if err != nil || v.Value < 0 {
+				s.Errorf("skipping metrics snapshot value counter=%s labels=%v err=%v", name, v.Labels, err)
+				continue
+			}
+			counter.Add(v.Value)
+			restored++
+		}
+	}
+	s.Printf("restored metrics snapshot name=%s time=%s values=%d", s.name, snapshot.Time.Format(time.RFC3339), restored)
+
+	return nil
+}
+
+// save puts current values of counters to storage, errors are logged.
+func (s *metricsSnapshotter) save() {
+	families, err := s.registry.Gather()
+	if err != nil {
+		s.Errorf("can't gather metrics snapshot err=%s", err)
+		return
+	}
+
+	snapshot := metricsSnapshot{Time: s.clock.Now(), Counters: make(map[string][]snapshotValue)}
+	for _, f := range families {
+		name := s.names[f.GetName()]
+		for _, m := range f.GetMetric() {
+			v := snapshotValue{Labels: make(map[string]string), Value: m.GetCounter().GetValue()}
+			for _, l := range m.GetLabel() {
+				v.Labels[l.GetName()] = l.GetValue()
+			}
+			snapshot.Counters[name] = append(snapshot.Counters[name], v)
+		}
+	}
+
+	data, _ := json.Marshal(snapshot)
+	ctx, cancel := context.WithTimeout(context.Background(), metricsSnapshotTimeout)
+	defer cancel()
+	if err := s.storage.Put(ctx, s.name, data); err != nil {
+		s.Errorf("can't save metrics snapshot name=%s err=%s", s.name, err)
+	}
+}
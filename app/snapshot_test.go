@@ -0,0 +1,95 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestMetricsSnapshot(t *testing.T) {
+	c := clock.NewFake(time.Now())
+
+	storage, err := OpenStorage("file://" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newApp := func() *App {
+		return &App{
+			AppName:             "test",
+			Clock:               c,
+			MetricsSnapshot:     []string{"proxy_requests_total", "slo_violation_total"},
+			MetricsSnapshotName: "ws2http-0",
+			storage:             storage,
+			pool:                newPoolStats("test"),
+			statBackendRequests: prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: "test", Subsystem: "proxy", Name: "requests_total"}, []string{"url", "method", "status"}),
+			statSloViolations:   prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: "test", Name: "slo_violation_total"}, []string{"url", "method", "objective"}),
+			statNotifications:   prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: "test", Subsystem: "proxy", Name: "notifications_total"}, []string{"url", "method", "status"}),
+		}
+	}
+
+	// missing snapshot starts from zero
+	a := newApp()
+	if err := a.startMetricsSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	a.statBackendRequests.WithLabelValues("/rpc", "users.get", "ok").Add(5)
+	a.statSloViolations.WithLabelValues("/rpc", "users.get", "p99").Inc()
+	a.statNotifications.WithLabelValues("/rpc", "log", "ok").Inc()
+	a.snapshots.save()
+
+	data, err := storage.Get(context.Background(), "metrics/ws2http-0.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot metricsSnapshot
+	json.Unmarshal(data, &snapshot)
+	if len(snapshot.Counters) != 2 || len(snapshot.Counters["proxy_requests_total"]) != 1 {
+		t.Errorf("got %s", data)
+	}
+
+	// restart restores selected counters
+	a = newApp()
+	if err := a.startMetricsSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	a.statBackendRequests.WithLabelValues("/rpc", "users.get", "ok").Inc()
+	if v := testutil.ToFloat64(a.statBackendRequests.WithLabelValues("/rpc", "users.get", "ok")); v != 6 {
+		t.Errorf("got requests %v", v)
+	}
+	if v := testutil.ToFloat64(a.statSloViolations.WithLabelValues("/rpc", "users.get", "p99")); v != 1 {
+		t.Errorf("got violations %v", v)
+	}
+	if v := testutil.ToFloat64(a.statNotifications.WithLabelValues("/rpc", "log", "ok")); v != 0 {
+		t.Errorf("not selected: got %v", v)
+	}
+
+	// corrupted snapshot is ignored
+	storage.Put(context.Background(), "metrics/ws2http-0.json", []byte("{"))
+	if err := newApp().startMetricsSnapshot(); err != nil {
+		t.Errorf("corrupted: got %v", err)
+	}
+
+	a = newApp()
+	a.MetricsSnapshot = []string{"proxy_unknown_total"}
+	if err := a.startMetricsSnapshot(); err == nil {
+		t.Error("unknown counter: expected error")
+	}
+	a = newApp()
+	a.storage = nil
+	if err := a.startMetricsSnapshot(); err != errNoStorage {
+		t.Errorf("no storage: got %v", err)
+	}
+	for _, name := range []string{"", "../ws2http"} {
+		a = newApp()
+		a.MetricsSnapshotName = name
+		if err := a.startMetricsSnapshot(); err != errSnapshotName {
+			t.Errorf("name %q: got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+const ProtocolSoap = "soap"
+
+var errSoapResult = errors.New("soap result was not found")
+
+// soapTranslator converts json-rpc calls into soap requests: method is mapped to SOAPAction,
+// params are rendered into soap:Body by request template and result is extracted by path.
+type soapTranslator struct {
+	tmpl       *template.Template
+	actions    map[string]string
+	resultPath []string
+}
+
+func newSoapTranslator(rule ProxyRule) (*soapTranslator, error) {
+	tmpl, err := parseRequestTemplate(rule.Src, rule.RequestTemplate)
+	if err != nil {
+		return nil, err
+	} else if tmpl == nil {
+		return nil, fmt.Errorf("soap request template is required for route=%s", rule.Src)
+	}
+
+	return &soapTranslator{
+		tmpl:       tmpl,
+		actions:    rule.SoapActions,
+		resultPath: strings.Split(strings.Trim(rule.SoapResultPath, "/"), "/"),
+	}, nil
+}
+
+func (t *soapTranslator) encode(req JsonRpcRequest, headers http.Header) ([]byte, error) {
+	data, err := newRequestTemplateData(req, nil, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>`)
+	if err = t.tmpl.Execute(&b, data); err != nil {
+		return nil, err
+	}
+	b.WriteString(`</soap:Body></soap:Envelope>`)
+
+	action, ok := t.actions[req.Method]
+	if !ok {
+		action = req.Method
+	}
+
+	headers.Set("Content-Type", "text/xml; charset=utf-8")
+	headers.Set("SOAPAction", `"`+action+`"`)
+	return b.Bytes(), nil
+}
+
+func (t *soapTranslator) decode(req JsonRpcRequest, resp []byte) ([]byte, error) {
+	var n xmlNode
+	if err := xml.Unmarshal(resp, &n); err != nil {
+		return nil, err
+	}
+
+	// soap:Fault is returned as json-rpc error
+	if body, ok := n.child("Body"); ok {
+		if f, ok := body.child("Fault"); ok {
+			fs, _ := f.child("faultstring")
+			return NewJsonRpcErr(req, JsonRpcServerErr, errors.New(strings.TrimSpace(fs.Content))).JSON(), nil
+		}
+	}
+
+	result, ok := n.find(t.resultPath)
+	if !ok {
+		return nil, errSoapResult
+	}
+
+	return JsonRpcResponse{Version: "2.0", Id: req.Id, Result: result.value()}.JSON(), nil
+}
+
+// find returns element by path of local names starting from n, like Envelope/Body/*/Result. * matches any element.
+func (n xmlNode) find(path []string) (xmlNode, bool) {
+	if len(path) == 0 || (path[0] != "*" && path[0] != n.XMLName.Local) {
+		return xmlNode{}, false
+	} else if len(path) == 1 {
+		return n, true
+	}
+
+	for _, c := range n.Nodes {
+		if r, ok := c.find(path[1:]); ok {
+			return r, true
+		}
+	}
+
+	return xmlNode{}, false
+}
+
+// value converts element into json value: text for leaf elements, map for elements with children.
+// Repeated child elements are converted into slice.
+func (n xmlNode) value() interface{} {
+	if len(n.Nodes) == 0 {
+		return strings.TrimSpace(n.Content)
+	}
+
+	m := make(map[string]interface{})
+	for _, c := range n.Nodes {
+		name := c.XMLName.Local
+		if v, ok := m[name]; !ok {
+			m[name] = c.value()
+		} else if list, ok := v.([]interface{}); ok {
+			m[name] = append(list, c.value())
+		} else {
+			m[name] = []interface{}{v, c.value()}
+		}
+	}
+
+	return m
+}
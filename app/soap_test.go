@@ -0,0 +1,49 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSoapTranslator(t *testing.T) {
+	tr, err := newSoapTranslator(ProxyRule{
+		Src:             "/soap",
+		RequestTemplate: `<GetUser xmlns="urn:users"><Id>{{xml .Args.id}}</Id></GetUser>`,
+		SoapActions:     map[string]string{"getUser": "urn:users/GetUser"},
+		SoapResultPath:  "Envelope/Body/*/User",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := json.RawMessage(`{"id":"<42>"}`)
+	req := JsonRpcRequest{JsonRpc: "2.0", Id: json.RawMessage(`1`), Method: "getUser", Params: &params}
+
+	h := make(http.Header)
+	body, err := tr.encode(req, h)
+	if err != nil || !strings.Contains(string(body), `<soap:Body><GetUser xmlns="urn:users"><Id>&lt;42&gt;</Id></GetUser></soap:Body>`) || h.Get("SOAPAction") != `"urn:users/GetUser"` {
+		t.Errorf("encode(): got = %s, %v, %v", body, h, err)
+	}
+
+	var tc = []struct {
+		in, out string
+	}{
+		{
+			in:  `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><GetUserResponse><User><Name>test</Name><Role>a</Role><Role>b</Role></User></GetUserResponse></soap:Body></soap:Envelope>`,
+			out: `{"jsonrpc":"2.0","id":1,"result":{"Name":"test","Role":["a","b"]}}`,
+		},
+		{
+			in:  `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><soap:Fault><faultcode>soap:Server</faultcode><faultstring>User not found</faultstring></soap:Fault></soap:Body></soap:Envelope>`,
+			out: `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"User not found"}}`,
+		},
+	}
+
+	for _, c := range tc {
+		resp, err := tr.decode(req, []byte(c.in))
+		if err != nil || string(resp) != c.out {
+			t.Errorf("decode(%s): got = %s, %v; expected = %s", c.in, resp, err, c.out)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// highlight returns json with syntax highlighting spans, text is escaped.
+function highlight(json) {
+	json = json.replace(/&/g, "&amp;").replace(/</g, "&lt;").replace(/>/g, "&gt;");
+	return json.replace(/("(\\u[a-fA-F0-9]{4}|\\[^u]|[^\\"])*"(\s*:)?|\b(true|false|null)\b|-?\d+(\.\d*)?([eE][+-]?\d+)?)/g, function (m) {
+		var cls = "number";
+		if (/^"/.test(m)) {
+			cls = /:$/.test(m) ? "key" : "string";
+		} else if (/true|false|null/.test(m)) {
+			cls = "literal";
+		}
+		return "<span class='" + cls + "'>" + m + "</span>";
+	});
+}
+
+(function () {
+	var output = document.getElementById("output");
+	if (!output) {
+		return; // client disconnected
+	}
+
+	var tabindex = 1,
+		addr = document.body.getAttribute("data-addr"),
+		base = document.body.getAttribute("data-base") || "",
+		scheme = document.location.protocol === "https:" ? "wss://" : "ws://";
+
+	var w = new WebSocket(scheme + document.location.host + base + "/debug/conns/ws?addr=" + encodeURIComponent(addr));
+	w.onmessage = function (data) {
+		var res = JSON.parse(data.data),
+			isRequest = res.method !== undefined,
+			reqId = 'req_' + res.id,
+			respId = 'resp_' + res.id,
+			id = isRequest ? reqId : respId,
+			relId = !isRequest ? reqId : respId;
+
+		tabindex++;
+
+		// response line, client values are set as text only
+		var tr = document.createElement("tr"),
+			info = document.createElement("td"),
+			link = document.createElement("a");
+		tr.id = id;
+		info.className = "info";
+		info.appendChild(document.createTextNode(String(data.timeStamp)));
+		info.appendChild(document.createElement("br"));
+		link.href = "#" + relId;
+		link.textContent = isRequest ? String(res.method) : 'to ' + reqId;
+		info.appendChild(link);
+		tr.appendChild(info);
+
+		var td = document.createElement("td"),
+			pre = document.createElement("pre");
+
+		pre.tabIndex = tabindex;
+		pre.className = "json";
+		pre.innerHTML = highlight(JSON.stringify(res, undefined, 4));
+		td.appendChild(pre);
+		tr.appendChild(td);
+		output.appendChild(tr);
+	};
+})();
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+const (
+	statsBuckets     = 60               // buckets in /debug/stats window
+	statsBucketWidth = 10 * time.Second // 10 minutes window
+)
+
+// statsBucket is a counters of backend requests in bucket time.
+type statsBucket struct {
+	start    int64 // bucket number since unix epoch
+	requests int
+	errors   int
+	latency  time.Duration // sum of request durations
+}
+
+// routeStats is a ring of request counters for route.
+type routeStats [statsBuckets]statsBucket
+
+// debugStats is an in-process counters of backend requests by route for /debug/stats, no Prometheus is required.
+type debugStats struct {
+	lock   sync.Mutex
+	routes map[string]*routeStats
+}
+
+var stats = &debugStats{routes: make(map[string]*routeStats)}
+
+// observe counts backend request for route.
+func (s *debugStats) observe(route string, failed bool, duration time.Duration, now time.Time) {
+	n := now.UnixNano() / int64(statsBucketWidth)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	rs, ok := s.routes[route]
+	if !ok {
+		rs = new(routeStats)
+		s.routes[route] = rs
+	}
+
+	b := &rs[n%statsBuckets]
+	if b.start != n {
+		*b = statsBucket{start: n}
+	}
+	b.requests++
+	b.latency += duration
+	if failed {
+		b.errors++
+	}
+}
+
+// routeSeries is a request rate, error rate and average latency of route by bucket, oldest first.
+type routeSeries struct {
+	Route                        string
+	Rates, ErrorRates, Latencies []float64 // requests per second, error percent, milliseconds
+}
+
+// series returns route series for complete buckets before now.
+func (s *debugStats) series(now time.Time) []routeSeries {
+	last := now.UnixNano()/int64(statsBucketWidth) - 1
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	list := make([]routeSeries, 0, len(s.routes))
+	for route, rs := range s.routes {
+		sr := routeSeries{
+			Route:      route,
+			Rates:      make([]float64, statsBuckets),
+			ErrorRates: make([]float64, statsBuckets),
+			Latencies:  make([]float64, statsBuckets),
+		}
+
+		for i := range sr.Rates {
+			n := last - statsBuckets + 1 + int64(i)
+			b := rs[(n%statsBuckets+statsBuckets)%statsBuckets]
+			if b.start != n || b.requests == 0 {
+				continue
+			}
+
+			sr.Rates[i] = float64(b.requests) / statsBucketWidth.Seconds()
+			sr.ErrorRates[i] = float64(b.errors) * 100 / float64(b.requests)
+			sr.Latencies[i] = (b.latency / time.Duration(b.requests)).Seconds() * 1000
+		}
+		list = append(list, sr)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Route < list[j].Route })
+	return list
+}
+
+// sparkline returns svg polyline points for values scaled to 120x20 box.
+func sparkline(values []float64) string {
+	max := 0.0
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+
+	points := make([]string, len(values))
+	for i, v := range values {
+		y := 20.0
+		if max > 0 {
+			y -= v / max * 20
+		}
+		points[i] = fmt.Sprintf("%d,%.1f", i*2, y)
+	}
+
+	return strings.Join(points, " ")
+}
+
+// lastValue returns value of last complete bucket.
+func lastValue(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	return values[len(values)-1]
+}
+
+// stats shows request rates, error rates and latencies by route for last 10 minutes.
+func (d *debugApp) stats(w http.ResponseWriter, r *http.Request) {
+	tmpl := struct {
+		Window time.Duration
+		List   []routeSeries
+	}{Window: statsBuckets * statsBucketWidth, List: stats.series(clock.OrReal(d.clock).Now())}
+
+	d.render(w, "stats.html", tmpl)
+}
@@ -0,0 +1,316 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const stompResponseSuffix = "/response"
+
+// STOMP websocket subprotocols by preference.
+var stompSubprotocols = []string{"v12.stomp", "v11.stomp", "v10.stomp"}
+
+var (
+	errStompFrame   = errors.New("malformed stomp frame")
+	errStompCommand = errors.New("unsupported stomp command")
+
+	stompEscaper   = strings.NewReplacer("\\", "\\\\", "\r", "\\r", "\n", "\\n", ":", "\\c")
+	stompUnescaper = strings.NewReplacer("\\\\", "\\", "\\r", "\r", "\\n", "\n", "\\c", ":")
+)
+
+// stompFrame is a STOMP 1.x frame, one frame per websocket message.
+type stompFrame struct {
+	command string
+	headers map[string]string
+	body    []byte
+}
+
+// parseStompFrame parses frame from websocket message. Heart-beats (EOLs) are returned as empty frame.
+func parseStompFrame(data []byte) (stompFrame, error) {
+	data = bytes.TrimLeft(data, "\r\n")
+	if len(data) == 0 {
+		return stompFrame{}, nil
+	}
+
+	i := bytes.Index(data, []byte("\n\n"))
+	if i < 0 {
+		return stompFrame{}, errStompFrame
+	}
+
+	lines := strings.Split(strings.Replace(string(data[:i]), "\r\n", "\n", -1), "\n")
+	f := stompFrame{command: lines[0], headers: make(map[string]string), body: data[i+2:]}
+	for _, l := range lines[1:] {
+		kv := strings.SplitN(l, ":", 2)
+		if len(kv) != 2 {
+			return stompFrame{}, errStompFrame
+		}
+
+		// first header value is used for repeated headers, CONNECT headers are not escaped
+		k, v := kv[0], kv[1]
+		if f.command != "CONNECT" {
+			k, v = stompUnescaper.Replace(k), stompUnescaper.Replace(v)
+		}
+		if _, ok := f.headers[k]; !ok {
+			f.headers[k] = v
+		}
+	}
+
+	// body is terminated by NULL, content-length is optional
+	if l, err := strconv.Atoi(f.headers["content-length"]); err == nil && l >= 0 && l <= len(f.body) {
+		f.body = f.body[:l]
+	} else if j := bytes.IndexByte(f.body, 0); j >= 0 {
+		f.body = f.body[:j]
+	} else {
+		return stompFrame{}, errStompFrame
+	}
+
+	return f, nil
+}
+
+// encode returns frame with escaped headers sorted by name.
+func (f stompFrame) encode() []byte {
+	keys := make([]string, 0, len(f.headers))
+	for k := range f.headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	b.WriteString(f.command + "\n")
+	for _, k := range keys {
+		b.WriteString(stompEscaper.Replace(k) + ":" + stompEscaper.Replace(f.headers[k]) + "\n")
+	}
+	b.WriteString("\n")
+	b.Write(f.body)
+	b.WriteByte(0)
+
+	return b.Bytes()
+}
+
+// destinationMethod converts destination into json-rpc method: /rpc/users/get -> rpc.users.get.
+func destinationMethod(destination string) string {
+	return topicMethod(destination)
+}
+
+// methodDestination converts json-rpc method into destination: rpc.users.get -> /rpc/users/get.
+func methodDestination(method string) string {
+	return "/" + methodTopic(method)
+}
+
+// selectStompProtocol returns preferred STOMP subprotocol from client offer or empty string.
+func selectStompProtocol(offer []string) string {
+	for _, p := range stompSubprotocols {
+		for _, o := range offer {
+			if o == p {
+				return p
+			}
+		}
+	}
+
+	return ""
+}
+
+// isStompConn checks websocket subprotocol for STOMP.
+func isStompConn(ws *wsConn) bool {
+	return selectStompProtocol([]string{ws.Subprotocol()}) != ""
+}
+
+// stompConn is a STOMP client session. SEND frames are json-rpc calls with destination as method and body
+// as params, responses are sent as MESSAGE to subscribers of reply-to header or destination + /response.
+// Notifications (broadcasts) are sent to subscribers with method as destination.
+type stompConn struct {
+	rf *requestForwarder
+
+	lock          sync.RWMutex
+	subscriptions map[string]string // subscription id: destination
+	lastId        int
+	lastMessageId int
+}
+
+// newStompConn returns STOMP session for connection, session notifications are sent to subscribers.
+func newStompConn(rf *requestForwarder) *stompConn {
+	c := &stompConn{rf: rf, subscriptions: make(map[string]string)}
+	rf.session.send = c.notify
+	return c
+}
+
+// write sends frame as text message.
+func (c *stompConn) write(f stompFrame) error {
+	return c.rf.write(string(f.encode()))
+}
+
+// message sends MESSAGE frame to all subscriptions for destination.
+func (c *stompConn) message(destination string, headers map[string]string, body []byte) error {
+	c.lock.Lock()
+	var ids []string
+	for id, d := range c.subscriptions {
+		if d == destination {
+			ids = append(ids, id)
+		}
+	}
+	c.lastMessageId++
+	messageId := strconv.Itoa(c.lastMessageId)
+	c.lock.Unlock()
+
+	for _, id := range ids {
+		h := map[string]string{
+			"subscription":   id,
+			"message-id":     messageId,
+			"destination":    destination,
+			"content-type":   "application/json",
+			"content-length": strconv.Itoa(len(body)),
+		}
+		for k, v := range headers {
+			h[k] = v
+		}
+
+		if err := c.write(stompFrame{command: "MESSAGE", headers: h, body: body}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// notify sends json-rpc notification params to subscribers of method destination.
+func (c *stompConn) notify(msg []byte) error {
+	var n JsonRpcRequest
+	if err := json.Unmarshal(msg, &n); err != nil {
+		return err
+	}
+
+	payload := []byte("null")
+	if n.Params != nil {
+		payload = *n.Params
+	}
+
+	return c.message(methodDestination(n.Method), nil, payload)
+}
+
+// receipt sends RECEIPT frame if client requested it.
+func (c *stompConn) receipt(f stompFrame) error {
+	if id, ok := f.headers["receipt"]; ok {
+		return c.write(stompFrame{command: "RECEIPT", headers: map[string]string{"receipt-id": id}})
+	}
+
+	return nil
+}
+
+// stompLoop handles STOMP session.
+func (hf *HttpForwarder) stompLoop(c *stompConn) {
+	var (
+		rf  = c.rf
+		msg []byte
+		err error
+	)
+
+	for {
+		if _, msg, err = rf.ws.Receive(); err != nil {
+			if err != io.EOF {
+				rf.Errorf("error while receiving stomp data from client err=%s", err)
+			}
+			return
+		}
+
+		f, err := parseStompFrame(msg)
+		if err == nil {
+			err = hf.handleStompFrame(c, f)
+		}
+
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			rf.Errorf("stomp err=%s command=%s", err, f.command)
+			c.write(stompFrame{command: "ERROR", headers: map[string]string{"message": err.Error()}})
+			return
+		}
+	}
+}
+
+// handleStompFrame handles one STOMP frame. Returns io.EOF on DISCONNECT.
+func (hf *HttpForwarder) handleStompFrame(c *stompConn, f stompFrame) error {
+	switch f.command {
+	case "":
+		return nil // heart-beat
+	case "CONNECT", "STOMP":
+		version := strings.TrimSuffix(strings.TrimPrefix(c.rf.ws.Subprotocol(), "v1"), ".stomp")
+		return c.write(stompFrame{command: "CONNECTED", headers: map[string]string{
+			"version":    "1." + version,
+			"heart-beat": "0,0",
+			"session":    c.rf.session.id,
+		}})
+	case "DISCONNECT":
+		c.receipt(f)
+		return io.EOF
+	case "SEND":
+		destination := f.headers["destination"]
+		if destination == "" {
+			return errStompFrame
+		}
+
+		// receipt is queued before response
+		if err := c.receipt(f); err != nil {
+			return err
+		}
+		return hf.stompCall(c, f, destination)
+	case "SUBSCRIBE", "UNSUBSCRIBE":
+		id := f.headers["id"]
+		if id == "" {
+			return errStompFrame
+		}
+
+		c.lock.Lock()
+		if f.command == "SUBSCRIBE" {
+			c.subscriptions[id] = f.headers["destination"]
+		} else {
+			delete(c.subscriptions, id)
+		}
+		c.lock.Unlock()
+	case "ACK", "NACK", "BEGIN", "COMMIT", "ABORT":
+		// subscriptions are auto-acknowledged, transactions are not supported
+	default:
+		return errStompCommand
+	}
+
+	return c.receipt(f)
+}
+
+// stompCall converts SEND into json-rpc request and sends response to reply destination subscribers.
+func (hf *HttpForwarder) stompCall(c *stompConn, f stompFrame, destination string) error {
+	params := json.RawMessage(f.body)
+	if !json.Valid(f.body) {
+		b, _ := json.Marshal(string(f.body))
+		params = b
+	}
+
+	c.lock.Lock()
+	c.lastId++
+	id := c.lastId
+	c.lock.Unlock()
+
+	msg, err := json.Marshal(JsonRpcRequest{JsonRpc: "2.0", Id: json.RawMessage(strconv.Itoa(id)), Method: destinationMethod(destination), Params: &params})
+	if err != nil {
+		return err
+	}
+
+	replyTo := f.headers["reply-to"]
+	if replyTo == "" {
+		replyTo = strings.TrimSuffix(destination, "/") + stompResponseSuffix
+	}
+
+	var headers map[string]string
+	if cid, ok := f.headers["correlation-id"]; ok {
+		headers = map[string]string{"correlation-id": cid}
+	}
+
+	hf.handleRequest(c.rf, msg, func(resp []byte) error {
+		return c.message(replyTo, headers, resp)
+	})
+	return nil
+}
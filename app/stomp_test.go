@@ -0,0 +1,38 @@
+package app
+
+import (
+	"testing"
+)
+
+func TestParseStompFrame(t *testing.T) {
+	f, err := parseStompFrame([]byte("SEND\ndestination:/rpc/users/get\nreceipt:a\\cb\n\n{\"id\":1}\x00"))
+	if err != nil || f.command != "SEND" || f.headers["destination"] != "/rpc/users/get" || f.headers["receipt"] != "a:b" || string(f.body) != `{"id":1}` {
+		t.Fatalf("parseStompFrame(): got = %v, %v", f, err)
+	}
+
+	if m := destinationMethod(f.headers["destination"]); m != "rpc.users.get" {
+		t.Errorf("destinationMethod(): got = %v", m)
+	}
+
+	if f, err = parseStompFrame(f.encode()); err != nil || f.headers["receipt"] != "a:b" || string(f.body) != `{"id":1}` {
+		t.Errorf("parseStompFrame(encode()): got = %v, %v", f, err)
+	}
+
+	if f, err = parseStompFrame([]byte("\n")); err != nil || f.command != "" {
+		t.Errorf("parseStompFrame(heart-beat): got = %v, %v", f, err)
+	}
+
+	if _, err = parseStompFrame([]byte("SEND\ndestination:/rpc\n\n{}")); err != errStompFrame {
+		t.Errorf("parseStompFrame(no NULL): got = %v", err)
+	}
+}
+
+func TestSelectStompProtocol(t *testing.T) {
+	if p := selectStompProtocol([]string{"v10.stomp", "v11.stomp", "v12.stomp"}); p != "v12.stomp" {
+		t.Errorf("selectStompProtocol(): got = %v", p)
+	}
+
+	if p := selectStompProtocol([]string{"mqtt"}); p != "" {
+		t.Errorf("selectStompProtocol(mqtt): got = %v", p)
+	}
+}
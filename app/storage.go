@@ -0,0 +1,117 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Storage keeps long-term artifacts: trace exports, audit logs and captured traffic.
+// Names are slash-separated paths like audit/2023-01-02T15-04-05.000000000-purge.json.
+type Storage interface {
+	Put(ctx context.Context, name string, data []byte) error
+	Get(ctx context.Context, name string) ([]byte, error)
+	List(ctx context.Context, prefix string) ([]string, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// StorageFactory returns storage for url, like s3://bucket/prefix.
+type StorageFactory func(u *url.URL) (Storage, error)
+
+var (
+	storagesLock sync.RWMutex
+	storages     = map[string]StorageFactory{
+		"file": newFileStorage,
+		"":     newFileStorage,
+	}
+)
+
+// RegisterStorage registers storage factory for url scheme, like s3 or gs adapters.
+// Local directory storage is registered for file scheme and paths without scheme.
+func RegisterStorage(scheme string, f StorageFactory) {
+	storagesLock.Lock()
+	defer storagesLock.Unlock()
+	storages[scheme] = f
+}
+
+// OpenStorage returns storage for url by registered scheme.
+func OpenStorage(rawurl string) (Storage, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	storagesLock.RLock()
+	f, ok := storages[u.Scheme]
+	storagesLock.RUnlock()
+	if !ok {
+		return nil, ErrUnknownStorage
+	}
+
+	return f(u)
+}
+
+// fileStorage keeps artifacts as files in local directory.
+type fileStorage struct {
+	root string
+}
+
+func newFileStorage(u *url.URL) (Storage, error) {
+	if u.Path == "" {
+		return nil, errors.New("empty storage path")
+	}
+
+	return fileStorage{root: filepath.FromSlash(u.Path)}, nil
+}
+
+// path returns file path for name, names can't point outside of root.
+func (s fileStorage) path(name string) string {
+	return filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+func (s fileStorage) Put(_ context.Context, name string, data []byte) error {
+	p := s.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(p, data, 0600)
+}
+
+func (s fileStorage) Get(_ context.Context, name string) ([]byte, error) {
+	return ioutil.ReadFile(s.path(name))
+}
+
+func (s fileStorage) List(_ context.Context, prefix string) ([]string, error) {
+	var names []string
+	err := filepath.Walk(s.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(s.root, p)
+		if name := filepath.ToSlash(rel); err == nil && strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return err
+	})
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return names, err
+}
+
+func (s fileStorage) Delete(_ context.Context, name string) error {
+	if err := os.Remove(s.path(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
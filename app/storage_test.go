@@ -0,0 +1,54 @@
+package app
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ws2http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := OpenStorage("file://" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, name := range []string{"captures/a/1.json", "captures/b/1.json", "audit/1-purge.json", "../escape.json"} {
+		if err := s.Put(ctx, name, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "escape.json")); err != nil {
+		t.Errorf("name outside of root: %s", err)
+	}
+
+	if data, err := s.Get(ctx, "captures/a/1.json"); err != nil || string(data) != "captures/a/1.json" {
+		t.Errorf("get: got %q, err = %v", data, err)
+	}
+
+	names, err := s.List(ctx, "captures/")
+	if want := []string{"captures/a/1.json", "captures/b/1.json"}; err != nil || !reflect.DeepEqual(names, want) {
+		t.Errorf("list: got %v, want %v, err = %v", names, want, err)
+	}
+
+	if err := s.Delete(ctx, "captures/a/1.json"); err != nil {
+		t.Fatal(err)
+	}
+	if names, _ := s.List(ctx, "captures/a/"); len(names) != 0 {
+		t.Errorf("delete: got %v", names)
+	}
+
+	if _, err := OpenStorage("unknown://bucket"); err != ErrUnknownStorage {
+		t.Errorf("unknown scheme: got %v", err)
+	}
+}
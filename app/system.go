@@ -0,0 +1,121 @@
+package app
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Reserved system methods answered by proxy without backend requests, so client health checks don't depend on backends.
+const (
+	pingMethod    = "ws2http.ping"    // round-trip check, result is params or "pong"
+	sessionMethod = "ws2http.session" // session info, see systemSession
+	routesMethod  = "ws2http.routes"  // routes available to connection, see systemRoute
+)
+
+// systemSession is a result of sessionMethod.
+type systemSession struct {
+	SessionId  string   `json:"sessionId"`
+	Route      string   `json:"route"`
+	RemoteAddr string   `json:"remoteAddr,omitempty"`
+	Principal  string   `json:"principal,omitempty"`
+	Tags       []string `json:"tags"`
+	Headers    []string `json:"headers"` // session header names, values are not exposed
+	Version    int      `json:"version"` // negotiated control protocol version
+}
+
+// systemRoute is an item of routesMethod result.
+type systemRoute struct {
+	Route       string   `json:"route"`
+	Prefix      string   `json:"prefix,omitempty"`  // method prefix in multiple rules mode, like rpc.
+	Aliases     []string `json:"aliases,omitempty"` // method aliases of route
+	Maintenance bool     `json:"maintenance"`
+}
+
+// checkSystemMethod answers system method request locally. Returns false for other messages.
+func (hf *HttpForwarder) checkSystemMethod(rf *requestForwarder, msg []byte, reply func(resp []byte) error) bool {
+	if !bytes.Contains(msg, []byte(`ws2http.`)) {
+		return false
+	}
+
+	req, err := parseRequest(msg)
+	if err != nil {
+		return false
+	}
+
+	var result interface{}
+	switch req.Method {
+	case pingMethod:
+		result = "pong"
+		if req.Params != nil {
+			result = req.Params
+		}
+	case sessionMethod:
+		result = rf.systemSession()
+	case routesMethod:
+		result = hf.systemRoutes(rf)
+	default:
+		return false
+	}
+
+	rf.Tracef("type=system method=%s", req.Method)
+	if req.Id != nil {
+		resp := JsonRpcResponse{Version: "2.0", Id: req.Id, Result: result}
+		if err := reply(resp.JSON()); err != nil {
+			rf.Errorf("can't send data to client lastErr=%s", err)
+		}
+	}
+
+	return true
+}
+
+// systemSession returns session info of connection.
+func (rf *requestForwarder) systemSession() systemSession {
+	s := systemSession{
+		SessionId:  rf.conn.SessionId,
+		Route:      rf.conn.Route,
+		RemoteAddr: rf.conn.RemoteAddr,
+		Principal:  rf.conn.Principal,
+		Tags:       []string{},
+		Headers:    []string{},
+		Version:    rf.version,
+	}
+
+	if rf.session != nil {
+		s.Tags = append(s.Tags, rf.session.tagList()...)
+	}
+	for name := range rf.header() {
+		s.Headers = append(s.Headers, name)
+	}
+	sort.Strings(s.Headers)
+
+	return s
+}
+
+// systemRoutes returns route of connection or routes of multiple rules mode with method prefixes.
+func (hf *HttpForwarder) systemRoutes(rf *requestForwarder) []systemRoute {
+	route := func(r ProxyRule, prefix string) systemRoute {
+		sr := systemRoute{Route: r.Src, Prefix: prefix, Maintenance: hf.routeState(r.Src).maintenanceErr() != nil}
+		for alias := range r.MethodAliases {
+			sr.Aliases = append(sr.Aliases, alias)
+		}
+		sort.Strings(sr.Aliases)
+		return sr
+	}
+
+	if len(rf.multipleRules) == 0 {
+		r := rf.rule
+		if r.Src == "" {
+			r.Src = rf.conn.Route
+		}
+		return []systemRoute{route(r, "")}
+	}
+
+	list := make([]systemRoute, 0, len(rf.multipleRules))
+	for src, r := range rf.multipleRules {
+		r.Src = src
+		list = append(list, route(r, src[1:]+"."))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Route < list[j].Route })
+
+	return list
+}
@@ -0,0 +1,61 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSystemMethods(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("system method was forwarded to backend")
+	}))
+	defer backend.Close()
+
+	hf := NewHttpForwarder("", nil, 10, 2)
+	hf.SetMultiMode([]ProxyRule{
+		{Src: "/rpc", DstUrl: backend.URL, MethodAliases: map[string]string{"getUser": "users.get"}},
+		{Src: "/pay", DstUrl: backend.URL},
+	})
+	srv := httptest.NewServer(hf.WebsocketHandler())
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial(strings.Replace(srv.URL, "http", "ws", 1), http.Header{"Origin": {srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	call := func(req string) string {
+		t.Helper()
+		ws.WriteMessage(websocket.TextMessage, []byte(req))
+		ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var resp struct {
+			Result json.RawMessage `json:"result"`
+		}
+		if err := ws.ReadJSON(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return string(resp.Result)
+	}
+
+	if got := call(`{"jsonrpc":"2.0","method":"ws2http.ping","id":1}`); got != `"pong"` {
+		t.Errorf("ping: got %s", got)
+	}
+	if got := call(`{"jsonrpc":"2.0","method":"ws2http.ping","params":{"n":1},"id":2}`); got != `{"n":1}` {
+		t.Errorf("ping with params: got %s", got)
+	}
+	if got := call(`{"jsonrpc":"2.0","method":"ws2http.session","id":3}`); !strings.Contains(got, `"sessionId":"`) || !strings.Contains(got, `"route":"/"`) {
+		t.Errorf("session: got %s", got)
+	}
+
+	want := `[{"route":"/pay","prefix":"pay.","maintenance":false},{"route":"/rpc","prefix":"rpc.","aliases":["getUser"],"maintenance":false}]`
+	if got := call(`{"jsonrpc":"2.0","method":"ws2http.routes","id":4}`); got != want {
+		t.Errorf("routes: got %s", got)
+	}
+}
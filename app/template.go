@@ -0,0 +1,97 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"text/template"
+)
+
+// requestTemplateFuncs are functions available in request templates.
+var requestTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"xml": func(v interface{}) (string, error) {
+		s, ok := v.(string)
+		if !ok {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return "", err
+			}
+			s = string(b)
+		}
+
+		var buf bytes.Buffer
+		err := xml.EscapeText(&buf, []byte(s))
+		return buf.String(), err
+	},
+}
+
+// requestTemplateData is a data for request template.
+// Example: {"auth":{"user":{{json (.Header.Get "X-User")}}},"payload":{{.Request}}}
+type requestTemplateData struct {
+	Request string // rewritten json-rpc request
+	Method  string
+	Id      string      // raw json id
+	Params  string      // raw json params, null if empty
+	Args    interface{} // decoded params: map for named params, slice for positional
+	Header  http.Header
+}
+
+// newRequestTemplateData returns template data for json-rpc request.
+func newRequestTemplateData(req JsonRpcRequest, msg []byte, headers http.Header) (requestTemplateData, error) {
+	data := requestTemplateData{
+		Request: string(msg),
+		Method:  req.Method,
+		Id:      "null",
+		Params:  "null",
+		Header:  headers,
+	}
+
+	if req.Id != nil {
+		data.Id = string(req.Id)
+	}
+
+	if req.Params != nil {
+		data.Params = string(*req.Params)
+		if err := json.Unmarshal(*req.Params, &data.Args); err != nil {
+			return data, err
+		}
+	}
+
+	return data, nil
+}
+
+// parseRequestTemplate parses backend request template, returns nil if tmpl is empty.
+func parseRequestTemplate(name, tmpl string) (*template.Template, error) {
+	if tmpl == "" {
+		return nil, nil
+	}
+
+	return template.New(name).Funcs(requestTemplateFuncs).Parse(tmpl)
+}
+
+// renderRequest converts rewritten request by route translator or wraps it into backend envelope by route request template.
+func (hf *HttpForwarder) renderRequest(req BackendRequest) ([]byte, error) {
+	rs := hf.routeState(req.Route)
+	if rs != nil && rs.translator != nil {
+		return rs.translator.encode(req.Request, req.Header)
+	} else if rs == nil || rs.requestTmpl == nil {
+		return req.Msg, nil
+	}
+
+	data, err := newRequestTemplateData(req.Request, req.Msg, req.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	if err := rs.requestTmpl.Execute(&b, data); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+// reauthMethod is a notification sent to client before auth token expiry.
+const reauthMethod = "ws2http.reauth"
+
+var errTokenExpired = errors.New("auth token expired, send AUTH with new token")
+
+// tokenExpiry returns exp claim of JWT bearer token from Authorization header value. Signature is not validated,
+// backends validate tokens. Returns zero time for tokens without exp claim or non-JWT tokens.
+func tokenExpiry(authorization string) time.Time {
+	token := strings.TrimSpace(authorization)
+	if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+		token = strings.TrimSpace(token[7:])
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+
+	var claims struct {
+		Exp float64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp <= 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(int64(claims.Exp), 0)
+}
+
+// tokenWatch tracks session auth token expiry: client is notified with reauthMethod notice before expiry,
+// requests are rejected with JsonRpcTokenExpired after expiry until client sends new token.
+type tokenWatch struct {
+	notice time.Duration
+	clock  clock.Clock
+
+	lock    sync.Mutex
+	expires time.Time // zero for tokens without expiry
+	timer   clock.Timer
+}
+
+// reset sets token expiry and schedules reauth notification with notify.
+func (tw *tokenWatch) reset(expires time.Time, notify func(expires time.Time)) {
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+
+	if tw.timer != nil {
+		tw.timer.Stop()
+		tw.timer = nil
+	}
+
+	tw.expires = expires
+	if expires.IsZero() {
+		return
+	}
+
+	tw.timer = tw.clock.AfterFunc(expires.Add(-tw.notice).Sub(tw.clock.Now()), func() { notify(expires) })
+}
+
+// stop cancels scheduled notification.
+func (tw *tokenWatch) stop() {
+	tw.reset(time.Time{}, nil)
+}
+
+// err returns errTokenExpired if token is expired at now.
+func (tw *tokenWatch) err(now time.Time) error {
+	if tw == nil {
+		return nil
+	}
+
+	tw.lock.Lock()
+	defer tw.lock.Unlock()
+	if !tw.expires.IsZero() && !now.Before(tw.expires) {
+		return errTokenExpired
+	}
+
+	return nil
+}
+
+// watchToken updates session token expiry from Authorization header.
+func (rf *requestForwarder) watchToken() {
+	if rf.token == nil {
+		return
+	}
+
+	rf.token.reset(tokenExpiry(rf.header().Get("Authorization")), func(expires time.Time) {
+		params, _ := json.Marshal(map[string]interface{}{"expiresAt": expires.UTC().Format(time.RFC3339)})
+		if err := rf.send(rf.session.notification(reauthMethod, params)); err != nil {
+			rf.Errorf("can't send reauth notification err=%s", err)
+		}
+	})
+}
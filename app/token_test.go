@@ -0,0 +1,54 @@
+package app
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+func TestTokenExpiry(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1","exp":1700000000}`))
+
+	for auth, want := range map[string]int64{
+		"Bearer eyJhbGciOiJIUzI1NiJ9." + payload + ".sig": 1700000000,
+		"bearer eyJhbGciOiJIUzI1NiJ9." + payload + ".sig": 1700000000,
+		"Basic dXNlcjpwYXNz":                              0,
+		"Bearer a.b.c":                                    0,
+		"":                                                0,
+	} {
+		if got := tokenExpiry(auth); (want == 0 && !got.IsZero()) || (want != 0 && got.Unix() != want) {
+			t.Errorf("%q: got %v", auth, got)
+		}
+	}
+}
+
+func TestTokenWatch(t *testing.T) {
+	c := clock.NewFake(time.Now())
+
+	tw := &tokenWatch{notice: time.Hour, clock: c}
+	expires := c.Now().Add(time.Hour + 50*time.Millisecond)
+
+	var notified []time.Time
+	tw.reset(expires, func(e time.Time) { notified = append(notified, e) })
+	defer tw.stop()
+
+	c.Advance(40 * time.Millisecond)
+	if len(notified) != 0 {
+		t.Errorf("notified before notice: %v", notified)
+	}
+
+	c.Advance(10 * time.Millisecond)
+	if len(notified) != 1 || !notified[0].Equal(expires) {
+		t.Errorf("notify: got %v", notified)
+	}
+
+	if err := tw.err(c.Now()); err != nil {
+		t.Errorf("before expiry: got %v", err)
+	}
+	c.Advance(time.Hour)
+	if err := tw.err(c.Now()); err != errTokenExpired {
+		t.Errorf("after expiry: got %v", err)
+	}
+}
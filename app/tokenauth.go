@@ -0,0 +1,394 @@
+package app
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // SHA-256 for RS256, ES256 and HS256
+	_ "crypto/sha512" // SHA-384 and SHA-512
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+// jwksMinRefetch limits JWKS requests for tokens with unknown key id.
+const jwksMinRefetch = 10 * time.Second
+
+var (
+	errNoToken         = errors.New("auth token is missing")
+	errInvalidToken    = errors.New("auth token is invalid")
+	errTokenSignature  = errors.New("auth token signature is invalid")
+	errTokenExpiredAt  = errors.New("auth token is expired")
+	errTokenNotYet     = errors.New("auth token is not valid yet")
+	errUnsupportedAlg  = errors.New("auth token algorithm is not supported")
+	errUnknownTokenKey = errors.New("auth token key is unknown")
+)
+
+// verifiedTokenKey is a request context key of bearer token accepted by TokenAuthHook.
+type verifiedTokenKey struct{}
+
+// verifiedToken returns bearer token accepted by TokenAuthHook for upgrade request, empty without token auth.
+// Session Authorization header set by client messages is not verified and must not be trusted instead.
+func verifiedToken(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+
+	token, _ := r.Context().Value(verifiedTokenKey{}).(string)
+	return token
+}
+
+// TokenValidator checks bearer token of websocket upgrade request.
+type TokenValidator func(token string) error
+
+// TokenAuthHook rejects upgrades with 401 unless bearer token from Authorization header or query parameter is
+// accepted by any of validators. Query parameter is removed from request, so tokens don't get to logs and captures.
+// Empty query disables query tokens.
+func TokenAuthHook(query string, validators ...TokenValidator) UpgradeHook {
+	return func(r *http.Request) error {
+		token := bearerToken(r.Header.Get("Authorization"))
+		if query != "" {
+			q := r.URL.Query()
+			if token == "" {
+				token = q.Get(query)
+			}
+			if _, ok := q[query]; ok {
+				q.Del(query)
+				r.URL.RawQuery = q.Encode()
+			}
+		}
+		if token == "" {
+			return &UpgradeError{Status: http.StatusUnauthorized, Message: errNoToken.Error()}
+		}
+
+		var err error
+		for _, v := range validators {
+			if err = v(token); err == nil {
+				*r = *r.WithContext(context.WithValue(r.Context(), verifiedTokenKey{}, token))
+				return nil
+			}
+		}
+
+		var ue *UpgradeError
+		if errors.As(err, &ue) {
+			return ue
+		}
+
+		return &UpgradeError{Status: http.StatusUnauthorized, Message: err.Error()}
+	}
+}
+
+// bearerToken returns token of Authorization header value with Bearer scheme.
+func bearerToken(authorization string) string {
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "bearer ") {
+		return strings.TrimSpace(authorization[7:])
+	}
+
+	return ""
+}
+
+// StaticTokens accepts any of shared secret tokens.
+func StaticTokens(tokens ...string) TokenValidator {
+	return func(token string) error {
+		for _, t := range tokens {
+			if t != "" && subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+				return nil
+			}
+		}
+
+		return errInvalidToken
+	}
+}
+
+// HmacJwt accepts JWT signed by secret with HS256, HS384 or HS512 and valid exp and nbf claims by system clock.
+func HmacJwt(secret []byte) TokenValidator {
+	return hmacJwt(secret, clock.Real)
+}
+
+// hmacJwt is HmacJwt with claims checked by clock c.
+func hmacJwt(secret []byte, c clock.Clock) TokenValidator {
+	return func(token string) error {
+		jwt, err := parseJwt(token)
+		if err != nil {
+			return err
+		}
+
+		hash, ok := jwtHash(jwt.header.Alg, "HS")
+		if !ok {
+			return errUnsupportedAlg
+		}
+
+		mac := hmac.New(hash.New, secret)
+		mac.Write(jwt.signed)
+		if !hmac.Equal(mac.Sum(nil), jwt.signature) {
+			return errTokenSignature
+		}
+
+		return jwt.checkTime(c.Now())
+	}
+}
+
+// JwksJwt accepts JWT signed with RS256-512 or ES256-512 by key from JWKS endpoint and valid exp and nbf claims.
+// Keys are fetched on first use and refreshed after refresh interval or for unknown key ids, claims and refresh
+// use system clock.
+func JwksJwt(jwksUrl string, refresh, timeout time.Duration) TokenValidator {
+	return jwksJwt(jwksUrl, refresh, timeout, clock.Real)
+}
+
+// jwksJwt is JwksJwt on clock c.
+func jwksJwt(jwksUrl string, refresh, timeout time.Duration, c clock.Clock) TokenValidator {
+	ks := &jwksKeys{url: jwksUrl, refresh: refresh, client: &http.Client{Timeout: timeout}, clock: c}
+	return func(token string) error {
+		jwt, err := parseJwt(token)
+		if err != nil {
+			return err
+		}
+
+		key, err := ks.key(jwt.header.Kid)
+		if err != nil {
+			return err
+		}
+
+		if err := jwt.verify(key); err != nil {
+			return err
+		}
+
+		return jwt.checkTime(ks.clock.Now())
+	}
+}
+
+// jsonWebToken is a parsed JWT.
+type jsonWebToken struct {
+	header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	claims struct {
+		Exp float64 `json:"exp"`
+		Nbf float64 `json:"nbf"`
+	}
+	signed    []byte // header.payload
+	signature []byte
+}
+
+// parseJwt parses compact JWT without signature verification.
+func parseJwt(token string) (*jsonWebToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidToken
+	}
+
+	jwt := &jsonWebToken{signed: []byte(parts[0] + "." + parts[1])}
+	header, hErr := base64.RawURLEncoding.DecodeString(parts[0])
+	payload, pErr := base64.RawURLEncoding.DecodeString(parts[1])
+	signature, sErr := base64.RawURLEncoding.DecodeString(parts[2])
+	if hErr != nil || pErr != nil || sErr != nil {
+		return nil, errInvalidToken
+	}
+	if json.Unmarshal(header, &jwt.header) != nil || json.Unmarshal(payload, &jwt.claims) != nil {
+		return nil, errInvalidToken
+	}
+	jwt.signature = signature
+
+	return jwt, nil
+}
+
+// checkTime checks exp and nbf claims at now.
+func (t *jsonWebToken) checkTime(now time.Time) error {
+	if t.claims.Exp > 0 && !now.Before(time.Unix(int64(t.claims.Exp), 0)) {
+		return errTokenExpiredAt
+	}
+	if t.claims.Nbf > 0 && now.Before(time.Unix(int64(t.claims.Nbf), 0)) {
+		return errTokenNotYet
+	}
+
+	return nil
+}
+
+// verify checks RS* or ES* signature with public key.
+func (t *jsonWebToken) verify(key crypto.PublicKey) error {
+	var (
+		hash crypto.Hash
+		ok   bool
+	)
+	switch key.(type) {
+	case *rsa.PublicKey:
+		hash, ok = jwtHash(t.header.Alg, "RS")
+	case *ecdsa.PublicKey:
+		hash, ok = jwtHash(t.header.Alg, "ES")
+	}
+	if !ok {
+		return errUnsupportedAlg
+	}
+
+	h := hash.New()
+	h.Write(t.signed)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(k, hash, digest, t.signature) != nil {
+			return errTokenSignature
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(t.signature) != 2*size {
+			return errTokenSignature
+		}
+		r, s := new(big.Int).SetBytes(t.signature[:size]), new(big.Int).SetBytes(t.signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errTokenSignature
+		}
+	}
+
+	return nil
+}
+
+// jwtHash returns hash of alg with family prefix, like HS256 for HS.
+func jwtHash(alg, family string) (crypto.Hash, bool) {
+	switch alg {
+	case family + "256":
+		return crypto.SHA256, true
+	case family + "384":
+		return crypto.SHA384, true
+	case family + "512":
+		return crypto.SHA512, true
+	}
+
+	return 0, false
+}
+
+// jwksKeys is a cache of JWKS endpoint public keys by key id.
+type jwksKeys struct {
+	url     string
+	refresh time.Duration
+	client  *http.Client
+	clock   clock.Clock
+
+	lock    sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+	err     error // last fetch error
+}
+
+// key returns public key by id, keys are fetched if cache is stale or key id is unknown.
+func (ks *jwksKeys) key(kid string) (crypto.PublicKey, error) {
+	ks.lock.Lock()
+	defer ks.lock.Unlock()
+
+	now := ks.clock.Now()
+	_, known := ks.keys[kid]
+	stale := ks.refresh > 0 && now.Sub(ks.fetched) >= ks.refresh
+	if ks.fetched.IsZero() || stale || (!known && now.Sub(ks.fetched) >= jwksMinRefetch) {
+		// failed refresh keeps previous keys until next attempt
+		var keys map[string]crypto.PublicKey
+		if keys, ks.err = ks.fetch(); ks.err == nil {
+			ks.keys = keys
+		}
+		ks.fetched = now
+	}
+
+	if ks.keys == nil {
+		return nil, &UpgradeError{Status: http.StatusServiceUnavailable, Message: fmt.Sprintf("can't fetch jwks: %s", ks.err)}
+	} else if key, ok := ks.keys[kid]; ok {
+		return key, nil
+	}
+
+	return nil, errUnknownTokenKey
+}
+
+// fetch requests JWKS endpoint and returns RSA and EC signing keys.
+func (ks *jwksKeys) fetch() (map[string]crypto.PublicKey, error) {
+	resp, err := ks.client.Get(ks.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status=%d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+
+	return keys, nil
+}
+
+// jsonWebKey is a RSA or EC public key of JWKS.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns rsa or ecdsa public key.
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	num := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil, errInvalidToken
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := num(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := num(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errInvalidToken
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, errUnsupportedAlg
+		}
+		x, err := num(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := num(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, errUnsupportedAlg
+}
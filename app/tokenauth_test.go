@@ -0,0 +1,163 @@
+package app
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/semrush/ws2http/clock"
+)
+
+// testJwt returns JWT with header and claims signed by sign.
+func testJwt(header, claims string, sign func(signed []byte) []byte) string {
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString([]byte(header)) + "." + enc.EncodeToString([]byte(claims))
+	return signed + "." + enc.EncodeToString(sign([]byte(signed)))
+}
+
+func TestTokenAuthHook(t *testing.T) {
+	c := clock.NewFake(time.Unix(1700000000, 0))
+
+	secret := []byte("secret")
+	hs256 := func(signed []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return mac.Sum(nil)
+	}
+	hook := TokenAuthHook("access_token", StaticTokens("static"), hmacJwt(secret, c))
+
+	tests := []struct {
+		name, auth, query string
+		status            int
+	}{
+		{"static", "Bearer static", "", 0},
+		{"hmac", "Bearer " + testJwt(`{"alg":"HS256"}`, `{"exp":1700000060}`, hs256), "", 0},
+		{"query", "", "?access_token=static&v=2", 0},
+		{"missing", "", "?v=2", http.StatusUnauthorized},
+		{"basic", "Basic static", "", http.StatusUnauthorized},
+		{"wrong secret", "Bearer " + testJwt(`{"alg":"HS256"}`, `{}`, func([]byte) []byte { return []byte("x") }), "", http.StatusUnauthorized},
+		{"expired", "Bearer " + testJwt(`{"alg":"HS256"}`, `{"exp":1700000000}`, hs256), "", http.StatusUnauthorized},
+		{"not yet", "Bearer " + testJwt(`{"alg":"HS256"}`, `{"nbf":1700000060}`, hs256), "", http.StatusUnauthorized},
+		{"alg none", "Bearer " + testJwt(`{"alg":"none"}`, `{}`, func([]byte) []byte { return nil }), "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/rpc"+tt.query, nil)
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+
+		status, err := 0, hook(r)
+		if ue, ok := err.(*UpgradeError); ok {
+			status = ue.Status
+		} else if err != nil {
+			status = -1
+		}
+		if status != tt.status {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+		if r.URL.Query().Get("access_token") != "" {
+			t.Errorf("%s: query token is kept %s", tt.name, r.URL.RawQuery)
+		}
+		if token := verifiedToken(r); (token != "") != (tt.status == 0) {
+			t.Errorf("%s: got verified token %q", tt.name, token)
+		}
+	}
+}
+
+func TestJwksJwt(t *testing.T) {
+	c := clock.NewFake(time.Unix(1700000000, 0))
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+	var requests, unavailable int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&unavailable) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprintf(w, `{"keys":[{"kty":"RSA","kid":"rsa1","use":"sig","n":"%s","e":"%s"},{"kty":"EC","kid":"ec1","crv":"P-256","x":"%s","y":"%s"}]}`,
+			b64(rsaKey.N.Bytes()), b64(big.NewInt(int64(rsaKey.E)).Bytes()), b64(ecKey.X.Bytes()), b64(ecKey.Y.Bytes()))
+	}))
+	defer ts.Close()
+
+	digest := func(signed []byte) []byte {
+		h := sha256.Sum256(signed)
+		return h[:]
+	}
+	rs256 := func(signed []byte) []byte {
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest(signed))
+		return sig
+	}
+	es256 := func(signed []byte) []byte {
+		r, s, _ := ecdsa.Sign(rand.Reader, ecKey, digest(signed))
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig
+	}
+
+	validate := jwksJwt(ts.URL, time.Hour, time.Second, c)
+	if err := validate(testJwt(`{"alg":"RS256","kid":"rsa1"}`, `{"exp":1700000060}`, rs256)); err != nil {
+		t.Errorf("rsa: got %v", err)
+	}
+	if err := validate(testJwt(`{"alg":"ES256","kid":"ec1"}`, `{}`, es256)); err != nil {
+		t.Errorf("ec: got %v", err)
+	}
+	if err := validate(testJwt(`{"alg":"ES256","kid":"rsa1"}`, `{}`, es256)); err != errUnsupportedAlg {
+		t.Errorf("alg of other key: got %v", err)
+	}
+	if err := validate(testJwt(`{"alg":"RS256","kid":"rsa1"}`, `{"sub":"1"}`, func([]byte) []byte { return rs256([]byte("x")) })); err != errTokenSignature {
+		t.Errorf("signature: got %v", err)
+	}
+
+	// unknown key ids are refetched at most every jwksMinRefetch
+	for i := 0; i < 3; i++ {
+		if err := validate(testJwt(`{"alg":"RS256","kid":"rsa2"}`, `{}`, rs256)); err != errUnknownTokenKey {
+			t.Errorf("unknown key: got %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("got %d jwks requests", n)
+	}
+
+	// failed refresh keeps keys
+	atomic.StoreInt32(&unavailable, 1)
+	c.Advance(time.Hour)
+	if err := validate(testJwt(`{"alg":"ES256","kid":"ec1"}`, `{}`, es256)); err != nil {
+		t.Errorf("failed refresh: got %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("got %d jwks requests", n)
+	}
+
+	// no keys is rejected with 503
+	err := jwksJwt(ts.URL, time.Hour, time.Second, c)(testJwt(`{"alg":"ES256","kid":"ec1"}`, `{}`, es256))
+	if ue, ok := err.(*UpgradeError); !ok || ue.Status != http.StatusServiceUnavailable {
+		t.Errorf("unavailable: got %v", err)
+	}
+}
+
+func TestJsonWebKey(t *testing.T) {
+	var k jsonWebKey
+	json.Unmarshal([]byte(`{"kty":"OKP","crv":"Ed25519","x":"AA"}`), &k)
+	if _, err := k.publicKey(); err != errUnsupportedAlg {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Backend request phases for latency breakdown metrics.
+const (
+	phaseDNS      = "dns"
+	phaseConnect  = "connect"
+	phaseTLS      = "tls"
+	phaseTTFB     = "ttfb"
+	phaseBodyRead = "body_read"
+)
+
+// backendTrace records backend request phases with httptrace. Phases of reused connections are not recorded.
+type backendTrace struct {
+	lock                                    sync.Mutex
+	start, dnsStart, connectStart, tlsStart time.Time
+	phases                                  map[string]time.Duration
+}
+
+func newBackendTrace() *backendTrace {
+	return &backendTrace{start: time.Now(), phases: make(map[string]time.Duration)}
+}
+
+// observe saves phase duration from start, first observation is used for parallel dials.
+func (t *backendTrace) observe(phase string, start time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, ok := t.phases[phase]; !ok && !start.IsZero() {
+		t.phases[phase] = time.Since(start)
+	}
+}
+
+// mark sets phase start time.
+func (t *backendTrace) mark(start *time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if start.IsZero() {
+		*start = time.Now()
+	}
+}
+
+// withContext returns context with client trace.
+func (t *backendTrace) withContext(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { t.mark(&t.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { t.observe(phaseDNS, t.startOf(&t.dnsStart)) },
+		ConnectStart:         func(string, string) { t.mark(&t.connectStart) },
+		ConnectDone:          func(string, string, error) { t.observe(phaseConnect, t.startOf(&t.connectStart)) },
+		TLSHandshakeStart:    func() { t.mark(&t.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { t.observe(phaseTLS, t.startOf(&t.tlsStart)) },
+		GotFirstResponseByte: func() { t.observe(phaseTTFB, t.start) },
+	})
+}
+
+// startOf returns phase start time under lock.
+func (t *backendTrace) startOf(start *time.Time) time.Time {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return *start
+}
+
+// statTrace exports recorded phases for backend url.
+func (hf *HttpForwarder) statTrace(dstUrl string, t *backendTrace) {
+	if hf.statBackendPhases == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for phase, d := range t.phases {
+		hf.statBackendPhases.WithLabelValues(dstUrl, phase).Observe(d.Seconds())
+	}
+}
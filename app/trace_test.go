@@ -0,0 +1,29 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBackendTrace(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","result":true,"id":1}`))
+	}))
+	defer srv.Close()
+
+	hf := NewHttpForwarder(srv.URL, nil, 5, 1)
+	trace := newBackendTrace()
+	resp, err := hf.doPostRequest(trace.withContext(context.Background()), &http.Client{}, []byte(`{}`), srv.URL, make(http.Header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	for _, phase := range []string{phaseConnect, phaseTTFB} {
+		if _, ok := trace.phases[phase]; !ok {
+			t.Errorf("phase %s was not recorded: %v", phase, trace.phases)
+		}
+	}
+}
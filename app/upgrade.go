@@ -0,0 +1,109 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UpgradeError rejects websocket upgrade with http status.
+type UpgradeError struct {
+	Status     int
+	Message    string
+	RetryAfter time.Duration // Retry-After header in seconds rounded up, 0 is not set
+}
+
+func (e *UpgradeError) Error() string {
+	return fmt.Sprintf("upgrade rejected: status=%d %s", e.Status, e.Message)
+}
+
+// UpgradeHook checks websocket upgrade request before any websocket traffic. Returned error rejects upgrade.
+// *UpgradeError sets response status, other errors are rejected with App.UpgradeRejectStatus.
+type UpgradeHook func(r *http.Request) error
+
+// DenyPathsHook rejects upgrades for paths with given prefixes.
+func DenyPathsHook(status int, prefixes ...string) UpgradeHook {
+	return func(r *http.Request) error {
+		for _, p := range prefixes {
+			if p != "" && strings.HasPrefix(r.URL.Path, p) {
+				return &UpgradeError{Status: status, Message: "path is not allowed"}
+			}
+		}
+
+		return nil
+	}
+}
+
+// OriginHook rejects upgrades with Origin header not from allowed origins: exact like https://example.com,
+// wildcards like https://*.example.com or regex prefixed with ~. Invalid regex patterns don't match.
+func OriginHook(status int, origins ...string) UpgradeHook {
+	var patterns []originPattern
+	for _, o := range origins {
+		if p, err := compileOrigins([]string{o}); err == nil {
+			patterns = append(patterns, p...)
+		}
+	}
+
+	return originHook(status, false, patterns, nil)
+}
+
+// RequireHeadersHook rejects upgrades without any of given headers.
+func RequireHeadersHook(status int, headers ...string) UpgradeHook {
+	return func(r *http.Request) error {
+		for _, h := range headers {
+			if h != "" && r.Header.Get(h) == "" {
+				return &UpgradeError{Status: status, Message: "required header is missing: " + h}
+			}
+		}
+
+		return nil
+	}
+}
+
+// ForwardAuthHook rejects upgrades if authUrl returns non-2xx status (like nginx auth_request).
+// Client headers are passed to authUrl, 401 and 403 statuses are returned to client as is.
+func ForwardAuthHook(authUrl string, timeout time.Duration) UpgradeHook {
+	client := &http.Client{Timeout: timeout}
+	return func(r *http.Request) error {
+		_, err := forwardAuth(client, authUrl, r.URL.RequestURI(), r.Header)
+		return err
+	}
+}
+
+// upgradeHandler runs upgrade hooks before websocket handler h.
+func (a *App) upgradeHandler(h http.Handler) http.Handler {
+	if len(a.UpgradeHooks) == 0 {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, hook := range a.UpgradeHooks {
+			if err := hook(r); err != nil {
+				a.rejectUpgrade(w, r, err)
+				return
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// rejectUpgrade writes http status from UpgradeError or App.UpgradeRejectStatus.
+func (a *App) rejectUpgrade(w http.ResponseWriter, r *http.Request, err error) {
+	a.Errorf("upgrade rejected ip=%s uri=%s err=%s", r.RemoteAddr, r.URL.RequestURI(), err)
+	status := a.UpgradeRejectStatus
+	if ue, ok := err.(*UpgradeError); ok {
+		status = ue.Status
+		if ue.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(ue.RetryAfter.Seconds()))))
+		}
+	}
+	if status == 0 {
+		status = http.StatusForbidden
+	}
+
+	http.Error(w, http.StatusText(status), status)
+}
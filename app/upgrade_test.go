@@ -0,0 +1,102 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUpgradeHooks(t *testing.T) {
+	var authHeader http.Header
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader = r.Header
+		switch r.Header.Get("Authorization") {
+		case "Bearer ok":
+		case "Bearer forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		case "":
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer auth.Close()
+
+	denyPaths := DenyPathsHook(http.StatusNotFound, "/internal", "")
+	requireHeaders := RequireHeadersHook(http.StatusBadRequest, "X-Client", "")
+	forwardAuth := ForwardAuthHook(auth.URL, time.Second)
+
+	tests := []struct {
+		name   string
+		hook   UpgradeHook
+		path   string
+		header http.Header
+		status int // UpgradeError status, -1 for other errors, 0 is allowed
+	}{
+		{"path allowed", denyPaths, "/rpc", nil, 0},
+		{"path denied", denyPaths, "/internal/rpc", nil, http.StatusNotFound},
+		{"header present", requireHeaders, "/rpc", http.Header{"X-Client": {"app"}}, 0},
+		{"header missing", requireHeaders, "/rpc", nil, http.StatusBadRequest},
+		{"auth ok", forwardAuth, "/rpc", http.Header{"Authorization": {"Bearer ok"}}, 0},
+		{"auth unauthorized", forwardAuth, "/rpc", nil, http.StatusUnauthorized},
+		{"auth forbidden", forwardAuth, "/rpc", http.Header{"Authorization": {"Bearer forbidden"}}, http.StatusForbidden},
+		{"auth server error", forwardAuth, "/rpc", http.Header{"Authorization": {"Bearer other"}}, -1},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path+"?v=1", nil)
+		for k, vv := range tt.header {
+			r.Header[k] = vv
+		}
+		r.Header.Set("Upgrade", "websocket")
+
+		err := tt.hook(r)
+		var ue *UpgradeError
+		switch {
+		case tt.status == 0 && err != nil:
+			t.Errorf("%s: got %v", tt.name, err)
+		case tt.status > 0 && (!errors.As(err, &ue) || ue.Status != tt.status):
+			t.Errorf("%s: got %v", tt.name, err)
+		case tt.status < 0 && (err == nil || errors.As(err, &ue)):
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+
+	// client headers are passed to auth url without upgrade headers
+	forwardAuth(httptest.NewRequest(http.MethodGet, "/rpc?v=1", nil))
+	if authHeader.Get("X-Original-Uri") != "/rpc?v=1" || authHeader.Get("Upgrade") != "" {
+		t.Errorf("got auth headers %v", authHeader)
+	}
+}
+
+func TestUpgradeHandler(t *testing.T) {
+	errOther := errors.New("other")
+	tests := []struct {
+		name          string
+		err           error
+		rejectStatus  int
+		status        int
+		retryAfter    string
+		handlerCalled bool
+	}{
+		{"allowed", nil, 0, http.StatusOK, "", true},
+		{"upgrade error", &UpgradeError{Status: http.StatusUnauthorized}, 0, http.StatusUnauthorized, "", false},
+		{"retry after", &UpgradeError{Status: http.StatusTooManyRequests, RetryAfter: 1500 * time.Millisecond}, 0, http.StatusTooManyRequests, "2", false},
+		{"other error", errOther, 0, http.StatusForbidden, "", false},
+		{"other error with reject status", errOther, http.StatusTeapot, http.StatusTeapot, "", false},
+	}
+
+	for _, tt := range tests {
+		called, err := false, tt.err
+		a := &App{UpgradeRejectStatus: tt.rejectStatus, UpgradeHooks: []UpgradeHook{func(*http.Request) error { return err }}}
+		h := a.upgradeHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/rpc", nil))
+		if w.Code != tt.status || w.Header().Get("Retry-After") != tt.retryAfter || called != tt.handlerCalled {
+			t.Errorf("%s: got %d retry-after %q called %v", tt.name, w.Code, w.Header().Get("Retry-After"), called)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Client control protocol versions. Control protocol covers text commands (SET, AUTH, TAG, VERSION),
+// control acks and server notifications. Clients negotiate version with "VERSION <n>" message or
+// ws2http.v<n> websocket subprotocol, connections without negotiation use version 1.
+const (
+	ProtocolVersion    = 1 // latest supported control protocol version
+	MinProtocolVersion = 1 // oldest supported control protocol version
+
+	versionSubprotocolPrefix = "ws2http.v"
+)
+
+var errProtocolVersion = errors.New("unsupported protocol version")
+
+// negotiateVersion returns supported version for client version: client version or latest supported if client is newer.
+func negotiateVersion(client int) (int, error) {
+	if client < MinProtocolVersion {
+		return 0, errProtocolVersion
+	} else if client > ProtocolVersion {
+		return ProtocolVersion, nil
+	}
+
+	return client, nil
+}
+
+// parseVersionSubprotocol returns version from ws2http.v<n> subprotocol or 0.
+func parseVersionSubprotocol(protocol string) int {
+	if !strings.HasPrefix(protocol, versionSubprotocolPrefix) {
+		return 0
+	}
+
+	v, _ := strconv.Atoi(protocol[len(versionSubprotocolPrefix):])
+	return v
+}
+
+// selectVersionProtocol returns best supported ws2http.v<n> subprotocol from client offer or empty string.
+func selectVersionProtocol(offer []string) string {
+	best := 0
+	for _, p := range offer {
+		if v := parseVersionSubprotocol(p); v >= MinProtocolVersion && v <= ProtocolVersion && v > best {
+			best = v
+		}
+	}
+
+	if best == 0 {
+		return ""
+	}
+
+	return versionSubprotocolPrefix + strconv.Itoa(best)
+}
+
+// checkVersion handles "VERSION <n>" message and replies with "VERSION <negotiated>" or "ERR VERSION <error>".
+// Reply is sent regardless of control acks, rejected version is followed by controlErrorMethod notification.
+// "VERSION" without number returns current connection version.
+func (rf *requestForwarder) checkVersion(msg []byte) bool {
+	if !bytes.Equal(msg, []byte("VERSION")) && !bytes.HasPrefix(msg, []byte("VERSION ")) {
+		return false
+	}
+
+	reply := "VERSION " + strconv.Itoa(rf.version)
+	if arg := strings.TrimSpace(string(msg[len("VERSION"):])); arg != "" {
+		client, _ := strconv.Atoi(arg)
+		if v, err := negotiateVersion(client); err != nil {
+			defer rf.controlError(msg, err)
+			reply = "ERR VERSION " + err.Error()
+		} else {
+			rf.version = v
+			reply = "VERSION " + strconv.Itoa(v)
+		}
+	}
+
+	if err := rf.write(reply); err != nil {
+		rf.Errorf("can't send version err=%s", err)
+	}
+
+	return true
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestNegotiateVersion(t *testing.T) {
+	if v, err := negotiateVersion(ProtocolVersion + 1); err != nil || v != ProtocolVersion {
+		t.Errorf("newer client: got %d, %v", v, err)
+	}
+
+	if _, err := negotiateVersion(MinProtocolVersion - 1); err != errProtocolVersion {
+		t.Errorf("older client: got %v", err)
+	}
+
+	if p := selectVersionProtocol([]string{"json", "ws2http.v1", "ws2http.v99"}); p != "ws2http.v1" {
+		t.Errorf("selectVersionProtocol(): got %s", p)
+	}
+
+	if p := selectVersionProtocol([]string{"ws2http.vx"}); p != "" {
+		t.Errorf("selectVersionProtocol(invalid): got %s", p)
+	}
+}
+
+func TestRequestForwarderVersion(t *testing.T) {
+	srv := httptest.NewServer(NewHttpForwarder("http://localhost", nil, 1, 1).WebsocketHandler())
+	defer srv.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{"ws2http.v1", "ws2http.v99"}}
+	ws, _, err := dialer.Dial(strings.Replace(srv.URL, "http", "ws", 1), http.Header{"Origin": {srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	for msg, want := range map[string]string{
+		"VERSION":    "VERSION 1",
+		"VERSION 99": "VERSION 1",
+		"VERSION 0":  "ERR VERSION " + errProtocolVersion.Error(),
+	} {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		_, reply, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		} else if string(reply) != want {
+			t.Errorf("%s: got %s, want %s", msg, reply, want)
+		}
+
+		// rejected version is followed by control error notification
+		if strings.HasPrefix(want, "ERR") {
+			if _, reply, err = ws.ReadMessage(); err != nil {
+				t.Fatal(err)
+			} else if !strings.Contains(string(reply), `"reason":"unsupported_version"`) {
+				t.Errorf("%s: got %s, want control error", msg, reply)
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/semrush/ws2http/clock"
+)
+
+const closeFrameTimeout = time.Second // write deadline of close and ping frames
+
+var errNullOrigin = errors.New("null origin")
+
+// wsConn is a client websocket connection with upgrade request. Messages are read by connection goroutine and
+// written by send queue writer, close and ping frames are written from any goroutine.
+// Zero value has no connection and request, it is used while testing.
+type wsConn struct {
+	conn     *websocket.Conn
+	req      *http.Request
+	instance instance // identity appended to close frame reasons
+}
+
+// Request returns upgrade request, nil while testing.
+func (c *wsConn) Request() *http.Request {
+	return c.req
+}
+
+// Subprotocol returns negotiated websocket subprotocol, empty if none was selected.
+func (c *wsConn) Subprotocol() string {
+	if c.conn == nil {
+		return ""
+	}
+
+	return c.conn.Subprotocol()
+}
+
+// Receive reads next text or binary message with its websocket.TextMessage or websocket.BinaryMessage type.
+// Normal close of client and closed connection are returned as io.EOF.
+func (c *wsConn) Receive() (int, []byte, error) {
+	mt, data, err := c.conn.ReadMessage()
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) || errors.Is(err, net.ErrClosed) {
+		return mt, nil, io.EOF
+	}
+
+	return mt, data, err
+}
+
+// Send writes string frame as text message and []byte frame as binary message.
+func (c *wsConn) Send(frame interface{}) error {
+	switch f := frame.(type) {
+	case string:
+		return c.conn.WriteMessage(websocket.TextMessage, []byte(f))
+	case []byte:
+		return c.conn.WriteMessage(websocket.BinaryMessage, f)
+	}
+
+	return fmt.Errorf("unsupported frame type %T", frame)
+}
+
+// SetWriteDeadline sets deadline of next writes, must be called from writer.
+func (c *wsConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// interrupt fails current write of writer, connection can't be written after interrupted write.
+func (c *wsConn) interrupt() {
+	c.conn.UnderlyingConn().SetWriteDeadline(time.Now())
+}
+
+// Close closes connection with normal closure code.
+func (c *wsConn) Close() error {
+	return c.closeWith(websocket.CloseNormalClosure, "")
+}
+
+// closeWith sends close frame with status and reason with instance identity and closes connection. It must not
+// be used after failed writes, see abort.
+func (c *wsConn) closeWith(status int, reason string) error {
+	reason = c.instance.closeReason(reason)
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(status, reason), time.Now().Add(closeFrameTimeout))
+	return c.conn.Close()
+}
+
+// abort closes connection without close frame, it is used after failed writes, because partially written frame
+// can't be followed by another frame.
+func (c *wsConn) abort() error {
+	return c.conn.Close()
+}
+
+// ping sends ping frames each interval until done is closed. Read deadline is extended by client pongs,
+// so connection reader fails if client doesn't answer for two intervals.
+func (c *wsConn) ping(interval time.Duration, clk clock.Clock, done <-chan struct{}) {
+	t := clk.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C():
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(closeFrameTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// startPing sets read deadline for pongs and starts ping goroutine with ticker of clk, it is stopped by closing done.
+func (c *wsConn) startPing(interval time.Duration, clk clock.Clock, goroutines *goroutineTracker, done <-chan struct{}) {
+	c.conn.SetReadDeadline(time.Now().Add(2 * interval))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(2 * interval))
+	})
+
+	goroutines.spawn("ping", func() { c.ping(interval, clk, done) })
+}
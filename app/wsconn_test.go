@@ -0,0 +1,163 @@
+package app
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/semrush/ws2http/clock"
+)
+
+// newWsServer returns test server running h for every upgraded connection.
+func newWsServer(h func(ws *wsConn)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		ws := &wsConn{conn: conn, req: r}
+		defer ws.Close()
+
+		h(ws)
+	}))
+}
+
+func TestWsConn(t *testing.T) {
+	received := make(chan []byte, 1)
+	srv := newWsServer(func(ws *wsConn) {
+		ws.Send("text")
+		ws.Send([]byte{1, 2})
+		for _, want := range []int{websocket.TextMessage, websocket.BinaryMessage} {
+			mt, msg, err := ws.Receive()
+			if err != nil || mt != want {
+				t.Errorf("got %d %v", mt, err)
+			}
+			received <- msg
+		}
+
+		if _, _, err := ws.Receive(); err != io.EOF {
+			t.Errorf("client close: got %v", err)
+		}
+		close(received)
+	})
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// message types follow frame types
+	if mt, msg, err := ws.ReadMessage(); err != nil || mt != websocket.TextMessage || string(msg) != "text" {
+		t.Errorf("got %d %s %v", mt, msg, err)
+	}
+	if mt, msg, err := ws.ReadMessage(); err != nil || mt != websocket.BinaryMessage || len(msg) != 2 {
+		t.Errorf("got %d %v %v", mt, msg, err)
+	}
+
+	ws.WriteMessage(websocket.TextMessage, []byte("hello"))
+	if msg := <-received; string(msg) != "hello" {
+		t.Errorf("got %s", msg)
+	}
+	ws.WriteMessage(websocket.BinaryMessage, []byte{3})
+	if msg := <-received; len(msg) != 1 || msg[0] != 3 {
+		t.Errorf("got %v", msg)
+	}
+	ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	<-received
+}
+
+func TestWsConnCloseWith(t *testing.T) {
+	srv := newWsServer(func(ws *wsConn) {
+		ws.instance = instance{id: "ws-1"}
+		ws.closeWith(websocket.CloseGoingAway, "shutdown")
+	})
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	_, _, err = ws.ReadMessage()
+	if ce, ok := err.(*websocket.CloseError); !ok || ce.Code != websocket.CloseGoingAway || ce.Text != "shutdown instance=ws-1" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestWsConnPing(t *testing.T) {
+	errc := make(chan error, 1)
+	srv := newWsServer(func(ws *wsConn) {
+		done := make(chan struct{})
+		defer close(done)
+		ws.startPing(50*time.Millisecond, clock.Real, nil, done)
+
+		_, _, err := ws.Receive()
+		errc <- err
+	})
+	defer srv.Close()
+
+	// client never reads, so pings are not answered
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	select {
+	case err := <-errc:
+		if te, ok := err.(errTimeout); !ok || !te.Timeout() {
+			t.Errorf("expected timeout error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("client without pongs was not disconnected")
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	hf := NewHttpForwarder("http://localhost", nil, 1, 1)
+	handshake := func(origin string, offer ...string) (string, error) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if len(offer) > 0 {
+			r.Header.Set("Sec-Websocket-Protocol", strings.Join(offer, ", "))
+		}
+		return hf.handshake(r)
+	}
+
+	if _, err := handshake(""); err != errNullOrigin {
+		t.Errorf("no origin: got %v", err)
+	}
+	if _, err := handshake("null"); err == nil {
+		t.Error("null origin: expected error")
+	}
+	if p, err := handshake("http://localhost"); p != "" || err != nil {
+		t.Errorf("no offer: got %q %v", p, err)
+	}
+	if p, err := handshake("http://localhost", "json", "ws2http.v1"); p != "ws2http.v1" || err != nil {
+		t.Errorf("version: got %q %v", p, err)
+	}
+	if p, err := handshake("http://localhost", "mqtt"); p != "mqtt" || err != nil {
+		t.Errorf("single offer: got %q %v", p, err)
+	}
+	if _, err := handshake("http://localhost", "a", "b"); err == nil {
+		t.Error("unsupported offer: expected error")
+	}
+
+	// STOMP is selected only in STOMP mode
+	if p, _ := handshake("http://localhost", "v10.stomp", "v12.stomp"); p != "" {
+		t.Errorf("stomp disabled: got %q", p)
+	}
+	hf.SetStomp(true)
+	if p, _ := handshake("http://localhost", "v10.stomp", "v12.stomp"); p != "v12.stomp" {
+		t.Errorf("stomp: got %q", p)
+	}
+}
@@ -0,0 +1,256 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Backend protocols.
+const (
+	ProtocolJsonRpc = "jsonrpc"
+	ProtocolXmlRpc  = "xmlrpc"
+)
+
+var errXmlRpcResponse = errors.New("invalid xml-rpc response")
+
+// translator converts json-rpc request into backend protocol and backend response back into json-rpc response.
+type translator interface {
+	encode(req JsonRpcRequest, headers http.Header) ([]byte, error)
+	decode(req JsonRpcRequest, resp []byte) ([]byte, error)
+}
+
+// newTranslator returns translator for rule protocol or nil for json-rpc.
+func newTranslator(rule ProxyRule) (translator, error) {
+	switch rule.Protocol {
+	case "", ProtocolJsonRpc:
+		return nil, nil
+	case ProtocolXmlRpc:
+		return xmlRpcTranslator{}, nil
+	case ProtocolSoap:
+		return newSoapTranslator(rule)
+	}
+
+	return nil, fmt.Errorf("unknown protocol=%s for route=%s", rule.Protocol, rule.Src)
+}
+
+// decodeResponse converts backend response into json-rpc response by route translator.
+func (hf *HttpForwarder) decodeResponse(req BackendRequest, resp []byte) ([]byte, error) {
+	if rs := hf.routeState(req.Route); rs != nil && rs.translator != nil {
+		return rs.translator.decode(req.Request, resp)
+	}
+
+	return resp, nil
+}
+
+// xmlNode is a generic xml element.
+type xmlNode struct {
+	XMLName xml.Name
+	Content string    `xml:",chardata"`
+	Nodes   []xmlNode `xml:",any"`
+}
+
+// child returns first child element with name.
+func (n xmlNode) child(name string) (xmlNode, bool) {
+	for _, c := range n.Nodes {
+		if c.XMLName.Local == name {
+			return c, true
+		}
+	}
+
+	return xmlNode{}, false
+}
+
+// xmlRpcTranslator converts json-rpc calls into xml-rpc methodCall and methodResponse or fault back.
+type xmlRpcTranslator struct{}
+
+func (xmlRpcTranslator) encode(req JsonRpcRequest, headers http.Header) ([]byte, error) {
+	var params []interface{}
+	if req.Params != nil {
+		d := json.NewDecoder(bytes.NewReader(*req.Params))
+		d.UseNumber()
+
+		var p interface{}
+		if err := d.Decode(&p); err != nil {
+			return nil, err
+		}
+
+		// positional params are xml-rpc params, named params are one struct param
+		switch pv := p.(type) {
+		case []interface{}:
+			params = pv
+		case nil:
+		default:
+			params = []interface{}{pv}
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString("<methodCall><methodName>")
+	xml.EscapeText(&b, []byte(req.Method))
+	b.WriteString("</methodName><params>")
+	for _, p := range params {
+		b.WriteString("<param>")
+		if err := xmlRpcEncodeValue(&b, p); err != nil {
+			return nil, err
+		}
+		b.WriteString("</param>")
+	}
+	b.WriteString("</params></methodCall>")
+
+	headers.Set("Content-Type", "text/xml")
+	return b.Bytes(), nil
+}
+
+func (xmlRpcTranslator) decode(req JsonRpcRequest, resp []byte) ([]byte, error) {
+	var n xmlNode
+	if err := xml.Unmarshal(resp, &n); err != nil {
+		return nil, err
+	} else if n.XMLName.Local != "methodResponse" {
+		return nil, errXmlRpcResponse
+	}
+
+	// fault: struct with faultCode and faultString
+	if f, ok := n.child("fault"); ok {
+		fv, ok := f.child("value")
+		if !ok {
+			return nil, errXmlRpcResponse
+		}
+
+		v, err := xmlRpcDecodeValue(fv)
+		if err != nil {
+			return nil, err
+		}
+
+		fault, _ := v.(map[string]interface{})
+		code, _ := fault["faultCode"].(int64)
+		message, _ := fault["faultString"].(string)
+		return NewJsonRpcErr(req, int(code), errors.New(message)).JSON(), nil
+	}
+
+	var result interface{}
+	if p, ok := n.child("params"); ok {
+		if pv, ok := p.child("param"); ok {
+			if v, ok := pv.child("value"); ok {
+				var err error
+				if result, err = xmlRpcDecodeValue(v); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return json.Marshal(JsonRpcResponse{Version: "2.0", Id: req.Id, Result: result})
+}
+
+// xmlRpcEncodeValue writes json value as xml-rpc <value>.
+func xmlRpcEncodeValue(b *bytes.Buffer, v interface{}) error {
+	b.WriteString("<value>")
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("<nil/>")
+	case bool:
+		if v {
+			b.WriteString("<boolean>1</boolean>")
+		} else {
+			b.WriteString("<boolean>0</boolean>")
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil && i >= math.MinInt32 && i <= math.MaxInt32 {
+			b.WriteString("<int>" + v.String() + "</int>")
+		} else if err == nil {
+			b.WriteString("<i8>" + v.String() + "</i8>")
+		} else {
+			b.WriteString("<double>" + v.String() + "</double>")
+		}
+	case string:
+		b.WriteString("<string>")
+		xml.EscapeText(b, []byte(v))
+		b.WriteString("</string>")
+	case []interface{}:
+		b.WriteString("<array><data>")
+		for _, av := range v {
+			if err := xmlRpcEncodeValue(b, av); err != nil {
+				return err
+			}
+		}
+		b.WriteString("</data></array>")
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		b.WriteString("<struct>")
+		for _, k := range keys {
+			b.WriteString("<member><name>")
+			xml.EscapeText(b, []byte(k))
+			b.WriteString("</name>")
+			if err := xmlRpcEncodeValue(b, v[k]); err != nil {
+				return err
+			}
+			b.WriteString("</member>")
+		}
+		b.WriteString("</struct>")
+	default:
+		return fmt.Errorf("unsupported xml-rpc value type %T", v)
+	}
+	b.WriteString("</value>")
+
+	return nil
+}
+
+// xmlRpcDecodeValue returns json value from xml-rpc <value>.
+func xmlRpcDecodeValue(n xmlNode) (interface{}, error) {
+	if len(n.Nodes) == 0 {
+		return n.Content, nil // string is default type
+	}
+
+	t := n.Nodes[0]
+	switch t.XMLName.Local {
+	case "int", "i4", "i8":
+		return strconv.ParseInt(strings.TrimSpace(t.Content), 10, 64)
+	case "double":
+		return strconv.ParseFloat(strings.TrimSpace(t.Content), 64)
+	case "boolean":
+		return strings.TrimSpace(t.Content) == "1", nil
+	case "string", "dateTime.iso8601", "base64":
+		return t.Content, nil
+	case "nil":
+		return nil, nil
+	case "array":
+		list := []interface{}{}
+		data, _ := t.child("data")
+		for _, dv := range data.Nodes {
+			v, err := xmlRpcDecodeValue(dv)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case "struct":
+		m := make(map[string]interface{})
+		for _, member := range t.Nodes {
+			name, _ := member.child("name")
+			mv, _ := member.child("value")
+			v, err := xmlRpcDecodeValue(mv)
+			if err != nil {
+				return nil, err
+			}
+			m[name.Content] = v
+		}
+		return m, nil
+	}
+
+	return nil, fmt.Errorf("unsupported xml-rpc value type %s", t.XMLName.Local)
+}
@@ -0,0 +1,45 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestXmlRpcTranslator(t *testing.T) {
+	params := json.RawMessage(`[42,"a<b",true,{"k":[1.5,null]}]`)
+	req := JsonRpcRequest{JsonRpc: "2.0", Id: json.RawMessage(`1`), Method: "test.add", Params: &params}
+
+	h := make(http.Header)
+	body, err := xmlRpcTranslator{}.encode(req, h)
+	expected := `<methodCall><methodName>test.add</methodName><params>` +
+		`<param><value><int>42</int></value></param>` +
+		`<param><value><string>a&lt;b</string></value></param>` +
+		`<param><value><boolean>1</boolean></value></param>` +
+		`<param><value><struct><member><name>k</name><value><array><data><value><double>1.5</double></value><value><nil/></value></data></array></value></member></struct></value></param>` +
+		`</params></methodCall>`
+	if err != nil || !strings.HasSuffix(string(body), expected) || h.Get("Content-Type") != "text/xml" {
+		t.Errorf("encode(): got = %s, %v; expected = %s", body, err, expected)
+	}
+
+	var tc = []struct {
+		in, out string
+	}{
+		{
+			in:  `<?xml version="1.0"?><methodResponse><params><param><value><struct><member><name>sum</name><value><i4>65</i4></value></member><member><name>name</name><value>test</value></member></struct></value></param></params></methodResponse>`,
+			out: `{"jsonrpc":"2.0","id":1,"result":{"name":"test","sum":65}}`,
+		},
+		{
+			in:  `<methodResponse><fault><value><struct><member><name>faultCode</name><value><int>4</int></value></member><member><name>faultString</name><value><string>Too many parameters.</string></value></member></struct></value></fault></methodResponse>`,
+			out: `{"jsonrpc":"2.0","id":1,"error":{"code":4,"message":"Too many parameters."}}`,
+		},
+	}
+
+	for _, c := range tc {
+		resp, err := xmlRpcTranslator{}.decode(req, []byte(c.in))
+		if err != nil || string(resp) != c.out {
+			t.Errorf("decode(%s): got = %s, %v; expected = %s", c.in, resp, err, c.out)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/semrush/ws2http/client"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bench is a load test of ws2http endpoint: every connection calls method in loop, churn drops connections
+// to check reconnects and session restore under load.
+type bench struct {
+	url      string
+	conns    int
+	duration time.Duration
+	method   string
+	params   json.RawMessage
+	auth     string
+	churn    time.Duration // max period between connection drops, 0 is disabled
+	resume   bool          // restore session on reconnect after drop, otherwise new client is dialed
+
+	calls, failed, lost, drops, disconnects int64
+
+	lock      sync.Mutex
+	latencies []time.Duration
+}
+
+// runBench parses bench subcommand flags from args, runs bench and prints results.
+func runBench(args []string) error {
+	b := &bench{}
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	fs.StringVar(&b.url, "url", "ws://localhost:8090/rpc", "ws2http websocket endpoint")
+	fs.IntVar(&b.conns, "conns", 10, "parallel client connections")
+	fs.DurationVar(&b.duration, "duration", 10*time.Second, "bench duration")
+	fs.StringVar(&b.method, "method", "", "json-rpc method called in loop by every connection, required")
+	params := fs.String("params", "", "json-rpc params of method, like [1]")
+	fs.StringVar(&b.auth, "auth", "", "Authorization session header sent with AUTH, like 'Bearer token'")
+	fs.DurationVar(&b.churn, "churn", 0, "drop every connection after random period up to churn and reconnect, 0 is disabled")
+	fs.BoolVar(&b.resume, "resume", true, "restore session headers and tags on churn reconnect, false dials new client with new session")
+	fs.Parse(args)
+
+	if *params != "" {
+		if !json.Valid([]byte(*params)) {
+			return errors.New("bench: params are not valid json")
+		}
+		b.params = json.RawMessage(*params)
+	}
+	if b.method == "" {
+		return errors.New("bench: method is required")
+	} else if b.conns <= 0 {
+		return errors.New("bench: conns must be positive")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.duration)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, b.conns)
+	start := time.Now()
+	for i := 0; i < b.conns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- b.run(ctx)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Println(b.report(time.Since(start)))
+	return nil
+}
+
+// dial connects new client and sends AUTH if set.
+func (b *bench) dial() (*client.Client, error) {
+	c, err := client.Dial(b.url, client.Options{
+		Reconnect:    true,
+		OnDisconnect: func(error) { atomic.AddInt64(&b.disconnects, 1) },
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if b.auth != "" {
+		if err := c.Auth(b.auth); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// run calls method in loop until ctx is done, connection is dropped after each churn period.
+func (b *bench) run(ctx context.Context) error {
+	c, err := b.dial()
+	if err != nil {
+		return err
+	}
+	defer func() { c.Close() }()
+
+	next := b.nextDrop()
+	for ctx.Err() == nil {
+		if !next.IsZero() && time.Now().After(next) {
+			atomic.AddInt64(&b.drops, 1)
+			if b.resume {
+				c.Drop()
+			} else {
+				c.Close()
+				nc, err := b.dial()
+				if err != nil {
+					return err
+				}
+				c = nc
+			}
+			next = b.nextDrop()
+		}
+
+		start := time.Now()
+		err := c.Call(ctx, b.method, b.params, nil)
+		if ctx.Err() != nil {
+			break
+		}
+		b.observe(time.Since(start), err)
+	}
+
+	return nil
+}
+
+// nextDrop returns time of next churn drop, zero time if churn is disabled.
+func (b *bench) nextDrop() time.Time {
+	if b.churn <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(time.Duration(rand.Int63n(int64(b.churn))) + 1)
+}
+
+// observe counts call result, calls lost with connection are counted separately from json-rpc errors.
+func (b *bench) observe(d time.Duration, err error) {
+	atomic.AddInt64(&b.calls, 1)
+	if err == client.ErrDisconnected {
+		atomic.AddInt64(&b.lost, 1)
+	} else if err != nil {
+		atomic.AddInt64(&b.failed, 1)
+	}
+
+	b.lock.Lock()
+	b.latencies = append(b.latencies, d)
+	b.lock.Unlock()
+}
+
+// report returns bench results for elapsed time.
+func (b *bench) report(elapsed time.Duration) string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	sort.Slice(b.latencies, func(i, j int) bool { return b.latencies[i] < b.latencies[j] })
+	percentile := func(p float64) time.Duration {
+		if len(b.latencies) == 0 {
+			return 0
+		}
+		return b.latencies[int(p*float64(len(b.latencies)-1))]
+	}
+
+	return fmt.Sprintf("calls=%d errors=%d lost=%d drops=%d disconnects=%d rate=%.1f/s p50=%s p99=%s",
+		b.calls, b.failed, b.lost, b.drops, b.disconnects, float64(b.calls)/elapsed.Seconds(), percentile(0.5), percentile(0.99))
+}
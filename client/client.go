@@ -0,0 +1,450 @@
+// Package client is a Go client for ws2http: JSON-RPC calls over websocket with session headers,
+// request/response correlation by id, reconnects with backoff and notification callbacks.
+//
+//	c, err := client.Dial("ws://localhost:8090/rpc", client.Options{})
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//
+//	c.Auth("Bearer token")
+//	c.Subscribe("maintenance", func(params json.RawMessage) { ... })
+//
+//	var user User
+//	err = c.Call(ctx, "users.get", map[string]int{"id": 1}, &user)
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+
+	versionSubprotocol = "ws2http.v1"
+	cancelMethod       = "ws2http.cancel"
+)
+
+var (
+	ErrClosed       = errors.New("client is closed")
+	ErrDisconnected = errors.New("connection lost")
+)
+
+// Error is a JSON-RPC error returned by proxy or backend.
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc error %d: %s", e.Code, e.Message)
+}
+
+// Options are optional client settings.
+type Options struct {
+	Origin     string        // websocket origin, default is url with http scheme
+	Header     http.Header   // websocket handshake headers, like Cookie
+	Protocol   []string      // websocket subprotocols, default is ws2http.v1
+	MinBackoff time.Duration // first reconnect delay, doubled up to MaxBackoff, default is 100ms
+	MaxBackoff time.Duration // max reconnect delay, default is 30s
+	Reconnect  bool          // reconnect on connection loss, session headers and tags are restored
+
+	OnNotification func(method string, params json.RawMessage) // notifications without Subscribe callbacks
+	OnDisconnect   func(err error)                             // called on connection loss before reconnect
+}
+
+// message is an incoming JSON-RPC response or notification.
+type message struct {
+	Id     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *Error          `json:"error"`
+}
+
+// request is an outgoing JSON-RPC request or notification.
+type request struct {
+	JsonRpc string      `json:"jsonrpc"`
+	Id      uint64      `json:"id,omitempty"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// Client is a ws2http connection. Client is safe for concurrent use.
+type Client struct {
+	url  string
+	opts Options
+	seq  uint64
+
+	writeLock sync.Mutex // connection supports one concurrent writer
+	lock      sync.Mutex
+	ws        *websocket.Conn
+	connected chan struct{} // closed when ws is set
+	closed    chan struct{}
+	control   []string // SET, AUTH and TAG commands restored on reconnect
+	pending   map[uint64]chan message
+	subs      map[string][]func(params json.RawMessage)
+}
+
+// Dial connects to ws2http websocket endpoint, like ws://localhost:8090/rpc.
+func Dial(url string, opts Options) (*Client, error) {
+	if opts.Origin == "" {
+		opts.Origin = "http" + strings.TrimPrefix(url, "ws")
+	}
+	if opts.Protocol == nil {
+		opts.Protocol = []string{versionSubprotocol}
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = defaultMinBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaultMaxBackoff
+	}
+
+	c := &Client{
+		url:       url,
+		opts:      opts,
+		connected: make(chan struct{}),
+		closed:    make(chan struct{}),
+		pending:   make(map[uint64]chan message),
+		subs:      make(map[string][]func(params json.RawMessage)),
+	}
+
+	ws, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	c.setConn(ws)
+	return c, nil
+}
+
+// dial opens websocket connection and restores session control commands.
+func (c *Client) dial() (*websocket.Conn, error) {
+	header := http.Header{"Origin": {c.opts.Origin}}
+	for k, vv := range c.opts.Header {
+		header[k] = vv
+	}
+
+	dialer := websocket.Dialer{Subprotocols: c.opts.Protocol}
+	ws, _, err := dialer.Dial(c.url, header)
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	control := append([]string(nil), c.control...)
+	c.lock.Unlock()
+
+	for _, cmd := range control {
+		if err := c.write(ws, []byte(cmd)); err != nil {
+			ws.Close()
+			return nil, err
+		}
+	}
+
+	return ws, nil
+}
+
+// setConn sets active connection and starts reading loop.
+func (c *Client) setConn(ws *websocket.Conn) {
+	c.lock.Lock()
+	c.ws = ws
+	close(c.connected)
+	c.lock.Unlock()
+
+	go c.readLoop(ws)
+}
+
+// conn returns active connection, waits for reconnect if needed.
+func (c *Client) conn(ctx context.Context) (*websocket.Conn, error) {
+	for {
+		c.lock.Lock()
+		ws, connected := c.ws, c.connected
+		c.lock.Unlock()
+
+		select {
+		case <-c.closed:
+			return nil, ErrClosed
+		default:
+		}
+
+		if ws != nil {
+			return ws, nil
+		}
+
+		select {
+		case <-connected:
+		case <-c.closed:
+			return nil, ErrClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// readLoop dispatches incoming messages until connection is lost.
+func (c *Client) readLoop(ws *websocket.Conn) {
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			c.disconnected(ws, err)
+			return
+		}
+
+		// control acks and replies (OK, ERR, VERSION) are ignored
+		if len(data) == 0 || data[0] != '{' {
+			continue
+		}
+
+		var msg message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+
+		if msg.Method != "" && (len(msg.Id) == 0 || string(msg.Id) == "null") {
+			c.notify(msg)
+			continue
+		}
+
+		var id uint64
+		if err := json.Unmarshal(msg.Id, &id); err != nil {
+			continue
+		}
+
+		c.lock.Lock()
+		ch, ok := c.pending[id]
+		delete(c.pending, id)
+		c.lock.Unlock()
+		if ok {
+			ch <- msg
+		}
+	}
+}
+
+// notify calls subscription callbacks for notification.
+func (c *Client) notify(msg message) {
+	c.lock.Lock()
+	subs := c.subs[msg.Method]
+	c.lock.Unlock()
+
+	for _, fn := range subs {
+		fn(msg.Params)
+	}
+
+	if len(subs) == 0 && c.opts.OnNotification != nil {
+		c.opts.OnNotification(msg.Method, msg.Params)
+	}
+}
+
+// disconnected fails pending calls and reconnects if enabled, connections already replaced by Drop are ignored.
+func (c *Client) disconnected(ws *websocket.Conn, err error) {
+	ws.Close()
+
+	c.lock.Lock()
+	if c.ws != ws {
+		c.lock.Unlock()
+		return
+	}
+	c.ws = nil
+	c.connected = make(chan struct{})
+	pending := c.pending
+	c.pending = make(map[uint64]chan message)
+	c.lock.Unlock()
+
+	for _, ch := range pending {
+		close(ch)
+	}
+
+	select {
+	case <-c.closed:
+		return
+	default:
+	}
+
+	if c.opts.OnDisconnect != nil {
+		c.opts.OnDisconnect(err)
+	}
+
+	if !c.opts.Reconnect {
+		c.Close()
+		return
+	}
+
+	for backoff := c.opts.MinBackoff; ; backoff *= 2 {
+		if backoff > c.opts.MaxBackoff {
+			backoff = c.opts.MaxBackoff
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-c.closed:
+			return
+		}
+
+		if ws, err := c.dial(); err == nil {
+			c.setConn(ws)
+			return
+		}
+	}
+}
+
+// Call sends JSON-RPC request and decodes response result into result, result could be nil.
+// Backend request is cancelled by ws2http.cancel if ctx is done before response.
+// Returns *Error for JSON-RPC errors and ErrDisconnected if connection was lost before response.
+func (c *Client) Call(ctx context.Context, method string, params, result interface{}) error {
+	ws, err := c.conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	id := atomic.AddUint64(&c.seq, 1)
+	ch := make(chan message, 1)
+	c.lock.Lock()
+	c.pending[id] = ch
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		delete(c.pending, id)
+		c.lock.Unlock()
+	}()
+
+	if err := c.writeJSON(ws, request{JsonRpc: "2.0", Id: id, Method: method, Params: params}); err != nil {
+		return err
+	}
+
+	select {
+	case msg, ok := <-ch:
+		if !ok {
+			return ErrDisconnected
+		} else if msg.Error != nil {
+			return msg.Error
+		} else if result != nil && len(msg.Result) > 0 {
+			return json.Unmarshal(msg.Result, result)
+		}
+		return nil
+	case <-ctx.Done():
+		c.writeJSON(ws, request{JsonRpc: "2.0", Method: cancelMethod, Params: map[string]uint64{"id": id}})
+		return ctx.Err()
+	case <-c.closed:
+		return ErrClosed
+	}
+}
+
+// Notify sends JSON-RPC notification without waiting for backend.
+func (c *Client) Notify(ctx context.Context, method string, params interface{}) error {
+	ws, err := c.conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.writeJSON(ws, request{JsonRpc: "2.0", Method: method, Params: params})
+}
+
+// Set sets session header passed to backend with every request, header must be allowed by proxy.
+// Value must not contain spaces, use Auth for Authorization header.
+func (c *Client) Set(header, value string) error {
+	return c.sendControl("SET "+header+" "+value, "SET "+header+" ")
+}
+
+// Auth sets Authorization session header, like "Bearer token".
+func (c *Client) Auth(token string) error {
+	return c.sendControl("AUTH "+token, "AUTH ")
+}
+
+// Tag marks session with tag for proxy broadcasts.
+func (c *Client) Tag(tag string) error {
+	return c.sendControl("TAG "+tag, "TAG "+tag)
+}
+
+// sendControl sends control command and saves it for reconnect, replacing previous command with prefix.
+func (c *Client) sendControl(cmd, prefix string) error {
+	c.lock.Lock()
+	control := c.control[:0]
+	for _, s := range c.control {
+		if !strings.HasPrefix(s, prefix) {
+			control = append(control, s)
+		}
+	}
+	c.control = append(control, cmd)
+	ws := c.ws
+	c.lock.Unlock()
+
+	// command is sent on reconnect
+	if ws == nil {
+		return nil
+	}
+
+	return c.write(ws, []byte(cmd))
+}
+
+// Drop closes active connection like a network failure: pending calls fail with ErrDisconnected, OnDisconnect is
+// called and client with Reconnect dials again with session control commands. Drop returns after reconnect.
+func (c *Client) Drop() {
+	c.lock.Lock()
+	ws := c.ws
+	c.lock.Unlock()
+
+	if ws != nil {
+		c.disconnected(ws, ErrDisconnected)
+	}
+}
+
+// Subscribe adds callback for server notifications with method, like ws2http.reauth or broadcast methods.
+// Callbacks are called from reading goroutine and must not block.
+func (c *Client) Subscribe(method string, fn func(params json.RawMessage)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.subs[method] = append(c.subs[method], fn)
+}
+
+// Close closes connection and stops reconnects.
+func (c *Client) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	select {
+	case <-c.closed:
+		return nil
+	default:
+		close(c.closed)
+	}
+
+	if c.ws != nil {
+		c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		return c.ws.Close()
+	}
+
+	return nil
+}
+
+// write sends text message to ws.
+func (c *Client) write(ws *websocket.Conn, data []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	return ws.WriteMessage(websocket.TextMessage, data)
+}
+
+// writeJSON sends v as json text message to ws.
+func (c *Client) writeJSON(ws *websocket.Conn, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return c.write(ws, data)
+}
@@ -0,0 +1,155 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// rpcServer is a test ws2http server: responds with method and session Authorization, closes first connection after call.
+type rpcServer struct {
+	lock  sync.Mutex
+	conns int
+}
+
+func (s *rpcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	s.lock.Lock()
+	s.conns++
+	first := s.conns == 1
+	s.lock.Unlock()
+
+	var auth string
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		if msg := string(data); strings.HasPrefix(msg, "AUTH ") {
+			auth = msg[5:]
+			ws.WriteMessage(websocket.TextMessage, []byte("OK AUTH"))
+			continue
+		}
+
+		var req struct {
+			Id     uint64 `json:"id"`
+			Method string `json:"method"`
+		}
+		json.Unmarshal(data, &req)
+		switch req.Method {
+		case "notify":
+			ws.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"event","params":{"n":1}}`))
+		case "fail":
+			ws.WriteJSON(map[string]interface{}{"jsonrpc": "2.0", "id": req.Id, "error": Error{Code: -32000, Message: "failed"}})
+			continue
+		case "drop":
+			if first {
+				ws.Close()
+				return
+			}
+		}
+
+		ws.WriteJSON(map[string]interface{}{"jsonrpc": "2.0", "id": req.Id, "result": map[string]string{"method": req.Method, "auth": auth}})
+	}
+}
+
+func TestClient(t *testing.T) {
+	srv := httptest.NewServer(&rpcServer{})
+	defer srv.Close()
+
+	events := make(chan json.RawMessage, 1)
+	c, err := Dial(strings.Replace(srv.URL, "http", "ws", 1), Options{Reconnect: true, MinBackoff: time.Millisecond, Header: http.Header{"X-Test": {"1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Subscribe("event", func(params json.RawMessage) { events <- params })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := c.Auth("Bearer token"); err != nil {
+		t.Fatal(err)
+	}
+
+	var res map[string]string
+	if err := c.Call(ctx, "users.get", []int{1}, &res); err != nil || res["method"] != "users.get" || res["auth"] != "Bearer token" {
+		t.Fatalf("got %v, %v", res, err)
+	}
+
+	if err := c.Call(ctx, "fail", nil, nil); err == nil || err.(*Error).Code != -32000 {
+		t.Errorf("got err %v", err)
+	}
+
+	if err := c.Call(ctx, "notify", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case params := <-events:
+		if string(params) != `{"n":1}` {
+			t.Errorf("got params %s", params)
+		}
+	case <-time.After(time.Second):
+		t.Error("no notification")
+	}
+
+	// connection is lost, auth is restored after reconnect
+	if err := c.Call(ctx, "drop", nil, nil); err != ErrDisconnected {
+		t.Fatalf("got err %v", err)
+	}
+
+	res = nil
+	if err := c.Call(ctx, "drop", nil, &res); err != nil || res["auth"] != "Bearer token" {
+		t.Errorf("after reconnect got %v, %v", res, err)
+	}
+
+	c.Close()
+	if err := c.Call(ctx, "users.get", nil, nil); err != ErrClosed {
+		t.Errorf("after close got %v", err)
+	}
+}
+
+func TestClientDrop(t *testing.T) {
+	srv := httptest.NewServer(&rpcServer{})
+	defer srv.Close()
+
+	disconnects := make(chan error, 1)
+	c, err := Dial(strings.Replace(srv.URL, "http", "ws", 1), Options{Reconnect: true, MinBackoff: time.Millisecond, OnDisconnect: func(err error) { disconnects <- err }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c.Auth("Bearer token")
+	c.Drop()
+	if err := <-disconnects; err != ErrDisconnected {
+		t.Errorf("got disconnect err %v", err)
+	}
+
+	var res map[string]string
+	if err := c.Call(ctx, "users.get", nil, &res); err != nil || res["auth"] != "Bearer token" {
+		t.Errorf("after drop got %v, %v", res, err)
+	}
+
+	select {
+	case err := <-disconnects:
+		t.Errorf("dropped connection reported again: %v", err)
+	default:
+	}
+}
@@ -0,0 +1,188 @@
+// Package clock abstracts time for ws2http timers: keepalives, heartbeats, token expiry and slow-start ramps.
+// Real is a system clock, Fake is a manual clock for deterministic tests.
+//
+//	c := clock.NewFake(time.Now())
+//	a := &app.App{Clock: c, KeepAliveMethod: "system.ping", KeepAliveInterval: time.Minute}
+//	...
+//	c.Advance(time.Minute) // fires keepalive probes
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is a source of time and timers.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a scheduled func call.
+type Timer interface {
+	Stop() bool
+}
+
+// Ticker delivers ticks to C channel.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is a system clock.
+var Real Clock = realClock{}
+
+// OrReal returns c or Real if c is nil.
+func OrReal(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+
+	return c
+}
+
+// After returns channel receiving time of c after d, like time.After.
+func After(c Clock, d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.AfterFunc(d, func() { ch <- c.Now() })
+	return ch
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// Fake is a manual clock: time changes only by Advance, due timers and tickers fire in Advance.
+type Fake struct {
+	lock   sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFake returns fake clock at now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// fakeTimer is a timer or a ticker with period.
+type fakeTimer struct {
+	clock  *Fake
+	when   time.Time
+	period time.Duration // ticker period, 0 for timers
+	f      func()        // timer func
+	c      chan time.Time
+}
+
+func (f *Fake) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.now
+}
+
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	t := &fakeTimer{clock: f, when: f.now.Add(d), f: fn}
+	f.timers = append(f.timers, t)
+	return t
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	t := &fakeTimer{clock: f, when: f.now.Add(d), period: d, c: make(chan time.Time, 1)}
+	f.timers = append(f.timers, t)
+	return fakeTicker{t}
+}
+
+// Advance moves time forward by d and fires due timers and tickers in time order. Timer funcs are called
+// synchronously, ticks are dropped for tickers with unread tick like time.Ticker.
+func (f *Fake) Advance(d time.Duration) {
+	f.lock.Lock()
+	end := f.now.Add(d)
+	for {
+		sort.SliceStable(f.timers, func(i, j int) bool { return f.timers[i].when.Before(f.timers[j].when) })
+		if len(f.timers) == 0 || f.timers[0].when.After(end) {
+			break
+		}
+
+		t := f.timers[0]
+		f.now = t.when
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+			select {
+			case t.c <- f.now:
+			default:
+			}
+			continue
+		}
+
+		f.timers = f.timers[1:]
+		f.lock.Unlock()
+		t.f()
+		f.lock.Lock()
+	}
+	f.now = end
+	f.lock.Unlock()
+}
+
+// Timers returns number of active timers and tickers, like for waiting until goroutine starts ticker.
+func (f *Fake) Timers() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.timers)
+}
+
+// remove deletes timer, returns false if timer is not active.
+func (f *Fake) remove(t *fakeTimer) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for i, ft := range f.timers {
+		if ft == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *fakeTimer) Stop() bool {
+	return t.clock.remove(t)
+}
+
+type fakeTicker struct {
+	t *fakeTimer
+}
+
+func (t fakeTicker) C() <-chan time.Time {
+	return t.t.c
+}
+
+func (t fakeTicker) Stop() {
+	t.t.clock.remove(t.t)
+}
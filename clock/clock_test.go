@@ -0,0 +1,68 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+
+	var fired []time.Time
+	c.AfterFunc(time.Second, func() { fired = append(fired, c.Now()) })
+	stopped := c.AfterFunc(2*time.Second, func() { t.Error("stopped timer fired") })
+	ticker := c.NewTicker(500 * time.Millisecond)
+
+	if !stopped.Stop() || stopped.Stop() {
+		t.Error("invalid Stop result")
+	}
+
+	c.Advance(1200 * time.Millisecond)
+	if len(fired) != 1 || !fired[0].Equal(start.Add(time.Second)) {
+		t.Errorf("got %v", fired)
+	} else if now := c.Now(); !now.Equal(start.Add(1200 * time.Millisecond)) {
+		t.Errorf("got %v", now)
+	}
+
+	// first tick is kept, next ticks are dropped
+	select {
+	case tick := <-ticker.C():
+		if !tick.Equal(start.Add(500 * time.Millisecond)) {
+			t.Errorf("got tick %v", tick)
+		}
+	default:
+		t.Error("no tick")
+	}
+
+	ticker.Stop()
+	if n := c.Timers(); n != 0 {
+		t.Errorf("got %d timers", n)
+	}
+}
+
+func TestAfter(t *testing.T) {
+	c := NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ch := After(c, time.Second)
+
+	c.Advance(500 * time.Millisecond)
+	select {
+	case <-ch:
+		t.Error("early tick")
+	default:
+	}
+
+	c.Advance(500 * time.Millisecond)
+	select {
+	case now := <-ch:
+		if !now.Equal(c.Now()) {
+			t.Errorf("got %v", now)
+		}
+	default:
+		t.Error("no tick")
+	}
+
+	if OrReal(nil) != Real || OrReal(c) != c {
+		t.Error("invalid OrReal result")
+	}
+}
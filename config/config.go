@@ -0,0 +1,186 @@
+// Package config loads ws2http flag values from YAML or TOML files. Keys are flag names, lists are joined with
+// comma and maps are joined as k=v pairs with comma, so config values are validated by flag parsers:
+//
+//	h: localhost:8090
+//	headers: [Authorization, X-Token]
+//	timeout: 20
+//	routes:
+//	  - src: /rpc
+//	    dst: http://localhost/rpc
+//	    method-case: lower
+//	    method-alias: {getUser: users.get}
+//
+// Routes are set as "route" flag values src:dst, other route keys are per-route flags with src:value syntax.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RoutesKey is a key of route list in config file.
+const RoutesKey = "routes"
+
+var (
+	ErrUnknownFormat = errors.New("config: unknown file format, use .yaml, .yml, .json or .toml")
+	errRouteSrcDst   = errors.New("src and dst are required")
+)
+
+// Load reads YAML (or JSON) or TOML config file by extension.
+func Load(path string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		values, err = parseToml(data)
+	default:
+		return nil, ErrUnknownFormat
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config: %s: %w", path, err)
+	}
+
+	return values, nil
+}
+
+// Apply sets flags of fs from config values, flags set on command line take precedence. Route keys except src and dst
+// must be in routeFlags. Returns error for unknown keys and invalid values before any listener starts.
+func Apply(fs *flag.FlagSet, values map[string]interface{}, routeFlags []string) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if k == RoutesKey {
+			if err := applyRoutes(fs, values[k], routeFlags); err != nil {
+				return err
+			}
+			continue
+		} else if fs.Lookup(k) == nil {
+			return fmt.Errorf("config: unknown option %q", k)
+		} else if set[k] {
+			continue
+		}
+
+		v, err := flagValue(values[k])
+		if err == nil {
+			err = fs.Set(k, v)
+		}
+		if err != nil {
+			return fmt.Errorf("config: option %q: %w", k, err)
+		}
+	}
+
+	return nil
+}
+
+// applyRoutes sets route and per-route flags from route list.
+func applyRoutes(fs *flag.FlagSet, value interface{}, routeFlags []string) error {
+	routes, ok := value.([]interface{})
+	if !ok {
+		return fmt.Errorf("config: %s must be a list", RoutesKey)
+	}
+
+	for i, r := range routes {
+		route, ok := r.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("config: route %d must be a table", i+1)
+		}
+
+		src, _ := route["src"].(string)
+		dst, _ := route["dst"].(string)
+		if src == "" || dst == "" {
+			return fmt.Errorf("config: route %d: %w", i+1, errRouteSrcDst)
+		} else if err := fs.Set("route", src+":"+dst); err != nil {
+			return fmt.Errorf("config: route %s: %w", src, err)
+		}
+
+		for k, v := range route {
+			if k == "src" || k == "dst" {
+				continue
+			} else if !contains(routeFlags, k) {
+				return fmt.Errorf("config: route %s: unknown option %q", src, k)
+			}
+
+			s, err := flagValue(v)
+			if err == nil {
+				err = fs.Set(k, src+":"+s)
+			}
+			if err != nil {
+				return fmt.Errorf("config: route %s: option %q: %w", src, k, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// flagValue formats config value as flag value: lists are joined with comma, maps are joined as sorted k=v pairs.
+func flagValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := flagValue(item)
+			if err != nil {
+				return "", err
+			}
+			list = append(list, s)
+		}
+		return strings.Join(list, ","), nil
+	case map[string]interface{}:
+		list := make([]string, 0, len(v))
+		for k, item := range v {
+			s, err := flagValue(item)
+			if err != nil {
+				return "", err
+			}
+			list = append(list, k+"="+s)
+		}
+		sort.Strings(list)
+		return strings.Join(list, ","), nil
+	}
+
+	return "", fmt.Errorf("unsupported value %v", v)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
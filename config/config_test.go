@@ -0,0 +1,124 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// routeFlags is a per-route flag with src:value syntax.
+type routeFlags map[string]string
+
+func (f routeFlags) String() string { return "" }
+
+func (f routeFlags) Set(value string) error {
+	v := strings.SplitN(value, ":", 2)
+	f[v[0]] = v[1]
+	return nil
+}
+
+// listFlag is a repeated flag.
+type listFlag []string
+
+func (f *listFlag) String() string { return strings.Join(*f, " ") }
+
+func (f *listFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func newFlagSet() (*flag.FlagSet, *listFlag, routeFlags, routeFlags) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	routes, methodCase, aliases := &listFlag{}, routeFlags{}, routeFlags{}
+	fs.String("h", "localhost:8090", "")
+	fs.String("headers", "Authorization", "")
+	fs.Int("timeout", 20, "")
+	fs.Bool("control-acks", false, "")
+	fs.Var(routes, "route", "")
+	fs.Var(methodCase, "method-case", "")
+	fs.Var(aliases, "method-alias", "")
+	return fs, routes, methodCase, aliases
+}
+
+func writeConfig(t *testing.T, name, data string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestApply(t *testing.T) {
+	for name, data := range map[string]string{
+		"ws2http.yaml": `
+h: localhost:9000
+headers: [Authorization, X-Token]
+timeout: 30
+control-acks: true
+routes:
+  - src: /rpc
+    dst: http://localhost/rpc
+    method-case: lower
+    method-alias: {getUser: users.get, getOrder: orders.get}
+`,
+		"ws2http.toml": `
+h = "localhost:9000" # listener
+headers = ["Authorization", "X-Token"]
+timeout = 30
+control-acks = true
+
+[[routes]]
+src = "/rpc"
+dst = "http://localhost/rpc"
+method-case = 'lower'
+method-alias = { getUser = "users.get", getOrder = "orders.get" }
+`,
+	} {
+		values, err := Load(writeConfig(t, name, data))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		fs, routes, methodCase, aliases := newFlagSet()
+		fs.Parse([]string{"-timeout", "5"})
+		if err := Apply(fs, values, []string{"method-case", "method-alias"}); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		got := []string{
+			fs.Lookup("h").Value.String(), fs.Lookup("headers").Value.String(), fs.Lookup("timeout").Value.String(),
+			fs.Lookup("control-acks").Value.String(), routes.String(), methodCase["/rpc"], aliases["/rpc"],
+		}
+		want := []string{"localhost:9000", "Authorization,X-Token", "5", "true", "/rpc:http://localhost/rpc", "lower", "getOrder=orders.get,getUser=users.get"}
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	for data, want := range map[string]string{
+		`unknown: 1`:            `unknown option "unknown"`,
+		`timeout: abc`:          `option "timeout"`,
+		`routes: [{src: /rpc}]`: `route 1: src and dst are required`,
+		`routes: [{src: /rpc, dst: http://a, x: lower}]`: `unknown option "x"`,
+		`routes: /rpc`: `routes must be a list`,
+	} {
+		values, err := Load(writeConfig(t, "ws2http.yml", data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fs, _, _, _ := newFlagSet()
+		if err := Apply(fs, values, []string{"method-case"}); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %s", data, err, want)
+		}
+	}
+
+	if _, err := Load(writeConfig(t, "ws2http.ini", "")); err != ErrUnknownFormat {
+		t.Errorf("got %v", err)
+	}
+}
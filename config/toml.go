@@ -0,0 +1,308 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var errTomlSyntax = errors.New("invalid syntax")
+
+// parseToml decodes TOML subset used by configs: key/value pairs with strings, integers, floats, booleans, arrays and
+// inline tables, [table] and [[array of tables]] headers and comments. Dates, dotted keys and multi-line strings
+// are not supported.
+func parseToml(data []byte) (map[string]interface{}, error) {
+	root := make(map[string]interface{})
+	table := root
+
+	lines := strings.Split(string(data), "\n")
+	for n := 0; n < len(lines); n++ {
+		start, line := n, stripComment(lines[n])
+
+		// arrays and inline tables could span lines
+		for tomlDepth(line) > 0 && n+1 < len(lines) {
+			n++
+			line += " " + stripComment(lines[n])
+		}
+
+		var err error
+		switch line = strings.TrimSpace(line); {
+		case line == "":
+		case strings.HasPrefix(line, "[["):
+			if !strings.HasSuffix(line, "]]") {
+				err = errTomlSyntax
+				break
+			}
+			table, err = arrayTable(root, strings.TrimSpace(line[2:len(line)-2]))
+		case strings.HasPrefix(line, "["):
+			if !strings.HasSuffix(line, "]") {
+				err = errTomlSyntax
+				break
+			}
+			table, err = subTable(root, strings.TrimSpace(line[1:len(line)-1]))
+		default:
+			p := &tomlParser{s: line}
+			err = p.keyValue(table)
+			if err == nil && p.skipSpace() < len(p.s) {
+				err = errTomlSyntax
+			}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("toml line %d: %w", start+1, err)
+		}
+	}
+
+	return root, nil
+}
+
+// stripComment removes comment outside of strings.
+func stripComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+// tomlDepth returns depth of unclosed arrays and inline tables outside of strings.
+func tomlDepth(line string) int {
+	depth, quote := 0, byte(0)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && (c == '[' || c == '{'):
+			depth++
+		case quote == 0 && (c == ']' || c == '}'):
+			depth--
+		}
+	}
+
+	return depth
+}
+
+// subTable returns table by dotted name, last element is used for arrays of tables.
+func subTable(root map[string]interface{}, name string) (map[string]interface{}, error) {
+	table := root
+	for _, key := range strings.Split(name, ".") {
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		if key == "" {
+			return nil, errTomlSyntax
+		}
+
+		switch v := table[key].(type) {
+		case nil:
+			next := make(map[string]interface{})
+			table[key], table = next, next
+		case map[string]interface{}:
+			table = v
+		case []interface{}:
+			last, ok := v[len(v)-1].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%q is not a table", key)
+			}
+			table = last
+		default:
+			return nil, fmt.Errorf("%q is not a table", key)
+		}
+	}
+
+	return table, nil
+}
+
+// arrayTable appends new table to array of tables by dotted name.
+func arrayTable(root map[string]interface{}, name string) (map[string]interface{}, error) {
+	parent, key := root, name
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		var err error
+		if parent, err = subTable(root, name[:i]); err != nil {
+			return nil, err
+		}
+		key = name[i+1:]
+	}
+
+	key = strings.Trim(strings.TrimSpace(key), `"`)
+	if key == "" {
+		return nil, errTomlSyntax
+	}
+
+	var list []interface{}
+	switch v := parent[key].(type) {
+	case nil:
+	case []interface{}:
+		list = v
+	default:
+		return nil, fmt.Errorf("%q is not an array of tables", key)
+	}
+
+	table := make(map[string]interface{})
+	parent[key] = append(list, table)
+	return table, nil
+}
+
+// tomlParser decodes key/value pairs and values of a line.
+type tomlParser struct {
+	s string
+	i int
+}
+
+func (p *tomlParser) skipSpace() int {
+	for p.i < len(p.s) && (p.s[p.i] == ' ' || p.s[p.i] == '\t' || p.s[p.i] == '\r') {
+		p.i++
+	}
+
+	return p.i
+}
+
+// keyValue decodes key = value into table.
+func (p *tomlParser) keyValue(table map[string]interface{}) error {
+	key, err := p.key()
+	if err != nil {
+		return err
+	}
+
+	if p.skipSpace(); p.i >= len(p.s) || p.s[p.i] != '=' {
+		return errTomlSyntax
+	}
+	p.i++
+
+	value, err := p.value()
+	if err != nil {
+		return err
+	} else if _, ok := table[key]; ok {
+		return fmt.Errorf("duplicate key %q", key)
+	}
+
+	table[key] = value
+	return nil
+}
+
+// key decodes bare or quoted key.
+func (p *tomlParser) key() (string, error) {
+	if p.skipSpace(); p.i < len(p.s) && (p.s[p.i] == '"' || p.s[p.i] == '\'') {
+		return p.str()
+	}
+
+	start := p.i
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			break
+		}
+		p.i++
+	}
+
+	if p.i == start {
+		return "", errTomlSyntax
+	}
+
+	return p.s[start:p.i], nil
+}
+
+// value decodes string, number, boolean, array or inline table.
+func (p *tomlParser) value() (interface{}, error) {
+	if p.skipSpace(); p.i >= len(p.s) {
+		return nil, errTomlSyntax
+	}
+
+	switch p.s[p.i] {
+	case '"', '\'':
+		return p.str()
+	case '[':
+		p.i++
+		list := []interface{}{}
+		for {
+			if p.skipSpace(); p.i < len(p.s) && p.s[p.i] == ']' {
+				p.i++
+				return list, nil
+			}
+
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+
+			if p.skipSpace(); p.i < len(p.s) && p.s[p.i] == ',' {
+				p.i++
+			} else if p.i >= len(p.s) || p.s[p.i] != ']' {
+				return nil, errTomlSyntax
+			}
+		}
+	case '{':
+		p.i++
+		table := make(map[string]interface{})
+		for {
+			if p.skipSpace(); p.i < len(p.s) && p.s[p.i] == '}' {
+				p.i++
+				return table, nil
+			}
+
+			if err := p.keyValue(table); err != nil {
+				return nil, err
+			}
+
+			if p.skipSpace(); p.i < len(p.s) && p.s[p.i] == ',' {
+				p.i++
+			} else if p.i >= len(p.s) || p.s[p.i] != '}' {
+				return nil, errTomlSyntax
+			}
+		}
+	}
+
+	// bare value: boolean or number
+	start := p.i
+	for p.i < len(p.s) && !strings.ContainsRune(" \t\r,]}", rune(p.s[p.i])) {
+		p.i++
+	}
+
+	switch raw := p.s[start:p.i]; raw {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	default:
+		num := strings.Replace(raw, "_", "", -1)
+		if v, err := strconv.ParseInt(num, 10, 64); err == nil {
+			return v, nil
+		} else if v, err := strconv.ParseFloat(num, 64); err == nil {
+			return v, nil
+		}
+
+		return nil, fmt.Errorf("invalid value %q", raw)
+	}
+}
+
+// str decodes basic "string" with escapes or literal 'string'.
+func (p *tomlParser) str() (string, error) {
+	quote, start := p.s[p.i], p.i
+	for p.i++; p.i < len(p.s); p.i++ {
+		if c := p.s[p.i]; quote == '"' && c == '\\' {
+			p.i++
+		} else if c == quote {
+			p.i++
+			if quote == '\'' {
+				return p.s[start+1 : p.i-1], nil
+			}
+			return strconv.Unquote(p.s[start:p.i])
+		}
+	}
+
+	return "", errTomlSyntax
+}
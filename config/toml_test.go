@@ -0,0 +1,55 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseToml(t *testing.T) {
+	got, err := parseToml([]byte(`
+# comment
+name = "a \"b\" # c"
+path = 'C:\tmp'
+n = 1_000
+f = -1.5
+list = [
+  "a", # first
+  2,
+]
+
+[server.admin]
+addr = "localhost:8091"
+
+[[routes]]
+src = "/rpc"
+opts = { case = "lower", tags = ["x"] }
+
+[[routes]]
+src = "/v2"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"name":   `a "b" # c`,
+		"path":   `C:\tmp`,
+		"n":      int64(1000),
+		"f":      -1.5,
+		"list":   []interface{}{"a", int64(2)},
+		"server": map[string]interface{}{"admin": map[string]interface{}{"addr": "localhost:8091"}},
+		"routes": []interface{}{
+			map[string]interface{}{"src": "/rpc", "opts": map[string]interface{}{"case": "lower", "tags": []interface{}{"x"}}},
+			map[string]interface{}{"src": "/v2"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v", got)
+	}
+
+	for _, data := range []string{`a = `, `a = 1 2`, `a = 30s`, `[a`, `a = "x`, "a = 1\na = 2", `a.b = 1`} {
+		if _, err := parseToml([]byte(data)); err == nil {
+			t.Errorf("parseToml(%q): got no error", data)
+		}
+	}
+}
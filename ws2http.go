@@ -4,10 +4,15 @@ import (
 	"flag"
 	"fmt"
 	"github.com/semrush/ws2http/app"
+	"github.com/semrush/ws2http/config"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
 var Version string
@@ -15,21 +20,173 @@ var Version string
 const AppName = "ws2http"
 
 var (
+	flConfig      = flag.String("config", "", "YAML or TOML file with flag values by flag name and routes list, flags from command line take precedence, like ws2http.yaml")
 	flHost        = flag.String("h", "localhost:8090", "websocket listen address")
+	flTlsCert     = flag.String("tls-cert", "", "listener certificate file in PEM for wss://, requires -tls-key")
+	flTlsKey      = flag.String("tls-key", "", "listener certificate key file in PEM")
+	flTlsReload   = flag.Duration("tls-reload", 0, "check -tls-cert and -tls-key files for changes and reload certificate without restart, like 1m, 0 is disabled")
+	flAdmin       = flag.String("admin", "", "separate listen address for /admin/ handlers, admin handlers are disabled without -admin or -admin-token")
+	flAdminToken  = flag.String("admin-token", "", "bearer token required by /admin/ handlers, enables them on websocket listen address without -admin")
+	flBanner      = flag.String("banner", "", "startup banner template with app fields, like '{{.AppName}} at {{.ListenAddr}}'")
 	flHeaders     = flag.String("headers", "Authorization", "allow set custom http headers to rpc backend via comma")
+	flMaxHeaders  = flag.Int("max-headers", 32, "max session headers, further SET is rejected, 0 is unlimited")
+	flHeadersSize = flag.Int("max-headers-size", 8192, "max total size of session header names and values, further SET is rejected, 0 is unlimited")
+	flShutdown    = flag.Duration("shutdown-grace", 10*time.Second, "time for clients to reconnect after ws2http.shutdown notification on SIGTERM or SIGINT")
+	flDrain       = flag.Duration("drain-timeout", 5*time.Second, "wait for in-flight backend requests on shutdown after shutdown grace, new requests are rejected with -32001, 0 doesn't wait")
+	flMessageIds  = flag.String("message-ids", "", "messageId generator of proxy notifications (broadcast, shutdown, reauth, control errors): seq (per session), uuid7 or snowflake (node from -instance-id), empty sends no ids")
+	flReconnect   = flag.String("reconnect-url", "", "suggested reconnect endpoint in ws2http.shutdown notification, like wss://ws2.example.com/rpc")
+	flCluster     = flag.String("cluster", "", "session registry shared by ws2http instances, like redis://localhost:6379/0")
+	flAdvertise   = flag.String("advertise-url", "", "instance admin url for other instances in cluster registry, like http://10.0.0.1:8090")
+	flInstanceId  = flag.String("instance-id", "", "instance id for metric labels, logs, close frame reasons and cluster session ids, default is hostname")
+	flZone        = flag.String("zone", "", "instance zone or datacenter for metric labels, logs and close frame reasons, like eu-west-1a")
+	flSlo         = flag.String("slo", "", "method latency objectives via comma, violations are counted in slo_violation_total, like users.get:p99:300ms,*:p95:1s")
+	flCache       = flag.String("cache", "", "cache successful results of methods with ttl and optional max result size in bytes (64KiB by default) via comma, keyed by params and session headers, like users.get:30s,config.get:5m:1048576")
+	flCacheUrl    = flag.String("cache-url", "", "response cache for -cache, like memory://?entries=10000 or redis://localhost:6379/0 shared by instances, default is memory")
+	flCacheInval  = flag.String("cache-invalidate-url", "", "redis channel of cache invalidation messages like {\"method\":\"users.get\"} or {\"pattern\":\"users.*\"}, like redis://localhost:6379/0?channel=ws2http:cache:invalidate")
+	flDeadline    = flag.String("deadline-header", "", "rpc backend header with remaining request time in milliseconds, like X-Request-Timeout-Ms or grpc-timeout")
+	flRetryHold   = flag.Duration("retry-after-hold", 0, "delay requests of method after rpc backend 429 or 503 with Retry-After up to this time, like 30s, 0 is disabled")
+	flErrorHost   = flag.Bool("error-backend-host", false, "expose rpc backend host in json-rpc error data")
+	flQueueHdr    = flag.String("queue-header", "", "rpc backend header with time in milliseconds request waited in proxy since client message, like X-WS2HTTP-Queue-Ms")
+	flTokenExpiry = flag.Duration("token-expiry-notice", 0, "send ws2http.reauth notification before JWT exp in Authorization and reject requests after expiry, like 1m, 0 is disabled")
+	flForwarded   = flag.String("forwarded-headers", app.ForwardedOverwrite, "send client X-Forwarded-For, X-Real-IP and X-Forwarded-Proto to rpc backend: overwrite, append (keep values of trusted proxy in front) or off")
+	flLocale      = flag.String("locale-headers", "Accept-Language,X-Timezone", "client handshake headers forwarded with every rpc backend request via comma")
+	flExtMembers  = flag.String("extension-members", "", "allowed client json-rpc extension members via comma, like meta,trace, other members are stripped, empty keeps any member")
 	flTimeout     = flag.Int("timeout", 20, "timeout in seconds for http requests")
 	flMaxParallel = flag.Int("c", 10, "max parallel http requests per host")
+	flBrowserMode = flag.Bool("browser-mode", false, "enforce allowed origins and csrf handshake for browser clients")
+	flOrigins     = flag.String("origins", "", "allowed origins via comma: exact, wildcard or regex with ~ prefix, like https://example.com,https://*.example.com, required in browser mode, checked for clients with Origin otherwise")
+	flCsrfCookie  = flag.String("csrf-cookie", "ws2http_csrf", "cookie with csrf token for handshake in browser mode")
+	flCodec       = flag.String("codec", "json", "default codec for client frames, other codecs are selected by websocket subprotocol")
+	flMqtt        = flag.Bool("mqtt", false, "enable MQTT-over-WebSocket bridge for clients with mqtt subprotocol")
+	flStomp       = flag.Bool("stomp", false, "enable STOMP frames for clients with v10.stomp, v11.stomp or v12.stomp subprotocols")
+	flCookieJar   = flag.Bool("cookie-jar", false, "store backend cookies per websocket connection")
+	flSlowClient  = flag.Duration("slow-client-grace", 0, "disconnect clients with full send queue or blocked writes after grace period, like 10s, 0 is disabled")
+	flCorrelation = flag.Duration("correlation-ttl", time.Minute, "track request ids per connection and log and count backend responses with unknown, duplicate or mismatched ids, answered ids expire after ttl, 0 is disabled")
+	flLeakGrace   = flag.Duration("leak-grace", time.Minute, "log and count connection goroutines still running after grace since disconnect, must exceed request timeout, 0 is disabled")
+	flControlAcks = flag.Bool("control-acks", false, "acknowledge control messages with OK <command> or ERR <command> <error>")
+	flWriteTime   = flag.Duration("write-timeout", 10*time.Second, "write deadline for every frame sent to client, client is disconnected on violation, 0 is disabled")
+	flPingIv      = flag.Duration("ping-interval", 30*time.Second, "ping frames period, clients without pong for two periods are disconnected, 0 is disabled")
+	flReadBuf     = flag.Int("read-buffer", 0, "websocket connection read buffer in bytes, 0 is default 4096")
+	flWriteBuf    = flag.Int("write-buffer", 0, "websocket connection write buffer in bytes, larger buffers suit large streamed responses, 0 is default 4096")
+	flTcpReadBuf  = flag.Int("tcp-read-buffer", 0, "client socket receive buffer (SO_RCVBUF) in bytes, 0 is system default")
+	flTcpWriteBuf = flag.Int("tcp-write-buffer", 0, "client socket send buffer (SO_SNDBUF) in bytes, 0 is system default")
+	flNoDelay     = flag.Bool("tcp-nodelay", true, "set TCP_NODELAY for client connections, false batches small frames")
+	flAcceptors   = flag.Int("acceptors", 0, "listening sockets with SO_REUSEPORT and independent accept loops for high connect rates, like number of cores, 0 is single listener")
+	flKeepAlive   = flag.String("keepalive-method", "", "json-rpc method for periodic backend keep-alive probes over idle connections, like system.ping")
+	flKeepAliveIv = flag.Duration("keepalive-interval", 30*time.Second, "interval between backend keep-alive probes")
+	flHealthIv    = flag.Duration("health-interval", 0, "interval between active backend health probes reported by /readyz, /admin/backends and proxy_backend_healthy, 0 is disabled")
+	flHealthPath  = flag.String("health-path", "", "backend path of GET health probes, like /health, empty posts -health-method notification to backend url")
+	flHealthRpc   = flag.String("health-method", "system.ping", "json-rpc method of POST health probes if -health-path is empty")
+	flCaptureAge  = flag.Duration("capture-max-age", time.Hour, "retention of captured traffic for /debug/conns/export, expired records are purged automatically")
+	flCaptureSize = flag.Int("capture-max-size", 0, "max captured traffic bytes for /debug/conns/export, oldest records are purged, 0 disables capture")
+	flStorage     = flag.String("storage", "", "storage for saved captures and audit logs of admin actions, like /var/lib/ws2http")
+	flSnapshot    = flag.String("metrics-snapshot", "", "counters saved to -storage as metrics/<snapshot name>.json and restored at startup via comma, like proxy_requests_total,proxy_notifications_total")
+	flSnapshotIv  = flag.Duration("metrics-snapshot-interval", time.Minute, "interval between metrics snapshots, last snapshot is saved on shutdown")
+	flSnapshotNm  = flag.String("metrics-snapshot-name", "", "stable name of metrics snapshot surviving restarts, required with -metrics-snapshot, like ws2http-0")
+	flDebugBuf    = flag.Int("debug-events-buffer", 1000, "debug traffic events buffer, events are dropped and counted on overflow")
+	flTraceBuf    = flag.Int("debug-trace-buffer", 1000, "buffer per /debug/conns tracer, events are dropped and counted on overflow")
+	flDebugTmpl   = flag.String("debug-templates", "", "directory with debug UI overrides: index.html, trace.html, stats.html templates and static/ assets")
+	flDebugBase   = flag.String("debug-base-path", "", "reverse proxy path prefix for debug UI links and websocket, like /ws2http")
+	flPrefix      = flag.String("endpoint-prefix", "", "path prefix for /metrics, /debug/ and /admin/ endpoints, like /_ws2http")
+	flCorsOrigins = flag.String("cors-origins", "", "allowed CORS origins for /admin/, /debug/ and /metrics endpoints via comma, like https://dashboard.example.com or *")
+	flCorsMethods = flag.String("cors-methods", "GET,POST", "allowed CORS methods via comma")
+	flCorsCreds   = flag.Bool("cors-credentials", false, "allow CORS requests with credentials, requires exact -cors-origins")
+	flMaxClient   = flag.Int("client-requests", 0, "max outstanding requests per client connection, 0 is unlimited")
+	flMaxResp     = flag.Int("max-response", 0, "max rpc backend response size in bytes, larger responses are rejected with -32012 without buffering them, 0 is unlimited")
+	flRateLimit   = flag.Float64("rate-limit", 0, "max requests per second per client connection, requests over limit are rejected with -32029, 0 is unlimited")
+	flRateBurst   = flag.Int("rate-burst", 0, "requests accepted at once over -rate-limit, 0 is one second of rate")
+	flFeatures    = flag.String("features", "", "request features enabled by client header, claim of upgrade bearer token verified by token auth or session tag set by /admin/tags via comma: ordered (responses in request order), verbose_errors or features sent to rpc backend in X-Ws2http-Features, like ordered:tag:beta,verbose_errors:header:X-Debug,streaming:claim:features")
+	flMaxConns    = flag.Int("max-connections", 0, "max websocket connections of instance, further upgrades are rejected with 503, 0 is derived from open files limit (ulimit -n)")
+	flIdleConns   = flag.Int("backend-idle-conns", 0, "max idle rpc backend connections per host, 0 is derived from open files limit up to 128")
+	flSlots       = flag.Int("backend-slots", 0, "max parallel requests per backend url shared by all connections, waiting requests are served round-robin by connection, 0 is unlimited")
+	flAuthUrl     = flag.String("auth-url", "", "forward auth url for websocket upgrades, non-2xx response rejects upgrade")
+	flAuthTokens  = flag.String("auth-tokens", "", "static bearer tokens for websocket upgrades via comma, upgrades without accepted token are rejected with 401")
+	flAuthHmac    = flag.String("auth-hmac-secret", "", "secret of HS256, HS384 or HS512 JWT bearer tokens for websocket upgrades")
+	flAuthJwks    = flag.String("auth-jwks-url", "", "JWKS endpoint with keys of RS* or ES* JWT bearer tokens for websocket upgrades, like https://auth.example.com/.well-known/jwks.json")
+	flJwksRefresh = flag.Duration("auth-jwks-refresh", time.Hour, "refresh interval of -auth-jwks-url keys, unknown key ids are refetched at most every 10s")
+	flTokenQuery  = flag.String("auth-token-query", "", "query parameter with bearer token for clients without Authorization header, like access_token")
+	flReqHeaders  = flag.String("require-headers", "", "reject websocket upgrades without headers via comma")
+	flUpgradeRate = flag.Float64("upgrade-rate", 0, "max accepted websocket upgrades per second, overflow is rejected with 503 and Retry-After, 0 is unlimited")
+	flBurst       = flag.Int("upgrade-burst", 100, "upgrades accepted at once over -upgrade-rate")
+	flDenyPaths   = flag.String("deny-paths", "", "reject websocket upgrades for path prefixes via comma")
+	flRejectCode  = flag.Int("reject-status", 403, "http status for rejected websocket upgrades")
+	flNoDebug     = flag.String("no-debug-routes", "", "routes excluded from /debug/conns tracing via comma, like /pay,/private")
 	flVerbose     = flag.Bool("verbose", false, "enable debug output")
 	flTrace       = flag.Bool("trace", false, "enable trace output")
 	flRoutes      StringFlags
+	flRouteAuth   = RouteFlags{}
+	flMethodCase  = RouteFlags{}
+	flAliases     = RouteFlags{}
+	flTemplates   = RouteFlags{}
+	flProtocols   = RouteFlags{}
+	flSoapActions = RouteFlags{}
+	flSoapResult  = RouteFlags{}
+	flMirror      = RouteFlags{}
+	flCanary      = RouteFlags{}
+	flCanaryTag   = RouteFlags{}
+	flCanaryHdr   = RouteFlags{}
+	flGreen       = RouteFlags{}
+	flMaxBody     = RouteFlags{}
+	flSlowStart   = RouteFlags{}
+	flRouteRate   = RouteFlags{}
+	flMaintWindow = RouteFlags{}
+	flMaintMsg    = RouteFlags{}
+	flRouteTime   = RouteFlags{}
+	flRouteHdrs   = RouteFlags{}
+	flRoutePar    = RouteFlags{}
+	flRouteResp   = RouteFlags{}
+	flRouteTls    = RouteFlags{}
+	flCountNotif  = RouteFlags{}
+	flHttp2Ws     = RouteFlags{}
+	flAuthPerReq  = flag.Bool("auth-per-request", false, "check route forward auth url for every request")
+	flAuthHeaders = flag.String("auth-headers", "X-User", "route forward auth response headers passed to rpc backend via comma")
+	flTagHeaders  = flag.String("auth-tag-headers", "", "route forward auth response headers with comma-separated session tags via comma, like X-Roles")
 
 	flDst = flag.String("dst", "", "deprecated, use 'route' flag instead")     // deprecated, old syntax support
 	flSrc = flag.String("src", "/rpc", "deprecated, use 'route' flag instead") // deprecated, old syntax support
 )
 
 func main() {
+	// load test subcommand: ws2http bench -url ws://localhost:8090/rpc -conns 100 -churn 10s
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		if err := runBench(os.Args[2:]); err != nil {
+			log.Fatal(err.Error())
+		}
+		return
+	}
+
 	flag.Var(&flRoutes, "route", "mapping from websocket endpoint to http endpoint, like /rpc:http://localhost/rpc")
+	flag.Var(flRouteAuth, "route-auth", "forward auth url for route, like /rpc:http://localhost/auth")
+	flag.Var(flMethodCase, "method-case", "method case normalization for route: lower or upper, like /rpc:lower")
+	flag.Var(flAliases, "method-alias", "method aliases for route via comma, like /rpc:getUser=users.get,getOrder=orders.get")
+	flag.Var(flTemplates, "request-template", `backend request envelope template for route, like /rpc:{"payload":{{.Request}}}`)
+	flag.Var(flProtocols, "protocol", "backend protocol for route: jsonrpc, xmlrpc or soap, like /rpc:xmlrpc")
+	flag.Var(flSoapActions, "soap-action", "soap actions for route methods via comma, like /rpc:getUser=http://example.com/GetUser")
+	flag.Var(flSoapResult, "soap-result", "soap result element path for route, like /rpc:Envelope/Body/*/Result")
+	flag.Var(flMirror, "mirror", "mirror percent of route requests to shadow url ignoring responses, like /rpc:10:http://shadow/rpc")
+	flag.Var(flCanary, "canary", "route percent of route sessions to canary url, like /rpc:5:http://canary/rpc")
+	flag.Var(flCanaryTag, "canary-tag", "session tag routing all session requests to canary url, like /rpc:beta")
+	flag.Var(flCanaryHdr, "canary-header", "request header routing request to canary url if present, like /rpc:X-Canary")
+	flag.Var(flMaxBody, "max-body", "max forwarded request size in bytes for route, larger requests are rejected with -32600, like /rpc:65536")
+	flag.Var(flSlowStart, "slow-start", "traffic ramp duration for route destination after recovery from outage or failed health checks, requests over share are rejected with -32007, like /rpc:30s")
+	flag.Var(flRouteRate, "route-rate-limit", "max requests per second of route over all connections with optional burst, requests over limit are rejected with -32029, like /rpc:1000 or /rpc:1000:2000")
+	flag.Var(flMaintWindow, "maintenance-window", "scheduled maintenance windows of route via comma, requests are rejected with -32001, like /rpc:mon-fri 02:00-04:00 Europe/Moscow")
+	flag.Var(flMaintMsg, "maintenance-message", "error message for route requests in maintenance windows, like /rpc:nightly batch, back at 04:00")
+	flag.Var(flRouteTime, "route-timeout", "rpc backend timeout in seconds for route instead of -timeout, like /rpc:60")
+	flag.Var(flRouteHdrs, "route-headers", "allowed session headers for route via comma instead of -headers, like /rpc:Authorization,X-Token")
+	flag.Var(flRoutePar, "route-parallel", "max parallel requests per connection for route instead of -c, like /rpc:50")
+	flag.Var(flRouteResp, "route-max-response", "max rpc backend response size in bytes for route instead of -max-response, like /rpc:1048576")
+	flag.Var(flRouteTls, "route-tls", "backend tls settings for route via comma: ca, cert, key, server-name and insecure (skip verification), like /rpc:ca=/etc/ws2http/ca.pem")
+	flag.Var(flCountNotif, "count-notifications", "count route notifications (requests without id) in proxy_notifications_total instead of proxy_requests_total, like /rpc:true")
+	flag.Var(flHttp2Ws, "http2ws", "http endpoint forwarding json-rpc POST requests over websocket connection to websocket backend, like /http2ws:ws://localhost:8080/rpc")
+	flag.Var(flGreen, "green", "green destination of route for blue/green switch by /admin/switch, like /rpc:http://green/rpc")
 	flag.Parse()
+	if *flConfig != "" {
+		if err := loadConfig(*flConfig); err != nil {
+			log.SetOutput(os.Stderr)
+			log.Fatal(err.Error())
+		}
+	}
 	fixStdLog(*flVerbose, *flTrace)
 
 	if len(flRoutes.ProxyRules()) == 0 && (*flSrc == "" && *flDst == "") {
@@ -43,24 +200,265 @@ func main() {
 		rules = append(rules, app.ProxyRule{Src: *flSrc, DstUrl: *flDst})
 	}
 
+	if err := setRouteOptions(rules); err != nil {
+		log.SetOutput(os.Stderr)
+		log.Fatal(err.Error())
+	}
+
 	a := &app.App{
 		AppName:             AppName,
 		ListenAddr:          *flHost,
+		AdminAddr:           *flAdmin,
+		AdminToken:          *flAdminToken,
+		TlsCertFile:         *flTlsCert,
+		TlsKeyFile:          *flTlsKey,
+		TlsReload:           *flTlsReload,
+		Banner:              *flBanner,
 		RedirectRules:       rules,
 		Headers:             strings.Split(*flHeaders, ","),
+		LocaleHeaders:       strings.Split(*flLocale, ","),
+		ForwardedHeaders:    forwardedMode(*flForwarded),
+		MaxHeaders:          *flMaxHeaders,
+		MaxHeadersSize:      *flHeadersSize,
+		TokenExpiryNotice:   *flTokenExpiry,
+		DeadlineHeader:      *flDeadline,
+		QueueHeader:         *flQueueHdr,
+		RetryAfterHold:      *flRetryHold,
+		ErrorBackendHost:    *flErrorHost,
+		ShutdownGrace:       *flShutdown,
+		ReconnectUrl:        *flReconnect,
+		DrainTimeout:        *flDrain,
+		ClusterUrl:          *flCluster,
+		AdvertiseUrl:        *flAdvertise,
+		InstanceId:          *flInstanceId,
+		Zone:                *flZone,
 		Timeout:             *flTimeout,
 		MaxParallelRequests: *flMaxParallel,
+		MaxClientRequests:   *flMaxClient,
+		MaxResponseSize:     *flMaxResp,
+		RateLimit:           *flRateLimit,
+		RateBurst:           *flRateBurst,
+		MaxConnections:      *flMaxConns,
+		MaxIdleConnsPerHost: *flIdleConns,
+		BackendSlots:        *flSlots,
+		CookieJar:           *flCookieJar,
+		Codec:               *flCodec,
+		MqttBridge:          *flMqtt,
+		Stomp:               *flStomp,
+		SlowClientGrace:     *flSlowClient,
+		LeakGrace:           *flLeakGrace,
+		CorrelationTtl:      *flCorrelation,
+		ControlAcks:         *flControlAcks,
+		WriteTimeout:        *flWriteTime,
+		PingInterval:        *flPingIv,
+		ReadBufferSize:      *flReadBuf,
+		WriteBufferSize:     *flWriteBuf,
+		TcpReadBuffer:       *flTcpReadBuf,
+		TcpWriteBuffer:      *flTcpWriteBuf,
+		TcpDelay:            !*flNoDelay,
+		Acceptors:           *flAcceptors,
+		KeepAliveMethod:     *flKeepAlive,
+		KeepAliveInterval:   *flKeepAliveIv,
+		HealthCheckInterval: *flHealthIv,
+		HealthCheckPath:     *flHealthPath,
+		HealthCheckMethod:   *flHealthRpc,
+		CaptureMaxAge:       *flCaptureAge,
+		CaptureMaxSize:      *flCaptureSize,
+		StorageUrl:          *flStorage,
+		DebugEventsBuffer:   *flDebugBuf,
+		DebugTraceBuffer:    *flTraceBuf,
+		DebugTemplatesDir:   *flDebugTmpl,
+		DebugBasePath:       *flDebugBase,
+		EndpointPrefix:      *flPrefix,
+		Cors:                app.CorsConfig{Methods: strings.Split(*flCorsMethods, ","), Credentials: *flCorsCreds},
+		BrowserMode:         *flBrowserMode,
+		AllowedOrigins:      strings.Split(*flOrigins, ","),
+		CsrfCookie:          *flCsrfCookie,
+		CacheUrl:            *flCacheUrl,
+		CacheInvalidateUrl:  *flCacheInval,
+		UpgradeRejectStatus: *flRejectCode,
+	}
+
+	a.UpgradeHooks = upgradeHooks(*flRejectCode)
+	if *flExtMembers != "" {
+		a.ExtensionMembers = strings.Split(*flExtMembers, ",")
+	}
+	if *flCorsOrigins != "" {
+		a.Cors.Origins = strings.Split(*flCorsOrigins, ",")
+		if err := a.Cors.Validate(); err != nil {
+			log.SetOutput(os.Stderr)
+			log.Fatal(err.Error())
+		}
+	}
+	if *flSlo != "" {
+		for _, s := range strings.Split(*flSlo, ",") {
+			o, err := app.ParseSloObjective(s)
+			if err != nil {
+				log.SetOutput(os.Stderr)
+				log.Fatal(err.Error())
+			}
+			a.SloObjectives = append(a.SloObjectives, o)
+		}
+	}
+	if *flCache != "" {
+		for _, s := range strings.Split(*flCache, ",") {
+			r, err := app.ParseCacheRule(s)
+			if err != nil {
+				log.SetOutput(os.Stderr)
+				log.Fatal(err.Error())
+			}
+			a.CacheRules = append(a.CacheRules, r)
+		}
+	}
+	if *flSnapshot != "" {
+		a.MetricsSnapshot, a.MetricsSnapshotInterval, a.MetricsSnapshotName = strings.Split(*flSnapshot, ","), *flSnapshotIv, *flSnapshotNm
+	}
+	if *flFeatures != "" {
+		for _, s := range strings.Split(*flFeatures, ",") {
+			r, err := app.ParseFeatureRule(s)
+			if err != nil {
+				log.SetOutput(os.Stderr)
+				log.Fatal(err.Error())
+			}
+			a.FeatureRules = append(a.FeatureRules, r)
+		}
+	}
+	for src, dst := range flHttp2Ws {
+		a.Http2WsRoutes = append(a.Http2WsRoutes, app.Http2WsRoute{Src: src, DstUrl: dst})
+	}
+	if ids, err := app.NewIdGenerator(*flMessageIds, *flInstanceId); err != nil {
+		log.SetOutput(os.Stderr)
+		log.Fatal(err.Error())
+	} else {
+		a.MessageIds = ids
 	}
 
 	a.SetStdLoggers()
 	a.SetLogLevel(logLevel(*flVerbose, *flTrace))
 	a.Printf("starting %s version=%s", AppName, Version)
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+		a.Printf("shutting down signal=%s grace=%s", <-sig, *flShutdown)
+		if err := a.Shutdown(); err != nil {
+			a.Errorf("shutdown err=%s", err)
+		}
+	}()
+
 	if err := a.Run(); err != nil {
 		log.SetOutput(os.Stderr)
 		log.Fatal(err.Error())
 	}
 }
 
+// setRouteOptions sets per-route options of rules from route flags, invalid flag values are returned as error.
+func setRouteOptions(rules []app.ProxyRule) error {
+	var err error
+	for i, r := range rules {
+		rules[i].AuthUrl = flRouteAuth[r.Src]
+		rules[i].AuthPerRequest = *flAuthPerReq
+		rules[i].AuthHeaders = strings.Split(*flAuthHeaders, ",")
+		if *flTagHeaders != "" {
+			rules[i].TagHeaders = strings.Split(*flTagHeaders, ",")
+			rules[i].AuthHeaders = append(rules[i].AuthHeaders, rules[i].TagHeaders...)
+		}
+		rules[i].MethodCase = flMethodCase[r.Src]
+		rules[i].MethodAliases = methodAliases(flAliases[r.Src])
+		rules[i].RequestTemplate = flTemplates[r.Src]
+		rules[i].Protocol = flProtocols[r.Src]
+		rules[i].SoapActions = methodAliases(flSoapActions[r.Src])
+		rules[i].SoapResultPath = flSoapResult[r.Src]
+		if m := strings.SplitN(flMirror[r.Src], ":", 2); len(m) == 2 {
+			if rules[i].MirrorPercent, err = strconv.ParseFloat(m[0], 64); err != nil {
+				return fmt.Errorf("-mirror %s: %w", r.Src, err)
+			}
+			rules[i].MirrorUrl = m[1]
+		} else if m[0] != "" {
+			return fmt.Errorf("-mirror %s: expected percent:url", r.Src)
+		}
+		if c := strings.SplitN(flCanary[r.Src], ":", 2); len(c) == 2 {
+			if rules[i].CanaryPercent, err = strconv.ParseFloat(c[0], 64); err != nil {
+				return fmt.Errorf("-canary %s: %w", r.Src, err)
+			}
+			rules[i].CanaryUrl = c[1]
+		} else if c[0] != "" {
+			return fmt.Errorf("-canary %s: expected percent:url", r.Src)
+		}
+		rules[i].CanaryTag = flCanaryTag[r.Src]
+		rules[i].CanaryHeader = flCanaryHdr[r.Src]
+		rules[i].GreenUrl = flGreen[r.Src]
+		if v := flMaxBody[r.Src]; v != "" {
+			if rules[i].MaxBodySize, err = strconv.Atoi(v); err != nil {
+				return fmt.Errorf("-max-body %s: %w", r.Src, err)
+			}
+		}
+		if v := flSlowStart[r.Src]; v != "" {
+			if rules[i].SlowStart, err = time.ParseDuration(v); err != nil {
+				return fmt.Errorf("-slow-start %s: %w", r.Src, err)
+			}
+		}
+		if rl := strings.SplitN(flRouteRate[r.Src], ":", 2); rl[0] != "" {
+			if rules[i].RateLimit, err = strconv.ParseFloat(rl[0], 64); err != nil {
+				return fmt.Errorf("-route-rate-limit %s: %w", r.Src, err)
+			}
+			if len(rl) == 2 {
+				if rules[i].RateBurst, err = strconv.Atoi(rl[1]); err != nil {
+					return fmt.Errorf("-route-rate-limit %s burst: %w", r.Src, err)
+				}
+			}
+		}
+		if w := flMaintWindow[r.Src]; w != "" {
+			rules[i].MaintenanceWindows = strings.Split(w, ",")
+		}
+		rules[i].MaintenanceMessage = flMaintMsg[r.Src]
+		rules[i].CountNotifications = flCountNotif[r.Src] == "true"
+		if v := flRouteTime[r.Src]; v != "" {
+			if rules[i].Timeout, err = strconv.Atoi(v); err != nil {
+				return fmt.Errorf("-route-timeout %s: %w", r.Src, err)
+			}
+		}
+		if v := flRoutePar[r.Src]; v != "" {
+			if rules[i].MaxParallelRequests, err = strconv.Atoi(v); err != nil {
+				return fmt.Errorf("-route-parallel %s: %w", r.Src, err)
+			}
+		}
+		if v := flRouteResp[r.Src]; v != "" {
+			if rules[i].MaxResponseSize, err = strconv.Atoi(v); err != nil {
+				return fmt.Errorf("-route-max-response %s: %w", r.Src, err)
+			}
+		}
+		if h := flRouteHdrs[r.Src]; h != "" {
+			rules[i].Headers = strings.Split(h, ",")
+		}
+		tlsOpts := methodAliases(flRouteTls[r.Src])
+		rules[i].TlsCaFile, rules[i].TlsServerName = tlsOpts["ca"], tlsOpts["server-name"]
+		rules[i].TlsCertFile, rules[i].TlsKeyFile = tlsOpts["cert"], tlsOpts["key"]
+		rules[i].TlsInsecure = tlsOpts["insecure"] == "true"
+		for _, src := range strings.Split(*flNoDebug, ",") {
+			rules[i].DisableDebug = rules[i].DisableDebug || src == r.Src
+		}
+	}
+
+	return nil
+}
+
+// loadConfig sets flags from config file, per-route options of routes are set as RouteFlags.
+func loadConfig(path string) error {
+	values, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+
+	var routeFlags []string
+	flag.VisitAll(func(f *flag.Flag) {
+		if _, ok := f.Value.(RouteFlags); ok {
+			routeFlags = append(routeFlags, f.Name)
+		}
+	})
+
+	return config.Apply(flag.CommandLine, values, routeFlags)
+}
+
 func logLevel(verbose, trace bool) app.LogLevel {
 	if trace {
 		return app.LogTrace
@@ -71,6 +469,68 @@ func logLevel(verbose, trace bool) app.LogLevel {
 	return app.LogError
 }
 
+// forwardedMode returns client identity headers mode from -forwarded-headers, off disables headers.
+func forwardedMode(value string) string {
+	switch value {
+	case app.ForwardedOverwrite, app.ForwardedAppend:
+		return value
+	case "off", "":
+		return ""
+	}
+
+	log.SetOutput(os.Stderr)
+	log.Fatalf("unknown -forwarded-headers mode %q", value)
+	return ""
+}
+
+// methodAliases parses method mapping like getUser=users.get,getOrder=orders.get.
+func methodAliases(value string) map[string]string {
+	aliases := make(map[string]string)
+	for _, a := range strings.Split(value, ",") {
+		if kv := strings.SplitN(a, "=", 2); len(kv) == 2 {
+			aliases[kv[0]] = kv[1]
+		}
+	}
+
+	return aliases
+}
+
+// upgradeHooks returns websocket upgrade hooks from flags.
+func upgradeHooks(status int) []app.UpgradeHook {
+	var hooks []app.UpgradeHook
+	if *flUpgradeRate > 0 {
+		hooks = append(hooks, app.UpgradeRateHook(*flUpgradeRate, *flBurst))
+	}
+
+	if *flDenyPaths != "" {
+		hooks = append(hooks, app.DenyPathsHook(status, strings.Split(*flDenyPaths, ",")...))
+	}
+
+	if *flReqHeaders != "" {
+		hooks = append(hooks, app.RequireHeadersHook(status, strings.Split(*flReqHeaders, ",")...))
+	}
+
+	var validators []app.TokenValidator
+	if *flAuthTokens != "" {
+		validators = append(validators, app.StaticTokens(strings.Split(*flAuthTokens, ",")...))
+	}
+	if *flAuthHmac != "" {
+		validators = append(validators, app.HmacJwt([]byte(*flAuthHmac)))
+	}
+	if *flAuthJwks != "" {
+		validators = append(validators, app.JwksJwt(*flAuthJwks, *flJwksRefresh, time.Duration(*flTimeout)*time.Second))
+	}
+	if len(validators) > 0 {
+		hooks = append(hooks, app.TokenAuthHook(*flTokenQuery, validators...))
+	}
+
+	if *flAuthUrl != "" {
+		hooks = append(hooks, app.ForwardAuthHook(*flAuthUrl, time.Duration(*flTimeout)*time.Second))
+	}
+
+	return hooks
+}
+
 // fixStdLog sets additional params to std logger (prefix D, filename & line).
 func fixStdLog(verbose, trace bool) {
 	log.SetPrefix("D")
@@ -107,3 +567,20 @@ func (f StringFlags) ProxyRules() []app.ProxyRule {
 
 	return pv
 }
+
+// RouteFlags is a map of per-route option values with src:value syntax.
+type RouteFlags map[string]string
+
+func (f RouteFlags) String() string {
+	return fmt.Sprint(map[string]string(f))
+}
+
+func (f RouteFlags) Set(value string) error {
+	v := strings.SplitN(value, ":", 2)
+	if len(v) == 2 && strings.HasPrefix(v[0], "/") {
+		f[v[0]] = v[1]
+		return nil
+	}
+
+	return fmt.Errorf("invalid syntax: %v", value)
+}
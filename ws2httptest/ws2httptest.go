@@ -0,0 +1,238 @@
+// Package ws2httptest provides utilities for ws2http integration tests: in-process proxy with app.App routes,
+// fake JSON-RPC backend and scripted websocket client.
+//
+//	backend := ws2httptest.NewBackend(t)
+//	backend.Handle("users.get", func(req ws2httptest.Request) (interface{}, *ws2httptest.Error) {
+//		return map[string]int{"id": 1}, nil
+//	})
+//
+//	proxy := ws2httptest.NewProxy(t, &app.App{RedirectRules: []app.ProxyRule{{Src: "/rpc", DstUrl: backend.URL}}})
+//	c := ws2httptest.Dial(t, proxy.WsURL("/rpc"))
+//	c.Send(`SET Authorization token`)
+//	c.Expect(`{"jsonrpc":"2.0","id":1,"result":{"id":1}}`, `{"jsonrpc":"2.0","method":"users.get","id":1}`)
+package ws2httptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/semrush/ws2http/app"
+)
+
+// Timeout is a read timeout of Client frames.
+var Timeout = 5 * time.Second
+
+// Error is a JSON-RPC error of fake backend response.
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Request is a JSON-RPC request received by fake backend.
+type Request struct {
+	Method string          `json:"method"`
+	Id     json.RawMessage `json:"id,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Header http.Header     `json:"-"`
+}
+
+// HandlerFunc returns result or error for backend request.
+type HandlerFunc func(req Request) (interface{}, *Error)
+
+// Backend is a fake JSON-RPC over HTTP backend. Unknown methods return -32601 error.
+type Backend struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	handlers map[string]HandlerFunc
+	requests []Request
+}
+
+// NewBackend starts fake backend, it is closed on test cleanup.
+func NewBackend(t testing.TB) *Backend {
+	b := &Backend{handlers: make(map[string]HandlerFunc)}
+	b.Server = httptest.NewServer(http.HandlerFunc(b.serveHTTP))
+	t.Cleanup(b.Close)
+	return b
+}
+
+// Handle sets method handler.
+func (b *Backend) Handle(method string, h HandlerFunc) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.handlers[method] = h
+}
+
+// Requests returns received requests.
+func (b *Backend) Requests() []Request {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]Request(nil), b.requests...)
+}
+
+func (b *Backend) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Header = r.Header
+
+	b.lock.Lock()
+	b.requests = append(b.requests, req)
+	h, ok := b.handlers[req.Method]
+	b.lock.Unlock()
+
+	resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.Id}
+	if !ok {
+		resp["error"] = &Error{Code: app.JsonRpcMethodNotFound, Message: "method not found"}
+	} else if result, rpcErr := h(req); rpcErr != nil {
+		resp["error"] = rpcErr
+	} else {
+		resp["result"] = result
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Proxy is an in-process ws2http proxy with app.App routes.
+type Proxy struct {
+	*httptest.Server
+	App *app.App
+}
+
+// NewProxy starts proxy for a routes, it is closed on test cleanup. Timeout and MaxParallelRequests
+// default to ws2http flag defaults.
+func NewProxy(t testing.TB, a *app.App) *Proxy {
+	if a.Timeout == 0 {
+		a.Timeout = 20
+	}
+	if a.MaxParallelRequests == 0 {
+		a.MaxParallelRequests = 10
+	}
+
+	h, err := a.Handler()
+	if err != nil {
+		t.Fatalf("can't start proxy: %s", err)
+	}
+
+	p := &Proxy{Server: httptest.NewServer(h), App: a}
+	t.Cleanup(p.Close)
+	return p
+}
+
+// WsURL returns websocket url of proxy route, like ws://127.0.0.1:1234/rpc.
+func (p *Proxy) WsURL(path string) string {
+	return "ws" + strings.TrimPrefix(p.URL, "http") + path
+}
+
+// Client is a scripted websocket client, failed steps fail the test.
+type Client struct {
+	t  testing.TB
+	ws *websocket.Conn
+}
+
+// Dial connects to proxy url with optional subprotocols, connection is closed on test cleanup.
+func Dial(t testing.TB, url string, protocol ...string) *Client {
+	dialer := websocket.Dialer{Subprotocols: protocol}
+	ws, _, err := dialer.Dial(url, http.Header{"Origin": {"http" + strings.TrimPrefix(url, "ws")}})
+	if err != nil {
+		t.Fatalf("can't dial %s: %s", url, err)
+	}
+
+	c := &Client{t: t, ws: ws}
+	t.Cleanup(func() { ws.Close() })
+	return c
+}
+
+// Protocol returns negotiated subprotocol.
+func (c *Client) Protocol() string {
+	return c.ws.Subprotocol()
+}
+
+// Send sends text frame, like json-rpc request or control command.
+func (c *Client) Send(frame string) {
+	c.t.Helper()
+	if err := c.ws.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+		c.t.Fatalf("can't send %s: %s", frame, err)
+	}
+}
+
+// Receive returns next text frame or fails after Timeout.
+func (c *Client) Receive() string {
+	c.t.Helper()
+	c.ws.SetReadDeadline(time.Now().Add(Timeout))
+
+	_, frame, err := c.ws.ReadMessage()
+	if err != nil {
+		c.t.Fatalf("can't receive frame: %s", err)
+	}
+
+	return string(frame)
+}
+
+// Expect sends frames and checks next received frame, json frames are compared as json values.
+func (c *Client) Expect(want string, send ...string) {
+	c.t.Helper()
+	for _, frame := range send {
+		c.Send(frame)
+	}
+
+	if got := c.Receive(); !equalFrames(got, want) {
+		c.t.Errorf("got frame %s, want %s", got, want)
+	}
+}
+
+// Call sends json-rpc request with params and returns response, notifications before response are skipped.
+func (c *Client) Call(id interface{}, method string, params interface{}) (result json.RawMessage, rpcErr *Error) {
+	c.t.Helper()
+	req := map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method}
+	if params != nil {
+		req["params"] = params
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		c.t.Fatalf("can't marshal request: %s", err)
+	}
+	c.Send(string(data))
+
+	wantId, _ := json.Marshal(id)
+	for {
+		var resp struct {
+			Id     json.RawMessage `json:"id"`
+			Result json.RawMessage `json:"result"`
+			Error  *Error          `json:"error"`
+		}
+		frame := c.Receive()
+		if json.Unmarshal([]byte(frame), &resp) != nil || !bytes.Equal(resp.Id, wantId) {
+			continue
+		}
+
+		return resp.Result, resp.Error
+	}
+}
+
+// Close closes connection.
+func (c *Client) Close() error {
+	return c.ws.Close()
+}
+
+// equalFrames compares frames as json values or strings.
+func equalFrames(got, want string) bool {
+	var g, w interface{}
+	if json.Unmarshal([]byte(got), &g) != nil || json.Unmarshal([]byte(want), &w) != nil {
+		return got == want
+	}
+
+	return reflect.DeepEqual(g, w)
+}
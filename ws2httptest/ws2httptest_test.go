@@ -0,0 +1,54 @@
+package ws2httptest
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/semrush/ws2http/app"
+)
+
+func TestProxy(t *testing.T) {
+	backend := NewBackend(t)
+	backend.Handle("users.get", func(req Request) (interface{}, *Error) {
+		return map[string]string{"auth": req.Header.Get("Authorization")}, nil
+	})
+	backend.Handle("users.fail", func(req Request) (interface{}, *Error) {
+		return nil, &Error{Code: 42, Message: "failed"}
+	})
+
+	proxy := NewProxy(t, &app.App{
+		RedirectRules: []app.ProxyRule{{Src: "/rpc", DstUrl: backend.URL}},
+		Headers:       []string{"Authorization"},
+		ControlAcks:   true,
+	})
+
+	c := Dial(t, proxy.WsURL("/rpc"))
+	c.Expect(`OK SET`, `SET Authorization token`)
+	c.Expect(`{"jsonrpc":"2.0","id":1,"result":{"auth":"token"}}`, `{"jsonrpc":"2.0","method":"users.get","id":1}`)
+
+	if result, rpcErr := c.Call("a", "users.get", nil); rpcErr != nil || string(result) != `{"auth":"token"}` {
+		t.Errorf("got %s, %+v", result, rpcErr)
+	}
+	if _, rpcErr := c.Call(2, "users.fail", map[string]int{"id": 1}); rpcErr == nil || rpcErr.Code != 42 {
+		t.Errorf("got %+v", rpcErr)
+	}
+	if _, rpcErr := c.Call(3, "users.unknown", nil); rpcErr == nil || rpcErr.Code != app.JsonRpcMethodNotFound {
+		t.Errorf("got %+v", rpcErr)
+	}
+
+	requests := backend.Requests()
+	if len(requests) != 4 || requests[2].Method != "users.fail" || string(requests[2].Params) != `{"id":1}` {
+		data, _ := json.Marshal(requests)
+		t.Errorf("got %s", data)
+	}
+
+	// ids round-trip byte-exact
+	c.Send(`{"jsonrpc":"2.0","method":"users.get","id":9007199254740993}`)
+	if frame := c.Receive(); !strings.Contains(frame, `"id":9007199254740993`) {
+		t.Errorf("got %s", frame)
+	}
+	if requests = backend.Requests(); string(requests[len(requests)-1].Id) != `9007199254740993` {
+		t.Errorf("got backend id %s", requests[len(requests)-1].Id)
+	}
+}